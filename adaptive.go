@@ -0,0 +1,88 @@
+package stampede
+
+import "sync"
+
+const (
+	// adaptiveWindow is the number of recompute decisions between beta
+	// adjustments
+	adaptiveWindow = 100
+
+	// adaptiveStep is the factor by which beta is adjusted
+	adaptiveStep = 1.25
+)
+
+// AdaptiveBeta tunes beta within bounds.  It counts how many of the fetches
+// which decided to recompute found a recompute of the same key already in
+// flight in this process — stampedes which slipped through early expiration —
+// and how many recomputed early.  After each window of decisions, beta is
+// raised if the stampede fraction exceeds the target, and lowered if there
+// were no stampedes but early recomputes dominated, since those cost origin
+// capacity for nothing.  Stampedes are only visible with singleflight enabled.
+//
+// An AdaptiveBeta is safe for concurrent use and may be shared between
+// fetchers protecting similar workloads.
+type AdaptiveBeta struct {
+	min, max, target float64
+
+	mu        sync.Mutex
+	beta      float64
+	decisions int
+	stampedes int
+	early     int
+}
+
+// NewAdaptiveBeta returns an AdaptiveBeta starting at Beta, clamped to
+// [min,max], and aiming for at most the target fraction of recomputes to be
+// stampedes.
+func NewAdaptiveBeta(min, max, target float64) *AdaptiveBeta {
+	return &AdaptiveBeta{
+		min:    min,
+		max:    max,
+		target: target,
+		beta:   clampBeta(Beta, min, max),
+	}
+}
+
+// Beta returns the current beta
+func (a *AdaptiveBeta) Beta() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.beta
+}
+
+// observe records a decision to recompute.  early is whether the item had
+// not yet expired, and stampede whether another recompute of the key was
+// already in flight.
+func (a *AdaptiveBeta) observe(early, stampede bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.decisions++
+	if stampede {
+		a.stampedes++
+	}
+	if early {
+		a.early++
+	}
+	if a.decisions < adaptiveWindow {
+		return
+	}
+
+	switch {
+	case float64(a.stampedes) > a.target*float64(a.decisions):
+		a.beta = clampBeta(a.beta*adaptiveStep, a.min, a.max)
+	case a.stampedes == 0 && 2*a.early > a.decisions:
+		a.beta = clampBeta(a.beta/adaptiveStep, a.min, a.max)
+	}
+	a.decisions, a.stampedes, a.early = 0, 0, 0
+}
+
+func clampBeta(beta, min, max float64) float64 {
+	if beta < min {
+		return min
+	}
+	if beta > max {
+		return max
+	}
+	return beta
+}
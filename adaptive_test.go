@@ -0,0 +1,76 @@
+package stampede_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestAdaptiveBetaLowers(t *testing.T) {
+	ctx := context.Background()
+	a := stampede.NewAdaptiveBeta(0.7, 4, 0.1)
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithAdaptiveBeta(a),
+		stampede.WithRand(stampede.AlwaysExpire),
+	)
+	xf.Fetch(ctx, "k", succeeding)
+
+	// a window of early recomputes and no stampedes lowers beta
+	for range 99 {
+		xf.Fetch(ctx, "k", succeeding)
+	}
+	if b := a.Beta(); b != stampede.Beta/1.25 {
+		t.Fatalf("Beta after a window of early recomputes = %v, want %v", b, stampede.Beta/1.25)
+	}
+
+	// to no less than min
+	for range 100 {
+		xf.Fetch(ctx, "k", succeeding)
+	}
+	if b := a.Beta(); b != 0.7 {
+		t.Errorf("Beta = %v, want the minimum 0.7", b)
+	}
+}
+
+func TestAdaptiveBetaRaises(t *testing.T) {
+	ctx := context.Background()
+	a := stampede.NewAdaptiveBeta(0.5, 1.2, 0.1)
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithAdaptiveBeta(a),
+		stampede.WithRand(stampede.NeverExpire),
+	)
+
+	// concurrent misses of a key are coalesced into stampedes
+	slow := func(ctx context.Context) (int, time.Duration, error) {
+		time.Sleep(10 * time.Millisecond)
+		return 1, time.Minute, nil
+	}
+	for round := 0; a.Beta() == stampede.Beta && round < 100; round++ {
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				xf.Fetch(ctx, strconv.Itoa(round), slow)
+			}()
+		}
+		wg.Wait()
+	}
+	if b := a.Beta(); b != 1.2 {
+		t.Errorf("Beta after stampedes = %v, want the maximum 1.2", b)
+	}
+}
+
+func TestAdaptiveBetaBounds(t *testing.T) {
+	if b := stampede.NewAdaptiveBeta(2, 4, 0.1).Beta(); b != 2 {
+		t.Errorf("initial Beta = %v, want it clamped to 2", b)
+	}
+	if b := stampede.NewAdaptiveBeta(0.1, 0.5, 0.1).Beta(); b != 0.5 {
+		t.Errorf("initial Beta = %v, want it clamped to 0.5", b)
+	}
+}
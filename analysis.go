@@ -0,0 +1,212 @@
+package stampede
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Recommendation is the analysis of a group of keys recorded with
+// WithAnalysis, with suggested settings
+type Recommendation struct {
+	Group string
+
+	// Fetches counts the fetches of the group, and HitRatio is the
+	// fraction served from the cache without recomputing
+	Fetches  uint64
+	HitRatio float64
+
+	// Recomputes counts the recomputes and RecomputeRate is their rate per
+	// second.  EarlyFraction is the fraction of the fetches deciding to
+	// recompute which were early expirations.
+	Recomputes    uint64
+	RecomputeRate float64
+	EarlyFraction float64
+
+	// Beta is the mean beta of the fetches; MeanDelta the mean recompute
+	// time; MeanTTL the mean time-to-live returned by recompute; and
+	// MeanLead the mean time left before expiry at early expirations
+	Beta      float64
+	MeanDelta time.Duration
+	MeanTTL   time.Duration
+	MeanLead  time.Duration
+
+	// SuggestedTTL and SuggestedBeta are the suggested settings for the
+	// group, zero if the current ones look right, and Reasons explains
+	// them
+	SuggestedTTL  time.Duration
+	SuggestedBeta float64
+	Reasons       []string
+}
+
+// WithAnalysis records the hit ratio, recompute rate, recompute times and
+// time-to-live of each group of keys, for Recommend.  group maps a key to its
+// group, such as its prefix, and should yield few distinct groups; nil puts
+// every key in the group "".  The key type must match the fetcher's.
+func WithAnalysis[K comparable](group func(key K) string) Option {
+	return func(c *config) {
+		c.analysis = true
+		c.analysisGroup = group
+	}
+}
+
+// analysisMinRecomputes is the number of recomputes of a group below which
+// Recommend makes no suggestions
+const analysisMinRecomputes = 20
+
+// groupStats counts the activity of a group of keys
+type groupStats struct {
+	fetches, hits, early, late uint64
+	betaSum                    float64
+
+	recomputes    uint64
+	recomputeTime time.Duration
+	ttls          uint64
+	ttlSum        time.Duration
+	leadSum       time.Duration
+}
+
+type analysis[K comparable] struct {
+	group func(K) string
+	start time.Time
+
+	mu sync.Mutex
+	m  map[string]*groupStats
+}
+
+func newAnalysis[K comparable](group func(K) string, now time.Time) *analysis[K] {
+	return &analysis[K]{group: group, start: now, m: make(map[string]*groupStats)}
+}
+
+// stats returns the stats of the group of key.  a.mu must be held.
+func (a *analysis[K]) stats(key K) *groupStats {
+	var g string
+	if a.group != nil {
+		g = a.group(key)
+	}
+	s, ok := a.m[g]
+	if !ok {
+		s = &groupStats{}
+		a.m[g] = s
+	}
+	return s
+}
+
+func (a *analysis[K]) lookup(key K, info FetchInfo) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.stats(key)
+	s.fetches++
+	s.betaSum += info.Beta
+	switch {
+	case info.Decision == DecisionHit:
+		s.hits++
+	case info.Decision == DecisionEarlyExpire:
+		s.early++
+		s.leadSum += info.TTL
+	case info.TTL < 0:
+		// found, but already expired
+		s.late++
+	}
+}
+
+func (a *analysis[K]) recomputed(e RecomputeEvent[K]) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.stats(e.Key)
+	s.recomputes++
+	s.recomputeTime += e.Duration
+	if e.Err == nil && e.TTL > 0 && e.TTL != NoExpiry {
+		s.ttls++
+		s.ttlSum += e.TTL
+	}
+}
+
+// Recommend analyses the groups of keys recorded since the fetcher was
+// created, and suggests a TTL and beta for each, busiest first.  It returns
+// nil unless WithAnalysis is set.
+//
+// A longer TTL is suggested when recomputing takes more than 1% of the TTL,
+// as the origin then spends a noticeable share of its time on each key, or
+// when fewer than half the fetches hit and most of the others found the value
+// expired, so values expire between fetches.  A higher beta is suggested when
+// most fetches hit but more found the value already expired than recomputed
+// it early, as fetches then stampede at expiry, and a lower beta when early
+// expirations come more than four recompute times before expiry, which
+// wastes TTL.  No suggestions are made for groups with fewer than 20
+// recomputes.
+func (xf *XFetcher[K, V]) Recommend() []Recommendation {
+	a := xf.analysis
+	if a == nil {
+		return nil
+	}
+	elapsed := xf.clock.Now().Sub(a.start).Seconds()
+
+	a.mu.Lock()
+	recs := make([]Recommendation, 0, len(a.m))
+	for g, s := range a.m {
+		recs = append(recs, s.recommend(g, elapsed))
+	}
+	a.mu.Unlock()
+
+	slices.SortFunc(recs, func(a, b Recommendation) int {
+		return cmp.Or(cmp.Compare(b.Fetches, a.Fetches), cmp.Compare(a.Group, b.Group))
+	})
+	return recs
+}
+
+// recommend analyses s as group g, recorded over elapsed seconds
+func (s *groupStats) recommend(g string, elapsed float64) Recommendation {
+	r := Recommendation{Group: g, Fetches: s.fetches, Recomputes: s.recomputes}
+	if s.fetches > 0 {
+		r.HitRatio = float64(s.hits) / float64(s.fetches)
+		r.Beta = s.betaSum / float64(s.fetches)
+	}
+	if elapsed > 0 {
+		r.RecomputeRate = float64(s.recomputes) / elapsed
+	}
+	if s.fetches > s.hits {
+		r.EarlyFraction = float64(s.early) / float64(s.fetches-s.hits)
+	}
+	if s.recomputes > 0 {
+		r.MeanDelta = s.recomputeTime / time.Duration(s.recomputes)
+	}
+	if s.ttls > 0 {
+		r.MeanTTL = s.ttlSum / time.Duration(s.ttls)
+	}
+	if s.early > 0 {
+		r.MeanLead = s.leadSum / time.Duration(s.early)
+	}
+
+	if s.recomputes < analysisMinRecomputes {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("only %d recomputes recorded", s.recomputes))
+		return r
+	}
+
+	switch {
+	case r.MeanTTL > 0 && r.MeanDelta > r.MeanTTL/100:
+		r.SuggestedTTL = 100 * r.MeanDelta
+		r.Reasons = append(r.Reasons, fmt.Sprintf("recomputing takes %.1f%% of the TTL", 100*float64(r.MeanDelta)/float64(r.MeanTTL)))
+	case r.MeanTTL > 0 && r.HitRatio < 0.5 && s.late > (s.fetches-s.hits)/2:
+		r.SuggestedTTL = 2 * r.MeanTTL
+		r.Reasons = append(r.Reasons, fmt.Sprintf("only %.0f%% of fetches hit; values expire between fetches", 100*r.HitRatio))
+	}
+
+	switch {
+	case r.HitRatio >= 0.5 && s.late > s.early:
+		r.SuggestedBeta = 2 * r.Beta
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%d fetches found the value expired against %d early recomputes", s.late, s.early))
+	case s.early > 0 && r.MeanDelta > 0 && r.MeanLead > 4*r.MeanDelta:
+		r.SuggestedBeta = r.Beta * 2 * float64(r.MeanDelta) / float64(r.MeanLead)
+		r.Reasons = append(r.Reasons, fmt.Sprintf("early recomputes come %.0f recompute times before expiry", float64(r.MeanLead)/float64(r.MeanDelta)))
+	}
+	return r
+}
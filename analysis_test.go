@@ -0,0 +1,132 @@
+package stampede_test
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// analysed returns a fetcher recording WithAnalysis by key prefix
+func analysed(clock *fakeclock.Clock, opts ...stampede.Option) *stampede.XFetcher[string, int] {
+	prefix := func(key string) string { return key[:1] }
+	opts = append([]stampede.Option{stampede.WithClock(clock), stampede.WithAnalysis(prefix)}, opts...)
+	return stampede.New[string, int](memcache.New[string, int](), opts...)
+}
+
+func TestRecommend(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	xf := analysed(clock, stampede.WithRand(stampede.NeverExpire))
+	for range 10 {
+		xf.Fetch(ctx, "a1", taking(clock, time.Second))
+	}
+	for i := range 3 {
+		xf.Fetch(ctx, "b"+strconv.Itoa(i), taking(clock, time.Second))
+	}
+	clock.Advance(6 * time.Second)
+
+	recs := xf.Recommend()
+	if len(recs) != 2 || recs[0].Group != "a" || recs[1].Group != "b" {
+		t.Fatalf("Recommend = %+v, want groups a then b", recs)
+	}
+	r := recs[0]
+	if r.Fetches != 10 || r.HitRatio != 0.9 || r.Recomputes != 1 || r.RecomputeRate != 0.1 || r.Beta != stampede.Beta || r.MeanDelta != time.Second || r.MeanTTL != time.Minute {
+		t.Errorf("Recommendation for a = %+v", r)
+	}
+
+	// too few recomputes for suggestions
+	if r.SuggestedTTL != 0 || r.SuggestedBeta != 0 || len(r.Reasons) != 1 {
+		t.Errorf("Recommendation for a = %+v, want no suggestions", r)
+	}
+}
+
+func TestRecommendSuggestions(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		rand     func() float64
+		fetch    func(ctx context.Context, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock)
+		ttl      time.Duration
+		beta     float64
+		noReason bool
+	}{
+		{
+			name: "SlowRecompute",
+			rand: stampede.NeverExpire,
+			fetch: func(ctx context.Context, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock) {
+				xf.Fetch(ctx, "k", taking(clock, time.Second))
+				clock.Advance(time.Minute)
+			},
+			ttl: 100 * time.Second,
+		},
+		{
+			name: "ExpiringBetweenFetches",
+			rand: stampede.NeverExpire,
+			fetch: func(ctx context.Context, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock) {
+				xf.Fetch(ctx, "k", succeeding)
+				clock.Advance(2 * time.Minute)
+			},
+			ttl: 2 * time.Minute,
+		},
+		{
+			name: "StampedingAtExpiry",
+			rand: stampede.NeverExpire,
+			fetch: func(ctx context.Context, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock) {
+				for range 3 {
+					xf.Fetch(ctx, "k", succeeding)
+				}
+				clock.Advance(2 * time.Minute)
+			},
+			beta: 2 * stampede.Beta,
+		},
+		{
+			name: "RecomputingTooEarly",
+			rand: stampede.AlwaysExpire,
+			fetch: func(ctx context.Context, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock) {
+				xf.Fetch(ctx, "k", taking(clock, time.Millisecond))
+			},
+			beta: stampede.Beta * 2 * float64(time.Millisecond) / float64(time.Minute),
+		},
+		{
+			name: "Healthy",
+			rand: func() float64 { return 0.5 },
+			fetch: func(ctx context.Context, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock) {
+				// recompute shortly before expiry
+				for range 3 {
+					xf.Fetch(ctx, "k", taking(clock, 100*time.Millisecond))
+				}
+				clock.Advance(time.Minute - 50*time.Millisecond)
+			},
+			noReason: true,
+		},
+	} {
+		ctx := context.Background()
+		clock := fakeclock.New(time.Unix(1000, 0))
+		xf := analysed(clock, stampede.WithRand(tt.rand))
+		for range 25 {
+			tt.fetch(ctx, xf, clock)
+		}
+
+		recs := xf.Recommend()
+		if len(recs) != 1 {
+			t.Fatalf("%s: Recommend = %+v, want one group", tt.name, recs)
+		}
+		r := recs[0]
+		if r.SuggestedTTL != tt.ttl || math.Abs(r.SuggestedBeta-tt.beta) > 1e-12 || (len(r.Reasons) == 0) != tt.noReason {
+			t.Errorf("%s: Recommendation = %+v, want TTL %v and beta %v", tt.name, r, tt.ttl, tt.beta)
+		}
+	}
+}
+
+func TestRecommendDisabled(t *testing.T) {
+	xf := stampede.New[string, int](memcache.New[string, int]())
+	xf.Fetch(context.Background(), "k", succeeding)
+	if recs := xf.Recommend(); recs != nil {
+		t.Errorf("Recommend without WithAnalysis = %+v, want nil", recs)
+	}
+}
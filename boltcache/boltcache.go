@@ -0,0 +1,293 @@
+// Package boltcache is a persistent stampede.Cache backed by a bbolt database
+package boltcache
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Cache stores items in a bbolt bucket, encoded with a stampede.Codec, so that
+// they survive restarts.  Each value is prefixed with the time after which the
+// entry is dead, so dead entries can be found without decoding.  Dead entries
+// are treated as missing, and deleted by a background sweep.
+type Cache[V any] struct {
+	db     *bolt.DB
+	bucket []byte
+	codec  stampede.Codec[V]
+	config
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	bucket string
+	grace  time.Duration
+	sweep  time.Duration
+}
+
+// WithBucket sets the name of the bucket holding the entries.  The default is
+// "stampede".
+func WithBucket(name string) Option {
+	return func(c *config) { c.bucket = name }
+}
+
+// WithGrace keeps entries for grace after the item expires, so that they
+// remain available for stale serving.  The default is zero.
+func WithGrace(grace time.Duration) Option {
+	return func(c *config) { c.grace = grace }
+}
+
+// WithSweepInterval sets how often dead entries are deleted.  Zero disables
+// the sweep.  The default is one minute.  The space freed is reused by bbolt
+// but the file does not shrink; use bbolt's compaction for that.
+func WithSweepInterval(d time.Duration) Option {
+	return func(c *config) { c.sweep = d }
+}
+
+// New returns a Cache storing items in db, encoding items with codec.  It
+// creates the bucket if needed and starts the sweep.  The caller remains
+// responsible for closing db, after closing the Cache.
+func New[V any](db *bolt.DB, codec stampede.Codec[V], opts ...Option) (*Cache[V], error) {
+	cfg := config{bucket: "stampede", sweep: time.Minute}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	c := &Cache[V]{db: db, bucket: []byte(cfg.bucket), codec: codec, config: cfg, stop: make(chan struct{})}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(c.bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.sweep > 0 {
+		c.wg.Add(1)
+		go c.sweeper()
+	}
+	return c, nil
+}
+
+// Close stops the sweep
+func (c *Cache[V]) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	c.wg.Wait()
+	return nil
+}
+
+// Get implements stampede.Cache
+func (c *Cache[V]) Get(ctx context.Context, key string) (stampede.Item[V], error) {
+	var b []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b = live(tx.Bucket(c.bucket).Get([]byte(key)), time.Now())
+		return nil
+	})
+	if err != nil {
+		return stampede.Item[V]{}, err
+	}
+	if b == nil {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	return c.codec.Unmarshal(b)
+}
+
+// Set implements stampede.Cache.  Concurrent writes are batched into one
+// transaction.
+func (c *Cache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	v, err := c.encode(item)
+	if err != nil {
+		return err
+	}
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(c.bucket).Put([]byte(key), v)
+	})
+}
+
+// SetMulti implements stampede.BatchSetter in one transaction
+func (c *Cache[V]) SetMulti(ctx context.Context, items map[string]stampede.Item[V]) error {
+	vs := make(map[string][]byte, len(items))
+	for key, item := range items {
+		v, err := c.encode(item)
+		if err != nil {
+			return err
+		}
+		vs[key] = v
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+		for key, v := range vs {
+			if err := b.Put([]byte(key), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetIfNewer implements stampede.ConditionalSetter
+func (c *Cache[V]) SetIfNewer(ctx context.Context, key string, item stampede.Item[V]) (bool, error) {
+	v, err := c.encode(item)
+	if err != nil {
+		return false, err
+	}
+	var stored bool
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+		// a dead or undecodable entry is always replaced
+		if old := live(b.Get([]byte(key)), time.Now()); old != nil {
+			if old, err := c.codec.Unmarshal(old); err == nil && !item.Supersedes(old) {
+				return nil
+			}
+		}
+		stored = true
+		return b.Put([]byte(key), v)
+	})
+	return stored && err == nil, err
+}
+
+// ClaimLease implements stampede.LeaseClaimer
+func (c *Cache[V]) ClaimLease(ctx context.Context, key string, prev *stampede.Item[V], lease stampede.Item[V]) (bool, error) {
+	v, err := c.encode(lease)
+	if err != nil {
+		return false, err
+	}
+	var claimed bool
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+		now := time.Now()
+		// a dead or undecodable entry is always replaced
+		if old := live(b.Get([]byte(key)), now); old != nil {
+			if old, err := c.codec.Unmarshal(old); err == nil && !old.Claimable(prev, now) {
+				return nil
+			}
+		}
+		claimed = true
+		return b.Put([]byte(key), v)
+	})
+	return claimed && err == nil, err
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(c.bucket).Delete([]byte(key))
+	})
+}
+
+// GetMulti implements stampede.BatchGetter in one read transaction
+func (c *Cache[V]) GetMulti(ctx context.Context, keys []string) (map[string]stampede.Item[V], error) {
+	items := make(map[string]stampede.Item[V], len(keys))
+	now := time.Now()
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+		for _, key := range keys {
+			v := live(b.Get([]byte(key)), now)
+			if v == nil {
+				continue
+			}
+			item, err := c.codec.Unmarshal(v)
+			if err != nil {
+				return err
+			}
+			items[key] = item
+		}
+		return nil
+	})
+	return items, err
+}
+
+// sweepBatch bounds the deletions per transaction, so the sweep does not hold
+// the write lock for long
+const sweepBatch = 1000
+
+// Sweep deletes dead entries
+func (c *Cache[V]) Sweep() error {
+	var from []byte
+	for {
+		now := time.Now()
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(c.bucket)
+			cur := b.Cursor()
+			k, v := cur.First()
+			if from != nil {
+				k, v = cur.Seek(from)
+			}
+			var dead [][]byte
+			for ; k != nil && len(dead) < sweepBatch; k, v = cur.Next() {
+				if live(v, now) == nil {
+					dead = append(dead, k)
+				}
+			}
+			// deleting under the cursor would skip entries
+			for _, k := range dead {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			// the cursor is only valid within the transaction
+			from = append([]byte(nil), k...)
+			return nil
+		})
+		if err != nil || len(from) == 0 {
+			return err
+		}
+	}
+}
+
+func (c *Cache[V]) sweeper() {
+	defer c.wg.Done()
+	t := time.NewTicker(c.sweep)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			// failures are retried next time
+			c.Sweep()
+		}
+	}
+}
+
+// encode prefixes the encoding of item with its death time
+func (c *Cache[V]) encode(item stampede.Item[V]) ([]byte, error) {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var dead int64 = math.MaxInt64 // never expires
+	switch {
+	case !item.HardExpiry.IsZero():
+		// the item is useless past its hard expiry
+		dead = item.HardExpiry.UnixNano()
+	case !item.Expiry.IsZero():
+		dead = item.Expiry.Add(c.grace).UnixNano()
+	}
+	v := make([]byte, 8, 8+len(b))
+	binary.BigEndian.PutUint64(v, uint64(max(dead, 0)))
+	return append(v, b...), nil
+}
+
+// live returns a copy of the encoded item in the stored value v, or nil if it
+// is absent or dead at now
+func live(v []byte, now time.Time) []byte {
+	if len(v) < 8 {
+		return nil
+	}
+	if int64(binary.BigEndian.Uint64(v)) < now.UnixNano() {
+		return nil
+	}
+	return append([]byte(nil), v[8:]...)
+}
@@ -0,0 +1,86 @@
+package boltcache_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/boltcache"
+	"github.com/dgryski/go-stampede/cachetest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// open returns a bbolt database in a temporary directory, closed when the test
+// ends
+func open(t *testing.T, path string) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newCache(t *testing.T, db *bolt.DB, opts ...boltcache.Option) *boltcache.Cache[string] {
+	t.Helper()
+	c, err := boltcache.New(db, stampede.JSONCodec[string]{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return newCache(t, open(t, filepath.Join(t.TempDir(), "bolt.db")))
+	})
+}
+
+func TestDeadEntries(t *testing.T) {
+	ctx := context.Background()
+	c := newCache(t, open(t, filepath.Join(t.TempDir(), "bolt.db")), boltcache.WithGrace(time.Hour), boltcache.WithSweepInterval(0))
+	now := time.Now()
+	items := map[string]stampede.Item[string]{
+		"stale":   {Value: "stale", Expiry: now.Add(-time.Minute)},
+		"dead":    {Value: "dead", Expiry: now.Add(-2 * time.Hour)},
+		"hard":    {Value: "hard", Expiry: now.Add(-time.Minute), HardExpiry: now.Add(-time.Second)},
+		"forever": {Value: "forever"},
+	}
+	for key, item := range items {
+		if err := c.Set(ctx, key, item); err != nil {
+			t.Fatalf("Set(%q) = %v", key, err)
+		}
+	}
+	if err := c.Sweep(); err != nil {
+		t.Fatalf("Sweep = %v", err)
+	}
+	for key, live := range map[string]bool{"stale": true, "dead": false, "hard": false, "forever": true} {
+		item, err := c.Get(ctx, key)
+		if live && (err != nil || item.Value != key) {
+			t.Errorf("Get(%q) = %+v, %v; want it kept", key, item, err)
+		}
+		if !live && !errors.Is(err, stampede.ErrCacheMiss) {
+			t.Errorf("Get(%q) = %+v, %v; want it dead", key, item, err)
+		}
+	}
+}
+
+func TestPersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bolt.db")
+	db := open(t, path)
+	c := newCache(t, db, boltcache.WithBucket("b"))
+	c.Set(ctx, "k", stampede.Item[string]{Value: "value", Expiry: time.Now().Add(time.Hour)})
+	c.Close()
+	db.Close()
+
+	c = newCache(t, open(t, path), boltcache.WithBucket("b"))
+	if item, err := c.Get(ctx, "k"); err != nil || item.Value != "value" {
+		t.Fatalf("Get after reopening = %+v, %v", item, err)
+	}
+}
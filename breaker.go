@@ -0,0 +1,140 @@
+package stampede
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a recompute circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets recomputes through
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen short-circuits recomputes until the cool-down elapses
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single trial recompute through
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerMetrics may be implemented by a Metrics to observe circuit breaker
+// state changes
+type BreakerMetrics interface {
+	BreakerStateChange(group string, state BreakerState)
+}
+
+// breakerGroup holds one circuit breaker per key group
+type breakerGroup[K comparable] struct {
+	threshold int
+	cooldown  time.Duration
+	group     func(key K) string
+	onChange  func(group string, state BreakerState)
+
+	mu sync.Mutex
+	m  map[string]*breaker
+}
+
+type breaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a recompute of key may proceed at now, and whether
+// it is the trial of a half-open breaker, which must be recorded or
+// abandoned
+func (g *breakerGroup[K]) allow(key K, now time.Time) (ok, trial bool) {
+	name := g.name(key)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b := g.m[name]
+	if b == nil {
+		return true, false
+	}
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < g.cooldown {
+			return false, false
+		}
+		g.set(name, b, BreakerHalfOpen)
+		return true, true
+	case BreakerHalfOpen:
+		// a trial is already in flight
+		return false, false
+	}
+	return true, false
+}
+
+// abandon gives back the trial of key's half-open breaker, let through by
+// allow for a recompute which then did not run.  The breaker reopens with its
+// cool-down elapsed, so the next recompute is the trial.
+func (g *breakerGroup[K]) abandon(key K) {
+	name := g.name(key)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if b := g.m[name]; b != nil && b.state == BreakerHalfOpen {
+		g.set(name, b, BreakerOpen)
+	}
+}
+
+// record notes the outcome of a recompute of key at now
+func (g *breakerGroup[K]) record(key K, failed bool, now time.Time) {
+	name := g.name(key)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b := g.m[name]
+	if b == nil {
+		if !failed {
+			return
+		}
+		b = &breaker{}
+		g.m[name] = b
+	}
+
+	if !failed {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			g.set(name, b, BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= g.threshold {
+		b.openedAt = now
+		if b.state != BreakerOpen {
+			g.set(name, b, BreakerOpen)
+		}
+	}
+}
+
+func (g *breakerGroup[K]) set(name string, b *breaker, state BreakerState) {
+	b.state = state
+	if g.onChange != nil {
+		g.onChange(name, state)
+	}
+}
+
+func (g *breakerGroup[K]) name(key K) string {
+	if g.group == nil {
+		return ""
+	}
+	return g.group(key)
+}
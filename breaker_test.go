@@ -0,0 +1,121 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+var errOrigin = errors.New("origin down")
+
+func failing(ctx context.Context) (int, time.Duration, error) {
+	return 0, 0, errOrigin
+}
+
+func succeeding(ctx context.Context) (int, time.Duration, error) {
+	return 1, time.Minute, nil
+}
+
+func byKey(key string) string { return key }
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithCircuitBreaker(2, time.Minute, byKey),
+	)
+
+	for range 2 {
+		if _, err := xf.Fetch(ctx, "a", failing); !errors.Is(err, errOrigin) {
+			t.Fatalf("Fetch = %v, want %v", err, errOrigin)
+		}
+	}
+	if _, err := xf.Fetch(ctx, "a", succeeding); !errors.Is(err, stampede.ErrCircuitOpen) {
+		t.Fatalf("Fetch of open breaker = %v, want ErrCircuitOpen", err)
+	}
+	if _, err := xf.Fetch(ctx, "b", succeeding); err != nil {
+		t.Fatalf("Fetch of other group = %v", err)
+	}
+
+	// a failed trial reopens the breaker
+	clock.Advance(time.Minute)
+	if _, err := xf.Fetch(ctx, "a", failing); !errors.Is(err, errOrigin) {
+		t.Fatalf("trial Fetch = %v, want %v", err, errOrigin)
+	}
+	if _, err := xf.Fetch(ctx, "a", succeeding); !errors.Is(err, stampede.ErrCircuitOpen) {
+		t.Fatalf("Fetch after failed trial = %v, want ErrCircuitOpen", err)
+	}
+
+	clock.Advance(time.Minute)
+	for range 2 {
+		if v, err := xf.Fetch(ctx, "a", succeeding); err != nil || v != 1 {
+			t.Fatalf("Fetch after successful trial = %v, %v", v, err)
+		}
+	}
+}
+
+func TestBreakerTrialRefusedCapacity(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithCircuitBreaker(1, time.Minute, byKey),
+		stampede.WithMaxConcurrentRecomputes(1, stampede.LimitError),
+	)
+
+	xf.Fetch(ctx, "a", failing)
+	clock.Advance(time.Minute)
+
+	// hold the only recompute slot
+	started, unblock, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		xf.Fetch(ctx, "b", func(ctx context.Context) (int, time.Duration, error) {
+			close(started)
+			<-unblock
+			return 1, time.Minute, nil
+		})
+	}()
+	<-started
+
+	if _, err := xf.Fetch(ctx, "a", succeeding); !errors.Is(err, stampede.ErrOverCapacity) {
+		t.Fatalf("Fetch over capacity = %v, want ErrOverCapacity", err)
+	}
+	close(unblock)
+	<-done
+
+	// the refused recompute must not have used up the trial
+	if _, err := xf.Fetch(ctx, "a", succeeding); err != nil {
+		t.Fatalf("trial Fetch = %v", err)
+	}
+}
+
+func TestBreakerTrialRefusedRateLimit(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithCircuitBreaker(1, time.Minute, byKey),
+		stampede.WithRateLimit(1, 1, stampede.LimitError),
+	)
+
+	xf.Fetch(ctx, "a", failing)
+	clock.Advance(time.Minute)
+	xf.Fetch(ctx, "b", succeeding)
+
+	if _, err := xf.Fetch(ctx, "a", succeeding); !errors.Is(err, stampede.ErrRateLimited) {
+		t.Fatalf("Fetch over rate = %v, want ErrRateLimited", err)
+	}
+
+	// the refused recompute must not have used up the trial
+	clock.Advance(time.Second)
+	if _, err := xf.Fetch(ctx, "a", succeeding); err != nil {
+		t.Fatalf("trial Fetch = %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+)
+
+// errOverBudget is reported to OnStaleServed when a cached value is served
+// because the recompute exceeded the latency budget
+var errOverBudget = errors.New("stampede: recompute over latency budget")
+
+type recomputeResult[V any] struct {
+	item   Item[V]
+	shared bool
+	err    error
+}
+
+// budgetedRecompute runs the recompute of key in the background and waits
+// for it up to the latency budget in fc.  If it does not finish in time, or
+// ctx is done first, late is set and the recompute carries on to refresh the
+// cache.
+func (xf *XFetcher[K, V]) budgetedRecompute(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V], fc *fetchConfig) (r recomputeResult[V], late bool) {
+	done := make(chan recomputeResult[V], 1)
+	bctx := context.WithoutCancel(ctx)
+	started := xf.goBackground(func() {
+		var r recomputeResult[V]
+		r.item, r.shared, r.err = xf.recompute(bctx, key, recompute, prev)
+		done <- r
+	})
+	if !started {
+		// closed, so nothing may outlive the call
+		r.item, r.shared, r.err = xf.recompute(ctx, key, recompute, prev)
+		return r, false
+	}
+
+	select {
+	case r = <-done:
+		return r, false
+	case <-xf.clock.After(fc.latencyBudget):
+	case <-ctx.Done():
+	}
+	return r, true
+}
+
+// serveStale returns the result of serving the cached item in place of a
+// recompute, which could not be used because of err
+func (xf *XFetcher[K, V]) serveStale(ctx context.Context, key K, item Item[V], err error) Result[V] {
+	if !item.Expired(xf.clock.Now()) {
+		return xf.result(item, SourceCache)
+	}
+	fire(xf.hooks.OnStaleServed, StaleEvent[K]{Key: key, Expiry: item.Expiry, Err: err})
+	xf.stats.stale.Add(1)
+	xf.logStaleServed(ctx, key, item, err)
+	return xf.result(item, SourceStale)
+}
@@ -0,0 +1,134 @@
+package stampede_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// blocked returns a recompute of v which waits for unblock to be closed
+func blocked(v int, unblock chan struct{}) stampede.RecomputeFunc[int] {
+	return func(ctx context.Context) (int, time.Duration, error) {
+		<-unblock
+		return v, time.Minute, nil
+	}
+}
+
+func TestLatencyBudget(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache,
+		stampede.WithClock(clock),
+		stampede.WithLatencyBudget(10*time.Millisecond),
+	)
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(2 * time.Minute)
+
+	// a slow recompute of an expired value serves it stale at the budget
+	unblock := make(chan struct{})
+	done := make(chan stampede.Result[int])
+	go func() {
+		r, _ := xf.FetchItem(ctx, "k", blocked(2, unblock))
+		done <- r
+	}()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(10 * time.Millisecond)
+	if r := <-done; r.Value != 1 || r.Source != stampede.SourceStale {
+		t.Fatalf("FetchItem over budget = %+v, want the stale 1", r)
+	}
+
+	// and carries on to refresh the cache
+	close(unblock)
+	if err := xf.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if item, _ := cache.Get(ctx, "k"); item.Value != 2 {
+		t.Errorf("cached after the late recompute = %d, want 2", item.Value)
+	}
+}
+
+func TestLatencyBudgetEarly(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(stampede.AlwaysExpire),
+		stampede.WithLatencyBudget(10*time.Millisecond),
+	)
+	xf.Fetch(ctx, "k", succeeding)
+
+	// an early expired value is served from the cache
+	unblock := make(chan struct{})
+	defer close(unblock)
+	done := make(chan stampede.Result[int])
+	go func() {
+		r, _ := xf.FetchItem(ctx, "k", blocked(2, unblock))
+		done <- r
+	}()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(10 * time.Millisecond)
+	if r := <-done; r.Value != 1 || r.Source != stampede.SourceCache {
+		t.Errorf("FetchItem over budget = %+v, want the cached 1", r)
+	}
+}
+
+func TestLatencyBudgetWithin(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithLatencyBudget(10*time.Millisecond),
+	)
+
+	// misses wait however long recomputing takes
+	if v, err := xf.Fetch(ctx, "k", taking(clock, time.Second)); err != nil || v != 1 {
+		t.Fatalf("Fetch of a miss = %d, %v", v, err)
+	}
+
+	// a recompute within the budget is served
+	clock.Advance(2 * time.Minute)
+	var calls atomic.Int32
+	calls.Store(1)
+	if r, err := xf.FetchItem(ctx, "k", counting(&calls, time.Minute)); err != nil || r.Value != 2 || r.Source != stampede.SourceRecompute {
+		t.Errorf("FetchItem within budget = %+v, %v; want the recomputed 2", r, err)
+	}
+}
+
+func TestFetchLatencyBudget(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithClock(clock))
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(2 * time.Minute)
+
+	unblock := make(chan struct{})
+	done := make(chan stampede.Result[int])
+	go func() {
+		r, _ := xf.FetchItem(ctx, "k", blocked(2, unblock), stampede.WithFetchLatencyBudget(10*time.Millisecond))
+		done <- r
+	}()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(10 * time.Millisecond)
+	if r := <-done; r.Source != stampede.SourceStale {
+		t.Fatalf("FetchItem with WithFetchLatencyBudget = %+v, want it served stale", r)
+	}
+	close(unblock)
+	xf.Close(ctx)
+
+	// zero waits even when the fetcher has a budget
+	xf = stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithLatencyBudget(10*time.Millisecond),
+	)
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(2 * time.Minute)
+	if r, _ := xf.FetchItem(ctx, "k", taking(clock, time.Second), stampede.WithFetchLatencyBudget(0)); r.Source != stampede.SourceRecompute {
+		t.Errorf("FetchItem without a budget = %+v, want it recomputed", r)
+	}
+}
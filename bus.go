@@ -0,0 +1,14 @@
+package stampede
+
+import "context"
+
+// InvalidationBus broadcasts invalidated keys between processes, so each can
+// evict them from its in-process cache tier
+type InvalidationBus[K comparable] interface {
+	// Publish announces that key has been invalidated
+	Publish(ctx context.Context, key K) error
+
+	// Subscribe calls fn for every key published, including by this
+	// process, until ctx is done or the subscription fails
+	Subscribe(ctx context.Context, fn func(key K)) error
+}
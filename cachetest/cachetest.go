@@ -0,0 +1,409 @@
+// Package cachetest checks that stampede.Cache implementations meet the
+// contract expected by the XFetcher
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// TestCache runs the conformance tests against caches returned by newCache,
+// which is called once per subtest.  Implementations generic in the value
+// type should be instantiated with string values.  Caches implementing
+// stampede.Deleter, stampede.BatchGetter, stampede.BatchSetter,
+// stampede.ConditionalSetter and stampede.LeaseClaimer are also tested for
+// those.
+//
+// Keys are prefixed with the subtest name, so caches may share a backend.
+// Time fields need only survive the round trip to the millisecond.
+func TestCache(t *testing.T, newCache func(t *testing.T) stampede.Cache[string, string]) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, c stampede.Cache[string, string], key func(string) string)
+	}{
+		{"Miss", testMiss},
+		{"RoundTrip", testRoundTrip},
+		{"Overwrite", testOverwrite},
+		{"CachedError", testCachedError},
+		{"Concurrent", testConcurrent},
+		{"Delete", testDelete},
+		{"GetMulti", testGetMulti},
+		{"SetMulti", testSetMulti},
+		{"SetIfNewer", testSetIfNewer},
+		{"ClaimLease", testClaimLease},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := func(k string) string { return "cachetest/" + t.Name() + "/" + k }
+			tt.fn(t, newCache(t), key)
+		})
+	}
+}
+
+func item(v string) stampede.Item[string] {
+	now := time.Now()
+	return stampede.Item[string]{
+		Value:   v,
+		Expiry:  now.Add(time.Hour),
+		Delta:   250 * time.Millisecond,
+		Created: now,
+	}
+}
+
+func testMiss(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	_, err := c.Get(context.Background(), key("absent"))
+	if !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Fatalf("Get(absent) error = %v, want ErrCacheMiss", err)
+	}
+}
+
+func testRoundTrip(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	ctx := context.Background()
+	want := item("value")
+	if err := c.Set(ctx, key("k"), want); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, err := c.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkItem(t, got, want)
+}
+
+func testOverwrite(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	ctx := context.Background()
+	if err := c.Set(ctx, key("k"), item("old")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	want := item("new")
+	want.Delta = time.Second
+	if err := c.Set(ctx, key("k"), want); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, err := c.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkItem(t, got, want)
+}
+
+func testCachedError(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	ctx := context.Background()
+	want := item("")
+	want.Err = errors.New("not found")
+	if err := c.Set(ctx, key("k"), want); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, err := c.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Err == nil || got.Err.Error() != want.Err.Error() {
+		t.Errorf("Err = %v, want %v", got.Err, want.Err)
+	}
+}
+
+func testConcurrent(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	const (
+		workers = 8
+		ops     = 100
+	)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ops {
+				// half the keys are shared between workers
+				k := key(fmt.Sprint(i % 10))
+				if i%2 == 1 {
+					k = key(fmt.Sprint(w, "/", i%10))
+				}
+				if err := c.Set(ctx, k, item(k)); err != nil {
+					t.Errorf("Set(%q): %v", k, err)
+					return
+				}
+				got, err := c.Get(ctx, k)
+				if err != nil {
+					t.Errorf("Get(%q): %v", k, err)
+					return
+				}
+				if got.Value != k {
+					t.Errorf("Get(%q) = %q, want %q", k, got.Value, k)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func testDelete(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	d, ok := c.(stampede.Deleter[string])
+	if !ok {
+		t.Skip("cache does not implement Deleter")
+	}
+	ctx := context.Background()
+	if err := c.Set(ctx, key("k"), item("value")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := d.Delete(ctx, key("k")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := c.Get(ctx, key("k")); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("Get after Delete error = %v, want ErrCacheMiss", err)
+	}
+	if err := d.Delete(ctx, key("absent")); err != nil {
+		t.Errorf("Delete(absent): %v", err)
+	}
+}
+
+func testGetMulti(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	bg, ok := c.(stampede.BatchGetter[string, string])
+	if !ok {
+		t.Skip("cache does not implement BatchGetter")
+	}
+	ctx := context.Background()
+	want := map[string]stampede.Item[string]{
+		key("a"): item("a"),
+		key("b"): item("b"),
+	}
+	for k, it := range want {
+		if err := c.Set(ctx, k, it); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	got, err := bg.GetMulti(ctx, []string{key("a"), key("absent"), key("b")})
+	if err != nil {
+		t.Fatalf("GetMulti: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("GetMulti returned %d items, want %d", len(got), len(want))
+	}
+	for k, w := range want {
+		g, ok := got[k]
+		if !ok {
+			t.Errorf("GetMulti missing %q", k)
+			continue
+		}
+		checkItem(t, g, w)
+	}
+}
+
+func testSetMulti(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	bs, ok := c.(stampede.BatchSetter[string, string])
+	if !ok {
+		t.Skip("cache does not implement BatchSetter")
+	}
+	ctx := context.Background()
+	if err := c.Set(ctx, key("b"), item("old")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	want := map[string]stampede.Item[string]{
+		key("a"): item("a"),
+		key("b"): item("b"),
+	}
+	if err := bs.SetMulti(ctx, want); err != nil {
+		t.Fatalf("SetMulti: %v", err)
+	}
+	for k, w := range want {
+		g, err := c.Get(ctx, k)
+		if err != nil {
+			t.Errorf("Get(%q): %v", k, err)
+			continue
+		}
+		checkItem(t, g, w)
+	}
+}
+
+func testClaimLease(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	lc, ok := c.(stampede.LeaseClaimer[string, string])
+	if !ok {
+		t.Skip("cache does not implement LeaseClaimer")
+	}
+	ctx := context.Background()
+	lease := func(owner string, prev *stampede.Item[string]) stampede.Item[string] {
+		var it stampede.Item[string]
+		if prev != nil {
+			it = *prev
+		}
+		it.Pending = &stampede.Pending{Owner: owner, Until: time.Now().Add(time.Minute), Empty: prev == nil}
+		if prev == nil {
+			it.Expiry = it.Pending.Until
+		}
+		return it
+	}
+
+	ok, err := lc.ClaimLease(ctx, key("absent"), nil, lease("a", nil))
+	if err != nil || !ok {
+		t.Fatalf("ClaimLease(absent) = %v, %v; want true", ok, err)
+	}
+	ok, err = lc.ClaimLease(ctx, key("absent"), nil, lease("b", nil))
+	if err != nil || ok {
+		t.Errorf("ClaimLease of a leased key = %v, %v; want false", ok, err)
+	}
+
+	old := item("old")
+	if err := c.Set(ctx, key("k"), old); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	prev, err := c.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	ok, err = lc.ClaimLease(ctx, key("k"), &prev, lease("a", &prev))
+	if err != nil || !ok {
+		t.Fatalf("ClaimLease(k) = %v, %v; want true", ok, err)
+	}
+	ok, err = lc.ClaimLease(ctx, key("k"), &prev, lease("b", &prev))
+	if err != nil || ok {
+		t.Errorf("second ClaimLease(k) = %v, %v; want false", ok, err)
+	}
+	got, err := c.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Pending == nil || got.Pending.Owner != "a" {
+		t.Errorf("Get returned lease %+v, want owner a", got.Pending)
+	}
+	if got.Value != old.Value {
+		t.Errorf("Get returned value %q, want %q", got.Value, old.Value)
+	}
+}
+
+func testSetIfNewer(t *testing.T, c stampede.Cache[string, string], key func(string) string) {
+	cs, ok := c.(stampede.ConditionalSetter[string, string])
+	if !ok {
+		t.Skip("cache does not implement ConditionalSetter")
+	}
+	ctx := context.Background()
+	older, newer := item("older"), item("newer")
+	older.Created = newer.Created.Add(-time.Second)
+
+	if stored, err := cs.SetIfNewer(ctx, key("k"), newer); err != nil || !stored {
+		t.Fatalf("SetIfNewer(absent) = %v, %v; want true, nil", stored, err)
+	}
+	if stored, err := cs.SetIfNewer(ctx, key("k"), older); err != nil || stored {
+		t.Fatalf("SetIfNewer(older) = %v, %v; want false, nil", stored, err)
+	}
+	got, err := c.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkItem(t, got, newer)
+}
+
+// checkItem compares the fields of got and want, allowing time fields to be
+// rounded to the millisecond
+func checkItem(t *testing.T, got, want stampede.Item[string]) {
+	t.Helper()
+	if got.Value != want.Value {
+		t.Errorf("Value = %q, want %q", got.Value, want.Value)
+	}
+	if !closeTime(got.Expiry, want.Expiry) {
+		t.Errorf("Expiry = %v, want %v", got.Expiry, want.Expiry)
+	}
+	if !closeTime(got.Created, want.Created) {
+		t.Errorf("Created = %v, want %v", got.Created, want.Created)
+	}
+	if d := got.Delta - want.Delta; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("Delta = %v, want %v", got.Delta, want.Delta)
+	}
+	if got.Err != nil {
+		t.Errorf("Err = %v, want nil", got.Err)
+	}
+}
+
+func closeTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+// TestCodec checks that c round-trips values, cached errors and placeholders
+// with all their metadata, and fails on malformed input.  Codecs generic in
+// the value type should be instantiated with string values.
+func TestCodec(t *testing.T, c stampede.Codec[string]) {
+	now := time.Now()
+	full := item("value")
+	full.HardExpiry = now.Add(2 * time.Hour)
+	cachedErr := item("")
+	cachedErr.Err = errors.New("not found")
+	carrying := item("previous")
+	carrying.Pending = &stampede.Pending{Owner: "owner", Until: now.Add(time.Minute)}
+
+	tests := []struct {
+		name string
+		item stampede.Item[string]
+	}{
+		{"Value", full},
+		{"Zero", stampede.Item[string]{}},
+		{"Empty", item("")},
+		{"CachedError", cachedErr},
+		{"Placeholder", stampede.Item[string]{Expiry: now.Add(time.Minute), Pending: &stampede.Pending{Owner: "owner", Until: now.Add(time.Minute), Empty: true}}},
+		{"CarryingPlaceholder", carrying},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := c.Marshal(tt.item)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			got, err := c.Unmarshal(b)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			checkDecoded(t, got, tt.item)
+		})
+	}
+
+	t.Run("Malformed", func(t *testing.T) {
+		b, err := c.Marshal(full)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		for _, bad := range [][]byte{nil, b[:len(b)/2]} {
+			if _, err := c.Unmarshal(bad); err == nil {
+				t.Errorf("Unmarshal(%q) succeeded, want an error", bad)
+			}
+		}
+	})
+}
+
+// checkDecoded compares every field of the decoded item got with want
+func checkDecoded(t *testing.T, got, want stampede.Item[string]) {
+	t.Helper()
+	if got.Value != want.Value {
+		t.Errorf("Value = %q, want %q", got.Value, want.Value)
+	}
+	for _, f := range []struct {
+		name      string
+		got, want time.Time
+	}{
+		{"Expiry", got.Expiry, want.Expiry},
+		{"HardExpiry", got.HardExpiry, want.HardExpiry},
+		{"Created", got.Created, want.Created},
+	} {
+		if f.got.IsZero() != f.want.IsZero() || !closeTime(f.got, f.want) && !f.want.IsZero() {
+			t.Errorf("%s = %v, want %v", f.name, f.got, f.want)
+		}
+	}
+	if got.Delta != want.Delta {
+		t.Errorf("Delta = %v, want %v", got.Delta, want.Delta)
+	}
+	if (got.Err == nil) != (want.Err == nil) || got.Err != nil && got.Err.Error() != want.Err.Error() {
+		t.Errorf("Err = %v, want %v", got.Err, want.Err)
+	}
+	switch gp, wp := got.Pending, want.Pending; {
+	case (gp == nil) != (wp == nil):
+		t.Errorf("Pending = %+v, want %+v", gp, wp)
+	case gp != nil && (gp.Owner != wp.Owner || gp.Empty != wp.Empty || !closeTime(gp.Until, wp.Until)):
+		t.Errorf("Pending = %+v, want %+v", gp, wp)
+	}
+}
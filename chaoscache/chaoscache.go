@@ -0,0 +1,157 @@
+// Package chaoscache wraps a stampede.Cache to inject failures, for testing
+// stale-if-error, retry and write failure policies
+package chaoscache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// ErrInjected is the default error returned by injected failures
+var ErrInjected = errors.New("chaoscache: injected failure")
+
+// Cache is a stampede.Cache which delays, fails or drops operations on an
+// underlying cache at random
+type Cache[K comparable, V any] struct {
+	inner stampede.Cache[K, V]
+	config
+
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	minLatency  time.Duration
+	maxLatency  time.Duration
+	readErrors  float64
+	writeErrors float64
+	drops       float64
+	err         error
+	seed        int64
+}
+
+// WithLatency delays every operation by a uniformly random duration between
+// min and max
+func WithLatency(min, max time.Duration) Option {
+	return func(c *config) {
+		c.minLatency = min
+		c.maxLatency = max
+	}
+}
+
+// WithReadErrorRate fails the fraction p of reads
+func WithReadErrorRate(p float64) Option {
+	return func(c *config) { c.readErrors = p }
+}
+
+// WithWriteErrorRate fails the fraction p of writes and deletes
+func WithWriteErrorRate(p float64) Option {
+	return func(c *config) { c.writeErrors = p }
+}
+
+// WithDropRate silently discards the fraction p of writes, reporting success
+func WithDropRate(p float64) Option {
+	return func(c *config) { c.drops = p }
+}
+
+// WithError sets the error returned by injected failures.  The default is
+// ErrInjected.
+func WithError(err error) Option {
+	return func(c *config) { c.err = err }
+}
+
+// WithSeed seeds the random source, so failures can be reproduced.  The
+// default is 1.
+func WithSeed(seed int64) Option {
+	return func(c *config) { c.seed = seed }
+}
+
+// New returns a Cache injecting failures into inner
+func New[K comparable, V any](inner stampede.Cache[K, V], opts ...Option) *Cache[K, V] {
+	c := &Cache[K, V]{inner: inner, config: config{err: ErrInjected, seed: 1}}
+	for _, o := range opts {
+		o(&c.config)
+	}
+	c.r = rand.New(rand.NewSource(c.seed))
+	return c
+}
+
+// Get implements stampede.Cache
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (stampede.Item[V], error) {
+	if err := c.delay(ctx); err != nil {
+		return stampede.Item[V]{}, err
+	}
+	if c.chance(c.readErrors) {
+		return stampede.Item[V]{}, c.err
+	}
+	return c.inner.Get(ctx, key)
+}
+
+// Set implements stampede.Cache
+func (c *Cache[K, V]) Set(ctx context.Context, key K, item stampede.Item[V]) error {
+	if err := c.delay(ctx); err != nil {
+		return err
+	}
+	if c.chance(c.writeErrors) {
+		return c.err
+	}
+	if c.chance(c.drops) {
+		return nil
+	}
+	return c.inner.Set(ctx, key, item)
+}
+
+// Delete implements stampede.Deleter, returning stampede.ErrDeleteUnsupported
+// if the underlying cache does not
+func (c *Cache[K, V]) Delete(ctx context.Context, key K) error {
+	d, ok := c.inner.(stampede.Deleter[K])
+	if !ok {
+		return stampede.ErrDeleteUnsupported
+	}
+	if err := c.delay(ctx); err != nil {
+		return err
+	}
+	if c.chance(c.writeErrors) {
+		return c.err
+	}
+	return d.Delete(ctx, key)
+}
+
+// chance reports true with probability p
+func (c *Cache[K, V]) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.r.Float64() < p
+}
+
+// delay sleeps for the injected latency, or until ctx is done
+func (c *Cache[K, V]) delay(ctx context.Context) error {
+	if c.maxLatency <= 0 {
+		return nil
+	}
+	d := c.minLatency
+	if c.maxLatency > c.minLatency {
+		c.mu.Lock()
+		d += time.Duration(c.r.Int63n(int64(c.maxLatency - c.minLatency)))
+		c.mu.Unlock()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,130 @@
+package chaoscache_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/chaoscache"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestCache(t *testing.T) {
+	// with no failures configured it is transparent
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return chaoscache.New[string, string](memcache.New[string, string]())
+	})
+}
+
+// rate returns the fraction of n calls of op failing with err
+func rate(n int, err error, op func() error) float64 {
+	fails := 0
+	for range n {
+		if errors.Is(op(), err) {
+			fails++
+		}
+	}
+	return float64(fails) / float64(n)
+}
+
+func TestErrorRates(t *testing.T) {
+	ctx := context.Background()
+	const n = 10000
+	errBoom := errors.New("boom")
+	inner := memcache.New[string, string]()
+	c := chaoscache.New[string, string](inner,
+		chaoscache.WithReadErrorRate(0.25),
+		chaoscache.WithWriteErrorRate(0.5),
+		chaoscache.WithError(errBoom),
+	)
+	inner.Set(ctx, "k", stampede.Item[string]{Value: "v"})
+
+	for _, tt := range []struct {
+		name string
+		want float64
+		op   func() error
+	}{
+		{"Get", 0.25, func() error { _, err := c.Get(ctx, "k"); return err }},
+		{"Set", 0.5, func() error { return c.Set(ctx, "k", stampede.Item[string]{Value: "v"}) }},
+		{"Delete", 0.5, func() error { return c.Delete(ctx, "absent") }},
+	} {
+		if got := rate(n, errBoom, tt.op); math.Abs(got-tt.want) > 0.03 {
+			t.Errorf("%s failed %.3f of the time, want %.2f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDropRate(t *testing.T) {
+	ctx := context.Background()
+	inner := memcache.New[int, int]()
+	c := chaoscache.New[int, int](inner, chaoscache.WithDropRate(0.5))
+
+	const n = 10000
+	for i := range n {
+		if err := c.Set(ctx, i, stampede.Item[int]{Value: i}); err != nil {
+			t.Fatalf("Set = %v, want drops reported as success", err)
+		}
+	}
+	stored := 0
+	for i := range n {
+		if _, err := inner.Get(ctx, i); err == nil {
+			stored++
+		}
+	}
+	if got := float64(stored) / n; math.Abs(got-0.5) > 0.03 {
+		t.Errorf("stored %.3f of writes, want 0.5", got)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	pattern := func(seed int64) []bool {
+		c := chaoscache.New[string, string](memcache.New[string, string](),
+			chaoscache.WithReadErrorRate(0.5), chaoscache.WithSeed(seed))
+		var p []bool
+		for range 64 {
+			_, err := c.Get(ctx, "k")
+			p = append(p, errors.Is(err, chaoscache.ErrInjected))
+		}
+		return p
+	}
+	a, b, other := pattern(7), pattern(7), pattern(8)
+	same, differs := true, false
+	for i := range a {
+		same = same && a[i] == b[i]
+		differs = differs || a[i] != other[i]
+	}
+	if !same || !differs {
+		t.Errorf("failures reproducible %v, vary by seed %v", same, differs)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	ctx := context.Background()
+	c := chaoscache.New[string, string](memcache.New[string, string](),
+		chaoscache.WithLatency(20*time.Millisecond, 30*time.Millisecond))
+
+	start := time.Now()
+	c.Get(ctx, "k")
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("Get took %v, want at least 20ms", d)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := c.Set(cctx, "k", stampede.Item[string]{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set with an expiring context = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestDeleteUnsupported(t *testing.T) {
+	inner := struct{ stampede.Cache[string, string] }{memcache.New[string, string]()}
+	c := chaoscache.New[string, string](inner)
+	if err := c.Delete(context.Background(), "k"); !errors.Is(err, stampede.ErrDeleteUnsupported) {
+		t.Errorf("Delete = %v, want ErrDeleteUnsupported", err)
+	}
+}
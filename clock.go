@@ -0,0 +1,21 @@
+package stampede
+
+import "time"
+
+// Clock is the source of time for an XFetcher
+type Clock interface {
+	Now() time.Time
+
+	// After waits for d to elapse and then sends the current time on the
+	// returned channel, as time.After does
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock backed by the time package
+type SystemClock struct{}
+
+// Now implements Clock
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// After implements Clock
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,173 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is passed to the write error handler for a write retry abandoned
+// because the XFetcher was closed
+var ErrClosed = errors.New("stampede: fetcher closed")
+
+// lifecycle tracks the background work of an XFetcher for Close
+type lifecycle struct {
+	// mu is held for reading while counting new work, and for writing while
+	// closing.  It is never held while blocking, so Close keeps to its
+	// deadline.
+	mu     sync.RWMutex
+	closed atomic.Bool
+
+	// done is closed by Close, abandoning writes waiting for room in the
+	// queue
+	done chan struct{}
+
+	wg sync.WaitGroup
+
+	// senders counts the writes being queued, which must finish before the
+	// queue is closed
+	senders sync.WaitGroup
+	closers []func(ctx context.Context) error
+}
+
+// heldLocks records the Locker locks taken by in-flight recomputes
+type heldLocks[K comparable] struct {
+	mu sync.Mutex
+	m  map[K]string
+}
+
+// Close shuts down the fetcher's background work.  It stops starting
+// stale-while-revalidate refreshes and write retries, discards queued
+// refreshes, closes the Refreshers and namespaces created from it, lets the
+// asynchronous writers drain their queue, and waits for in-flight recomputes
+// and writes until ctx is done.  Writes waiting for room in the queue are
+// made synchronously instead.  Locks still held by recomputes are then
+// released, and ctx's error returned.
+//
+// Fetches remain possible after Close, but do all their work in the
+// foreground.
+func (xf *XFetcher[K, V]) Close(ctx context.Context) error {
+	xf.life.mu.Lock()
+	if xf.life.closed.Load() {
+		xf.life.mu.Unlock()
+		return nil
+	}
+	xf.life.closed.Store(true)
+	close(xf.life.done)
+	closers := xf.life.closers
+	xf.life.mu.Unlock()
+
+	var errs []error
+	for _, fn := range closers {
+		errs = append(errs, fn(ctx))
+	}
+
+	if xf.writes != nil {
+		// the writers are counted in wg, and drain the queue once the last
+		// write being queued is in
+		go func() {
+			xf.life.senders.Wait()
+			close(xf.writes)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		xf.life.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+
+	errs = append(errs, xf.releaseLocks(context.WithoutCancel(ctx)))
+	return errors.Join(errs...)
+}
+
+// onClose registers fn to be called by Close, or calls it now if the fetcher
+// is already closed
+func (xf *XFetcher[K, V]) onClose(fn func(ctx context.Context) error) {
+	xf.life.mu.Lock()
+	if !xf.life.closed.Load() {
+		xf.life.closers = append(xf.life.closers, fn)
+		xf.life.mu.Unlock()
+		return
+	}
+	xf.life.mu.Unlock()
+	fn(context.Background())
+}
+
+// track counts a unit of work for Close to wait on, which must be ended by
+// calling xf.life.wg.Done.  It reports false, and counts nothing, once the
+// fetcher is closed.
+func (xf *XFetcher[K, V]) track() bool {
+	return xf.life.add(&xf.life.wg)
+}
+
+// add counts a unit of work in wg, unless the fetcher is closed.  The count
+// is taken under mu so that Close does not wait on wg before it is added to.
+func (l *lifecycle) add(wg *sync.WaitGroup) bool {
+	if l.closed.Load() {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed.Load() {
+		return false
+	}
+	wg.Add(1)
+	return true
+}
+
+// goBackground runs fn in a new goroutine tracked for Close, unless the
+// fetcher is closed
+func (xf *XFetcher[K, V]) goBackground(fn func()) bool {
+	if !xf.track() {
+		return false
+	}
+	go func() {
+		defer xf.life.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// hold records that this process holds the lock on key
+func (xf *XFetcher[K, V]) hold(key K, token string) {
+	xf.held.mu.Lock()
+	if xf.held.m == nil {
+		xf.held.m = make(map[K]string)
+	}
+	xf.held.m[key] = token
+	xf.held.mu.Unlock()
+}
+
+// unlock releases the lock on key, if still held
+func (xf *XFetcher[K, V]) unlock(ctx context.Context, key K, token string) {
+	xf.held.mu.Lock()
+	held := xf.held.m[key] == token
+	if held {
+		delete(xf.held.m, key)
+	}
+	xf.held.mu.Unlock()
+	if held {
+		xf.locker.Unlock(ctx, key, token)
+	}
+}
+
+// releaseLocks releases all the locks still held
+func (xf *XFetcher[K, V]) releaseLocks(ctx context.Context) error {
+	xf.held.mu.Lock()
+	held := xf.held.m
+	xf.held.m = nil
+	xf.held.mu.Unlock()
+
+	var errs []error
+	for key, token := range held {
+		errs = append(errs, xf.locker.Unlock(ctx, key, token))
+	}
+	return errors.Join(errs...)
+}
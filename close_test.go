@@ -0,0 +1,85 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestCloseWaits(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithStaleWhileRevalidate(true),
+		stampede.WithRand(stampede.AlwaysExpire),
+	)
+	xf.Fetch(ctx, "k", succeeding)
+
+	// a revalidation is in flight
+	unblock := make(chan struct{})
+	xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		<-unblock
+		return 2, time.Minute, nil
+	})
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := xf.Close(tctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close with a recompute in flight = %v, want context.DeadlineExceeded", err)
+	}
+	close(unblock)
+	if err := xf.Close(ctx); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestFetchAfterClose(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithStaleWhileRevalidate(true),
+		stampede.WithRand(stampede.AlwaysExpire),
+	)
+	var calls atomic.Int32
+	xf.Fetch(ctx, "k", counting(&calls, time.Minute))
+	if err := xf.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+
+	// an early expired value is served without starting a revalidation
+	if v, err := xf.Fetch(ctx, "k", counting(&calls, time.Minute)); err != nil || v != 1 || calls.Load() != 1 {
+		t.Errorf("Fetch after Close = %d, %v after %d recomputes; want the cached 1", v, err, calls.Load())
+	}
+
+	// an expired one is recomputed in the foreground
+	clock.Advance(time.Minute)
+	if v, err := xf.Fetch(ctx, "k", counting(&calls, time.Minute)); err != nil || v != 2 {
+		t.Errorf("Fetch of an expired value after Close = %d, %v; want the recomputed 2", v, err)
+	}
+}
+
+func TestCloseReleasesLocks(t *testing.T) {
+	ctx := context.Background()
+	locker := stampede.NewStripedLocker[string](64, nil)
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithLocker[string](locker, time.Minute))
+	release := holdRecompute(t, xf, "k", 1)
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	xf.Close(tctx)
+	if _, ok, _ := locker.TryLock(ctx, "k", time.Minute); !ok {
+		t.Fatal("lock still held after Close")
+	}
+
+	// the recompute finishing later leaves the new holder's lock alone
+	release()
+	if _, ok, _ := locker.TryLock(ctx, "k", time.Minute); ok {
+		t.Error("finished recompute released another holder's lock")
+	}
+}
@@ -0,0 +1,83 @@
+package stampede
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// Codec serializes items for caches which store bytes
+type Codec[V any] interface {
+	Marshal(item Item[V]) ([]byte, error)
+	Unmarshal(b []byte) (Item[V], error)
+}
+
+// wireItem is the form of Item serialized by JSONCodec and GobCodec, with
+// any cached error kept as its message
+type wireItem[V any] struct {
+	Value      V
+	Expiry     time.Time
+	HardExpiry time.Time `json:",omitzero"`
+	Delta      time.Duration
+	Created    time.Time `json:",omitzero"`
+	Err        string    `json:",omitempty"`
+	Pending    *Pending  `json:",omitempty"`
+}
+
+func toWire[V any](item Item[V]) wireItem[V] {
+	return wireItem[V]{
+		Value:      item.Value,
+		Expiry:     item.Expiry,
+		HardExpiry: item.HardExpiry,
+		Delta:      item.Delta,
+		Created:    item.Created,
+		Err:        errorMessage(item.Err),
+		Pending:    item.Pending,
+	}
+}
+
+func (w wireItem[V]) item() Item[V] {
+	return Item[V]{
+		Value:      w.Value,
+		Expiry:     w.Expiry,
+		HardExpiry: w.HardExpiry,
+		Delta:      w.Delta,
+		Created:    w.Created,
+		Err:        errorFromMessage(w.Err),
+		Pending:    w.Pending,
+	}
+}
+
+// JSONCodec is a Codec using encoding/json
+type JSONCodec[V any] struct{}
+
+// Marshal implements Codec
+func (JSONCodec[V]) Marshal(item Item[V]) ([]byte, error) {
+	return json.Marshal(toWire(item))
+}
+
+// Unmarshal implements Codec
+func (JSONCodec[V]) Unmarshal(b []byte) (Item[V], error) {
+	var w wireItem[V]
+	err := json.Unmarshal(b, &w)
+	return w.item(), err
+}
+
+// GobCodec is a Codec using encoding/gob.  Concrete types stored in interface
+// values must be registered with gob.Register.
+type GobCodec[V any] struct{}
+
+// Marshal implements Codec
+func (GobCodec[V]) Marshal(item Item[V]) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(toWire(item))
+	return buf.Bytes(), err
+}
+
+// Unmarshal implements Codec
+func (GobCodec[V]) Unmarshal(b []byte) (Item[V], error) {
+	var w wireItem[V]
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&w)
+	return w.item(), err
+}
@@ -0,0 +1,114 @@
+package stampede_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+)
+
+func TestJSONCodec(t *testing.T) {
+	cachetest.TestCodec(t, stampede.JSONCodec[string]{})
+}
+
+func TestGobCodec(t *testing.T) {
+	cachetest.TestCodec(t, stampede.GobCodec[string]{})
+}
+
+func TestEnvelopeCodec(t *testing.T) {
+	cachetest.TestCodec(t, stampede.EnvelopeCodec[string]{Value: stampede.JSONValueCodec[string]{}})
+}
+
+func TestEnvelope(t *testing.T) {
+	e := stampede.Envelope{
+		Flags:      stampede.FlagPending,
+		Expiry:     time.Unix(100, 1),
+		HardExpiry: time.Unix(200, 2),
+		Delta:      time.Second,
+		Created:    time.Unix(50, 3),
+		Until:      time.Unix(60, 4),
+		Owner:      "owner",
+		Value:      []byte("value"),
+	}
+	b := stampede.AppendEnvelope([]byte("prefix"), e)
+	if !bytes.HasPrefix(b, []byte("prefix")) {
+		t.Fatalf("AppendEnvelope did not append: %q", b)
+	}
+	got, err := stampede.ReadEnvelope(b[len("prefix"):])
+	if err != nil {
+		t.Fatalf("ReadEnvelope = %v", err)
+	}
+	e.Version = stampede.EnvelopeVersion
+	if !got.Expiry.Equal(e.Expiry) || !got.HardExpiry.Equal(e.HardExpiry) || !got.Created.Equal(e.Created) || !got.Until.Equal(e.Until) ||
+		got.Version != e.Version || got.Flags != e.Flags || got.Delta != e.Delta || got.Owner != e.Owner || !bytes.Equal(got.Value, e.Value) {
+		t.Errorf("ReadEnvelope = %+v, want %+v", got, e)
+	}
+}
+
+// v1Envelope returns an envelope as written by version 1, with only the
+// flags, expiry and delta in its header
+func v1Envelope(flags uint64, expiry time.Time, delta time.Duration, value string) []byte {
+	h := binary.AppendUvarint(nil, flags)
+	h = binary.AppendVarint(h, expiry.UnixNano())
+	h = binary.AppendVarint(h, int64(delta))
+	b := binary.AppendUvarint([]byte{1}, uint64(len(h)))
+	return append(append(b, h...), value...)
+}
+
+func TestEnvelopeVersions(t *testing.T) {
+	expiry := time.Unix(100, 0)
+
+	// older versions leave later fields zero
+	e, err := stampede.ReadEnvelope(v1Envelope(0, expiry, time.Second, "value"))
+	if err != nil || e.Version != 1 || !e.Expiry.Equal(expiry) || e.Delta != time.Second || !e.Created.IsZero() || e.Owner != "" || string(e.Value) != "value" {
+		t.Fatalf("ReadEnvelope of version 1 = %+v, %v", e, err)
+	}
+
+	// newer versions' extra header fields are skipped
+	b := stampede.AppendEnvelope(nil, stampede.Envelope{Expiry: expiry, Value: []byte("value")})
+	hlen, n := binary.Uvarint(b[1:])
+	h := append(append([]byte(nil), b[1+n:1+n+int(hlen)]...), 0x7f, 0x7f)
+	future := binary.AppendUvarint([]byte{stampede.EnvelopeVersion + 1}, uint64(len(h)))
+	future = append(append(future, h...), "value"...)
+	e, err = stampede.ReadEnvelope(future)
+	if err != nil || !e.Expiry.Equal(expiry) || string(e.Value) != "value" {
+		t.Fatalf("ReadEnvelope of a later version = %+v, %v", e, err)
+	}
+}
+
+func TestEnvelopeMalformed(t *testing.T) {
+	good := stampede.AppendEnvelope(nil, stampede.Envelope{Expiry: time.Unix(100, 0), Owner: "owner", Value: []byte("value")})
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{"Empty", nil},
+		{"VersionZero", []byte{0, 0}},
+		{"NoHeaderLength", []byte{1}},
+		{"HeaderTooLong", []byte{1, 100, 0}},
+		{"ShortHeader", []byte{1, 1, 0}},
+		{"OwnerTooLong", []byte{1, 6, 0, 0, 0, 0, 0, 50}},
+		{"Truncated", good[:4]},
+	} {
+		if _, err := stampede.ReadEnvelope(tt.b); !errors.Is(err, stampede.ErrBadEnvelope) {
+			t.Errorf("%s: ReadEnvelope = %v, want ErrBadEnvelope", tt.name, err)
+		}
+	}
+}
+
+func TestBytesValueCodec(t *testing.T) {
+	var vc stampede.BytesValueCodec
+	b := []byte("value")
+	v, err := vc.UnmarshalValue(b)
+	if err != nil || string(v) != "value" {
+		t.Fatalf("UnmarshalValue = %q, %v", v, err)
+	}
+	b[0] = 'V'
+	if string(v) != "value" {
+		t.Error("UnmarshalValue result aliases its input")
+	}
+}
@@ -0,0 +1,182 @@
+package stampede
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+)
+
+// Compression compresses cached values
+type Compression interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// Gzip is a Compression using compress/gzip
+type Gzip struct {
+	// Level is the gzip compression level.  Zero means
+	// gzip.DefaultCompression.
+	Level int
+}
+
+// Compress implements Compression
+func (g Gzip) Compress(b []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compression
+func (Gzip) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// value header bytes written by compressedCache
+const (
+	uncompressed = 0
+	compressed   = 1
+)
+
+var errBadCompressed = errors.New("stampede: unknown compression header")
+
+type compressedCache[K comparable] struct {
+	inner   Cache[K, []byte]
+	c       Compression
+	minSize int
+}
+
+// CompressedCache returns a Cache which compresses values of at least minSize
+// bytes with c before storing them in inner.  Stored values carry a one byte
+// header, so inner must only be accessed through the returned Cache.  Empty
+// values, such as those of cached errors and empty placeholders, are stored
+// as they are.  The returned Cache supports batching, conditional writes and
+// leases through inner if it does.
+func CompressedCache[K comparable](inner Cache[K, []byte], c Compression, minSize int) Cache[K, []byte] {
+	return &compressedCache[K]{inner: inner, c: c, minSize: minSize}
+}
+
+func (cc *compressedCache[K]) Get(ctx context.Context, key K) (Item[[]byte], error) {
+	item, err := cc.inner.Get(ctx, key)
+	if err != nil {
+		return item, err
+	}
+	return cc.decode(item)
+}
+
+func (cc *compressedCache[K]) GetMulti(ctx context.Context, keys []K) (map[K]Item[[]byte], error) {
+	got, err := getMulti(ctx, cc.inner, keys)
+	items := make(map[K]Item[[]byte], len(got))
+	for key, item := range got {
+		item, derr := cc.decode(item)
+		if derr != nil {
+			return items, derr
+		}
+		items[key] = item
+	}
+	return items, err
+}
+
+func (cc *compressedCache[K]) Set(ctx context.Context, key K, item Item[[]byte]) error {
+	item, err := cc.encode(item)
+	if err != nil {
+		return err
+	}
+	return cc.inner.Set(ctx, key, item)
+}
+
+func (cc *compressedCache[K]) SetIfNewer(ctx context.Context, key K, item Item[[]byte]) (bool, error) {
+	cs, ok := cc.inner.(ConditionalSetter[K, []byte])
+	if !ok {
+		return true, cc.Set(ctx, key, item)
+	}
+	item, err := cc.encode(item)
+	if err != nil {
+		return false, err
+	}
+	return cs.SetIfNewer(ctx, key, item)
+}
+
+func (cc *compressedCache[K]) SetMulti(ctx context.Context, items map[K]Item[[]byte]) error {
+	encoded := make(map[K]Item[[]byte], len(items))
+	for key, item := range items {
+		item, err := cc.encode(item)
+		if err != nil {
+			return err
+		}
+		encoded[key] = item
+	}
+	return setMulti(ctx, cc.inner, encoded)
+}
+
+func (cc *compressedCache[K]) ClaimLease(ctx context.Context, key K, prev *Item[[]byte], lease Item[[]byte]) (bool, error) {
+	lc, ok := cc.inner.(LeaseClaimer[K, []byte])
+	if !ok {
+		return true, cc.Set(ctx, key, lease)
+	}
+	lease, err := cc.encode(lease)
+	if err != nil {
+		return false, err
+	}
+	return lc.ClaimLease(ctx, key, prev, lease)
+}
+
+// encode compresses the value of item if it is large enough
+func (cc *compressedCache[K]) encode(item Item[[]byte]) (Item[[]byte], error) {
+	if len(item.Value) == 0 {
+		return item, nil
+	}
+	if len(item.Value) < cc.minSize {
+		item.Value = append([]byte{uncompressed}, item.Value...)
+		return item, nil
+	}
+
+	b, err := cc.c.Compress(item.Value)
+	if err != nil {
+		return item, err
+	}
+	item.Value = append([]byte{compressed}, b...)
+	return item, nil
+}
+
+// decode reverses encode
+func (cc *compressedCache[K]) decode(item Item[[]byte]) (Item[[]byte], error) {
+	if len(item.Value) == 0 {
+		return item, nil
+	}
+
+	var err error
+	switch item.Value[0] {
+	case uncompressed:
+		item.Value = item.Value[1:]
+	case compressed:
+		if item.Value, err = cc.c.Decompress(item.Value[1:]); err != nil {
+			return Item[[]byte]{}, err
+		}
+	default:
+		return Item[[]byte]{}, errBadCompressed
+	}
+	return item, nil
+}
+
+func (cc *compressedCache[K]) Delete(ctx context.Context, key K) error {
+	return deleteKey(ctx, cc.inner, key)
+}
@@ -0,0 +1,103 @@
+package stampede_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// testByteCache checks that c, wrapping a memcache, round-trips values and
+// empty-valued items, and passes batching and leases through
+func testByteCache(t *testing.T, c stampede.Cache[string, []byte]) {
+	ctx := context.Background()
+	now := time.Now()
+	values := map[string][]byte{
+		"small": []byte("x"),
+		"large": bytes.Repeat([]byte("abcdefgh"), 64),
+	}
+	for key, v := range values {
+		if err := c.Set(ctx, key, stampede.Item[[]byte]{Value: v, Expiry: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("Set(%q) = %v", key, err)
+		}
+		item, err := c.Get(ctx, key)
+		if err != nil || !bytes.Equal(item.Value, v) {
+			t.Fatalf("Get(%q) = %q, %v; want %q", key, item.Value, err, v)
+		}
+	}
+
+	empty := map[string]stampede.Item[[]byte]{
+		"error":       {Expiry: now.Add(time.Hour), Err: errors.New("not found")},
+		"placeholder": {Expiry: now.Add(time.Hour), Pending: &stampede.Pending{Owner: "p1", Until: now.Add(time.Hour), Empty: true}},
+	}
+	for key, want := range empty {
+		if err := c.Set(ctx, key, want); err != nil {
+			t.Fatalf("Set(%q) = %v", key, err)
+		}
+		item, err := c.Get(ctx, key)
+		if err != nil || len(item.Value) != 0 || (item.Err == nil) != (want.Err == nil) || (item.Pending == nil) != (want.Pending == nil) {
+			t.Fatalf("Get(%q) = %+v, %v; want %+v", key, item, err, want)
+		}
+	}
+
+	bs, ok := c.(stampede.BatchSetter[string, []byte])
+	if !ok {
+		t.Fatal("cache is not a BatchSetter")
+	}
+	batch := map[string]stampede.Item[[]byte]{
+		"m1": {Value: []byte("one"), Expiry: now.Add(time.Hour)},
+		"m2": {Value: bytes.Repeat([]byte("two"), 100), Expiry: now.Add(time.Hour)},
+	}
+	if err := bs.SetMulti(ctx, batch); err != nil {
+		t.Fatalf("SetMulti = %v", err)
+	}
+	bg, ok := c.(stampede.BatchGetter[string, []byte])
+	if !ok {
+		t.Fatal("cache is not a BatchGetter")
+	}
+	got, err := bg.GetMulti(ctx, []string{"m1", "m2", "error", "absent"})
+	if err != nil || len(got) != 3 {
+		t.Fatalf("GetMulti = %v, %v", got, err)
+	}
+	for key, want := range batch {
+		if !bytes.Equal(got[key].Value, want.Value) {
+			t.Errorf("GetMulti[%q] = %q, want %q", key, got[key].Value, want.Value)
+		}
+	}
+
+	lc, ok := c.(stampede.LeaseClaimer[string, []byte])
+	if !ok {
+		t.Fatal("cache is not a LeaseClaimer")
+	}
+	lease := stampede.Item[[]byte]{Value: []byte("prev"), Expiry: now.Add(time.Hour), Pending: &stampede.Pending{Owner: "p1", Until: now.Add(time.Hour)}}
+	if claimed, err := lc.ClaimLease(ctx, "lease", nil, lease); err != nil || !claimed {
+		t.Fatalf("ClaimLease = %v, %v; want claimed", claimed, err)
+	}
+	if claimed, err := lc.ClaimLease(ctx, "lease", nil, lease); err != nil || claimed {
+		t.Fatalf("second ClaimLease = %v, %v; want refused", claimed, err)
+	}
+	if item, err := c.Get(ctx, "lease"); err != nil || string(item.Value) != "prev" || item.Pending == nil {
+		t.Fatalf("Get of lease = %+v, %v", item, err)
+	}
+}
+
+func TestCompressedCache(t *testing.T) {
+	testByteCache(t, stampede.CompressedCache(memcache.New[string, []byte](), stampede.Gzip{}, 16))
+}
+
+func TestCompressedCacheCompresses(t *testing.T) {
+	ctx := context.Background()
+	inner := memcache.New[string, []byte]()
+	c := stampede.CompressedCache(inner, stampede.Gzip{}, 16)
+
+	v := bytes.Repeat([]byte("a"), 4096)
+	c.Set(ctx, "k", stampede.Item[[]byte]{Value: v})
+	stored, err := inner.Get(ctx, "k")
+	if err != nil || len(stored.Value) >= len(v)/10 {
+		t.Fatalf("stored %d bytes, %v; want compressed", len(stored.Value), err)
+	}
+}
@@ -0,0 +1,50 @@
+// Package compression provides snappy and zstd implementations of
+// stampede.Compression
+package compression
+
+import (
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Snappy is a stampede.Compression using the snappy block format
+type Snappy struct{}
+
+// Compress implements stampede.Compression
+func (Snappy) Compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+// Decompress implements stampede.Compression
+func (Snappy) Decompress(b []byte) ([]byte, error) {
+	return snappy.Decode(nil, b)
+}
+
+// Zstd is a stampede.Compression using zstd.  It is safe for concurrent use.
+type Zstd struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+// NewZstd returns a Zstd compressing at level
+func NewZstd(level zstd.EncoderLevel) (*Zstd, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Zstd{enc: enc, dec: dec}, nil
+}
+
+// Compress implements stampede.Compression
+func (z *Zstd) Compress(b []byte) ([]byte, error) {
+	return z.enc.EncodeAll(b, nil), nil
+}
+
+// Decompress implements stampede.Compression
+func (z *Zstd) Decompress(b []byte) ([]byte, error) {
+	return z.dec.DecodeAll(b, nil)
+}
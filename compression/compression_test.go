@@ -0,0 +1,41 @@
+package compression_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/compression"
+	"github.com/klauspost/compress/zstd"
+)
+
+func testCompression(t *testing.T, c stampede.Compression) {
+	for _, b := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("compressible "), 1000)} {
+		z, err := c.Compress(b)
+		if err != nil {
+			t.Fatalf("Compress = %v", err)
+		}
+		got, err := c.Decompress(z)
+		if err != nil || !bytes.Equal(got, b) {
+			t.Fatalf("Decompress(Compress(%d bytes)) = %d bytes, %v", len(b), len(got), err)
+		}
+		if len(b) > 1000 && len(z) >= len(b)/10 {
+			t.Errorf("compressed %d bytes to %d", len(b), len(z))
+		}
+	}
+	if _, err := c.Decompress([]byte("\xff not compressed")); err == nil {
+		t.Error("Decompress of garbage succeeded")
+	}
+}
+
+func TestSnappy(t *testing.T) {
+	testCompression(t, compression.Snappy{})
+}
+
+func TestZstd(t *testing.T) {
+	z, err := compression.NewZstd(zstd.SpeedDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCompression(t, z)
+}
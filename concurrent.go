@@ -0,0 +1,61 @@
+package stampede
+
+import "sync"
+
+// StampedeMetrics may be implemented by a Metrics to observe residual
+// stampedes: recomputes of a key started while another was in flight
+type StampedeMetrics[K comparable] interface {
+	// ConcurrentRecompute is called when a recompute of key starts while
+	// another recompute of it runs in this process, or, if remote is set,
+	// while another process holds its WithLocker lock or WithPlaceholders
+	// lease
+	ConcurrentRecompute(key K, remote bool)
+}
+
+// inflight counts the recomputes running for each key
+type inflight[K comparable] struct {
+	mu sync.Mutex
+	m  map[K]int
+}
+
+// start records a recompute of key, reporting whether another was running
+func (f *inflight[K]) start(key K) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m == nil {
+		f.m = make(map[K]int)
+	}
+	f.m[key]++
+	return f.m[key] > 1
+}
+
+// done records the end of a recompute of key
+func (f *inflight[K]) done(key K) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m[key]--; f.m[key] == 0 {
+		delete(f.m, key)
+	}
+}
+
+// startRecompute records the start of a recompute of key, counting it if it
+// overlaps another in this process.  The returned function records its end.
+func (xf *XFetcher[K, V]) startRecompute(key K) func() {
+	if xf.inflight.start(key) {
+		xf.concurrent(key, false)
+	}
+	return func() { xf.inflight.done(key) }
+}
+
+// concurrent counts a recompute of key overlapping another, in another
+// process if remote is set
+func (xf *XFetcher[K, V]) concurrent(key K, remote bool) {
+	if remote {
+		xf.stats.remoteConcurrent.Add(1)
+	} else {
+		xf.stats.concurrent.Add(1)
+	}
+	if sm, ok := xf.metrics.(StampedeMetrics[K]); ok {
+		sm.ConcurrentRecompute(key, remote)
+	}
+}
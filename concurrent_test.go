@@ -0,0 +1,50 @@
+package stampede_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestConcurrentRecomputes(t *testing.T) {
+	ctx := context.Background()
+	m := &countingMetrics{}
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithSingleflight(false),
+		stampede.WithMetrics[string](m),
+	)
+
+	// without singleflight, a second miss of a key recomputing overlaps it
+	release := holdRecompute(t, xf, "k", 1)
+	xf.Fetch(ctx, "k", succeeding)
+	release()
+	xf.Fetch(ctx, "other", succeeding)
+
+	if n := xf.Stats().ConcurrentRecomputes; n != 1 {
+		t.Errorf("Stats().ConcurrentRecomputes = %d, want 1", n)
+	}
+	if n := m.get("ConcurrentRecompute"); n != 1 {
+		t.Errorf("ConcurrentRecompute called %d times, want 1", n)
+	}
+}
+
+func TestRemoteConcurrentRecomputes(t *testing.T) {
+	ctx := context.Background()
+	m := &countingMetrics{}
+	a, b, _ := processes(stampede.WithMetrics[string](m), stampede.WithLockWait(time.Millisecond, 10*time.Millisecond))
+
+	// b gives up waiting for a's result and recomputes alongside it
+	release := holdRecompute(t, a, "k", 1)
+	b.Fetch(ctx, "k", succeeding)
+	release()
+
+	if n := b.Stats().RemoteConcurrentRecomputes; n != 1 {
+		t.Errorf("Stats().RemoteConcurrentRecomputes = %d, want 1", n)
+	}
+	if n := m.get("RemoteConcurrentRecompute"); n != 1 {
+		t.Errorf("RemoteConcurrentRecompute called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,36 @@
+package stampede
+
+import "context"
+
+// ConditionalSetter is implemented by caches which can write an item only if
+// it supersedes the one stored, so that a slow recompute cannot overwrite the
+// fresher result of a concurrent one.  Fetch uses it in place of Set.
+type ConditionalSetter[K comparable, V any] interface {
+	// SetIfNewer stores item unless the cache holds an item it does not
+	// supersede, reporting whether it was stored
+	SetIfNewer(ctx context.Context, key K, item Item[V]) (stored bool, err error)
+}
+
+// Supersedes reports whether item should replace old under a conditional
+// write: old is a placeholder, or item was computed no earlier, or, if either
+// creation time is unknown, it expires no earlier
+func (item Item[V]) Supersedes(old Item[V]) bool {
+	if old.Pending != nil && item.Pending == nil {
+		return true
+	}
+	if item.Created.IsZero() || old.Created.IsZero() {
+		return !item.Expiry.Before(old.Expiry)
+	}
+	return !item.Created.Before(old.Created)
+}
+
+// store writes a freshly computed item to the cache, conditionally if the
+// cache supports it
+func (xf *XFetcher[K, V]) store(ctx context.Context, key K, item Item[V]) error {
+	defer xf.latencies.since(opCacheSet, xf.latencies.start())
+	if cs, ok := xf.cache.(ConditionalSetter[K, V]); ok {
+		_, err := cs.SetIfNewer(ctx, key, item)
+		return err
+	}
+	return xf.cache.Set(ctx, key, item)
+}
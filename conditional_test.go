@@ -0,0 +1,57 @@
+package stampede_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestSupersedes(t *testing.T) {
+	now := time.Unix(1000, 0)
+	later := now.Add(time.Second)
+	pending := &stampede.Pending{Owner: "other", Until: later}
+	for _, tt := range []struct {
+		name      string
+		item, old stampede.Item[int]
+		want      bool
+	}{
+		{"Newer", stampede.Item[int]{Created: later}, stampede.Item[int]{Created: now}, true},
+		{"Same", stampede.Item[int]{Created: now}, stampede.Item[int]{Created: now}, true},
+		{"Older", stampede.Item[int]{Created: now}, stampede.Item[int]{Created: later}, false},
+		{"OlderButLongerLived", stampede.Item[int]{Created: now, Expiry: later.Add(time.Hour)}, stampede.Item[int]{Created: later, Expiry: later}, false},
+		{"UnknownAgeLaterExpiry", stampede.Item[int]{Expiry: later}, stampede.Item[int]{Created: later, Expiry: now}, true},
+		{"UnknownAgeEarlierExpiry", stampede.Item[int]{Created: later, Expiry: now}, stampede.Item[int]{Expiry: later}, false},
+		{"OverPlaceholder", stampede.Item[int]{Created: now}, stampede.Item[int]{Created: later, Pending: pending}, true},
+		{"PlaceholderOverPlaceholder", stampede.Item[int]{Created: now, Pending: pending}, stampede.Item[int]{Created: later, Pending: pending}, false},
+	} {
+		if got := tt.item.Supersedes(tt.old); got != tt.want {
+			t.Errorf("%s: Supersedes = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalStore(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache)
+
+	// an item computed elsewhere after this recompute is kept
+	newer := stampede.Item[int]{Value: 9, Created: time.Now().Add(time.Hour), Expiry: time.Now().Add(2 * time.Hour)}
+	cache.Set(ctx, "k", newer)
+	if v, err := xf.Refresh(ctx, "k", succeeding); err != nil || v != 1 {
+		t.Fatalf("Refresh = %d, %v", v, err)
+	}
+	if item, _ := cache.Get(ctx, "k"); item.Value != 9 {
+		t.Errorf("cached after Refresh = %d, want the newer 9 kept", item.Value)
+	}
+
+	// an older one is replaced
+	cache.Set(ctx, "k", stampede.Item[int]{Value: 9, Created: time.Now().Add(-time.Hour), Expiry: time.Now().Add(time.Hour)})
+	xf.Refresh(ctx, "k", succeeding)
+	if item, _ := cache.Get(ctx, "k"); item.Value != 1 {
+		t.Errorf("cached after Refresh = %d, want the recomputed 1", item.Value)
+	}
+}
@@ -0,0 +1,60 @@
+package stampede
+
+import "time"
+
+// CostClass is a coarse recompute cost of a key, for WithCostClass
+type CostClass int
+
+const (
+	// CostNormal is the cost of keys not otherwise tagged
+	CostNormal CostClass = iota
+
+	// CostCheap marks keys which are quick to recompute
+	CostCheap
+
+	// CostExpensive marks keys which are slow to recompute, or load the
+	// origin heavily
+	CostExpensive
+)
+
+// Cost returns the relative cost of the class, as used by WithCostFunc
+func (c CostClass) Cost() float64 {
+	switch c {
+	case CostCheap:
+		return 0.25
+	case CostExpensive:
+		return 4
+	}
+	return 1
+}
+
+func (c CostClass) String() string {
+	switch c {
+	case CostNormal:
+		return "normal"
+	case CostCheap:
+		return "cheap"
+	case CostExpensive:
+		return "expensive"
+	}
+	return "unknown"
+}
+
+// costFor returns the relative recompute cost of key, 1 if no cost function
+// is set
+func (xf *XFetcher[K, V]) costFor(key K) float64 {
+	if xf.costFunc == nil {
+		return 1
+	}
+	return max(xf.costFunc(key), 0)
+}
+
+// urgency values recomputing key now, as its cost divided by the time before
+// prev expires.  Misses and expired values are the most urgent.
+func (xf *XFetcher[K, V]) urgency(key K, prev *Item[V]) float64 {
+	left := time.Millisecond
+	if prev != nil && !prev.Expiry.IsZero() {
+		left = max(ttlLeft(*prev, xf.clock.Now()), left)
+	}
+	return xf.costFor(key) / left.Seconds()
+}
@@ -0,0 +1,284 @@
+// Package diskcache is a stampede.Cache storing one file per key, for large
+// values
+package diskcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// Cache stores items in files under a directory, encoded with a
+// stampede.Codec.  Files are named by the SHA-256 of their key and sharded
+// into 256 subdirectories.  Writes go to a temporary file which is renamed
+// into place, so readers never see a partial value.
+//
+// Each file is prefixed with the time after which it is dead.  Dead files are
+// treated as missing.  A background GC deletes them and, if the total size
+// exceeds the limit, the least recently read files.
+type Cache[V any] struct {
+	dir   string
+	codec stampede.Codec[V]
+	config
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	grace    time.Duration
+	maxBytes int64
+	gc       time.Duration
+	sync     bool
+}
+
+// WithGrace keeps files for grace after the item expires, so that they remain
+// available for stale serving.  The default is zero.
+func WithGrace(grace time.Duration) Option {
+	return func(c *config) { c.grace = grace }
+}
+
+// WithMaxBytes bounds the total size of the files, enforced by each GC.  The
+// default of zero means no limit.
+func WithMaxBytes(n int64) Option {
+	return func(c *config) { c.maxBytes = n }
+}
+
+// WithGCInterval sets how often the GC runs.  Zero disables it.  The default
+// is one minute.
+func WithGCInterval(d time.Duration) Option {
+	return func(c *config) { c.gc = d }
+}
+
+// WithSync makes writes fsync the file before renaming it into place, so that
+// values survive a crash rather than just a restart
+func WithSync() Option {
+	return func(c *config) { c.sync = true }
+}
+
+// New returns a Cache storing files under dir, which is created if needed, and
+// starts the GC
+func New[V any](dir string, codec stampede.Codec[V], opts ...Option) (*Cache[V], error) {
+	cfg := config{gc: time.Minute}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &Cache[V]{dir: dir, codec: codec, config: cfg, stop: make(chan struct{})}
+	if c.gc > 0 {
+		c.wg.Add(1)
+		go c.collector()
+	}
+	return c, nil
+}
+
+// Close stops the GC
+func (c *Cache[V]) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	c.wg.Wait()
+	return nil
+}
+
+// Get implements stampede.Cache.  It marks the file as recently read for the
+// GC.
+func (c *Cache[V]) Get(ctx context.Context, key string) (stampede.Item[V], error) {
+	path := c.path(key)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	if err != nil {
+		return stampede.Item[V]{}, err
+	}
+	now := time.Now()
+	b = live(b, now)
+	if b == nil {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	// access times are often disabled, so recency is tracked by mtime
+	os.Chtimes(path, time.Time{}, now)
+	return c.codec.Unmarshal(b)
+}
+
+// Set implements stampede.Cache
+func (c *Cache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	var dead int64 = math.MaxInt64 // never expires
+	switch {
+	case !item.HardExpiry.IsZero():
+		// the item is useless past its hard expiry
+		dead = item.HardExpiry.UnixNano()
+	case !item.Expiry.IsZero():
+		dead = item.Expiry.Add(c.grace).UnixNano()
+	}
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(max(dead, 0)))
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails once renamed
+
+	_, err = f.Write(hdr[:])
+	if err == nil {
+		_, err = f.Write(b)
+	}
+	if err == nil && c.sync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	err := os.Remove(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// tempPrefix marks files being written
+const tempPrefix = ".tmp-"
+
+// tempMaxAge is how old a temporary file must be for the GC to consider it
+// abandoned by a crashed writer
+const tempMaxAge = time.Hour
+
+// GC deletes dead and abandoned files, then the least recently read files
+// until the total size is within the limit
+func (c *Cache[V]) GC() error {
+	type file struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var files []file
+	var total int64
+	now := time.Now()
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// removed under us
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), tempPrefix) {
+			if now.Sub(info.ModTime()) > tempMaxAge {
+				os.Remove(path)
+			}
+			return nil
+		}
+		if c.dead(path, now) {
+			os.Remove(path)
+			return nil
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil || c.maxBytes <= 0 || total <= c.maxBytes {
+		return err
+	}
+
+	slices.SortFunc(files, func(a, b file) int { return a.mtime.Compare(b.mtime) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			total -= f.size
+		}
+	}
+	return nil
+}
+
+// dead reports whether the file at path is dead at now, reading only its
+// header
+func (c *Cache[V]) dead(path string, now time.Time) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var hdr [8]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		// truncated
+		return true
+	}
+	return live(hdr[:], now) == nil
+}
+
+func (c *Cache[V]) collector() {
+	defer c.wg.Done()
+	t := time.NewTicker(c.gc)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+			// failures are retried next time
+			c.GC()
+		}
+	}
+}
+
+// path returns the file for key
+func (c *Cache[V]) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// live returns the encoded item in the file contents b, or nil if it is
+// truncated or dead at now
+func live(b []byte, now time.Time) []byte {
+	if len(b) < 8 || int64(binary.BigEndian.Uint64(b)) < now.UnixNano() {
+		return nil
+	}
+	return b[8:]
+}
@@ -0,0 +1,134 @@
+package diskcache_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/diskcache"
+)
+
+func newCache(t *testing.T, dir string, opts ...diskcache.Option) *diskcache.Cache[string] {
+	t.Helper()
+	c, err := diskcache.New(dir, stampede.JSONCodec[string]{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return newCache(t, t.TempDir())
+	})
+}
+
+func TestDeadFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := newCache(t, dir, diskcache.WithGrace(time.Hour), diskcache.WithGCInterval(0))
+	now := time.Now()
+	items := map[string]stampede.Item[string]{
+		"stale":   {Value: "stale", Expiry: now.Add(-time.Minute)},
+		"dead":    {Value: "dead", Expiry: now.Add(-2 * time.Hour)},
+		"hard":    {Value: "hard", Expiry: now.Add(-time.Minute), HardExpiry: now.Add(-time.Second)},
+		"forever": {Value: "forever"},
+	}
+	for key, item := range items {
+		if err := c.Set(ctx, key, item); err != nil {
+			t.Fatalf("Set(%q) = %v", key, err)
+		}
+	}
+	for key, live := range map[string]bool{"stale": true, "dead": false, "hard": false, "forever": true} {
+		item, err := c.Get(ctx, key)
+		if live && (err != nil || item.Value != key) {
+			t.Errorf("Get(%q) = %+v, %v; want it kept", key, item, err)
+		}
+		if !live && !errors.Is(err, stampede.ErrCacheMiss) {
+			t.Errorf("Get(%q) = %+v, %v; want it dead", key, item, err)
+		}
+	}
+
+	if err := c.GC(); err != nil {
+		t.Fatalf("GC = %v", err)
+	}
+	if n := countFiles(t, dir); n != 2 {
+		t.Errorf("%d files after GC, want the 2 live ones", n)
+	}
+}
+
+func TestGCMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	value := strings.Repeat("x", 1000)
+	c := newCache(t, dir, diskcache.WithMaxBytes(2500), diskcache.WithGCInterval(0))
+
+	for i, key := range []string{"old", "read", "new"} {
+		c.Set(ctx, key, stampede.Item[string]{Value: value})
+		// order the modification times
+		mtime := time.Now().Add(time.Duration(i-3) * time.Minute)
+		os.Chtimes(pathOf(dir, key), time.Time{}, mtime)
+	}
+	// reading marks read as the most recent
+	c.Get(ctx, "read")
+
+	if err := c.GC(); err != nil {
+		t.Fatalf("GC = %v", err)
+	}
+	for key, live := range map[string]bool{"old": false, "read": true, "new": true} {
+		if _, err := c.Get(ctx, key); (err == nil) != live {
+			t.Errorf("Get(%q) after GC = %v, want live %v", key, err, live)
+		}
+	}
+}
+
+func TestGCTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	c := newCache(t, dir, diskcache.WithGCInterval(0))
+	sub := filepath.Join(dir, "00")
+	os.MkdirAll(sub, 0o755)
+	for name, age := range map[string]time.Duration{".tmp-abandoned": 2 * time.Hour, ".tmp-writing": 0} {
+		path := filepath.Join(sub, name)
+		os.WriteFile(path, []byte("partial"), 0o644)
+		os.Chtimes(path, time.Time{}, time.Now().Add(-age))
+	}
+
+	if err := c.GC(); err != nil {
+		t.Fatalf("GC = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sub, ".tmp-abandoned")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("abandoned temporary file kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sub, ".tmp-writing")); err != nil {
+		t.Errorf("temporary file being written removed: %v", err)
+	}
+}
+
+// pathOf returns the file holding key, named by its SHA-256 in a
+// subdirectory named by the first byte
+func pathOf(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, name[:2], name)
+}
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	var n int
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,282 @@
+// Package dynamocache is a stampede.Cache backed by DynamoDB
+package dynamocache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dgryski/go-stampede"
+)
+
+// Client is the subset of the DynamoDB API used by Cache, implemented by
+// *dynamodb.Client
+type Client interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// Attribute names of the table's items, besides the partition key
+const (
+	// ItemAttribute holds the encoded item, as binary
+	ItemAttribute = "item"
+
+	// TTLAttribute holds the item's expiration for DynamoDB's native
+	// TTL, as a number of unix seconds
+	TTLAttribute = "ttl"
+)
+
+// Cache stores items in a DynamoDB table, encoded with a stampede.Codec.  The
+// table's partition key must be a string.
+type Cache[V any] struct {
+	client Client
+	table  string
+	codec  stampede.Codec[V]
+	config
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	prefix     string
+	keyAttr    string
+	nativeTTL  bool
+	grace      time.Duration
+	consistent bool
+}
+
+// WithPrefix prepends prefix to every key
+func WithPrefix(prefix string) Option {
+	return func(c *config) { c.prefix = prefix }
+}
+
+// WithKeyAttribute sets the name of the table's partition key.  The default
+// is "key".
+func WithKeyAttribute(name string) Option {
+	return func(c *config) { c.keyAttr = name }
+}
+
+// WithNativeTTL sets TTLAttribute on each item to grace after the item
+// expires.  TTL must be enabled on the table for DynamoDB to delete dead
+// entries.  Since DynamoDB deletes lazily, entries past their TTL are also
+// treated as missing on read.  By default items are kept until overwritten.
+func WithNativeTTL(grace time.Duration) Option {
+	return func(c *config) {
+		c.nativeTTL = true
+		c.grace = grace
+	}
+}
+
+// WithConsistentRead makes Get and GetMulti use strongly consistent reads.
+// SetIfNewer always reads consistently.
+func WithConsistentRead() Option {
+	return func(c *config) { c.consistent = true }
+}
+
+// New returns a Cache storing items in table using client, encoding items
+// with codec
+func New[V any](client Client, table string, codec stampede.Codec[V], opts ...Option) *Cache[V] {
+	c := &Cache[V]{client: client, table: table, codec: codec}
+	c.keyAttr = "key"
+	for _, o := range opts {
+		o(&c.config)
+	}
+	return c
+}
+
+// Get implements stampede.Cache
+func (c *Cache[V]) Get(ctx context.Context, key string) (stampede.Item[V], error) {
+	attrs, err := c.read(ctx, key, c.consistent)
+	if err != nil {
+		return stampede.Item[V]{}, err
+	}
+	b, ok := c.live(attrs)
+	if !ok {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	return c.codec.Unmarshal(b)
+}
+
+// read returns the table item for key, or nil if there is none
+func (c *Cache[V]) read(ctx context.Context, key string, consistent bool) (map[string]types.AttributeValue, error) {
+	out, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(c.table),
+		Key:            c.key(key),
+		ConsistentRead: aws.Bool(consistent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Item, nil
+}
+
+// Set implements stampede.Cache
+func (c *Cache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.table),
+		Item:      c.attributes(key, b, item),
+	})
+	return err
+}
+
+// putAttempts bounds the conditional write retries of SetIfNewer
+const putAttempts = 3
+
+// ErrConflict is returned by SetIfNewer if the entry keeps changing under it
+var ErrConflict = errors.New("dynamocache: conditional write conflict")
+
+// SetIfNewer implements stampede.ConditionalSetter with a conditional write,
+// which only succeeds if the entry is unchanged since it was read
+func (c *Cache[V]) SetIfNewer(ctx context.Context, key string, item stampede.Item[V]) (bool, error) {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+
+	for range putAttempts {
+		attrs, err := c.read(ctx, key, true)
+		if err != nil {
+			return false, err
+		}
+		in := &dynamodb.PutItemInput{
+			TableName:                aws.String(c.table),
+			Item:                     c.attributes(key, b, item),
+			ExpressionAttributeNames: map[string]string{"#item": ItemAttribute},
+		}
+		if old, ok := attrs[ItemAttribute]; ok {
+			// an undecodable entry, or one past its TTL, is always
+			// replaced
+			if live, ok := c.live(attrs); ok {
+				if old, err := c.codec.Unmarshal(live); err == nil && !item.Supersedes(old) {
+					return false, nil
+				}
+			}
+			in.ConditionExpression = aws.String("#item = :old")
+			in.ExpressionAttributeValues = map[string]types.AttributeValue{":old": old}
+		} else {
+			in.ConditionExpression = aws.String("attribute_not_exists(#item)")
+		}
+
+		_, err = c.client.PutItem(ctx, in)
+		var ccf *types.ConditionalCheckFailedException
+		if errors.As(err, &ccf) {
+			continue
+		}
+		return err == nil, err
+	}
+	return false, ErrConflict
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	_, err := c.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(c.table),
+		Key:       c.key(key),
+	})
+	return err
+}
+
+// batchLimit is the most keys BatchGetItem accepts in one request
+const batchLimit = 100
+
+// GetMulti implements stampede.BatchGetter with BatchGetItem, retrying keys
+// left unprocessed by throttling
+func (c *Cache[V]) GetMulti(ctx context.Context, keys []string) (map[string]stampede.Item[V], error) {
+	items := make(map[string]stampede.Item[V], len(keys))
+	seen := make(map[string]bool, len(keys))
+	var pending []map[string]types.AttributeValue
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			pending = append(pending, c.key(key))
+		}
+	}
+
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return items, err
+		}
+		batch := pending[:min(len(pending), batchLimit)]
+		pending = pending[len(batch):]
+
+		out, err := c.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{
+				c.table: {Keys: batch, ConsistentRead: aws.Bool(c.consistent)},
+			},
+		})
+		if err != nil {
+			return items, err
+		}
+		for _, attrs := range out.Responses[c.table] {
+			k, ok := attrs[c.keyAttr].(*types.AttributeValueMemberS)
+			if !ok || len(k.Value) < len(c.prefix) {
+				continue
+			}
+			b, ok := c.live(attrs)
+			if !ok {
+				continue
+			}
+			item, err := c.codec.Unmarshal(b)
+			if err != nil {
+				return items, err
+			}
+			items[k.Value[len(c.prefix):]] = item
+		}
+		if u, ok := out.UnprocessedKeys[c.table]; ok {
+			pending = append(pending, u.Keys...)
+		}
+	}
+	return items, nil
+}
+
+// key returns the primary key of key
+func (c *Cache[V]) key(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		c.keyAttr: &types.AttributeValueMemberS{Value: c.prefix + key},
+	}
+}
+
+// attributes returns the table item storing b, the encoding of item
+func (c *Cache[V]) attributes(key string, b []byte, item stampede.Item[V]) map[string]types.AttributeValue {
+	attrs := c.key(key)
+	attrs[ItemAttribute] = &types.AttributeValueMemberB{Value: b}
+	if c.nativeTTL && (!item.Expiry.IsZero() || !item.HardExpiry.IsZero()) {
+		attrs[TTLAttribute] = &types.AttributeValueMemberN{Value: strconv.FormatInt(c.expiration(item).Unix(), 10)}
+	}
+	return attrs
+}
+
+// live returns the encoded item in attrs, unless there is none or it is past
+// its TTL
+func (c *Cache[V]) live(attrs map[string]types.AttributeValue) ([]byte, bool) {
+	v, ok := attrs[ItemAttribute].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, false
+	}
+	if ttl, ok := attrs[TTLAttribute].(*types.AttributeValueMemberN); ok {
+		if sec, err := strconv.ParseInt(ttl.Value, 10, 64); err == nil && time.Now().Unix() >= sec {
+			return nil, false
+		}
+	}
+	return v.Value, true
+}
+
+// expiration returns the time DynamoDB may delete item
+func (c *Cache[V]) expiration(item stampede.Item[V]) time.Time {
+	if !item.HardExpiry.IsZero() {
+		// the item is useless past its hard expiry
+		return item.HardExpiry
+	}
+	return item.Expiry.Add(c.grace)
+}
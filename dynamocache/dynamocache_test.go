@@ -0,0 +1,214 @@
+package dynamocache_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/dynamocache"
+)
+
+// table is an in-memory dynamocache.Client for a single table, supporting
+// only the condition expressions used by the Cache
+type table struct {
+	mu    sync.Mutex
+	attr  string
+	items map[string]map[string]types.AttributeValue
+
+	// batch limits the keys BatchGetItem processes per request
+	batch int
+
+	// beforePut is called before each PutItem is applied
+	beforePut func()
+
+	gets, batchGets int
+}
+
+// newTable returns a table whose partition key is attr
+func newTable(attr string) *table {
+	return &table{attr: attr, items: make(map[string]map[string]types.AttributeValue)}
+}
+
+func (tb *table) keyOf(key map[string]types.AttributeValue) string {
+	return key[tb.attr].(*types.AttributeValueMemberS).Value
+}
+
+func (tb *table) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.gets++
+	return &dynamodb.GetItemOutput{Item: maps.Clone(tb.items[tb.keyOf(in.Key)])}, nil
+}
+
+func (tb *table) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if tb.beforePut != nil {
+		tb.beforePut()
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	key := tb.keyOf(in.Item)
+	old, exists := tb.items[key][dynamocache.ItemAttribute]
+	switch aws.ToString(in.ConditionExpression) {
+	case "":
+	case "attribute_not_exists(#item)":
+		if exists {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	case "#item = :old":
+		want := in.ExpressionAttributeValues[":old"].(*types.AttributeValueMemberB).Value
+		if !exists || !bytes.Equal(old.(*types.AttributeValueMemberB).Value, want) {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	default:
+		return nil, errors.New("unsupported condition " + *in.ConditionExpression)
+	}
+	tb.items[key] = maps.Clone(in.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (tb *table) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	delete(tb.items, tb.keyOf(in.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (tb *table) BatchGetItem(ctx context.Context, in *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.batchGets++
+	out := &dynamodb.BatchGetItemOutput{
+		Responses:       make(map[string][]map[string]types.AttributeValue),
+		UnprocessedKeys: make(map[string]types.KeysAndAttributes),
+	}
+	for name, ka := range in.RequestItems {
+		if len(ka.Keys) > 100 {
+			return nil, errors.New("too many keys")
+		}
+		keys := ka.Keys
+		if tb.batch > 0 && len(keys) > tb.batch {
+			out.UnprocessedKeys[name] = types.KeysAndAttributes{Keys: keys[tb.batch:]}
+			keys = keys[:tb.batch]
+		}
+		for _, k := range keys {
+			if item, ok := tb.items[tb.keyOf(k)]; ok {
+				out.Responses[name] = append(out.Responses[name], maps.Clone(item))
+			}
+		}
+	}
+	return out, nil
+}
+
+func TestCache(t *testing.T) {
+	tb := newTable("key")
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return dynamocache.New(tb, "cache", stampede.JSONCodec[string]{}, dynamocache.WithConsistentRead())
+	})
+}
+
+func TestNativeTTL(t *testing.T) {
+	ctx := context.Background()
+	tb := newTable("key")
+	c := dynamocache.New(tb, "cache", stampede.JSONCodec[string]{}, dynamocache.WithPrefix("p/"), dynamocache.WithNativeTTL(time.Minute))
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	c.Set(ctx, "soft", stampede.Item[string]{Value: "v", Expiry: expiry})
+	c.Set(ctx, "hard", stampede.Item[string]{Value: "v", Expiry: expiry, HardExpiry: expiry.Add(time.Hour)})
+	c.Set(ctx, "forever", stampede.Item[string]{Value: "v"})
+
+	for key, want := range map[string]time.Time{"soft": expiry.Add(time.Minute), "hard": expiry.Add(time.Hour), "forever": {}} {
+		ttl, ok := tb.items["p/"+key][dynamocache.TTLAttribute].(*types.AttributeValueMemberN)
+		if want.IsZero() {
+			if ok {
+				t.Errorf("%s: TTL %s, want none", key, ttl.Value)
+			}
+			continue
+		}
+		if !ok || ttl.Value != strconv.FormatInt(want.Unix(), 10) {
+			t.Errorf("%s: TTL %v, want %d", key, tb.items["p/"+key][dynamocache.TTLAttribute], want.Unix())
+		}
+	}
+
+	// entries past their TTL but not yet deleted are misses
+	tb.items["p/soft"][dynamocache.TTLAttribute] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix()-1, 10)}
+	if _, err := c.Get(ctx, "soft"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("Get past the TTL = %v, want ErrCacheMiss", err)
+	}
+	if items, _ := c.GetMulti(ctx, []string{"soft", "hard"}); len(items) != 1 {
+		t.Errorf("GetMulti = %v, want only the live item", items)
+	}
+	if ok, err := c.SetIfNewer(ctx, "soft", stampede.Item[string]{Value: "w", Expiry: expiry.Add(-time.Hour)}); !ok || err != nil {
+		t.Errorf("SetIfNewer over a dead entry = %v, %v; want it replaced", ok, err)
+	}
+}
+
+func TestKeyAttribute(t *testing.T) {
+	ctx := context.Background()
+	tb := newTable("pk")
+	c := dynamocache.New(tb, "cache", stampede.JSONCodec[string]{}, dynamocache.WithKeyAttribute("pk"))
+	c.Set(ctx, "k", stampede.Item[string]{Value: "v"})
+	if item, err := c.Get(ctx, "k"); err != nil || item.Value != "v" {
+		t.Errorf("Get = %+v, %v", item, err)
+	}
+	if items, err := c.GetMulti(ctx, []string{"k"}); err != nil || items["k"].Value != "v" {
+		t.Errorf("GetMulti = %v, %v", items, err)
+	}
+}
+
+func TestGetMultiBatches(t *testing.T) {
+	ctx := context.Background()
+	tb := newTable("key")
+	c := dynamocache.New(tb, "cache", stampede.JSONCodec[string]{})
+
+	var keys []string
+	for i := range 250 {
+		key := strconv.Itoa(i)
+		keys = append(keys, key, key)
+		c.Set(ctx, key, stampede.Item[string]{Value: key})
+	}
+	// throttled requests leave keys unprocessed
+	tb.batch = 60
+	items, err := c.GetMulti(ctx, append(keys, "absent"))
+	if err != nil || len(items) != 250 {
+		t.Fatalf("GetMulti = %d items, %v; want 250", len(items), err)
+	}
+	if items["42"].Value != "42" {
+		t.Errorf("GetMulti[42] = %+v", items["42"])
+	}
+	if tb.batchGets < 5 {
+		t.Errorf("%d BatchGetItem requests, want unprocessed keys retried", tb.batchGets)
+	}
+}
+
+func TestSetIfNewerConflict(t *testing.T) {
+	ctx := context.Background()
+	tb := newTable("key")
+	c := dynamocache.New(tb, "cache", stampede.JSONCodec[string]{})
+	now := time.Now()
+	c.Set(ctx, "k", stampede.Item[string]{Value: "old", Created: now.Add(-time.Hour)})
+
+	// another writer changes the entry between every read and write
+	n := 0
+	tb.beforePut = func() {
+		n++
+		tb.mu.Lock()
+		tb.items["k"][dynamocache.ItemAttribute] = &types.AttributeValueMemberB{Value: []byte(strconv.Itoa(n))}
+		tb.mu.Unlock()
+	}
+	if ok, err := c.SetIfNewer(ctx, "k", stampede.Item[string]{Value: "new", Created: now}); ok || !errors.Is(err, dynamocache.ErrConflict) {
+		t.Errorf("SetIfNewer = %v, %v; want ErrConflict", ok, err)
+	}
+	if tb.gets != 3 {
+		t.Errorf("%d reads, want 3 attempts", tb.gets)
+	}
+}
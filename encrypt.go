@@ -0,0 +1,182 @@
+package stampede
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Keyring holds the AEADs used by an EncryptedCache, by key ID.  Values are
+// sealed with the Primary key; the others open values sealed before a
+// rotation, and may be removed once those have expired.
+type Keyring struct {
+	Primary string
+	AEADs   map[string]cipher.AEAD
+}
+
+// ErrUnknownKeyID is returned by an EncryptedCache reading a value sealed
+// with a key missing from its Keyring
+var ErrUnknownKeyID = errors.New("stampede: unknown encryption key ID")
+
+var errBadEncrypted = errors.New("stampede: malformed encrypted value")
+
+// encryptedVersion is the version byte of sealed values
+const encryptedVersion = 1
+
+type encryptedCache[K comparable] struct {
+	inner Cache[K, []byte]
+	ring  Keyring
+}
+
+// EncryptedCache returns a Cache which seals values with the primary key of
+// ring before storing them in inner, for caching sensitive data in shared
+// backends.  Stored values carry the key ID and nonce, so inner must only be
+// accessed through the returned Cache.  The key is bound to its value as
+// additional data, so a value copied to another key fails to open.  Item
+// metadata, and the messages of cached errors, are stored in the clear.  The
+// returned Cache supports batching, conditional writes and leases through
+// inner if it does.
+func EncryptedCache[K comparable](inner Cache[K, []byte], ring Keyring) (Cache[K, []byte], error) {
+	aead, ok := ring.AEADs[ring.Primary]
+	if !ok || aead == nil {
+		return nil, fmt.Errorf("stampede: primary key %q not in keyring", ring.Primary)
+	}
+	if len(ring.Primary) > 255 {
+		return nil, fmt.Errorf("stampede: key ID %q too long", ring.Primary)
+	}
+	return &encryptedCache[K]{inner: inner, ring: ring}, nil
+}
+
+func (ec *encryptedCache[K]) Get(ctx context.Context, key K) (Item[[]byte], error) {
+	item, err := ec.inner.Get(ctx, key)
+	if err != nil {
+		return item, err
+	}
+	return ec.openItem(key, item)
+}
+
+func (ec *encryptedCache[K]) GetMulti(ctx context.Context, keys []K) (map[K]Item[[]byte], error) {
+	got, err := getMulti(ctx, ec.inner, keys)
+	items := make(map[K]Item[[]byte], len(got))
+	for key, item := range got {
+		item, oerr := ec.openItem(key, item)
+		if oerr != nil {
+			return items, oerr
+		}
+		items[key] = item
+	}
+	return items, err
+}
+
+func (ec *encryptedCache[K]) Set(ctx context.Context, key K, item Item[[]byte]) error {
+	item, err := ec.seal(key, item)
+	if err != nil {
+		return err
+	}
+	return ec.inner.Set(ctx, key, item)
+}
+
+func (ec *encryptedCache[K]) SetIfNewer(ctx context.Context, key K, item Item[[]byte]) (bool, error) {
+	cs, ok := ec.inner.(ConditionalSetter[K, []byte])
+	if !ok {
+		return true, ec.Set(ctx, key, item)
+	}
+	item, err := ec.seal(key, item)
+	if err != nil {
+		return false, err
+	}
+	return cs.SetIfNewer(ctx, key, item)
+}
+
+func (ec *encryptedCache[K]) SetMulti(ctx context.Context, items map[K]Item[[]byte]) error {
+	sealed := make(map[K]Item[[]byte], len(items))
+	for key, item := range items {
+		item, err := ec.seal(key, item)
+		if err != nil {
+			return err
+		}
+		sealed[key] = item
+	}
+	return setMulti(ctx, ec.inner, sealed)
+}
+
+func (ec *encryptedCache[K]) ClaimLease(ctx context.Context, key K, prev *Item[[]byte], lease Item[[]byte]) (bool, error) {
+	lc, ok := ec.inner.(LeaseClaimer[K, []byte])
+	if !ok {
+		return true, ec.Set(ctx, key, lease)
+	}
+	lease, err := ec.seal(key, lease)
+	if err != nil {
+		return false, err
+	}
+	return lc.ClaimLease(ctx, key, prev, lease)
+}
+
+func (ec *encryptedCache[K]) Delete(ctx context.Context, key K) error {
+	return deleteKey(ctx, ec.inner, key)
+}
+
+// seal encrypts the value of item as
+//
+//	version uint8, idLen uint8, id, nonce, ciphertext
+//
+// leaving a nil value, such as that of a cached error, unset
+func (ec *encryptedCache[K]) seal(key K, item Item[[]byte]) (Item[[]byte], error) {
+	if item.Value == nil {
+		return item, nil
+	}
+	id := ec.ring.Primary
+	aead := ec.ring.AEADs[id]
+
+	b := make([]byte, 0, 2+len(id)+aead.NonceSize()+len(item.Value)+aead.Overhead())
+	b = append(b, encryptedVersion, byte(len(id)))
+	b = append(b, id...)
+	nonce := b[len(b) : len(b)+aead.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return item, err
+	}
+	b = b[:len(b)+len(nonce)]
+	item.Value = aead.Seal(b, nonce, item.Value, additionalData(key))
+	return item, nil
+}
+
+// openItem decrypts the value of an item written by seal
+func (ec *encryptedCache[K]) openItem(key K, item Item[[]byte]) (Item[[]byte], error) {
+	if item.Value == nil {
+		return item, nil
+	}
+	var err error
+	if item.Value, err = ec.open(key, item.Value); err != nil {
+		return Item[[]byte]{}, err
+	}
+	return item, nil
+}
+
+// open decrypts a value written by seal
+func (ec *encryptedCache[K]) open(key K, b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != encryptedVersion || len(b) < 2+int(b[1]) {
+		return nil, errBadEncrypted
+	}
+	id := string(b[2 : 2+b[1]])
+	b = b[2+len(id):]
+	aead, ok := ec.ring.AEADs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, id)
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errBadEncrypted
+	}
+	nonce, ct := b[:aead.NonceSize()], b[aead.NonceSize():]
+	v, err := aead.Open(nil, nonce, ct, additionalData(key))
+	if err != nil {
+		return nil, fmt.Errorf("stampede: opening encrypted value: %w", err)
+	}
+	return v, nil
+}
+
+// additionalData binds a sealed value to its key
+func additionalData[K comparable](key K) []byte {
+	return []byte(fmt.Sprint(key))
+}
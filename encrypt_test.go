@@ -0,0 +1,68 @@
+package stampede_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func newAEAD(t *testing.T, seed byte) cipher.AEAD {
+	t.Helper()
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = seed
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestEncryptedCache(t *testing.T) {
+	ring := stampede.Keyring{Primary: "k1", AEADs: map[string]cipher.AEAD{"k1": newAEAD(t, 1)}}
+	c, err := stampede.EncryptedCache(memcache.New[string, []byte](), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testByteCache(t, c)
+}
+
+func TestEncryptedCacheRotation(t *testing.T) {
+	ctx := context.Background()
+	inner := memcache.New[string, []byte]()
+	k1, k2 := newAEAD(t, 1), newAEAD(t, 2)
+	old, _ := stampede.EncryptedCache(inner, stampede.Keyring{Primary: "k1", AEADs: map[string]cipher.AEAD{"k1": k1}})
+	old.Set(ctx, "a", stampede.Item[[]byte]{Value: []byte("secret"), Expiry: time.Now().Add(time.Hour)})
+
+	if stored, _ := inner.Get(ctx, "a"); string(stored.Value) == "secret" {
+		t.Fatal("value stored in the clear")
+	}
+
+	rotated, _ := stampede.EncryptedCache(inner, stampede.Keyring{Primary: "k2", AEADs: map[string]cipher.AEAD{"k1": k1, "k2": k2}})
+	if item, err := rotated.Get(ctx, "a"); err != nil || string(item.Value) != "secret" {
+		t.Fatalf("Get after rotation = %q, %v", item.Value, err)
+	}
+
+	dropped, _ := stampede.EncryptedCache(inner, stampede.Keyring{Primary: "k2", AEADs: map[string]cipher.AEAD{"k2": k2}})
+	if _, err := dropped.Get(ctx, "a"); !errors.Is(err, stampede.ErrUnknownKeyID) {
+		t.Fatalf("Get with key removed = %v, want ErrUnknownKeyID", err)
+	}
+
+	// values are bound to their key
+	stored, _ := inner.Get(ctx, "a")
+	inner.Set(ctx, "b", stored)
+	if _, err := rotated.Get(ctx, "b"); err == nil {
+		t.Fatal("value copied to another key opened")
+	}
+}
@@ -0,0 +1,272 @@
+package stampede
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// The binary envelope format stores item metadata alongside an already
+// serialized value.  All integers are varints as in encoding/binary (and
+// protocol buffers):
+//
+//	version    uint8   currently EnvelopeVersion
+//	headerLen  uvarint length of the header fields which follow
+//	flags      uvarint
+//	expiry     varint  unix time in nanoseconds; 0 for the zero time
+//	delta      varint  nanoseconds
+//	created    varint  unix time in nanoseconds; 0 for the zero time (v2)
+//	until      varint  placeholder deadline, as for expiry (v3)
+//	ownerLen   uvarint length of the placeholder owner which follows (v3)
+//	owner      bytes   (v3)
+//	hardExpiry varint  as for expiry (v4)
+//	value      the remaining bytes
+//
+// Later versions only append fields to the header, so readers skip header
+// bytes they do not understand, and treat fields missing from entries
+// written by earlier versions as zero.
+
+// EnvelopeVersion is the version written by AppendEnvelope
+const EnvelopeVersion = 4
+
+// Envelope flags
+const (
+	// FlagError marks a cached error, whose message is the value
+	FlagError = 1 << iota
+
+	// FlagPending marks a placeholder; see Pending
+	FlagPending
+
+	// FlagEmpty marks a placeholder carrying no value
+	FlagEmpty
+)
+
+// ErrBadEnvelope is returned when decoding a malformed envelope
+var ErrBadEnvelope = errors.New("stampede: malformed envelope")
+
+// Envelope is the decoded form of the binary envelope format
+type Envelope struct {
+	Version    uint8
+	Flags      uint64
+	Expiry     time.Time
+	HardExpiry time.Time
+	Delta      time.Duration
+	Created    time.Time
+
+	// Until and Owner describe a placeholder, flagged with FlagPending
+	Until time.Time
+	Owner string
+
+	Value []byte
+}
+
+// AppendEnvelope appends the encoding of e to dst.  The Version field is
+// ignored; EnvelopeVersion is always written.
+func AppendEnvelope(dst []byte, e Envelope) []byte {
+	var hdr [7 * binary.MaxVarintLen64]byte
+	h := binary.AppendUvarint(hdr[:0], e.Flags)
+	h = binary.AppendVarint(h, unixNano(e.Expiry))
+	h = binary.AppendVarint(h, int64(e.Delta))
+	h = binary.AppendVarint(h, unixNano(e.Created))
+	h = binary.AppendVarint(h, unixNano(e.Until))
+	h = binary.AppendUvarint(h, uint64(len(e.Owner)))
+	h = append(h, e.Owner...)
+	h = binary.AppendVarint(h, unixNano(e.HardExpiry))
+
+	dst = append(dst, EnvelopeVersion)
+	dst = binary.AppendUvarint(dst, uint64(len(h)))
+	dst = append(dst, h...)
+	return append(dst, e.Value...)
+}
+
+// ReadEnvelope decodes an envelope of any version.  The returned Value
+// aliases b.
+func ReadEnvelope(b []byte) (Envelope, error) {
+	var e Envelope
+
+	if len(b) < 1 || b[0] < 1 {
+		return e, ErrBadEnvelope
+	}
+	e.Version = b[0]
+	b = b[1:]
+
+	hlen, n := binary.Uvarint(b)
+	if n <= 0 || hlen > uint64(len(b)-n) {
+		return e, ErrBadEnvelope
+	}
+	h := headerReader(b[n : n+int(hlen)])
+	e.Value = b[n+int(hlen):]
+
+	// version 1 fields are required
+	e.Flags = h.uvarint()
+	e.Expiry = fromUnixNano(h.varint())
+	e.Delta = time.Duration(h.varint())
+	if h == nil {
+		return e, ErrBadEnvelope
+	}
+
+	if len(h) > 0 {
+		e.Created = fromUnixNano(h.varint())
+	}
+	if len(h) > 0 {
+		e.Until = fromUnixNano(h.varint())
+		e.Owner = string(h.bytes())
+	}
+	if len(h) > 0 {
+		e.HardExpiry = fromUnixNano(h.varint())
+	}
+	if h == nil {
+		return e, ErrBadEnvelope
+	}
+	return e, nil
+}
+
+// headerReader consumes varints from an envelope header.  It becomes nil on
+// a malformed varint, after which reads return 0.
+type headerReader []byte
+
+func (h *headerReader) uvarint() uint64 {
+	v, n := binary.Uvarint(*h)
+	if n <= 0 {
+		*h = nil
+		return 0
+	}
+	*h = (*h)[n:]
+	return v
+}
+
+func (h *headerReader) varint() int64 {
+	v, n := binary.Varint(*h)
+	if n <= 0 {
+		*h = nil
+		return 0
+	}
+	*h = (*h)[n:]
+	return v
+}
+
+func (h *headerReader) bytes() []byte {
+	n := h.uvarint()
+	if n > uint64(len(*h)) {
+		*h = nil
+		return nil
+	}
+	b := (*h)[:n]
+	*h = (*h)[n:]
+	return b
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// ValueCodec serializes values, for use with EnvelopeCodec
+type ValueCodec[V any] interface {
+	MarshalValue(v V) ([]byte, error)
+	UnmarshalValue(b []byte) (V, error)
+}
+
+// EnvelopeCodec is a Codec writing the binary envelope format, with the value
+// serialized by a ValueCodec
+type EnvelopeCodec[V any] struct {
+	Value ValueCodec[V]
+}
+
+// Marshal implements Codec
+func (c EnvelopeCodec[V]) Marshal(item Item[V]) ([]byte, error) {
+	e, err := ToEnvelope(item, c.Value)
+	if err != nil {
+		return nil, err
+	}
+	return AppendEnvelope(nil, e), nil
+}
+
+// Unmarshal implements Codec
+func (c EnvelopeCodec[V]) Unmarshal(b []byte) (Item[V], error) {
+	e, err := ReadEnvelope(b)
+	if err != nil {
+		return Item[V]{}, err
+	}
+	return FromEnvelope(e, c.Value)
+}
+
+// ToEnvelope returns the envelope of item, with the value serialized by vc,
+// for codecs writing the envelope fields in other formats
+func ToEnvelope[V any](item Item[V], vc ValueCodec[V]) (Envelope, error) {
+	e := Envelope{Expiry: item.Expiry, HardExpiry: item.HardExpiry, Delta: item.Delta, Created: item.Created}
+	if p := item.Pending; p != nil {
+		e.Flags |= FlagPending
+		e.Until, e.Owner = p.Until, p.Owner
+		if p.Empty {
+			e.Flags |= FlagEmpty
+			return e, nil
+		}
+	}
+	if item.Err != nil {
+		e.Flags |= FlagError
+		e.Value = []byte(item.Err.Error())
+		return e, nil
+	}
+	b, err := vc.MarshalValue(item.Value)
+	if err != nil {
+		return Envelope{}, err
+	}
+	e.Value = b
+	return e, nil
+}
+
+// FromEnvelope returns the item in e, with the value deserialized by vc
+func FromEnvelope[V any](e Envelope, vc ValueCodec[V]) (Item[V], error) {
+	item := Item[V]{Expiry: e.Expiry, HardExpiry: e.HardExpiry, Delta: e.Delta, Created: e.Created}
+	if e.Flags&FlagPending != 0 {
+		item.Pending = &Pending{Owner: e.Owner, Until: e.Until, Empty: e.Flags&FlagEmpty != 0}
+		if item.Pending.Empty {
+			return item, nil
+		}
+	}
+	if e.Flags&FlagError != 0 {
+		item.Err = errorFromMessage(string(e.Value))
+		return item, nil
+	}
+	v, err := vc.UnmarshalValue(e.Value)
+	if err != nil {
+		return Item[V]{}, err
+	}
+	item.Value = v
+	return item, nil
+}
+
+// BytesValueCodec is a ValueCodec for values which are already bytes
+type BytesValueCodec struct{}
+
+// MarshalValue implements ValueCodec
+func (BytesValueCodec) MarshalValue(v []byte) ([]byte, error) { return v, nil }
+
+// UnmarshalValue implements ValueCodec.  The result does not alias b.
+func (BytesValueCodec) UnmarshalValue(b []byte) ([]byte, error) {
+	return append([]byte(nil), b...), nil
+}
+
+// JSONValueCodec is a ValueCodec using encoding/json
+type JSONValueCodec[V any] struct{}
+
+// MarshalValue implements ValueCodec
+func (JSONValueCodec[V]) MarshalValue(v V) ([]byte, error) { return json.Marshal(v) }
+
+// UnmarshalValue implements ValueCodec
+func (JSONValueCodec[V]) UnmarshalValue(b []byte) (V, error) {
+	var v V
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
@@ -0,0 +1,114 @@
+package stampede
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCacheMiss is returned by Cache.Get when the key is not present
+var ErrCacheMiss = errors.New("stampede: cache miss")
+
+// ErrRecomputeTimeout is returned by Fetch when recompute does not finish
+// within the timeout set with WithRecomputeTimeout and no stale value exists
+var ErrRecomputeTimeout = errors.New("stampede: recompute timed out")
+
+// ErrCircuitOpen is returned by Fetch when the circuit breaker for the key is
+// open and no stale value exists
+var ErrCircuitOpen = errors.New("stampede: circuit breaker open")
+
+// ErrDontCache may be returned by recompute along with a value, which Fetch
+// then returns to the caller without writing it to the cache, e.g. for
+// partial or degraded results
+var ErrDontCache = errors.New("stampede: don't cache")
+
+// ErrDeleteUnsupported is returned by Invalidate when the cache cannot delete
+// keys
+var ErrDeleteUnsupported = errors.New("stampede: cache does not support delete")
+
+// ErrOverCapacity is returned by Fetch when the WithMaxConcurrentRecomputes
+// limit is reached and no stale value is served
+var ErrOverCapacity = errors.New("stampede: too many concurrent recomputes")
+
+// ErrRateLimited is returned by Fetch when a WithRateLimit or
+// WithKeyRateLimit limit is reached and no stale value is served
+var ErrRateLimited = errors.New("stampede: recompute rate limited")
+
+// CacheReadError is a failure reading Key from the cache
+type CacheReadError struct {
+	Key any
+	Err error
+}
+
+func (e *CacheReadError) Error() string {
+	return fmt.Sprintf("stampede: reading %v from cache: %v", e.Key, e.Err)
+}
+
+func (e *CacheReadError) Unwrap() error { return e.Err }
+
+// CacheWriteError is a failure writing Key to the cache
+type CacheWriteError struct {
+	Key any
+	Err error
+}
+
+func (e *CacheWriteError) Error() string {
+	return fmt.Sprintf("stampede: writing %v to cache: %v", e.Key, e.Err)
+}
+
+func (e *CacheWriteError) Unwrap() error { return e.Err }
+
+// RecomputeError is a failure recomputing Key
+type RecomputeError struct {
+	Key any
+	Err error
+}
+
+func (e *RecomputeError) Error() string {
+	return fmt.Sprintf("stampede: recomputing %v: %v", e.Key, e.Err)
+}
+
+func (e *RecomputeError) Unwrap() error { return e.Err }
+
+// RecomputePanicError is the error of a recompute which panicked.  Fetch
+// recovers the panic, so it is handled like any other recompute failure,
+// including serving a stale value under WithStaleIfError.
+type RecomputePanicError struct {
+	// Value is the value passed to panic, and Stack the stack trace of
+	// the panicking goroutine
+	Value any
+	Stack []byte
+}
+
+func (e *RecomputePanicError) Error() string {
+	return fmt.Sprintf("stampede: recompute panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *RecomputePanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// MultiError holds the keys which failed in a FetchMulti, and their errors
+type MultiError[K comparable] struct {
+	Errs map[K]error
+}
+
+func (e *MultiError[K]) Error() string {
+	for key, err := range e.Errs {
+		if len(e.Errs) == 1 {
+			return err.Error()
+		}
+		return fmt.Sprintf("stampede: %d keys failed, including %v: %v", len(e.Errs), key, err)
+	}
+	return "stampede: no keys failed"
+}
+
+// Unwrap returns the errors of the keys
+func (e *MultiError[K]) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		errs = append(errs, err)
+	}
+	return errs
+}
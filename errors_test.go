@@ -0,0 +1,44 @@
+package stampede_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dgryski/go-stampede"
+)
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		msg  string
+		want error
+	}{
+		{&stampede.CacheReadError{Key: "k", Err: errBackend}, "stampede: reading k from cache: backend down", errBackend},
+		{&stampede.CacheWriteError{Key: "k", Err: errBackend}, "stampede: writing k to cache: backend down", errBackend},
+		{&stampede.RecomputeError{Key: 1, Err: errOrigin}, "stampede: recomputing 1: " + errOrigin.Error(), errOrigin},
+		{&stampede.RecomputePanicError{Value: errOrigin}, "stampede: recompute panicked: " + errOrigin.Error(), errOrigin},
+		{&stampede.MultiError[string]{Errs: map[string]error{"k": errOrigin}}, errOrigin.Error(), errOrigin},
+	} {
+		if tt.err.Error() != tt.msg || !errors.Is(tt.err, tt.want) {
+			t.Errorf("%T: %q, want %q wrapping %v", tt.err, tt.err, tt.msg, tt.want)
+		}
+	}
+
+	if err := (&stampede.RecomputePanicError{Value: "boom"}); errors.Unwrap(err) != nil {
+		t.Errorf("RecomputePanicError of a string unwraps to %v", errors.Unwrap(err))
+	}
+}
+
+func TestMultiErrorMessage(t *testing.T) {
+	err := &stampede.MultiError[string]{Errs: map[string]error{"a": errOrigin, "b": errBackend}}
+	msg := err.Error()
+	if msg != "stampede: 2 keys failed, including a: "+errOrigin.Error() && msg != "stampede: 2 keys failed, including b: backend down" {
+		t.Errorf("Error() = %q", msg)
+	}
+	if !errors.Is(err, errOrigin) || !errors.Is(err, errBackend) {
+		t.Error("MultiError does not wrap every key's error")
+	}
+	if msg := (&stampede.MultiError[string]{}).Error(); msg != "stampede: no keys failed" {
+		t.Errorf("empty MultiError = %q", msg)
+	}
+}
@@ -0,0 +1,46 @@
+package stampede_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestExplain(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	var events []stampede.LookupEvent[string]
+	record := func(e stampede.LookupEvent[string]) { events = append(events, e) }
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithHooks(stampede.Hooks[string]{OnHit: record, OnMiss: record, OnEarlyExpire: record}),
+		stampede.WithDeltaFloor(10*time.Second),
+	)
+
+	xf.Fetch(ctx, "k", succeeding)
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(50 * time.Second)
+	for range 50 {
+		xf.Fetch(ctx, "k", succeeding, stampede.WithFetchExplain())
+	}
+
+	if events[0].Explain != nil || events[1].Explain != nil {
+		t.Fatalf("unexplained fetches have explanations: %+v, %+v", events[0].Explain, events[1].Explain)
+	}
+	for _, e := range events[2:] {
+		ex := e.Explain
+		if ex == nil {
+			t.Fatalf("explained %v has no explanation", e.Decision)
+		}
+		if ex.Delta != 10*time.Second || ex.Beta != 1 || ex.Strategy {
+			t.Errorf("explanation %+v, want the floored delta and default beta", *ex)
+		}
+		if ex.Recompute != (ex.Gap >= ex.TTL) || ex.Recompute != (e.Decision == stampede.DecisionEarlyExpire) {
+			t.Errorf("%v explained as %+v", e.Decision, *ex)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package fakeclock provides a manually advanced stampede.Clock for tests
+package fakeclock
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// Clock is a stampede.Clock whose time only moves when advanced.  It is safe
+// for concurrent use.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+var _ stampede.Clock = (*Clock)(nil)
+
+// New returns a Clock set to now
+func New(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now implements stampede.Clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements stampede.Clock.  The channel receives once the clock has
+// been advanced by at least d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing any After channels which fall
+// due
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the clock to t, firing any After channels which fall due.  Setting
+// the clock backwards fires nothing.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+	sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].deadline.Before(c.waiters[j].deadline) })
+	n := 0
+	for _, w := range c.waiters {
+		if w.deadline.After(t) {
+			break
+		}
+		w.ch <- t
+		n++
+	}
+	c.waiters = c.waiters[n:]
+}
+
+// Waiters returns the number of pending After channels, so tests can wait for
+// background work to start sleeping before advancing the clock
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
@@ -0,0 +1,55 @@
+package fakeclock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede/fakeclock"
+)
+
+func fired(ch <-chan time.Time) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := fakeclock.New(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Now = %v, want %v", c.Now(), start)
+	}
+
+	if !fired(c.After(0)) {
+		t.Error("After(0) did not fire at once")
+	}
+	second, minute := c.After(time.Second), c.After(time.Minute)
+	if c.Waiters() != 2 {
+		t.Fatalf("Waiters = %d, want 2", c.Waiters())
+	}
+
+	c.Advance(999 * time.Millisecond)
+	if fired(second) {
+		t.Fatal("After(1s) fired early")
+	}
+	c.Advance(time.Millisecond)
+	if !fired(second) || fired(minute) || c.Waiters() != 1 {
+		t.Fatalf("after a second: Waiters = %d", c.Waiters())
+	}
+	if want := start.Add(time.Second); !c.Now().Equal(want) {
+		t.Fatalf("Now = %v, want %v", c.Now(), want)
+	}
+
+	// setting the clock backwards fires nothing
+	c.Set(start)
+	if fired(minute) {
+		t.Fatal("After(1m) fired when the clock went back")
+	}
+	c.Set(start.Add(time.Hour))
+	if !fired(minute) || c.Waiters() != 0 {
+		t.Fatalf("after an hour: Waiters = %d", c.Waiters())
+	}
+}
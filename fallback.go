@@ -0,0 +1,28 @@
+package stampede
+
+import "context"
+
+// WithFallback sets a function supplying a degraded value, such as an empty
+// list, when a fetch has nothing to serve: the cache read or recompute failed
+// and no stale value was available.  It is passed the error the fetch would
+// have returned.  Its value is returned, without error, with SourceFallback,
+// and is not cached.  If fn fails too the original error is returned.  The
+// key and value types must match the fetcher's.
+func WithFallback[K comparable, V any](fn func(ctx context.Context, key K, err error) (V, error)) Option {
+	return func(c *config) { c.fallback = fn }
+}
+
+// fallbackFor replaces the result of a failed fetch of key with the WithFallback
+// value, if the fetch served nothing.  A value served has a creation time, or
+// an expiry if read from a cache which does not keep creation times; values
+// which never expire have only the former.
+func (xf *XFetcher[K, V]) fallbackFor(ctx context.Context, key K, r Result[V], err error) (Result[V], error) {
+	if xf.fallback == nil || err == nil || !r.Created.IsZero() || !r.Expiry.IsZero() {
+		return r, err
+	}
+	v, ferr := xf.fallback(ctx, key, err)
+	if ferr != nil {
+		return r, err
+	}
+	return Result[V]{Value: v, Source: SourceFallback}, nil
+}
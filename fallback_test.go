@@ -0,0 +1,54 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+var errWrite = errors.New("cache down")
+
+// readOnlyCache fails every write
+type readOnlyCache struct {
+	testutil.NullCache[string, int]
+}
+
+func (readOnlyCache) Set(ctx context.Context, key string, item stampede.Item[int]) error {
+	return errWrite
+}
+
+func fallback(ctx context.Context, key string, err error) (int, error) {
+	return -1, nil
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{}, stampede.WithFallback(fallback))
+
+	r, err := xf.FetchItem(ctx, "k", failing)
+	if err != nil || r.Value != -1 || r.Source != stampede.SourceFallback {
+		t.Fatalf("FetchItem = %+v, %v; want the fallback", r, err)
+	}
+}
+
+func TestFallbackNotForServedValue(t *testing.T) {
+	for _, ttl := range []time.Duration{time.Minute, stampede.NoExpiry} {
+		ctx := context.Background()
+		xf := stampede.New[string, int](readOnlyCache{},
+			stampede.WithFallback(fallback),
+			stampede.WithWriteFailurePolicy(stampede.WriteFailureReturn),
+		)
+
+		r, err := xf.FetchItem(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+			return 1, ttl, nil
+		})
+		var werr *stampede.CacheWriteError
+		if !errors.As(err, &werr) || r.Value != 1 || r.Source != stampede.SourceRecompute {
+			t.Errorf("TTL %v: FetchItem = %+v, %v; want the value and a CacheWriteError", ttl, r, err)
+		}
+	}
+}
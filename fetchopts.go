@@ -0,0 +1,97 @@
+package stampede
+
+import (
+	"context"
+	"time"
+)
+
+// A FetchOption overrides the fetcher's configuration for a single call
+type FetchOption func(*fetchConfig)
+
+// fetchConfig holds the per-call settings
+type fetchConfig struct {
+	beta         float64
+	bypass       bool
+	timeout      time.Duration
+	defaultTTL   time.Duration
+	staleIfError time.Duration
+
+	latencyBudget time.Duration
+	explain       bool
+}
+
+// WithFetchBeta uses beta for this call in place of the fetcher's beta
+func WithFetchBeta(beta float64) FetchOption {
+	return func(c *fetchConfig) { c.beta = beta }
+}
+
+// WithFetchTimeout bounds the recompute for this call as WithRecomputeTimeout
+// does.  When concurrent fetches are coalesced, the timeout of the one making
+// the call applies.
+func WithFetchTimeout(d time.Duration) FetchOption {
+	return func(c *fetchConfig) { c.timeout = d }
+}
+
+// WithFetchDefaultTTL uses d for this call in place of the fetcher's
+// WithDefaultTTL.  When concurrent fetches are coalesced, the default of the
+// one making the call applies.
+func WithFetchDefaultTTL(d time.Duration) FetchOption {
+	return func(c *fetchConfig) { c.defaultTTL = d }
+}
+
+// WithFetchStaleIfError bounds stale serving after a failed recompute for
+// this call, as WithStaleIfError does; zero disables it
+func WithFetchStaleIfError(maxStale time.Duration) FetchOption {
+	return func(c *fetchConfig) { c.staleIfError = maxStale }
+}
+
+// WithFetchExplain records the inputs of this call's early expiration
+// decision in FetchInfo.Explain, for hooks, tracing and logging; see
+// WithExplain
+func WithFetchExplain() FetchOption {
+	return func(c *fetchConfig) { c.explain = true }
+}
+
+// WithFetchLatencyBudget uses d for this call in place of the fetcher's
+// WithLatencyBudget; zero disables it
+func WithFetchLatencyBudget(d time.Duration) FetchOption {
+	return func(c *fetchConfig) { c.latencyBudget = d }
+}
+
+// WithBypassCache skips the cache read and recomputes the value, still
+// writing the result to the cache.  The recompute is not coalesced with
+// concurrent fetches, so it cannot return a value computed before the call.
+func WithBypassCache() FetchOption {
+	return func(c *fetchConfig) { c.bypass = true }
+}
+
+// callKey is the context key for the per-call settings applied by compute.
+// It names the fetcher, so the settings do not leak into fetches made by
+// recompute on other fetchers.
+type callKey struct {
+	xf any
+}
+
+// callSettings are the per-call settings carried to compute
+type callSettings struct {
+	timeout    time.Duration
+	defaultTTL time.Duration
+}
+
+// withCall returns a context carrying the per-call settings of fc, if they
+// differ from the fetcher's
+func (xf *XFetcher[K, V]) withCall(ctx context.Context, fc *fetchConfig) context.Context {
+	cs := callSettings{timeout: fc.timeout, defaultTTL: fc.defaultTTL}
+	if cs == xf.callSettings(ctx) {
+		return ctx
+	}
+	return context.WithValue(ctx, callKey{xf}, cs)
+}
+
+// callSettings returns the per-call settings for a call made with ctx
+func (xf *XFetcher[K, V]) callSettings(ctx context.Context) callSettings {
+	if cs, ok := ctx.Value(callKey{xf}).(callSettings); ok {
+		return cs
+	}
+	return callSettings{timeout: xf.recomputeTimeout, defaultTTL: xf.defaultTTL}
+}
@@ -0,0 +1,63 @@
+package stampede_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestBypassCacheNotCoalesced(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int]())
+
+	// a fetch is recomputing the key
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan int)
+	go func() {
+		v, _ := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+			close(started)
+			<-unblock
+			return 1, time.Minute, nil
+		})
+		done <- v
+	}()
+	<-started
+
+	var calls atomic.Int32
+	calls.Store(1)
+	if v, err := xf.Fetch(ctx, "k", counting(&calls, time.Minute), stampede.WithBypassCache()); err != nil || v != 2 {
+		t.Errorf("Fetch with WithBypassCache = %d, %v; want its own recompute", v, err)
+	}
+	close(unblock)
+	if v := <-done; v != 1 {
+		t.Errorf("coalescing Fetch = %d, want 1", v)
+	}
+}
+
+func TestFetchOptionsScoped(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	inner := memcache.New[string, int]()
+	outer := stampede.New[string, int](memcache.New[string, int](), stampede.WithClock(clock))
+	nested := stampede.New[string, int](inner, stampede.WithClock(clock), stampede.WithDefaultTTL(time.Minute))
+
+	// the per-call default applies to outer's recompute only, not to the
+	// fetches it makes of nested
+	outer.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		v, err := nested.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) { return 1, 0, nil })
+		return v, 0, err
+	}, stampede.WithFetchDefaultTTL(time.Hour))
+
+	if item, err := inner.Get(ctx, "k"); err != nil || !item.Expiry.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("nested item = %+v, %v; want nested's default TTL", item, err)
+	}
+	if r, _ := outer.FetchItem(ctx, "k", failing); r.TTL != time.Hour {
+		t.Errorf("outer item = %+v, want the per-call default TTL", r)
+	}
+}
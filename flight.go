@@ -0,0 +1,52 @@
+package stampede
+
+import (
+	"context"
+	"sync"
+)
+
+// call is an in-flight or completed recompute
+type call[V any] struct {
+	done chan struct{}
+	item Item[V]
+	err  error
+}
+
+// flightGroup coalesces concurrent recomputes of the same key, in the style
+// of golang.org/x/sync/singleflight.
+type flightGroup[K comparable, V any] struct {
+	mu sync.Mutex
+	m  map[K]*call[V]
+}
+
+// do runs fn for key, unless a call for key is already in flight, in which
+// case it waits for and returns that call's result, with shared set.  Waiters
+// give up when their own context is done; the in-flight call is unaffected.
+func (g *flightGroup[K, V]) do(ctx context.Context, key K, fn func() (Item[V], error)) (item Item[V], shared bool, err error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[K]*call[V])
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.item, true, c.err
+		case <-ctx.Done():
+			return Item[V]{}, true, ctx.Err()
+		}
+	}
+	c := &call[V]{done: make(chan struct{})}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.item, c.err = fn()
+	return c.item, false, c.err
+}
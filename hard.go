@@ -0,0 +1,62 @@
+package stampede
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type hardTTLKey struct{}
+
+// SetHardTTL gives the value being computed a hard expiry d after its soft
+// expiry, as WithHardTTL does, when called by a RecomputeFunc with the
+// context it was passed.  It overrides any WithHardTTL setting.  Past its
+// hard expiry an item is treated as a miss and is never served stale.  Only
+// the setting of the attempt whose value is used counts.
+func SetHardTTL(ctx context.Context, d time.Duration) {
+	if h, ok := ctx.Value(hardTTLKey{}).(*hardTTL); ok {
+		h.mu.Lock()
+		h.d = d
+		h.mu.Unlock()
+	}
+}
+
+// hardTTL holds the SetHardTTL setting of one recompute attempt, which may
+// still be running in the background after being abandoned
+type hardTTL struct {
+	mu sync.Mutex
+	d  time.Duration
+}
+
+// withHardTTL returns a context for a recompute attempt in which SetHardTTL
+// stores into h
+func withHardTTL(ctx context.Context) (context.Context, *hardTTL) {
+	h := new(hardTTL)
+	return context.WithValue(ctx, hardTTLKey{}, h), h
+}
+
+func (h *hardTTL) get() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.d
+}
+
+// hardExpiry returns the hard expiry for an item computed at now with ttl,
+// given any hard time-to-live past the soft expiry set by the recompute
+func (xf *XFetcher[K, V]) hardExpiry(now time.Time, ttl, hard time.Duration) time.Time {
+	if ttl == NoExpiry {
+		return time.Time{}
+	}
+	if hard <= 0 {
+		hard = xf.hardTTL
+	}
+	if hard <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl + hard)
+}
+
+// hardExpired reports whether item is past its hard expiry at now
+func hardExpired[V any](item Item[V], now time.Time) bool {
+	return !item.HardExpiry.IsZero() && !now.Before(item.HardExpiry)
+}
@@ -0,0 +1,92 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestHardTTL(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	clock := fakeclock.New(start)
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache,
+		stampede.WithClock(clock),
+		stampede.WithHardTTL(time.Hour),
+		stampede.WithStaleIfError(time.Hour),
+	)
+
+	xf.Fetch(ctx, "option", succeeding)
+	xf.Fetch(ctx, "set", func(ctx context.Context) (int, time.Duration, error) {
+		stampede.SetHardTTL(ctx, 10*time.Minute)
+		return 1, time.Minute, nil
+	})
+
+	// both count from the soft expiry
+	for key, want := range map[string]time.Duration{"option": time.Minute + time.Hour, "set": 11 * time.Minute} {
+		item, err := cache.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q) = %v", key, err)
+		}
+		if got := item.HardExpiry.Sub(start); got != want {
+			t.Errorf("%q hard expiry after %v, want %v", key, got, want)
+		}
+	}
+
+	// stale until the hard expiry, a miss after
+	clock.Advance(10 * time.Minute)
+	if v, err := xf.Fetch(ctx, "set", failing); err != nil || v != 1 {
+		t.Errorf("Fetch before hard expiry = %v, %v; want stale 1", v, err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := xf.Fetch(ctx, "set", failing); !errors.Is(err, errOrigin) {
+		t.Errorf("Fetch after hard expiry = %v, want %v", err, errOrigin)
+	}
+}
+
+func TestHardTTLAbandonedAttempt(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache,
+		stampede.WithRecomputeTimeout(10*time.Millisecond),
+		stampede.WithRetry(stampede.RetryPolicy{Attempts: 2}),
+	)
+
+	var attempts atomic.Int32
+	release := make(chan struct{})
+	done := make(chan struct{})
+	_, err := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		if attempts.Add(1) == 1 {
+			go func() {
+				defer close(done)
+				<-release
+				// the timed out attempt sets its hard TTL late
+				stampede.SetHardTTL(ctx, time.Hour)
+			}()
+			<-ctx.Done()
+			return 0, 0, ctx.Err()
+		}
+		stampede.SetHardTTL(ctx, time.Minute)
+		close(release)
+		<-done
+		return 1, time.Minute, nil
+	})
+	if err != nil {
+		t.Fatalf("Fetch = %v", err)
+	}
+
+	item, err := cache.Get(ctx, "k")
+	if err != nil {
+		t.Fatalf("Get = %v", err)
+	}
+	if got := item.HardExpiry.Sub(item.Expiry); got != time.Minute {
+		t.Errorf("hard expiry %v after expiry, want the successful attempt's %v", got, time.Minute)
+	}
+}
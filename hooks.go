@@ -0,0 +1,87 @@
+package stampede
+
+import (
+	"context"
+	"time"
+)
+
+// Hooks are callbacks fired by an XFetcher.  Nil hooks are skipped.  Hooks
+// are called synchronously from Fetch and should be fast.
+type Hooks[K comparable] struct {
+	// OnHit is called when a fresh value is served from the cache
+	OnHit func(LookupEvent[K])
+
+	// OnMiss is called when the key is absent from the cache or expired
+	OnMiss func(LookupEvent[K])
+
+	// OnEarlyExpire is called when the XFetch algorithm chooses to
+	// recompute a value which has not yet expired
+	OnEarlyExpire func(LookupEvent[K])
+
+	// OnRecompute is called after each call to recompute
+	OnRecompute func(RecomputeEvent[K])
+
+	// OnStaleServed is called when an expired value is served in place of
+	// a failed recompute
+	OnStaleServed func(StaleEvent[K])
+}
+
+// LookupEvent describes the cache lookup made by a fetch
+type LookupEvent[K comparable] struct {
+	Key  K
+	Time time.Time
+	FetchInfo
+}
+
+// RecomputeEvent describes a call to recompute
+type RecomputeEvent[K comparable] struct {
+	Key   K
+	Start time.Time
+
+	// Duration includes any retries
+	Duration time.Duration
+
+	// TTL is the time-to-live returned by recompute, and Err its error
+	TTL time.Duration
+	Err error
+}
+
+// StaleEvent describes an expired value served in place of a failed recompute
+type StaleEvent[K comparable] struct {
+	Key K
+
+	// Expiry is the served item's expiry, and Err the recompute error
+	Expiry time.Time
+	Err    error
+}
+
+// fire calls hook with e, if hook is set
+func fire[E any](hook func(E), e E) {
+	if hook != nil {
+		hook(e)
+	}
+}
+
+// observeLookup reports the cache lookup for key to the span, metrics and
+// hooks
+func (xf *XFetcher[K, V]) observeLookup(ctx context.Context, key K, info FetchInfo, now time.Time, span Span) {
+	span.Annotate(info)
+	e := LookupEvent[K]{Key: key, Time: now, FetchInfo: info}
+	xf.keyStats.fetched(key)
+	xf.analysis.lookup(key, info)
+	switch info.Decision {
+	case DecisionHit:
+		xf.metrics.Hit(key)
+		xf.stats.hits.Add(1)
+		fire(xf.hooks.OnHit, e)
+	case DecisionMiss:
+		xf.metrics.Miss(key)
+		xf.stats.misses.Add(1)
+		fire(xf.hooks.OnMiss, e)
+	case DecisionEarlyExpire:
+		xf.metrics.EarlyExpire(key)
+		xf.stats.early.Add(1)
+		fire(xf.hooks.OnEarlyExpire, e)
+	}
+	xf.logLookup(ctx, key, info)
+}
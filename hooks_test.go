@@ -0,0 +1,84 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// exercise makes xf miss, hit, expire early and serve stale on key k, with
+// recomputes taking a second on clock
+func exercise(t *testing.T, xf *stampede.XFetcher[string, int], clock *fakeclock.Clock) {
+	t.Helper()
+	ctx := context.Background()
+	v := 0
+	recompute := func(ctx context.Context) (int, time.Duration, error) {
+		clock.Advance(time.Second)
+		v++
+		return v, time.Minute, nil
+	}
+
+	xf.Fetch(ctx, "k", recompute)
+	xf.Fetch(ctx, "k", recompute)
+	clock.Advance(59*time.Second + 500*time.Millisecond)
+	if r, _ := xf.FetchItem(ctx, "k", recompute); r.Value != 2 {
+		t.Fatalf("FetchItem near expiry = %+v, want an early recompute", r)
+	}
+	clock.Advance(2 * time.Minute)
+	if r, err := xf.FetchItem(ctx, "k", failing, stampede.WithFetchStaleIfError(time.Hour)); err != nil || r.Source != stampede.SourceStale {
+		t.Fatalf("FetchItem of an expired value = %+v, %v; want it served stale", r, err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	clock := fakeclock.New(time.Unix(1000, 0))
+	var events []string
+	lookup := func(name string) func(stampede.LookupEvent[string]) {
+		return func(e stampede.LookupEvent[string]) {
+			if e.Key != "k" || !e.Time.Equal(clock.Now()) {
+				t.Errorf("%s event %+v at %v", name, e, clock.Now())
+			}
+			events = append(events, name)
+		}
+	}
+	var recomputes []stampede.RecomputeEvent[string]
+	var stale []stampede.StaleEvent[string]
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(func() float64 { return 0.5 }),
+		stampede.WithHooks(stampede.Hooks[string]{
+			OnHit:         lookup("hit"),
+			OnMiss:        lookup("miss"),
+			OnEarlyExpire: lookup("early"),
+			OnRecompute: func(e stampede.RecomputeEvent[string]) {
+				events = append(events, "recompute")
+				recomputes = append(recomputes, e)
+			},
+			OnStaleServed: func(e stampede.StaleEvent[string]) {
+				events = append(events, "stale")
+				stale = append(stale, e)
+			},
+		}),
+	)
+	exercise(t, xf, clock)
+
+	want := []string{"miss", "recompute", "hit", "early", "recompute", "miss", "recompute", "stale"}
+	if !slices.Equal(events, want) {
+		t.Fatalf("hooks fired %v, want %v", events, want)
+	}
+	if e := recomputes[0]; e.Key != "k" || !e.Start.Equal(time.Unix(1000, 0)) || e.Duration != time.Second || e.TTL != time.Minute || e.Err != nil {
+		t.Errorf("first RecomputeEvent = %+v", e)
+	}
+	if e := recomputes[2]; !errors.Is(e.Err, errOrigin) {
+		t.Errorf("failed RecomputeEvent = %+v, want %v", e, errOrigin)
+	}
+	if e := stale[0]; e.Key != "k" || !e.Expiry.Equal(time.Unix(1121, 5e8)) || !errors.Is(e.Err, errOrigin) {
+		t.Errorf("StaleEvent = %+v", e)
+	}
+}
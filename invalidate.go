@@ -0,0 +1,24 @@
+package stampede
+
+import "context"
+
+// Deleter is implemented by caches which can remove keys.  Deleting a missing
+// key is not an error.
+type Deleter[K comparable] interface {
+	Delete(ctx context.Context, key K) error
+}
+
+// Invalidate removes key from the cache, so the next fetch recomputes it.  It
+// returns ErrDeleteUnsupported if the cache does not implement Deleter.
+func (xf *XFetcher[K, V]) Invalidate(ctx context.Context, key K) error {
+	return deleteKey(ctx, xf.cache, key)
+}
+
+// deleteKey deletes key from c if c implements Deleter
+func deleteKey[K comparable, V any](ctx context.Context, c Cache[K, V], key K) error {
+	d, ok := c.(Deleter[K])
+	if !ok {
+		return ErrDeleteUnsupported
+	}
+	return d.Delete(ctx, key)
+}
@@ -0,0 +1,36 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+func TestInvalidate(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int]())
+	var calls atomic.Int32
+	xf.Fetch(ctx, "k", counting(&calls, time.Hour))
+	if err := xf.Invalidate(ctx, "k"); err != nil {
+		t.Fatalf("Invalidate = %v", err)
+	}
+	if v, _ := xf.Fetch(ctx, "k", counting(&calls, time.Hour)); v != 2 {
+		t.Errorf("Fetch after Invalidate = %d, want a recompute", v)
+	}
+	if err := xf.Invalidate(ctx, "absent"); err != nil {
+		t.Errorf("Invalidate(absent) = %v", err)
+	}
+
+	// a cache with only Get and Set
+	nodelete := struct{ stampede.Cache[string, int] }{testutil.NullCache[string, int]{}}
+	xf = stampede.New[string, int](nodelete)
+	if err := xf.Invalidate(ctx, "k"); !errors.Is(err, stampede.ErrDeleteUnsupported) {
+		t.Errorf("Invalidate without Delete = %v, want ErrDeleteUnsupported", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Package keys builds string cache keys from structured values, hashing those
+// too long or unsafe for the backend
+package keys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-stampede"
+)
+
+// Sep separates the parts of keys built by Join
+const Sep = ':'
+
+var escaper = strings.NewReplacer(`\`, `\\`, string(Sep), `\`+string(Sep))
+
+// Join formats parts with fmt's %v and joins them with Sep.  Occurrences of
+// Sep within a part are escaped, so distinct parts always give distinct keys.
+func Join(parts ...any) string {
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 {
+			b.WriteByte(Sep)
+		}
+		escaper.WriteString(&b, fmt.Sprint(p))
+	}
+	return b.String()
+}
+
+// Struct returns a key for the struct v built by Join from the type name and
+// the exported fields in order.  v may be a pointer to a struct.
+func Struct(v any) string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("keys: Struct of non-struct %T", v))
+	}
+	rt := rv.Type()
+	parts := []any{rt.Name()}
+	for i := range rt.NumField() {
+		if rt.Field(i).IsExported() {
+			parts = append(parts, rv.Field(i).Interface())
+		}
+	}
+	return Join(parts...)
+}
+
+// A Hasher returns a digest of b, as text safe for any backend
+type Hasher func(b []byte) string
+
+// SHA256 is a Hasher returning the hex SHA-256 of b
+func SHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// XXHash is a Hasher returning the hex 64-bit xxHash of b.  It is much faster
+// than SHA256, but collisions can be constructed deliberately.
+func XXHash(b []byte) string {
+	return strconv.FormatUint(xxhash.Sum64(b), 16)
+}
+
+// Limit returns key unchanged if it is at most max bytes, and otherwise a
+// prefix of key followed by '#' and its digest with h, max bytes in all.
+// Keeping a prefix leaves prefix-based metrics labels and debugging intact.
+func Limit(key string, max int, h Hasher) string {
+	if len(key) <= max {
+		return key
+	}
+	d := h([]byte(key))
+	n := max - len(d) - 1
+	if n < 0 {
+		return d[:max]
+	}
+	return key[:n] + "#" + d
+}
+
+// MemcachedMaxKey is the longest key memcached accepts
+const MemcachedMaxKey = 250
+
+// Memcached returns key if memcached accepts it, and otherwise its digest
+// with h: memcached keys are at most 250 bytes, without spaces or control
+// characters.
+func Memcached(key string, h Hasher) string {
+	if len(key) <= MemcachedMaxKey && !strings.ContainsFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return key
+	}
+	return Limit(h([]byte(key)), MemcachedMaxKey, h)
+}
+
+// Cache adapts a cache with string keys to keys of type K, so an XFetcher can
+// be keyed by structured values
+type Cache[K comparable, V any] struct {
+	inner stampede.Cache[string, V]
+	key   func(K) string
+}
+
+// Map returns a Cache storing in inner under the keys given by fn, which must
+// map distinct keys to distinct strings
+func Map[K comparable, V any](inner stampede.Cache[string, V], fn func(K) string) *Cache[K, V] {
+	return &Cache[K, V]{inner: inner, key: fn}
+}
+
+// Get implements stampede.Cache
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (stampede.Item[V], error) {
+	return c.inner.Get(ctx, c.key(key))
+}
+
+// Set implements stampede.Cache
+func (c *Cache[K, V]) Set(ctx context.Context, key K, item stampede.Item[V]) error {
+	return c.inner.Set(ctx, c.key(key), item)
+}
+
+// SetIfNewer implements stampede.ConditionalSetter, writing unconditionally
+// if the underlying cache does not support it
+func (c *Cache[K, V]) SetIfNewer(ctx context.Context, key K, item stampede.Item[V]) (bool, error) {
+	cs, ok := c.inner.(stampede.ConditionalSetter[string, V])
+	if !ok {
+		return true, c.Set(ctx, key, item)
+	}
+	return cs.SetIfNewer(ctx, c.key(key), item)
+}
+
+// ClaimLease implements stampede.LeaseClaimer, writing unconditionally if the
+// underlying cache does not support it
+func (c *Cache[K, V]) ClaimLease(ctx context.Context, key K, prev *stampede.Item[V], lease stampede.Item[V]) (bool, error) {
+	lc, ok := c.inner.(stampede.LeaseClaimer[string, V])
+	if !ok {
+		return true, c.Set(ctx, key, lease)
+	}
+	return lc.ClaimLease(ctx, c.key(key), prev, lease)
+}
+
+// Delete implements stampede.Deleter, returning stampede.ErrDeleteUnsupported
+// if the underlying cache does not
+func (c *Cache[K, V]) Delete(ctx context.Context, key K) error {
+	d, ok := c.inner.(stampede.Deleter[string])
+	if !ok {
+		return stampede.ErrDeleteUnsupported
+	}
+	return d.Delete(ctx, c.key(key))
+}
+
+// GetMulti implements stampede.BatchGetter, in one operation if the
+// underlying cache supports it
+func (c *Cache[K, V]) GetMulti(ctx context.Context, keys []K) (map[K]stampede.Item[V], error) {
+	items := make(map[K]stampede.Item[V], len(keys))
+	bg, ok := c.inner.(stampede.BatchGetter[string, V])
+	if !ok {
+		for _, key := range keys {
+			item, err := c.Get(ctx, key)
+			if errors.Is(err, stampede.ErrCacheMiss) {
+				continue
+			}
+			if err != nil {
+				return items, err
+			}
+			items[key] = item
+		}
+		return items, nil
+	}
+
+	mapped := make([]string, len(keys))
+	for i, key := range keys {
+		mapped[i] = c.key(key)
+	}
+	got, err := bg.GetMulti(ctx, mapped)
+	for i, key := range keys {
+		if item, ok := got[mapped[i]]; ok {
+			items[key] = item
+		}
+	}
+	return items, err
+}
+
+// SetMulti implements stampede.BatchSetter, in one operation if the
+// underlying cache supports it
+func (c *Cache[K, V]) SetMulti(ctx context.Context, items map[K]stampede.Item[V]) error {
+	bs, ok := c.inner.(stampede.BatchSetter[string, V])
+	if !ok {
+		var errs []error
+		for key, item := range items {
+			errs = append(errs, c.Set(ctx, key, item))
+		}
+		return errors.Join(errs...)
+	}
+
+	mapped := make(map[string]stampede.Item[V], len(items))
+	for key, item := range items {
+		mapped[c.key(key)] = item
+	}
+	return bs.SetMulti(ctx, mapped)
+}
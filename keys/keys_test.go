@@ -0,0 +1,115 @@
+package keys_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/keys"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestJoin(t *testing.T) {
+	for _, tt := range []struct {
+		parts []any
+		want  string
+	}{
+		{nil, ""},
+		{[]any{"user", 42}, "user:42"},
+		{[]any{"a:b", "c"}, `a\:b:c`},
+		{[]any{"a", "b:c"}, `a:b\:c`},
+		{[]any{`a\`, "b"}, `a\\:b`},
+	} {
+		if got := keys.Join(tt.parts...); got != tt.want {
+			t.Errorf("Join(%q) = %q, want %q", tt.parts, got, tt.want)
+		}
+	}
+}
+
+type query struct {
+	User   int
+	Page   int
+	hidden string
+}
+
+func TestStruct(t *testing.T) {
+	q := query{User: 1, Page: 2, hidden: "x"}
+	if got, want := keys.Struct(q), "query:1:2"; got != want {
+		t.Errorf("Struct = %q, want %q", got, want)
+	}
+	if keys.Struct(&q) != keys.Struct(q) {
+		t.Error("Struct of a pointer differs")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Struct of an int did not panic")
+		}
+	}()
+	keys.Struct(1)
+}
+
+func TestLimit(t *testing.T) {
+	short := "short"
+	if got := keys.Limit(short, 10, keys.SHA256); got != short {
+		t.Errorf("Limit(%q) = %q", short, got)
+	}
+
+	long := strings.Repeat("x", 100)
+	for _, max := range []int{80, 10} {
+		got := keys.Limit(long, max, keys.XXHash)
+		if len(got) > max {
+			t.Errorf("Limit(long, %d) = %q, too long", max, got)
+		}
+		if keys.Limit(long+"y", max, keys.XXHash) == got {
+			t.Errorf("Limit(long, %d) collided", max)
+		}
+	}
+	if got := keys.Limit(long, 80, keys.SHA256); !strings.HasPrefix(got, "xxxxxxxxxxxxxxx#") {
+		t.Errorf("Limit(long, 80) = %q, want the key's prefix kept", got)
+	}
+}
+
+func TestMemcached(t *testing.T) {
+	if got := keys.Memcached("user:1", keys.SHA256); got != "user:1" {
+		t.Errorf("Memcached(user:1) = %q", got)
+	}
+	for _, key := range []string{"with space", "ctl\x01", "del\x7f", strings.Repeat("x", 251)} {
+		got := keys.Memcached(key, keys.SHA256)
+		if got == key || len(got) > keys.MemcachedMaxKey || strings.ContainsAny(got, " \x01\x7f") {
+			t.Errorf("Memcached(%q) = %q", key, got)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return keys.Map[string, string](memcache.New[string, string](), func(k string) string { return "m/" + k })
+	})
+}
+
+func TestMapBatchFallback(t *testing.T) {
+	ctx := context.Background()
+	// a cache with only Get and Set, so Map emulates the batch operations
+	inner := struct{ stampede.Cache[string, string] }{memcache.New[string, string]()}
+	c := keys.Map[int, string](inner, strconv.Itoa)
+
+	err := c.SetMulti(ctx, map[int]stampede.Item[string]{1: {Value: "a"}, 2: {Value: "b"}})
+	if err != nil {
+		t.Fatalf("SetMulti = %v", err)
+	}
+	if item, err := inner.Get(ctx, "2"); err != nil || item.Value != "b" {
+		t.Fatalf("inner Get(2) = %+v, %v", item, err)
+	}
+	items, err := c.GetMulti(ctx, []int{1, 2, 3})
+	if err != nil || len(items) != 2 || items[1].Value != "a" || items[2].Value != "b" {
+		t.Fatalf("GetMulti = %v, %v", items, err)
+	}
+	if err := c.Delete(ctx, 1); !errors.Is(err, stampede.ErrDeleteUnsupported) {
+		t.Errorf("Delete = %v, want ErrDeleteUnsupported", err)
+	}
+}
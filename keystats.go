@@ -0,0 +1,132 @@
+package stampede
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// KeyStats counts the activity of one key
+type KeyStats[K comparable] struct {
+	Key K
+
+	// Fetches estimates the fetches of the key, scaled up from those
+	// sampled
+	Fetches uint64
+
+	// Recomputes counts the recomputes of the key, and RecomputeTime
+	// their total duration
+	Recomputes    uint64
+	RecomputeTime time.Duration
+
+	// LastDelta is the duration of the latest recompute
+	LastDelta time.Duration
+}
+
+// WithKeyStats tracks per-key counters for TopKeys.  The fraction sample of
+// fetches is counted; recomputes are always counted.  At most maxKeys keys are
+// tracked: when full, a rarely recomputed key is forgotten to make room.
+// Key stats are disabled by default.
+func WithKeyStats(sample float64, maxKeys int) Option {
+	return func(c *config) {
+		c.keySample = sample
+		c.maxKeyStats = maxKeys
+	}
+}
+
+type keyStats[K comparable] struct {
+	sample  float64
+	max     int
+	float64 func() float64
+
+	mu sync.Mutex
+	m  map[K]*KeyStats[K]
+}
+
+func newKeyStats[K comparable](sample float64, maxKeys int, rand func() float64) *keyStats[K] {
+	return &keyStats[K]{
+		sample:  min(sample, 1),
+		max:     maxKeys,
+		float64: rand,
+		m:       make(map[K]*KeyStats[K]),
+	}
+}
+
+// evictSample is how many keys are considered for eviction when full
+const evictSample = 5
+
+// entry returns the stats for key, making room for it if needed.  ks.mu must
+// be held.
+func (ks *keyStats[K]) entry(key K) *KeyStats[K] {
+	if s, ok := ks.m[key]; ok {
+		return s
+	}
+	if len(ks.m) >= ks.max {
+		// approximate the least loaded key from a few, in map order
+		var victim *KeyStats[K]
+		n := 0
+		for _, s := range ks.m {
+			if victim == nil || s.Recomputes < victim.Recomputes ||
+				s.Recomputes == victim.Recomputes && s.Fetches < victim.Fetches {
+				victim = s
+			}
+			if n++; n == evictSample {
+				break
+			}
+		}
+		delete(ks.m, victim.Key)
+	}
+	s := &KeyStats[K]{Key: key}
+	ks.m[key] = s
+	return s
+}
+
+func (ks *keyStats[K]) fetched(key K) {
+	if ks == nil || ks.sample < 1 && ks.float64() >= ks.sample {
+		return
+	}
+	ks.mu.Lock()
+	ks.entry(key).Fetches++
+	ks.mu.Unlock()
+}
+
+func (ks *keyStats[K]) recomputed(key K, d time.Duration) {
+	if ks == nil {
+		return
+	}
+	ks.mu.Lock()
+	s := ks.entry(key)
+	s.Recomputes++
+	s.RecomputeTime += d
+	s.LastDelta = d
+	ks.mu.Unlock()
+}
+
+// TopKeys returns the stats of the n keys which have spent the most time
+// recomputing, and so put the most load on the origin, most first.  Ties are
+// broken by fetches.  It returns nil unless WithKeyStats is set.
+func (xf *XFetcher[K, V]) TopKeys(n int) []KeyStats[K] {
+	ks := xf.keyStats
+	if ks == nil {
+		return nil
+	}
+
+	ks.mu.Lock()
+	top := make([]KeyStats[K], 0, len(ks.m))
+	for _, s := range ks.m {
+		top = append(top, *s)
+	}
+	ks.mu.Unlock()
+
+	for i := range top {
+		top[i].Fetches = uint64(float64(top[i].Fetches) / ks.sample)
+	}
+	slices.SortFunc(top, func(a, b KeyStats[K]) int {
+		if c := cmp.Compare(b.RecomputeTime, a.RecomputeTime); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Fetches, a.Fetches)
+	})
+	return top[:min(n, len(top))]
+}
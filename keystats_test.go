@@ -0,0 +1,95 @@
+package stampede_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// taking returns a recompute which takes d on clock
+func taking(clock *fakeclock.Clock, d time.Duration) stampede.RecomputeFunc[int] {
+	return func(ctx context.Context) (int, time.Duration, error) {
+		clock.Advance(d)
+		return 1, time.Minute, nil
+	}
+}
+
+func TestTopKeys(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(stampede.NeverExpire),
+		stampede.WithKeyStats(1, 10),
+	)
+
+	xf.Fetch(ctx, "a", taking(clock, 3*time.Second))
+	for range 5 {
+		xf.Fetch(ctx, "b", taking(clock, time.Second))
+	}
+	xf.Fetch(ctx, "c", taking(clock, 2*time.Second))
+	xf.Refresh(ctx, "c", taking(clock, 2*time.Second))
+
+	top := xf.TopKeys(2)
+	if len(top) != 2 || top[0].Key != "c" || top[1].Key != "a" {
+		t.Fatalf("TopKeys(2) = %+v, want c then a", top)
+	}
+	if s := top[0]; s.Recomputes != 2 || s.RecomputeTime != 4*time.Second || s.LastDelta != 2*time.Second || s.Fetches != 1 {
+		t.Errorf("stats of c = %+v", s)
+	}
+	top = xf.TopKeys(10)
+	if len(top) != 3 || top[2].Key != "b" || top[2].Fetches != 5 || top[2].Recomputes != 1 {
+		t.Errorf("TopKeys(10) = %+v, want b last with 5 fetches", top)
+	}
+}
+
+func TestTopKeysSampled(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(1))
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithRand(r.Float64),
+		stampede.WithBeta(0),
+		stampede.WithKeyStats(0.25, 10),
+	)
+	for range 1000 {
+		xf.Fetch(ctx, "k", succeeding)
+	}
+
+	// fetches are estimated from the sample, and recomputes counted exactly
+	top := xf.TopKeys(1)
+	if len(top) != 1 || top[0].Fetches < 800 || top[0].Fetches > 1200 || top[0].Recomputes != 1 {
+		t.Errorf("TopKeys(1) = %+v, want about 1000 fetches and 1 recompute", top)
+	}
+}
+
+func TestTopKeysEviction(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithKeyStats(1, 2),
+	)
+	xf.Fetch(ctx, "a", taking(clock, time.Second))
+	xf.Refresh(ctx, "a", taking(clock, time.Second))
+	xf.Fetch(ctx, "b", taking(clock, time.Second))
+
+	// the less recomputed key makes room
+	xf.Fetch(ctx, "c", taking(clock, time.Second))
+	top := xf.TopKeys(10)
+	if len(top) != 2 || top[0].Key != "a" || top[1].Key != "c" {
+		t.Errorf("TopKeys after eviction = %+v, want a and c", top)
+	}
+}
+
+func TestTopKeysDisabled(t *testing.T) {
+	xf := stampede.New[string, int](memcache.New[string, int]())
+	xf.Fetch(context.Background(), "k", succeeding)
+	if top := xf.TopKeys(10); top != nil {
+		t.Errorf("TopKeys without WithKeyStats = %+v, want nil", top)
+	}
+}
@@ -0,0 +1,188 @@
+package stampede
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// LatencySummary summarizes the durations of an operation over the
+// WithLatencyWindow window.  Percentiles are accurate to within about 6%.
+type LatencySummary struct {
+	Count         uint64
+	P50, P95, P99 time.Duration
+	Max           time.Duration
+}
+
+// WithLatencyWindow records histograms of the durations of recomputes, cache
+// reads and cache writes over a sliding window of the last window, reported
+// in Stats, so that a slow origin can be told from a slow cache.  The window
+// advances in tenths.  A FetchMulti batch read or write counts as one
+// operation.  Latencies are not recorded by default.
+func WithLatencyWindow(window time.Duration) Option {
+	return func(c *config) { c.latencyWindow = window }
+}
+
+// latencyOp is an operation whose latency is recorded
+type latencyOp int
+
+const (
+	opRecompute latencyOp = iota
+	opCacheGet
+	opCacheSet
+	numLatencyOps
+)
+
+// latencies holds the latency windows of a fetcher
+type latencies struct {
+	clock Clock
+	ops   [numLatencyOps]latencyWindow
+}
+
+func newLatencies(window time.Duration, clock Clock) *latencies {
+	l := &latencies{clock: clock}
+	for i := range l.ops {
+		l.ops[i].slice = max(window/latencySlices, 1)
+	}
+	return l
+}
+
+// start returns the start time of an operation, or the zero time if l is nil
+func (l *latencies) start() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	return l.clock.Now()
+}
+
+// since records an operation op which began at start
+func (l *latencies) since(op latencyOp, start time.Time) {
+	if l == nil {
+		return
+	}
+	now := l.clock.Now()
+	l.ops[op].record(now, now.Sub(start))
+}
+
+// observe records an operation op which took d
+func (l *latencies) observe(op latencyOp, d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.ops[op].record(l.clock.Now(), d)
+}
+
+func (l *latencies) summary(op latencyOp) LatencySummary {
+	if l == nil {
+		return LatencySummary{}
+	}
+	return l.ops[op].summary(l.clock.Now())
+}
+
+const (
+	// latencySlices is the number of slices a window advances by
+	latencySlices = 10
+
+	// latencySubBits sets the 2^latencySubBits buckets per power of two
+	latencySubBits = 3
+
+	// latencyMaxBits bounds recorded durations, at about 36 minutes
+	latencyMaxBits = 41
+
+	latencyBuckets = (latencyMaxBits - latencySubBits + 1) << latencySubBits
+)
+
+// latencyWindow is a sliding window histogram with log-linear buckets, as in
+// HdrHistogram
+type latencyWindow struct {
+	slice time.Duration
+
+	mu     sync.Mutex
+	slices [latencySlices]latencySlice
+}
+
+type latencySlice struct {
+	start  time.Time
+	count  uint64
+	max    time.Duration
+	counts [latencyBuckets]uint64
+}
+
+func (w *latencyWindow) record(now time.Time, d time.Duration) {
+	start := now.Truncate(w.slice)
+	i := int(uint64(start.UnixNano()/int64(w.slice)) % latencySlices)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &w.slices[i]
+	if !s.start.Equal(start) {
+		*s = latencySlice{start: start}
+	}
+	s.count++
+	s.max = max(s.max, d)
+	s.counts[latencyBucket(d)]++
+}
+
+func (w *latencyWindow) summary(now time.Time) LatencySummary {
+	var counts [latencyBuckets]uint64
+	var sum LatencySummary
+	oldest := now.Truncate(w.slice).Add(-w.slice * (latencySlices - 1))
+
+	w.mu.Lock()
+	for i := range w.slices {
+		s := &w.slices[i]
+		if s.count == 0 || s.start.Before(oldest) {
+			continue
+		}
+		sum.Count += s.count
+		sum.Max = max(sum.Max, s.max)
+		for b, n := range s.counts {
+			counts[b] += n
+		}
+	}
+	w.mu.Unlock()
+
+	if sum.Count == 0 {
+		return sum
+	}
+	sum.P50 = percentile(&counts, sum.Count, 0.50, sum.Max)
+	sum.P95 = percentile(&counts, sum.Count, 0.95, sum.Max)
+	sum.P99 = percentile(&counts, sum.Count, 0.99, sum.Max)
+	return sum
+}
+
+// percentile returns the p'th percentile of the n durations in counts, no
+// more than the largest, hi
+func percentile(counts *[latencyBuckets]uint64, n uint64, p float64, hi time.Duration) time.Duration {
+	rank := uint64(float64(n-1)*p) + 1
+	var seen uint64
+	for b, c := range counts {
+		if seen += c; seen >= rank {
+			return min(bucketValue(b), hi)
+		}
+	}
+	return hi
+}
+
+// latencyBucket returns the bucket of d: durations below 2^latencySubBits
+// nanoseconds have their own bucket, and each power of two above is split
+// into 2^latencySubBits buckets
+func latencyBucket(d time.Duration) int {
+	v := uint64(max(d, 0))
+	v = min(v, 1<<latencyMaxBits-1)
+	if v < 1<<latencySubBits {
+		return int(v)
+	}
+	e := bits.Len64(v) - latencySubBits - 1
+	return (e+1)<<latencySubBits + int(v>>e) - 1<<latencySubBits
+}
+
+// bucketValue returns the midpoint of bucket b
+func bucketValue(b int) time.Duration {
+	if b < 1<<latencySubBits {
+		return time.Duration(b)
+	}
+	e := b>>latencySubBits - 1
+	m := uint64(b&(1<<latencySubBits-1) + 1<<latencySubBits)
+	return time.Duration(m<<e + (1<<e)/2)
+}
@@ -0,0 +1,77 @@
+package stampede_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// within reports whether d is within 6% of want
+func within(d, want time.Duration) bool {
+	return d >= want-want*6/100 && d <= want+want*6/100
+}
+
+func TestLatencyWindow(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithLatencyWindow(10*time.Second),
+	)
+	for i := 1; i <= 100; i++ {
+		xf.Fetch(ctx, strconv.Itoa(i), taking(clock, time.Duration(i)*time.Millisecond))
+	}
+
+	st := xf.Stats()
+	r := st.RecomputeLatency
+	if r.Count != 100 || r.Max != 100*time.Millisecond {
+		t.Fatalf("RecomputeLatency = %+v, want 100 up to 100ms", r)
+	}
+	for _, p := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"P50", r.P50, 50 * time.Millisecond},
+		{"P95", r.P95, 95 * time.Millisecond},
+		{"P99", r.P99, 99 * time.Millisecond},
+	} {
+		if !within(p.got, p.want) {
+			t.Errorf("RecomputeLatency.%s = %v, want about %v", p.name, p.got, p.want)
+		}
+	}
+	if g, s := st.CacheGetLatency, st.CacheSetLatency; g.Count != 100 || g.Max != 0 || s.Count != 100 || s.Max != 0 {
+		t.Errorf("cache latencies = %+v, %+v; want 100 instant operations each", g, s)
+	}
+
+	// the window slides past them
+	clock.Advance(10 * time.Second)
+	if r := xf.Stats().RecomputeLatency; r != (stampede.LatencySummary{}) {
+		t.Errorf("RecomputeLatency a window later = %+v, want none", r)
+	}
+}
+
+func TestLatencyWindowSlides(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithLatencyWindow(10*time.Second),
+	)
+	xf.Fetch(ctx, "slow", taking(clock, time.Second))
+	clock.Advance(5 * time.Second)
+	xf.Fetch(ctx, "fast", taking(clock, time.Millisecond))
+	if r := xf.Stats().RecomputeLatency; r.Count != 2 || r.Max != time.Second {
+		t.Fatalf("RecomputeLatency = %+v, want both recomputes", r)
+	}
+
+	// the slow recompute leaves the window first
+	clock.Advance(5 * time.Second)
+	if r := xf.Stats().RecomputeLatency; r.Count != 1 || r.Max != time.Millisecond || !within(r.P99, time.Millisecond) {
+		t.Errorf("RecomputeLatency = %+v, want only the fast recompute", r)
+	}
+}
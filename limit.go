@@ -0,0 +1,172 @@
+package stampede
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+)
+
+// LimitPolicy controls what happens to a recompute beyond a limit
+type LimitPolicy int
+
+const (
+	// LimitServeStale fails the recompute, serving the cached value of
+	// any age if there is one
+	LimitServeStale LimitPolicy = iota
+
+	// LimitWait blocks until the recompute is allowed or the context is
+	// done
+	LimitWait
+
+	// LimitError fails the recompute, with stale serving bounded as for
+	// other errors by WithStaleIfError
+	LimitError
+)
+
+// acquire takes a recompute slot, if WithMaxConcurrentRecomputes is set.
+// Under LimitWait, the most urgent waiter is admitted first.  The caller must
+// release it if it succeeds.
+func (xf *XFetcher[K, V]) acquire(ctx context.Context, urgency float64) error {
+	if xf.slots == nil {
+		return nil
+	}
+	if xf.capacityPolicy != LimitWait {
+		if !xf.slots.tryAcquire() {
+			return limitFailed(xf.capacityPolicy, ErrOverCapacity)
+		}
+		return nil
+	}
+	return xf.slots.acquire(ctx, urgency)
+}
+
+func (xf *XFetcher[K, V]) release() {
+	if xf.slots != nil {
+		xf.slots.release()
+	}
+}
+
+// slotPool is a counting semaphore handing freed slots to the waiter of
+// highest priority, first come first served within a priority
+type slotPool struct {
+	mu      sync.Mutex
+	free    int
+	waiters slotWaiters
+	seq     uint64
+}
+
+type slotWaiter struct {
+	priority float64
+	seq      uint64
+	ready    chan struct{}
+	index    int // in waiters, or -1 once granted
+}
+
+func newSlotPool(n int) *slotPool {
+	return &slotPool{free: n}
+}
+
+func (p *slotPool) tryAcquire() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.free > 0 && len(p.waiters) == 0 {
+		p.free--
+		return true
+	}
+	return false
+}
+
+func (p *slotPool) acquire(ctx context.Context, priority float64) error {
+	p.mu.Lock()
+	if p.free > 0 && len(p.waiters) == 0 {
+		p.free--
+		p.mu.Unlock()
+		return nil
+	}
+	p.seq++
+	w := &slotWaiter{priority: priority, seq: p.seq, ready: make(chan struct{})}
+	heap.Push(&p.waiters, w)
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	if w.index < 0 {
+		// granted while giving up: pass the slot on
+		p.mu.Unlock()
+		p.release()
+	} else {
+		heap.Remove(&p.waiters, w.index)
+		p.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+func (p *slotPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.waiters) == 0 {
+		p.free++
+		return
+	}
+	w := heap.Pop(&p.waiters).(*slotWaiter)
+	close(w.ready)
+}
+
+// slotWaiters is a max-heap of waiters by priority, then arrival
+type slotWaiters []*slotWaiter
+
+func (h slotWaiters) Len() int { return len(h) }
+func (h slotWaiters) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h slotWaiters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *slotWaiters) Push(x any) {
+	w := x.(*slotWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *slotWaiters) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
+
+// limitError marks a limit failure which may serve a stale value of any age
+type limitError struct {
+	err error
+}
+
+func (e *limitError) Error() string { return e.err.Error() }
+func (e *limitError) Unwrap() error { return e.err }
+
+// limitFailed returns the error for a recompute refused with err under policy
+func limitFailed(policy LimitPolicy, err error) error {
+	if policy == LimitServeStale {
+		return &limitError{err}
+	}
+	return err
+}
+
+// staleAllowed reports whether err is a limit failure allowing stale values
+// of any age
+func staleAllowed(err error) bool {
+	var lerr *limitError
+	return errors.As(err, &lerr)
+}
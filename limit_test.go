@@ -0,0 +1,231 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithRateLimit(1, 2, stampede.LimitError),
+	)
+
+	for i := range 2 {
+		if _, err := xf.Fetch(ctx, "k", succeeding); err != nil {
+			t.Fatalf("Fetch %d within the burst = %v", i, err)
+		}
+	}
+	if _, err := xf.Fetch(ctx, "k", succeeding); !errors.Is(err, stampede.ErrRateLimited) {
+		t.Fatalf("Fetch beyond the burst = %v, want ErrRateLimited", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := xf.Fetch(ctx, "k", succeeding); err != nil {
+		t.Fatalf("Fetch after a second's refill = %v", err)
+	}
+}
+
+func TestRateLimitStale(t *testing.T) {
+	for _, tt := range []struct {
+		policy stampede.LimitPolicy
+		stale  bool
+	}{
+		{stampede.LimitServeStale, true},
+		{stampede.LimitError, false},
+	} {
+		ctx := context.Background()
+		clock := fakeclock.New(time.Unix(0, 0))
+		xf := stampede.New[string, int](memcache.New[string, int](),
+			stampede.WithClock(clock),
+			stampede.WithRateLimit(1e-6, 1, tt.policy),
+		)
+
+		xf.Fetch(ctx, "k", succeeding)
+		clock.Advance(time.Hour)
+		r, err := xf.FetchItem(ctx, "k", succeeding)
+		if tt.stale && (err != nil || r.Source != stampede.SourceStale) {
+			t.Errorf("policy %v: FetchItem = %+v, %v; want the stale value", tt.policy, r, err)
+		}
+		if !tt.stale && !errors.Is(err, stampede.ErrRateLimited) {
+			t.Errorf("policy %v: FetchItem = %+v, %v; want ErrRateLimited", tt.policy, r, err)
+		}
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithRateLimit(2, 1, stampede.LimitWait),
+	)
+
+	xf.Fetch(ctx, "k", succeeding)
+	done := make(chan error)
+	go func() {
+		_, err := xf.Fetch(ctx, "k", succeeding)
+		done <- err
+	}()
+
+	// the next token is half a second away
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(400 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Fetch returned %v before its token", err)
+	default:
+	}
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("Fetch after waiting = %v", err)
+	}
+
+	// a waiting fetch gives up with its context
+	clock.Advance(500 * time.Millisecond)
+	xf.Fetch(ctx, "k", succeeding)
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		_, err := xf.Fetch(cctx, "k", succeeding)
+		done <- err
+	}()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled Fetch = %v, want context.Canceled", err)
+	}
+}
+
+func TestKeyRateLimit(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	prefix := func(key string) string { return key[:1] }
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithKeyRateLimit(1, 1, stampede.LimitError, prefix),
+	)
+
+	if _, err := xf.Fetch(ctx, "a1", succeeding); err != nil {
+		t.Fatalf("Fetch(a1) = %v", err)
+	}
+	if _, err := xf.Fetch(ctx, "a2", succeeding); !errors.Is(err, stampede.ErrRateLimited) {
+		t.Fatalf("Fetch(a2) = %v, want a's group rate limited", err)
+	}
+	if _, err := xf.Fetch(ctx, "b1", succeeding); err != nil {
+		t.Fatalf("Fetch(b1) = %v, want b's group unaffected", err)
+	}
+}
+
+func TestMaxConcurrentRecomputes(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithMaxConcurrentRecomputes(1, stampede.LimitServeStale),
+	)
+	xf.Fetch(ctx, "stale", succeeding)
+	clock.Advance(time.Hour)
+
+	release := hold(t, xf)
+	defer release()
+
+	if _, err := xf.Fetch(ctx, "k", succeeding); !errors.Is(err, stampede.ErrOverCapacity) {
+		t.Errorf("Fetch over capacity = %v, want ErrOverCapacity", err)
+	}
+	if r, err := xf.FetchItem(ctx, "stale", succeeding); err != nil || r.Source != stampede.SourceStale {
+		t.Errorf("FetchItem over capacity = %+v, %v; want the stale value", r, err)
+	}
+}
+
+func TestMaxConcurrentRecomputesByCost(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithMaxConcurrentRecomputes(1, stampede.LimitWait),
+		stampede.WithCostClass(func(key string) stampede.CostClass {
+			switch key {
+			case "cheap":
+				return stampede.CostCheap
+			case "expensive":
+				return stampede.CostExpensive
+			}
+			return stampede.CostNormal
+		}),
+	)
+
+	release := hold(t, xf)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for _, key := range []string{"cheap", "normal", "expensive"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			xf.Fetch(ctx, key, func(ctx context.Context) (int, time.Duration, error) {
+				mu.Lock()
+				order = append(order, key)
+				mu.Unlock()
+				return 1, time.Minute, nil
+			})
+		}()
+		// queue the waiters in turn
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+	wg.Wait()
+
+	want := []string{"expensive", "normal", "cheap"}
+	for i := range want {
+		if i >= len(order) || order[i] != want[i] {
+			t.Fatalf("recompute order %v, want %v", order, want)
+		}
+	}
+}
+
+// hold takes the only recompute slot of xf, until the returned function is
+// called
+func hold(t *testing.T, xf *stampede.XFetcher[string, int]) func() {
+	t.Helper()
+	started, unblock, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		xf.Fetch(context.Background(), "held", func(ctx context.Context) (int, time.Duration, error) {
+			close(started)
+			<-unblock
+			return 1, time.Minute, nil
+		})
+	}()
+	<-started
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(unblock)
+			<-done
+		})
+	}
+}
+
+func TestCostClass(t *testing.T) {
+	for _, tt := range []struct {
+		class stampede.CostClass
+		name  string
+		cost  float64
+	}{
+		{stampede.CostNormal, "normal", 1},
+		{stampede.CostCheap, "cheap", 0.25},
+		{stampede.CostExpensive, "expensive", 4},
+	} {
+		if tt.class.String() != tt.name || tt.class.Cost() != tt.cost {
+			t.Errorf("class %d = %q costing %v, want %q costing %v", tt.class, tt.class, tt.class.Cost(), tt.name, tt.cost)
+		}
+	}
+}
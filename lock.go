@@ -0,0 +1,74 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Locker is a distributed lock used to ensure only one process recomputes a
+// key.  Locks expire after their TTL, so a crashed holder cannot block a key
+// forever.
+type Locker[K comparable] interface {
+	// TryLock attempts to acquire the lock for key without blocking.  On
+	// success it returns a token identifying this holder.
+	TryLock(ctx context.Context, key K, ttl time.Duration) (token string, ok bool, err error)
+
+	// Unlock releases the lock for key if it is still held with token
+	Unlock(ctx context.Context, key K, token string) error
+}
+
+// errLockHeld is returned by lockedCompute when another process holds the
+// lock and the cached item should be served instead
+var errLockHeld = errors.New("stampede: recompute lock held elsewhere")
+
+// lockedCompute runs compute while holding the distributed lock for key, if
+// a Locker is configured.  If the lock is held elsewhere, it returns
+// errLockHeld when the cached item prev should be served, or otherwise polls
+// the cache for the holder's result, computing the value itself if none
+// arrives in time.  Locker failures fall back to computing without the lock.
+func (xf *XFetcher[K, V]) lockedCompute(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) (Item[V], error) {
+	if xf.locker == nil {
+		return xf.claimedCompute(ctx, key, recompute, prev)
+	}
+
+	token, ok, err := xf.locker.TryLock(ctx, key, xf.lockTTL)
+	if err != nil {
+		return xf.claimedCompute(ctx, key, recompute, prev)
+	}
+	if ok {
+		xf.hold(key, token)
+		defer xf.unlock(context.WithoutCancel(ctx), key, token)
+		return xf.claimedCompute(ctx, key, recompute, prev)
+	}
+
+	now := xf.clock.Now()
+	if prev != nil && (!prev.Expired(now) || xf.lockServeStale) {
+		return Item[V]{}, errLockHeld
+	}
+
+	if item, done, err := xf.awaitHolder(ctx, key); done {
+		return item, err
+	}
+	xf.concurrent(key, true)
+	return xf.claimedCompute(ctx, key, recompute, prev)
+}
+
+// awaitHolder polls the cache for the result of a recompute held by another
+// process, for up to the WithLockWait duration.  done is set if it arrived or
+// ctx is done.
+func (xf *XFetcher[K, V]) awaitHolder(ctx context.Context, key K) (item Item[V], done bool, err error) {
+	deadline := xf.clock.Now().Add(xf.lockWait)
+	for xf.clock.Now().Before(deadline) {
+		select {
+		case <-xf.clock.After(xf.lockPoll):
+		case <-ctx.Done():
+			return Item[V]{}, true, ctx.Err()
+		}
+		item, err := xf.cache.Get(ctx, key)
+		if err == nil && item.Pending == nil && !item.Expired(xf.clock.Now()) {
+			return item, true, item.Err
+		}
+	}
+	return Item[V]{}, false, nil
+}
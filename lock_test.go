@@ -0,0 +1,102 @@
+package stampede_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// processes returns two fetchers sharing a cache and a locker, as two
+// processes would, and the shared cache
+func processes(opts ...stampede.Option) (a, b *stampede.XFetcher[string, int], cache *memcache.Cache[string, int]) {
+	cache = memcache.New[string, int]()
+	locker := stampede.NewStripedLocker[string](64, nil)
+	opts = append([]stampede.Option{
+		stampede.WithLocker[string](locker, time.Minute),
+		stampede.WithLockWait(time.Millisecond, time.Second),
+	}, opts...)
+	return stampede.New[string, int](cache, opts...), stampede.New[string, int](cache, opts...), cache
+}
+
+// holdRecompute starts a fetch of key on xf which recomputes v, and holds any
+// lock it takes, until the returned function is called
+func holdRecompute(t *testing.T, xf *stampede.XFetcher[string, int], key string, v int) func() {
+	t.Helper()
+	started, unblock := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		xf.Fetch(context.Background(), key, func(ctx context.Context) (int, time.Duration, error) {
+			close(started)
+			<-unblock
+			return v, time.Minute, nil
+		})
+	}()
+	<-started
+	return func() {
+		close(unblock)
+		<-done
+	}
+}
+
+func TestLockerWaitsForHolder(t *testing.T) {
+	ctx := context.Background()
+	a, b, _ := processes()
+	release := holdRecompute(t, a, "k", 1)
+
+	// b polls for a's result rather than recomputing
+	var calls atomic.Int32
+	done := make(chan int)
+	go func() {
+		v, _ := b.Fetch(ctx, "k", counting(&calls, time.Minute))
+		done <- v
+	}()
+	time.Sleep(20 * time.Millisecond)
+	release()
+	if v := <-done; v != 1 || calls.Load() != 0 {
+		t.Errorf("Fetch while locked = %d after %d recomputes, want a's 1", v, calls.Load())
+	}
+}
+
+func TestLockerServesCached(t *testing.T) {
+	ctx := context.Background()
+	a, b, cache := processes(stampede.WithRand(stampede.AlwaysExpire))
+	cache.Set(ctx, "k", stampede.Item[int]{Value: 1, Expiry: time.Now().Add(time.Minute)})
+	release := holdRecompute(t, a, "k", 2)
+	defer release()
+
+	// an early expiring value is served while the lock is held elsewhere
+	var calls atomic.Int32
+	if v, err := b.Fetch(ctx, "k", counting(&calls, time.Minute)); err != nil || v != 1 || calls.Load() != 0 {
+		t.Errorf("Fetch while locked = %d, %v after %d recomputes, want the cached 1", v, err, calls.Load())
+	}
+}
+
+func TestLockerServeStale(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		serveStale bool
+		want       int
+	}{
+		{true, 1},
+		{false, 3},
+	} {
+		a, b, cache := processes(
+			stampede.WithLockServeStale(tt.serveStale),
+			stampede.WithLockWait(time.Millisecond, 10*time.Millisecond),
+		)
+		cache.Set(ctx, "k", stampede.Item[int]{Value: 1, Expiry: time.Now().Add(-time.Minute)})
+		release := holdRecompute(t, a, "k", 2)
+
+		// without stale serving, b gives up waiting and recomputes
+		v, _ := b.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) { return 3, time.Minute, nil })
+		if v != tt.want {
+			t.Errorf("serve stale %v: Fetch of an expired value while locked = %d, want %d", tt.serveStale, v, tt.want)
+		}
+		release()
+	}
+}
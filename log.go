@@ -0,0 +1,80 @@
+package stampede
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// WithLogger logs fetch events to l, with the context of the fetch: the
+// decision of each cache lookup at debug level, stale values served at info
+// level, and recompute and cache write failures at warn level.  By default
+// nothing is logged.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) { c.logger = l }
+}
+
+// lookupMessages are the log messages of the lookup decisions
+var lookupMessages = [...]string{
+	DecisionHit:         "stampede: cache hit",
+	DecisionMiss:        "stampede: cache miss",
+	DecisionEarlyExpire: "stampede: early expiration",
+}
+
+func (xf *XFetcher[K, V]) logLookup(ctx context.Context, key K, info FetchInfo) {
+	if xf.logger == nil || !xf.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	xf.logger.LogAttrs(ctx, slog.LevelDebug, lookupMessages[info.Decision],
+		slog.Any("key", key),
+		slog.String("decision", info.Decision.String()),
+		slog.Duration("delta", info.Delta),
+		slog.Duration("ttl", info.TTL),
+		explainAttr(info.Explain),
+	)
+}
+
+// explainAttr returns the log attribute of the explanation ex, empty if nil
+func explainAttr(ex *Explanation) slog.Attr {
+	if ex == nil {
+		return slog.Attr{}
+	}
+	return slog.Group("explain",
+		slog.Float64("beta", ex.Beta),
+		slog.Float64("rand", ex.Rand),
+		slog.Duration("gap", ex.Gap),
+		slog.Bool("strategy", ex.Strategy),
+	)
+}
+
+func (xf *XFetcher[K, V]) logStaleServed(ctx context.Context, key K, item Item[V], err error) {
+	if xf.logger == nil {
+		return
+	}
+	xf.logger.LogAttrs(ctx, slog.LevelInfo, "stampede: serving stale value",
+		slog.Any("key", key),
+		slog.Duration("ttl", item.Expiry.Sub(xf.clock.Now())),
+		slog.Any("error", err),
+	)
+}
+
+func (xf *XFetcher[K, V]) logRecomputeFailure(ctx context.Context, key K, d time.Duration, err error) {
+	if xf.logger == nil {
+		return
+	}
+	xf.logger.LogAttrs(ctx, slog.LevelWarn, "stampede: recompute failed",
+		slog.Any("key", key),
+		slog.Duration("delta", d),
+		slog.Any("error", err),
+	)
+}
+
+func (xf *XFetcher[K, V]) logWriteFailure(ctx context.Context, key K, err error) {
+	if xf.logger == nil {
+		return
+	}
+	xf.logger.LogAttrs(ctx, slog.LevelWarn, "stampede: cache write failed",
+		slog.Any("key", key),
+		slog.Any("error", err),
+	)
+}
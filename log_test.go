@@ -0,0 +1,71 @@
+package stampede_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+type requestIDKey struct{}
+
+// record is a log record with the request ID of its context
+type record struct {
+	level     slog.Level
+	msg       string
+	requestID any
+}
+
+// recordingHandler is a slog.Handler keeping the records it handles
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []record
+}
+
+func (h *recordingHandler) Enabled(ctx context.Context, level slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs(attrs []slog.Attr) slog.Handler           { return h }
+func (h *recordingHandler) WithGroup(name string) slog.Handler                 { return h }
+
+func (h *recordingHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, record{r.Level, r.Message, ctx.Value(requestIDKey{})})
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	clock := fakeclock.New(time.Now())
+	h := &recordingHandler{}
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithLogger(slog.New(h)),
+		stampede.WithStaleIfError(time.Hour),
+	)
+
+	xf.Fetch(ctx, "k", succeeding)
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(2 * time.Minute)
+	xf.Fetch(ctx, "k", failing)
+
+	want := []record{
+		{slog.LevelDebug, "stampede: cache miss", "req-1"},
+		{slog.LevelDebug, "stampede: cache hit", "req-1"},
+		{slog.LevelDebug, "stampede: cache miss", "req-1"},
+		{slog.LevelWarn, "stampede: recompute failed", "req-1"},
+		{slog.LevelInfo, "stampede: serving stale value", "req-1"},
+	}
+	if len(h.records) != len(want) {
+		t.Fatalf("logged %v, want %v", h.records, want)
+	}
+	for i := range want {
+		if h.records[i] != want[i] {
+			t.Errorf("record %d = %v, want %v", i, h.records[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package memcache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// EvictReason is why an entry left the cache
+type EvictReason int
+
+const (
+	// Evicted entries made room for others under WithMaxEntries or
+	// WithMaxBytes
+	Evicted EvictReason = iota
+
+	// Expired entries were removed by a sweep; see WithSweeper
+	Expired
+
+	// Deleted entries were removed by Delete
+	Deleted
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case Evicted:
+		return "evicted"
+	case Expired:
+		return "expired"
+	case Deleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("EvictReason(%d)", int(r))
+}
+
+// Eviction describes an entry which left the cache
+type Eviction[K comparable, V any] struct {
+	Key    K
+	Item   stampede.Item[V]
+	Reason EvictReason
+
+	// Age is the time since the entry was last written
+	Age time.Duration
+}
+
+// WithEvictionHandler calls fn for each entry leaving the cache, e.g. to
+// maintain derived indexes or re-warm critical keys.  Overwriting an entry
+// does not count.  fn runs on the goroutine which caused the eviction, once
+// the shard lock is released, so it may use the cache; to handle evictions
+// asynchronously, fn can send them to a buffered channel.  The key and value
+// types must match the cache's.
+func WithEvictionHandler[K comparable, V any](fn func(ev Eviction[K, V])) Option {
+	return func(c *config) { c.onEvict = fn }
+}
+
+// unlock releases s and reports the evictions made while it was held
+func (c *Cache[K, V]) unlock(s *shard[K, V]) {
+	evicted := s.evicted
+	s.evicted = nil
+	s.mu.Unlock()
+	for _, ev := range evicted {
+		c.onEvict(ev)
+	}
+}
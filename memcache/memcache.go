@@ -0,0 +1,480 @@
+// Package memcache is an in-process stampede.Cache with LRU eviction
+package memcache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// Cache is a sharded, mutex-striped in-memory cache.  Each shard evicts its
+// least recently used entries when full.  Expired items are kept until
+// evicted, or swept by WithSweeper, so that they remain available for stale
+// serving.
+type Cache[K comparable, V any] struct {
+	seed    maphash.Seed
+	shards  []shard[K, V]
+	sizeOf  func(V) int
+	clone   func(V) V
+	codec   stampede.Codec[V]
+	onEvict func(Eviction[K, V])
+	clock   stampede.Clock
+
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	rejected atomic.Uint64
+	swept    atomic.Uint64
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	sweeping  sync.WaitGroup
+}
+
+type shard[K comparable, V any] struct {
+	mu     sync.Mutex
+	ll     *list.List
+	m      map[K]*list.Element
+	max    int
+	sketch *sketch
+
+	bytes    int64
+	maxBytes int64
+
+	// evicted holds the evictions to report once mu is released, if
+	// notify is set, aged by clock
+	notify  bool
+	evicted []Eviction[K, V]
+	clock   stampede.Clock
+}
+
+type entry[K comparable, V any] struct {
+	key  K
+	hash uint64
+	size int64
+	item stampede.Item[V]
+
+	// stored is when item was written
+	stored time.Time
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	shards     int
+	maxEntries int
+	maxBytes   int64
+	sizeOf     any
+	clone      any
+	codec      any
+	onEvict    any
+	admission  bool
+	clock      stampede.Clock
+
+	sweepInterval time.Duration
+	sweepGrace    time.Duration
+	sweepMetrics  stampede.SweepMetrics
+}
+
+// WithShards sets the number of independently locked shards.  The default is
+// 32.
+func WithShards(n int) Option {
+	return func(c *config) { c.shards = n }
+}
+
+// WithMaxEntries bounds the number of entries, divided evenly among the
+// shards.  The default of zero means no limit.
+func WithMaxEntries(n int) Option {
+	return func(c *config) { c.maxEntries = n }
+}
+
+// WithMaxBytes bounds the estimated memory used by entries, divided evenly
+// among the shards.  Entries are sized with the WithSizeOf function plus a
+// fixed overhead.  The default of zero means no limit.
+func WithMaxBytes(n int64) Option {
+	return func(c *config) { c.maxBytes = n }
+}
+
+// WithSizeOf sets the function estimating the size in bytes of values, for
+// WithMaxBytes.  The default sizes byte slices and strings by their length,
+// and other values by their shallow size, which undercounts values holding
+// pointers.  The value type must match the cache's.
+func WithSizeOf[V any](fn func(v V) int) Option {
+	return func(c *config) { c.sizeOf = fn }
+}
+
+// WithClone sets a function copying values, applied to values written and
+// read, so that the cache holds its own copy and callers cannot corrupt what
+// other goroutines read by mutating a returned slice or map.  For slices and
+// maps of values, slices.Clone and maps.Clone suffice.  By default values are
+// shared.  The value type must match the cache's.
+func WithClone[V any](fn func(v V) V) Option {
+	return func(c *config) { c.clone = fn }
+}
+
+// WithAdmission enables TinyLFU admission: once a shard is full, a new key is
+// only admitted if it has been accessed more often recently than the least
+// recently used entry it would evict, so keys seen once cannot flush out hot
+// ones.  Access frequencies are estimated with a count-min sketch.  Writes
+// which are not admitted are dropped.  It has no effect without
+// WithMaxEntries or WithMaxBytes.
+func WithAdmission() Option {
+	return func(c *config) { c.admission = true }
+}
+
+// WithSweeper removes expired entries every interval, so that their memory is
+// reclaimed even if they are never read or evicted again.  An entry is
+// removed once its item is past its hard expiry, or grace past its expiry if
+// it has none; the grace period keeps entries available for stale serving.
+// Each shard is locked in turn while it is swept.  Close stops the sweeper.
+func WithSweeper(interval, grace time.Duration) Option {
+	return func(c *config) {
+		c.sweepInterval = interval
+		c.sweepGrace = grace
+	}
+}
+
+// WithSweepMetrics reports each sweep to m, e.g. a stampedeprom.Collector
+func WithSweepMetrics(m stampede.SweepMetrics) Option {
+	return func(c *config) { c.sweepMetrics = m }
+}
+
+// WithClock sets the source of time for sweeping, claiming leases and
+// eviction ages.  It
+// should be the clock of the XFetchers using the cache, as their leases
+// expire by it.  The default is stampede.SystemClock.
+func WithClock(clock stampede.Clock) Option {
+	return func(c *config) { c.clock = clock }
+}
+
+// New returns an empty Cache
+func New[K comparable, V any](opts ...Option) *Cache[K, V] {
+	cfg := config{shards: 32, clock: stampede.SystemClock{}}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.shards < 1 {
+		cfg.shards = 1
+	}
+
+	c := &Cache[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]shard[K, V], cfg.shards),
+		sizeOf: defaultSizeOf[V],
+		codec:  stampede.GobCodec[V]{},
+		clock:  cfg.clock,
+	}
+	if cfg.sizeOf != nil {
+		fn, ok := cfg.sizeOf.(func(V) int)
+		if !ok {
+			panic(fmt.Sprintf("memcache: WithSizeOf function is %T, not %T", cfg.sizeOf, fn))
+		}
+		c.sizeOf = fn
+	}
+	if cfg.clone != nil {
+		fn, ok := cfg.clone.(func(V) V)
+		if !ok {
+			panic(fmt.Sprintf("memcache: WithClone function is %T, not %T", cfg.clone, fn))
+		}
+		c.clone = fn
+	}
+	if cfg.codec != nil {
+		codec, ok := cfg.codec.(stampede.Codec[V])
+		if !ok {
+			panic(fmt.Sprintf("memcache: WithCodec codec %T does not match the value type", cfg.codec))
+		}
+		c.codec = codec
+	}
+	if cfg.onEvict != nil {
+		fn, ok := cfg.onEvict.(func(Eviction[K, V]))
+		if !ok {
+			panic(fmt.Sprintf("memcache: WithEvictionHandler function is %T, not %T", cfg.onEvict, fn))
+		}
+		c.onEvict = fn
+	}
+
+	perShard := 0
+	if cfg.maxEntries > 0 {
+		perShard = (cfg.maxEntries + cfg.shards - 1) / cfg.shards
+	}
+	var bytesPerShard int64
+	if cfg.maxBytes > 0 {
+		bytesPerShard = (cfg.maxBytes + int64(cfg.shards) - 1) / int64(cfg.shards)
+	}
+	for i := range c.shards {
+		c.shards[i] = shard[K, V]{
+			ll:       list.New(),
+			m:        make(map[K]*list.Element),
+			max:      perShard,
+			maxBytes: bytesPerShard,
+			notify:   c.onEvict != nil,
+			clock:    c.clock,
+		}
+		if cfg.admission && (perShard > 0 || bytesPerShard > 0) {
+			// size the sketch by an estimate of the entries held
+			n := perShard
+			if n == 0 {
+				n = int(bytesPerShard / (entryOverhead * 4))
+			}
+			c.shards[i].sketch = newSketch(n)
+		}
+	}
+
+	c.stop = make(chan struct{})
+	if cfg.sweepInterval > 0 {
+		c.sweeping.Add(1)
+		go c.sweeper(cfg.sweepInterval, cfg.sweepGrace, cfg.sweepMetrics)
+	}
+	return c
+}
+
+// Close stops the sweeper set with WithSweeper, waiting for a sweep in
+// progress to finish.  The cache remains usable.
+func (c *Cache[K, V]) Close() {
+	c.closeOnce.Do(func() { close(c.stop) })
+	c.sweeping.Wait()
+}
+
+// sweeper sweeps the cache every interval until it is closed
+func (c *Cache[K, V]) sweeper(interval, grace time.Duration, m stampede.SweepMetrics) {
+	defer c.sweeping.Done()
+	for {
+		select {
+		case <-c.clock.After(interval):
+		case <-c.stop:
+			return
+		}
+		start := c.clock.Now()
+		n := c.Sweep(grace)
+		if m != nil {
+			m.Swept(n, c.clock.Now().Sub(start))
+		}
+	}
+}
+
+// Sweep removes the entries whose items are past their hard expiry, or grace
+// past their expiry if they have none, and returns how many it removed
+func (c *Cache[K, V]) Sweep(grace time.Duration) int {
+	var n int
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		now := c.clock.Now()
+		for e := s.ll.Front(); e != nil; {
+			next := e.Next()
+			if dead(e.Value.(*entry[K, V]).item, grace, now) {
+				s.removeElement(e, Expired)
+				n++
+			}
+			e = next
+		}
+		c.unlock(s)
+	}
+	c.swept.Add(uint64(n))
+	return n
+}
+
+// dead reports whether item can no longer be served, even stale
+func dead[V any](item stampede.Item[V], grace time.Duration, now time.Time) bool {
+	if !item.HardExpiry.IsZero() {
+		return !now.Before(item.HardExpiry)
+	}
+	return !item.Expiry.IsZero() && !now.Before(item.Expiry.Add(grace))
+}
+
+func (c *Cache[K, V]) shard(key K) (*shard[K, V], uint64) {
+	h := maphash.Comparable(c.seed, key)
+	return &c.shards[h%uint64(len(c.shards))], h
+}
+
+// Get implements stampede.Cache
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (stampede.Item[V], error) {
+	s, h := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sketch != nil {
+		s.sketch.add(h)
+	}
+	e, ok := s.m[key]
+	if !ok {
+		c.misses.Add(1)
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	c.hits.Add(1)
+	s.ll.MoveToFront(e)
+	return c.copy(e.Value.(*entry[K, V]).item), nil
+}
+
+// Set implements stampede.Cache
+func (c *Cache[K, V]) Set(ctx context.Context, key K, item stampede.Item[V]) error {
+	item = c.copy(item)
+	s, h := c.shard(key)
+	s.mu.Lock()
+	defer c.unlock(s)
+
+	if e, ok := s.m[key]; ok {
+		c.update(s, e, item)
+		return nil
+	}
+	c.add(s, key, h, item)
+	return nil
+}
+
+// SetIfNewer implements stampede.ConditionalSetter
+func (c *Cache[K, V]) SetIfNewer(ctx context.Context, key K, item stampede.Item[V]) (bool, error) {
+	item = c.copy(item)
+	s, h := c.shard(key)
+	s.mu.Lock()
+	defer c.unlock(s)
+
+	if e, ok := s.m[key]; ok {
+		if !item.Supersedes(e.Value.(*entry[K, V]).item) {
+			return false, nil
+		}
+		c.update(s, e, item)
+		return true, nil
+	}
+	return c.add(s, key, h, item), nil
+}
+
+// ClaimLease implements stampede.LeaseClaimer
+func (c *Cache[K, V]) ClaimLease(ctx context.Context, key K, prev *stampede.Item[V], lease stampede.Item[V]) (bool, error) {
+	lease = c.copy(lease)
+	s, h := c.shard(key)
+	s.mu.Lock()
+	defer c.unlock(s)
+
+	if e, ok := s.m[key]; ok {
+		if !e.Value.(*entry[K, V]).item.Claimable(prev, c.clock.Now()) {
+			return false, nil
+		}
+		c.update(s, e, lease)
+		return true, nil
+	}
+	return c.add(s, key, h, lease), nil
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[K, V]) Delete(ctx context.Context, key K) error {
+	s, _ := c.shard(key)
+	s.mu.Lock()
+	defer c.unlock(s)
+
+	if e, ok := s.m[key]; ok {
+		s.removeElement(e, Deleted)
+	}
+	return nil
+}
+
+// Stats counts cache operations
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+
+	// Rejected counts writes of new keys refused by WithAdmission
+	Rejected uint64
+
+	// Swept counts expired entries removed by sweeps
+	Swept uint64
+}
+
+// HitRatio returns the fraction of reads which were hits
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns the counts of operations so far
+func (c *Cache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Rejected: c.rejected.Load(),
+		Swept:    c.swept.Load(),
+	}
+}
+
+// Len returns the number of entries in the cache
+func (c *Cache[K, V]) Len() int {
+	var n int
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		n += s.ll.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// copy returns item with its value cloned, if WithClone is set
+func (c *Cache[K, V]) copy(item stampede.Item[V]) stampede.Item[V] {
+	if c.clone != nil {
+		item.Value = c.clone(item.Value)
+	}
+	return item
+}
+
+// add inserts a new entry into s, evicting if the shard is full, and reports
+// whether it was admitted
+func (c *Cache[K, V]) add(s *shard[K, V], key K, h uint64, item stampede.Item[V]) bool {
+	size := int64(entryOverhead + keySize(key) + c.sizeOf(item.Value))
+	if s.sketch != nil {
+		s.sketch.add(h)
+		if s.ll.Len() > 0 && s.full(1, size) {
+			victim := s.ll.Back().Value.(*entry[K, V])
+			if s.sketch.estimate(h) <= s.sketch.estimate(victim.hash) {
+				c.rejected.Add(1)
+				return false
+			}
+		}
+	}
+
+	s.m[key] = s.ll.PushFront(&entry[K, V]{key: key, hash: h, size: size, item: item, stored: c.clock.Now()})
+	s.bytes += size
+	s.evict()
+	return true
+}
+
+// update replaces the item of the entry e and marks it recently used
+func (c *Cache[K, V]) update(s *shard[K, V], e *list.Element, item stampede.Item[V]) {
+	ent := e.Value.(*entry[K, V])
+	size := int64(entryOverhead + keySize(ent.key) + c.sizeOf(item.Value))
+	s.bytes += size - ent.size
+	ent.size, ent.item, ent.stored = size, item, c.clock.Now()
+	s.ll.MoveToFront(e)
+	s.evict()
+}
+
+// full reports whether adding n entries of size bytes would exceed the
+// shard's bounds
+func (s *shard[K, V]) full(n int, size int64) bool {
+	return s.max > 0 && s.ll.Len()+n > s.max || s.maxBytes > 0 && s.bytes+size > s.maxBytes
+}
+
+// evict removes least recently used entries until the shard is within its
+// bounds, keeping at least the most recent
+func (s *shard[K, V]) evict() {
+	for s.ll.Len() > 1 && s.full(0, 0) {
+		s.removeElement(s.ll.Back(), Evicted)
+	}
+}
+
+// removeElement removes the entry e, which left the cache for reason
+func (s *shard[K, V]) removeElement(e *list.Element, reason EvictReason) {
+	ent := e.Value.(*entry[K, V])
+	s.ll.Remove(e)
+	delete(s.m, ent.key)
+	s.bytes -= ent.size
+	if s.notify {
+		s.evicted = append(s.evicted, Eviction[K, V]{Key: ent.key, Item: ent.item, Reason: reason, Age: s.clock.Now().Sub(ent.stored)})
+	}
+}
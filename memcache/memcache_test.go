@@ -0,0 +1,316 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/fakeclock"
+)
+
+func item(v int) stampede.Item[int] {
+	return stampede.Item[int]{Value: v, Expiry: time.Now().Add(time.Hour), Created: time.Now()}
+}
+
+func TestCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return New[string, string]()
+	})
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	var evicted []string
+	c := New[string, int](WithShards(1), WithMaxEntries(2), WithEvictionHandler(func(ev Eviction[string, int]) {
+		if ev.Reason != Evicted {
+			t.Errorf("eviction of %q for %v, want %v", ev.Key, ev.Reason, Evicted)
+		}
+		evicted = append(evicted, ev.Key)
+	}))
+
+	c.Set(ctx, "a", item(1))
+	c.Set(ctx, "b", item(2))
+	c.Get(ctx, "a")
+	c.Set(ctx, "b", item(3))
+	c.Set(ctx, "c", item(4))
+	c.Set(ctx, "d", item(5))
+
+	// b was overwritten, but a read more recently
+	if !slices.Equal(evicted, []string{"a", "b"}) {
+		t.Errorf("evicted %v, want [a b]", evicted)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	// room for ten entries of one byte keys and empty values
+	bound := int64(10 * (entryOverhead + 1))
+	c := New[string, string](WithShards(1), WithMaxBytes(bound))
+	big := stampede.Item[string]{Value: string(bytes.Repeat([]byte("x"), entryOverhead))}
+	for i := range 10 {
+		c.Set(ctx, fmt.Sprint(i), big)
+	}
+	if n := c.Len(); n != 5 {
+		t.Errorf("Len = %d, want 5 entries within the byte bound", n)
+	}
+
+	c = New[string, string](WithShards(1), WithMaxBytes(bound), WithSizeOf(func(v string) int { return 0 }))
+	for i := range 10 {
+		c.Set(ctx, fmt.Sprint(i), big)
+	}
+	if n := c.Len(); n != 10 {
+		t.Errorf("Len = %d with values sized at zero, want 10", n)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	ctx := context.Background()
+	c := New[string, int](WithShards(1), WithMaxEntries(1), WithAdmission())
+	c.Set(ctx, "hot", item(1))
+	for range 5 {
+		c.Get(ctx, "hot")
+	}
+
+	c.Set(ctx, "cold", item(2))
+	if _, err := c.Get(ctx, "hot"); err != nil {
+		t.Fatalf("hot key evicted by a cold one: %v", err)
+	}
+	if got := c.Stats().Rejected; got != 1 {
+		t.Errorf("Rejected = %d, want 1", got)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	var swept []string
+	c := New[string, int](WithEvictionHandler(func(ev Eviction[string, int]) {
+		if ev.Reason == Expired {
+			swept = append(swept, ev.Key)
+		}
+	}))
+	now := time.Now()
+	c.Set(ctx, "fresh", item(1))
+	c.Set(ctx, "stale", stampede.Item[int]{Value: 2, Expiry: now.Add(-time.Second)})
+	c.Set(ctx, "dead", stampede.Item[int]{Value: 3, Expiry: now.Add(-time.Hour)})
+	c.Set(ctx, "hard", stampede.Item[int]{Value: 4, Expiry: now.Add(-time.Second), HardExpiry: now.Add(-time.Millisecond)})
+	c.Set(ctx, "forever", stampede.Item[int]{Value: 5})
+
+	if n := c.Sweep(time.Minute); n != 2 {
+		t.Errorf("Sweep = %d, want 2", n)
+	}
+	slices.Sort(swept)
+	if !slices.Equal(swept, []string{"dead", "hard"}) {
+		t.Errorf("swept %v, want [dead hard]", swept)
+	}
+	if c.Len() != 3 || c.Stats().Swept != 2 {
+		t.Errorf("Len = %d, Swept = %d after sweep", c.Len(), c.Stats().Swept)
+	}
+}
+
+// sweepCounter is a stampede.SweepMetrics counting sweeps
+type sweepCounter chan int
+
+func (s sweepCounter) Swept(n int, d time.Duration) { s <- n }
+
+func TestSweeper(t *testing.T) {
+	ctx := context.Background()
+	sweeps := make(sweepCounter, 10)
+	c := New[string, int](WithSweeper(time.Millisecond, 0), WithSweepMetrics(sweeps))
+	defer c.Close()
+	c.Set(ctx, "dead", stampede.Item[int]{Value: 1, Expiry: time.Now().Add(-time.Second)})
+
+	for n := range sweeps {
+		if n == 1 {
+			break
+		}
+	}
+	if _, err := c.Get(ctx, "dead"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("Get after sweep = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestClaimLease(t *testing.T) {
+	ctx := context.Background()
+	c := New[string, int]()
+	prev := item(1)
+	c.Set(ctx, "k", prev)
+
+	// of two claimants which read prev, only the first takes the lease
+	lease := stampede.Item[int]{Value: 1, Pending: &stampede.Pending{Owner: "p1", Until: time.Now().Add(time.Minute)}}
+	if ok, err := c.ClaimLease(ctx, "k", &prev, lease); !ok || err != nil {
+		t.Fatalf("first ClaimLease = %v, %v", ok, err)
+	}
+	if ok, _ := c.ClaimLease(ctx, "k", &prev, lease); ok {
+		t.Error("second ClaimLease took the held lease")
+	}
+	// an absent key is claimable, but not an item the claimant did not read
+	if ok, _ := c.ClaimLease(ctx, "absent", nil, lease); !ok {
+		t.Error("ClaimLease of an absent key refused")
+	}
+	newer := item(2)
+	newer.Created = prev.Created.Add(time.Second)
+	c.Set(ctx, "newer", newer)
+	if ok, _ := c.ClaimLease(ctx, "newer", &prev, lease); ok {
+		t.Error("ClaimLease replaced an item the claimant did not read")
+	}
+}
+
+func TestClock(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	c := New[string, int](WithClock(clock))
+
+	// leases expire by the clock of the fetchers taking them
+	lease := stampede.Item[int]{Pending: &stampede.Pending{Owner: "p1", Until: clock.Now().Add(time.Minute), Empty: true}}
+	if ok, err := c.ClaimLease(ctx, "k", nil, lease); !ok || err != nil {
+		t.Fatalf("ClaimLease = %v, %v", ok, err)
+	}
+	if ok, _ := c.ClaimLease(ctx, "k", nil, lease); ok {
+		t.Error("claimed a held lease")
+	}
+	clock.Advance(time.Minute)
+	if ok, _ := c.ClaimLease(ctx, "k", nil, lease); !ok {
+		t.Error("did not claim an abandoned lease")
+	}
+
+	// as do items, when swept
+	c.Set(ctx, "k", stampede.Item[int]{Value: 1, Expiry: clock.Now().Add(time.Second)})
+	if n := c.Sweep(0); n != 0 {
+		t.Errorf("Sweep = %d before expiry", n)
+	}
+	clock.Advance(time.Second)
+	if n := c.Sweep(0); n != 1 {
+		t.Errorf("Sweep = %d at expiry, want 1", n)
+	}
+}
+
+func TestDeleteEviction(t *testing.T) {
+	ctx := context.Background()
+	var got []Eviction[string, int]
+	c := New[string, int](WithEvictionHandler(func(ev Eviction[string, int]) { got = append(got, ev) }))
+	c.Set(ctx, "k", item(1))
+	c.Delete(ctx, "k")
+	c.Delete(ctx, "absent")
+	if len(got) != 1 || got[0].Key != "k" || got[0].Reason != Deleted || got[0].Item.Value != 1 {
+		t.Errorf("evictions %+v, want k deleted", got)
+	}
+}
+
+func TestEvictionAge(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	var got []Eviction[string, int]
+	c := New[string, int](WithClock(clock), WithEvictionHandler(func(ev Eviction[string, int]) { got = append(got, ev) }))
+
+	// the age runs from the last write
+	c.Set(ctx, "k", item(1))
+	clock.Advance(time.Minute)
+	c.Set(ctx, "k", item(2))
+	clock.Advance(5 * time.Second)
+	c.Delete(ctx, "k")
+	if len(got) != 1 || got[0].Age != 5*time.Second {
+		t.Errorf("evictions %+v, want k deleted aged 5s", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	c := New[string, []int](WithClone(slices.Clone[[]int]))
+	v := []int{1, 2}
+	c.Set(ctx, "k", stampede.Item[[]int]{Value: v})
+	v[0] = 9
+	got, _ := c.Get(ctx, "k")
+	got.Value[1] = 9
+	if again, _ := c.Get(ctx, "k"); !slices.Equal(again.Value, []int{1, 2}) {
+		t.Errorf("cached value %v, want [1 2] unchanged by callers", again.Value)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	c := New[string, int]()
+	c.Set(ctx, "a", item(1))
+	c.Set(ctx, "b", item(2))
+	c.Set(ctx, "placeholder", stampede.Item[int]{Pending: &stampede.Pending{Owner: "p1", Until: time.Now().Add(time.Minute), Empty: true}})
+
+	var buf bytes.Buffer
+	if err := c.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot = %v", err)
+	}
+
+	r := New[string, int]()
+	newer := item(3)
+	newer.Created = time.Now().Add(time.Hour)
+	r.Set(ctx, "b", newer)
+	if err := r.Restore(&buf); err != nil {
+		t.Fatalf("Restore = %v", err)
+	}
+	if got, err := r.Get(ctx, "a"); err != nil || got.Value != 1 {
+		t.Errorf("restored a = %+v, %v", got, err)
+	}
+	if got, _ := r.Get(ctx, "b"); got.Value != 3 {
+		t.Errorf("restored b = %d, want the newer 3 kept", got.Value)
+	}
+	if _, err := r.Get(ctx, "placeholder"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("placeholder restored: %v", err)
+	}
+
+	if err := r.Restore(bytes.NewReader([]byte("junk"))); err == nil {
+		t.Error("Restore of junk succeeded")
+	}
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	c := New[string, int]()
+	c.Set(ctx, "k", item(1))
+	c.Get(ctx, "k")
+	c.Get(ctx, "k")
+	c.Get(ctx, "absent")
+	s := c.Stats()
+	if s.Hits != 2 || s.Misses != 1 || s.HitRatio() != 2.0/3 {
+		t.Errorf("Stats = %+v with hit ratio %v", s, s.HitRatio())
+	}
+	if (Stats{}).HitRatio() != 0 {
+		t.Error("HitRatio of no reads is not 0")
+	}
+}
+
+func TestMaxBytesSmallerThanShards(t *testing.T) {
+	ctx := context.Background()
+	c := New[string, int](WithShards(32), WithMaxBytes(16))
+	for i := range 1000 {
+		c.Set(ctx, fmt.Sprint(i), item(i))
+	}
+	// each shard keeps only its most recent entry
+	if n := c.Len(); n > 32 {
+		t.Fatalf("Len = %d, want at most one entry per shard", n)
+	}
+}
+
+func TestAdmissionSmallByteBound(t *testing.T) {
+	ctx := context.Background()
+	// too small a bound to hold entryOverhead*4 bytes
+	c := New[string, int](WithShards(1), WithMaxBytes(200), WithAdmission())
+	c.Set(ctx, "cold", item(1))
+
+	for range 3 {
+		c.Get(ctx, "hot")
+	}
+	c.Set(ctx, "hot", item(2))
+	if _, err := c.Get(ctx, "hot"); err != nil {
+		t.Fatalf("frequently read key not admitted: %v", err)
+	}
+	if got := c.Stats().Rejected; got != 0 {
+		t.Fatalf("Rejected = %d, want 0", got)
+	}
+}
@@ -0,0 +1,26 @@
+package memcache
+
+import "unsafe"
+
+// entryOverhead approximates the memory used by an entry besides its key and
+// value: the list element, map slot and item metadata
+const entryOverhead = 128
+
+// defaultSizeOf sizes byte slices and strings by length, and other values by
+// their shallow size
+func defaultSizeOf[V any](v V) int {
+	switch v := any(v).(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	return int(unsafe.Sizeof(v))
+}
+
+func keySize[K comparable](key K) int {
+	if s, ok := any(key).(string); ok {
+		return len(s)
+	}
+	return int(unsafe.Sizeof(key))
+}
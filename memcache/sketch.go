@@ -0,0 +1,62 @@
+package memcache
+
+import "math/bits"
+
+// sketch is a count-min sketch of recent access frequencies, as used by
+// TinyLFU.  Counters saturate at 255, and are halved once the number of
+// additions reaches ten times the capacity, so old popularity fades.
+type sketch struct {
+	rows  [4][]uint8
+	shift uint
+	adds  int
+	reset int
+}
+
+// seeds are odd multipliers giving each row an independent index
+var seeds = [4]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+
+func newSketch(capacity int) *sketch {
+	capacity = max(capacity, 1)
+	width := 1 << bits.Len(uint(max(capacity, 8)-1))
+	s := &sketch{
+		shift: uint(64 - bits.Len(uint(width-1))),
+		reset: 10 * capacity,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *sketch) index(i int, h uint64) uint64 {
+	return (h * seeds[i]) >> s.shift
+}
+
+func (s *sketch) add(h uint64) {
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(i, h)]; *c < 255 {
+			*c++
+		}
+	}
+	s.adds++
+	if s.adds >= s.reset {
+		s.halve()
+	}
+}
+
+func (s *sketch) estimate(h uint64) uint8 {
+	est := uint8(255)
+	for i := range s.rows {
+		est = min(est, s.rows[i][s.index(i, h)])
+	}
+	return est
+}
+
+func (s *sketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.adds /= 2
+}
@@ -0,0 +1,99 @@
+package memcache
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot
+const snapshotVersion = 1
+
+// snapshotHeader starts a snapshot
+type snapshotHeader struct {
+	Version int
+}
+
+// record is a snapshot entry: a key and its item encoded with the codec
+type record[K comparable] struct {
+	Key  K
+	Item []byte
+}
+
+// WithCodec sets the codec encoding items in snapshots.  The default is
+// stampede.GobCodec.  The value type must match the cache's.
+func WithCodec[V any](codec stampede.Codec[V]) Option {
+	return func(c *config) { c.codec = codec }
+}
+
+// Snapshot writes the entries of the cache to w, so that a later process can
+// Restore them and start warm.  Keys are encoded with encoding/gob and items
+// with the WithCodec codec.  Placeholders are left out, as their owner will
+// not replace them.  Each shard is copied under its lock, so the snapshot is
+// consistent per shard but not across shards.
+func (c *Cache[K, V]) Snapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion}); err != nil {
+		return err
+	}
+
+	var entries []entry[K, V]
+	for i := range c.shards {
+		s := &c.shards[i]
+		entries = entries[:0]
+		s.mu.Lock()
+		// least recently used first, so Restore reinserts in recency order
+		for e := s.ll.Back(); e != nil; e = e.Prev() {
+			if ent := e.Value.(*entry[K, V]); ent.item.Pending == nil {
+				entries = append(entries, *ent)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, ent := range entries {
+			b, err := c.codec.Marshal(ent.item)
+			if err != nil {
+				return fmt.Errorf("memcache: encoding %v: %w", ent.key, err)
+			}
+			if err := enc.Encode(record[K]{Key: ent.key, Item: b}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restore adds the entries of a snapshot written by Snapshot, read from r, to
+// the cache.  An entry does not replace an item for the same key which
+// supersedes it, so Restore may run while the cache is in use.  Entries read
+// before an error are kept.
+func (c *Cache[K, V]) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var hdr snapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return err
+	}
+	if hdr.Version != snapshotVersion {
+		return fmt.Errorf("memcache: unsupported snapshot version %d", hdr.Version)
+	}
+
+	for {
+		var rec record[K]
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		item, err := c.codec.Unmarshal(rec.Item)
+		if err != nil {
+			return fmt.Errorf("memcache: decoding %v: %w", rec.Key, err)
+		}
+		c.SetIfNewer(context.Background(), rec.Key, item)
+	}
+}
@@ -0,0 +1,206 @@
+// Package memcachedcache is a stampede.Cache backed by memcached
+package memcachedcache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/dgryski/go-stampede"
+)
+
+// Cache stores items in memcached, encoded with a stampede.Codec.  Each entry
+// is given a memcached expiration matching the item's.
+type Cache[V any] struct {
+	client *memcache.Client
+	codec  stampede.Codec[V]
+	config
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	prefix string
+	grace  time.Duration
+}
+
+// WithPrefix prepends prefix to every key
+func WithPrefix(prefix string) Option {
+	return func(c *config) { c.prefix = prefix }
+}
+
+// WithGrace keeps entries in memcached for grace after the item expires, so
+// that they remain available for stale serving.  The default is zero.
+func WithGrace(grace time.Duration) Option {
+	return func(c *config) { c.grace = grace }
+}
+
+// New returns a Cache using client, encoding items with codec
+func New[V any](client *memcache.Client, codec stampede.Codec[V], opts ...Option) *Cache[V] {
+	c := &Cache[V]{client: client, codec: codec}
+	for _, o := range opts {
+		o(&c.config)
+	}
+	return c
+}
+
+// Get implements stampede.Cache
+func (c *Cache[V]) Get(ctx context.Context, key string) (stampede.Item[V], error) {
+	it, err := c.client.Get(c.prefix + key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	if err != nil {
+		return stampede.Item[V]{}, err
+	}
+	return c.codec.Unmarshal(it.Value)
+}
+
+// Set implements stampede.Cache
+func (c *Cache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(&memcache.Item{
+		Key:        c.prefix + key,
+		Value:      b,
+		Expiration: c.expiration(item),
+	})
+}
+
+// casAttempts bounds the compare-and-swap retries of SetIfNewer
+const casAttempts = 3
+
+// SetIfNewer implements stampede.ConditionalSetter using memcached's
+// compare-and-swap.  It fails with memcache.ErrCASConflict if the entry keeps
+// changing under it.
+func (c *Cache[V]) SetIfNewer(ctx context.Context, key string, item stampede.Item[V]) (bool, error) {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+	k := c.prefix + key
+	for range casAttempts {
+		it, err := c.client.Get(k)
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			err = c.client.Add(&memcache.Item{Key: k, Value: b, Expiration: c.expiration(item)})
+			if errors.Is(err, memcache.ErrNotStored) {
+				// added concurrently
+				continue
+			}
+			return err == nil, err
+		}
+		if err != nil {
+			return false, err
+		}
+		// an undecodable entry is always replaced
+		if old, err := c.codec.Unmarshal(it.Value); err == nil && !item.Supersedes(old) {
+			return false, nil
+		}
+		it.Value, it.Expiration = b, c.expiration(item)
+		err = c.client.CompareAndSwap(it)
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) {
+			continue
+		}
+		return err == nil, err
+	}
+	return false, memcache.ErrCASConflict
+}
+
+// ClaimLease implements stampede.LeaseClaimer using memcached's
+// compare-and-swap.  It fails with memcache.ErrCASConflict if the entry keeps
+// changing under it.
+func (c *Cache[V]) ClaimLease(ctx context.Context, key string, prev *stampede.Item[V], lease stampede.Item[V]) (bool, error) {
+	b, err := c.codec.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	k := c.prefix + key
+	for range casAttempts {
+		it, err := c.client.Get(k)
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			err = c.client.Add(&memcache.Item{Key: k, Value: b, Expiration: c.expiration(lease)})
+			if errors.Is(err, memcache.ErrNotStored) {
+				// added concurrently
+				continue
+			}
+			return err == nil, err
+		}
+		if err != nil {
+			return false, err
+		}
+		// an undecodable entry is always replaced
+		if old, err := c.codec.Unmarshal(it.Value); err == nil && !old.Claimable(prev, time.Now()) {
+			return false, nil
+		}
+		it.Value, it.Expiration = b, c.expiration(lease)
+		err = c.client.CompareAndSwap(it)
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) {
+			continue
+		}
+		return err == nil, err
+	}
+	return false, memcache.ErrCASConflict
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	err := c.client.Delete(c.prefix + key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// GetMulti implements stampede.BatchGetter
+func (c *Cache[V]) GetMulti(ctx context.Context, keys []string) (map[string]stampede.Item[V], error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+
+	its, err := c.client.GetMulti(prefixed)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]stampede.Item[V], len(its))
+	for i, key := range keys {
+		it, ok := its[prefixed[i]]
+		if !ok {
+			continue
+		}
+		item, err := c.codec.Unmarshal(it.Value)
+		if err != nil {
+			return items, err
+		}
+		items[key] = item
+	}
+	return items, nil
+}
+
+// relativeLimit is the largest expiration memcached treats as relative;
+// anything larger is a unix timestamp
+const relativeLimit = 30 * 24 * time.Hour
+
+// expiration converts the item's expiry to a memcached expiration
+func (c *Cache[V]) expiration(item stampede.Item[V]) int32 {
+	end := item.Expiry.Add(c.grace)
+	if !item.HardExpiry.IsZero() {
+		// the item is useless past its hard expiry
+		end = item.HardExpiry
+	} else if item.Expiry.IsZero() {
+		// never expires
+		return 0
+	}
+	ttl := time.Until(end)
+	if ttl > relativeLimit {
+		return int32(min(end.Unix(), math.MaxInt32))
+	}
+	// zero would mean never expire
+	return int32(max(math.Ceil(ttl.Seconds()), 1))
+}
@@ -0,0 +1,199 @@
+package memcachedcache_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/memcachedcache"
+)
+
+// server is a memcached speaking the subset of the text protocol used by
+// gomemcache for the commands the Cache sends.  Entries never expire; their
+// expiration is only recorded.
+type server struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	entries map[string]entry
+	cas     uint64
+
+	// conflict fails every compare-and-swap, as if another writer always
+	// got in first
+	conflict bool
+}
+
+type entry struct {
+	value []byte
+	flags string
+	exp   int32
+	cas   uint64
+}
+
+// newServer starts a server, closed when the test ends
+func newServer(t *testing.T) *server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{ln: ln, entries: make(map[string]entry)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *server) client() *memcache.Client {
+	return memcache.New(s.ln.Addr().String())
+}
+
+func (s *server) entry(key string) (entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			return
+		}
+		switch f[0] {
+		case "get", "gets":
+			s.mu.Lock()
+			for _, key := range f[1:] {
+				if e, ok := s.entries[key]; ok {
+					fmt.Fprintf(w, "VALUE %s %s %d %d\r\n%s\r\n", key, e.flags, len(e.value), e.cas, e.value)
+				}
+			}
+			s.mu.Unlock()
+			w.WriteString("END\r\n")
+		case "set", "add", "cas":
+			n, _ := strconv.Atoi(f[4])
+			value := make([]byte, n+2)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return
+			}
+			exp, _ := strconv.Atoi(f[3])
+			w.WriteString(s.store(f, entry{value: value[:n], flags: f[2], exp: int32(exp)}))
+		case "delete":
+			s.mu.Lock()
+			_, ok := s.entries[f[1]]
+			delete(s.entries, f[1])
+			s.mu.Unlock()
+			if ok {
+				w.WriteString("DELETED\r\n")
+			} else {
+				w.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			w.WriteString("ERROR\r\n")
+		}
+		w.Flush()
+	}
+}
+
+// store applies the storage command f, returning the reply
+func (s *server) store(f []string, e entry) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, exists := s.entries[f[1]]
+	switch f[0] {
+	case "add":
+		if exists {
+			return "NOT_STORED\r\n"
+		}
+	case "cas":
+		if !exists {
+			return "NOT_FOUND\r\n"
+		}
+		if s.conflict || strconv.FormatUint(old.cas, 10) != f[5] {
+			return "EXISTS\r\n"
+		}
+	}
+	s.cas++
+	e.cas = s.cas
+	s.entries[f[1]] = e
+	return "STORED\r\n"
+}
+
+func TestCache(t *testing.T) {
+	s := newServer(t)
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return memcachedcache.New(s.client(), stampede.JSONCodec[string]{})
+	})
+}
+
+func TestExpiration(t *testing.T) {
+	ctx := context.Background()
+	s := newServer(t)
+	c := memcachedcache.New(s.client(), stampede.JSONCodec[string]{}, memcachedcache.WithPrefix("p/"), memcachedcache.WithGrace(time.Minute))
+
+	now := time.Now()
+	far := now.Add(60 * 24 * time.Hour)
+	for _, tt := range []struct {
+		key  string
+		item stampede.Item[string]
+		exp  int32
+	}{
+		{"forever", stampede.Item[string]{}, 0},
+		{"soft", stampede.Item[string]{Expiry: now.Add(time.Hour)}, 3660},
+		{"hard", stampede.Item[string]{Expiry: now.Add(time.Hour), HardExpiry: now.Add(2 * time.Hour)}, 7200},
+		{"expired", stampede.Item[string]{Expiry: now.Add(-time.Hour)}, 1},
+		{"far", stampede.Item[string]{Expiry: far}, int32(far.Add(time.Minute).Unix())},
+	} {
+		if err := c.Set(ctx, tt.key, tt.item); err != nil {
+			t.Fatalf("Set(%q) = %v", tt.key, err)
+		}
+		e, ok := s.entry("p/" + tt.key)
+		if !ok || e.exp < tt.exp-1 || e.exp > tt.exp {
+			t.Errorf("%s: expiration %d, want %d", tt.key, e.exp, tt.exp)
+		}
+	}
+}
+
+func TestSetIfNewerConflict(t *testing.T) {
+	ctx := context.Background()
+	s := newServer(t)
+	c := memcachedcache.New(s.client(), stampede.JSONCodec[string]{})
+	now := time.Now()
+	c.Set(ctx, "k", stampede.Item[string]{Value: "old", Created: now.Add(-time.Hour)})
+
+	s.mu.Lock()
+	s.conflict = true
+	s.mu.Unlock()
+	ok, err := c.SetIfNewer(ctx, "k", stampede.Item[string]{Value: "new", Created: now})
+	if ok || err != memcache.ErrCASConflict {
+		t.Errorf("SetIfNewer = %v, %v; want ErrCASConflict", ok, err)
+	}
+	if e, _ := s.entry("k"); !strings.Contains(string(e.value), "old") {
+		t.Errorf("entry %q, want the old item kept", e.value)
+	}
+}
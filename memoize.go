@@ -0,0 +1,18 @@
+package stampede
+
+import (
+	"context"
+	"time"
+)
+
+// Memoize wraps fn so its results are cached in xf for ttl, keyed by
+// keyFn(arg).  The returned function has the same signature as fn, so it can
+// be dropped in at existing call sites.
+func Memoize[A any, K comparable, V any](xf *XFetcher[K, V], keyFn func(A) K, fn func(context.Context, A) (V, error), ttl time.Duration) func(context.Context, A) (V, error) {
+	return func(ctx context.Context, arg A) (V, error) {
+		return xf.Fetch(ctx, keyFn(arg), func(ctx context.Context) (V, time.Duration, error) {
+			v, err := fn(ctx, arg)
+			return v, ttl, err
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package stampede_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestMemoize(t *testing.T) {
+	ctx := context.Background()
+	mc := memcache.New[string, int]()
+	xf := stampede.New[string, int](mc)
+	calls := 0
+	square := stampede.Memoize(xf, strconv.Itoa, func(ctx context.Context, n int) (int, error) {
+		calls++
+		return n * n, nil
+	}, time.Minute)
+
+	for _, n := range []int{3, 3, 4, 3} {
+		if v, err := square(ctx, n); err != nil || v != n*n {
+			t.Fatalf("square(%d) = %d, %v", n, v, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("%d calls, want one per distinct argument", calls)
+	}
+
+	item, err := mc.Get(ctx, "3")
+	if err != nil || item.Expiry.Sub(item.Created) != time.Minute {
+		t.Errorf("cached item %+v, %v; want a minute's TTL", item, err)
+	}
+}
@@ -0,0 +1,51 @@
+package stampede
+
+import "time"
+
+// Metrics receives counts and timings from an XFetcher
+type Metrics[K comparable] interface {
+	// Hit is called when a fresh value is served from the cache
+	Hit(key K)
+
+	// Miss is called when the key is absent from the cache or expired
+	Miss(key K)
+
+	// EarlyExpire is called when the XFetch algorithm chooses to recompute
+	// a value which has not yet expired
+	EarlyExpire(key K)
+
+	// ReadFailure is called when the cache read fails with an error other
+	// than ErrCacheMiss
+	ReadFailure(key K)
+
+	// RecomputeStart is called before recompute is invoked
+	RecomputeStart(key K)
+
+	// RecomputeSuccess is called when recompute succeeds after d
+	RecomputeSuccess(key K, d time.Duration)
+
+	// RecomputeFailure is called when recompute fails after d
+	RecomputeFailure(key K, d time.Duration)
+
+	// WriteFailure is called when the cache write fails
+	WriteFailure(key K)
+}
+
+type nopMetrics[K comparable] struct{}
+
+func (nopMetrics[K]) Hit(K)                             {}
+func (nopMetrics[K]) Miss(K)                            {}
+func (nopMetrics[K]) EarlyExpire(K)                     {}
+func (nopMetrics[K]) ReadFailure(K)                     {}
+func (nopMetrics[K]) RecomputeStart(K)                  {}
+func (nopMetrics[K]) RecomputeSuccess(K, time.Duration) {}
+func (nopMetrics[K]) RecomputeFailure(K, time.Duration) {}
+func (nopMetrics[K]) WriteFailure(K)                    {}
+
+// SweepMetrics may be implemented by a Metrics to observe the sweeps of
+// expired entries made by in-memory caches, such as memcache.WithSweeper
+type SweepMetrics interface {
+	// Swept is called after each sweep, with the number of entries it
+	// removed and its duration
+	Swept(removed int, d time.Duration)
+}
@@ -0,0 +1,99 @@
+package stampede_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// countingMetrics counts the calls made to it, by method name
+type countingMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int
+	depth  int
+}
+
+func (m *countingMetrics) count(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[string]int)
+	}
+	m.counts[name]++
+}
+
+func (m *countingMetrics) get(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[name]
+}
+
+func (m *countingMetrics) Hit(string)                             { m.count("Hit") }
+func (m *countingMetrics) Miss(string)                            { m.count("Miss") }
+func (m *countingMetrics) EarlyExpire(string)                     { m.count("EarlyExpire") }
+func (m *countingMetrics) ReadFailure(string)                     { m.count("ReadFailure") }
+func (m *countingMetrics) RecomputeStart(string)                  { m.count("RecomputeStart") }
+func (m *countingMetrics) RecomputeSuccess(string, time.Duration) { m.count("RecomputeSuccess") }
+func (m *countingMetrics) RecomputeFailure(string, time.Duration) { m.count("RecomputeFailure") }
+func (m *countingMetrics) WriteFailure(string)                    { m.count("WriteFailure") }
+
+func (m *countingMetrics) ConcurrentRecompute(key string, remote bool) {
+	if remote {
+		m.count("RemoteConcurrentRecompute")
+	} else {
+		m.count("ConcurrentRecompute")
+	}
+}
+
+func (m *countingMetrics) RefreshQueueDepth(n int) {
+	m.mu.Lock()
+	m.depth = n
+	m.mu.Unlock()
+	m.count("RefreshQueueDepth")
+}
+
+func (m *countingMetrics) queueDepth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.depth
+}
+
+func (m *countingMetrics) RefreshDropped() { m.count("RefreshDropped") }
+
+func TestMetrics(t *testing.T) {
+	clock := fakeclock.New(time.Unix(1000, 0))
+	m := &countingMetrics{}
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(func() float64 { return 0.5 }),
+		stampede.WithMetrics[string](m),
+	)
+	exercise(t, xf, clock)
+
+	for name, want := range map[string]int{
+		"Hit":              1,
+		"Miss":             2,
+		"EarlyExpire":      1,
+		"RecomputeStart":   3,
+		"RecomputeSuccess": 2,
+		"RecomputeFailure": 1,
+	} {
+		if n := m.get(name); n != want {
+			t.Errorf("%s called %d times, want %d", name, n, want)
+		}
+	}
+}
+
+func TestMetricsFailures(t *testing.T) {
+	m := &countingMetrics{}
+	xf := stampede.New[string, int](brokenCache{}, stampede.WithMetrics[string](m))
+	xf.Fetch(context.Background(), "k", succeeding)
+	if r, w := m.get("ReadFailure"), m.get("WriteFailure"); r != 1 || w != 1 {
+		t.Errorf("ReadFailure and WriteFailure called %d and %d times, want once each", r, w)
+	}
+}
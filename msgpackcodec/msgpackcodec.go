@@ -0,0 +1,132 @@
+// Package msgpackcodec provides stampede codecs using MessagePack
+package msgpackcodec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a stampede.Codec writing the item envelope as a MessagePack
+// array, with the value serialized by a stampede.ValueCodec:
+//
+//	[version, flags, expiry, hardExpiry, delta, created, until, owner, value]
+//
+// The version is currently 1.  Times are unix nanoseconds, 0 for the zero
+// time, and the delta is in nanoseconds.  Flags are the stampede envelope
+// flags, until and owner describe a placeholder, and value is a bin.  Later
+// versions only append elements, which readers skip.
+type Codec[V any] struct {
+	Value stampede.ValueCodec[V]
+}
+
+// version is the envelope version written by Codec
+const version = 1
+
+// fields is the number of envelope elements in version 1
+const fields = 9
+
+// ErrBadEnvelope is returned when decoding a malformed envelope
+var ErrBadEnvelope = errors.New("msgpackcodec: malformed envelope")
+
+// Marshal implements stampede.Codec
+func (c Codec[V]) Marshal(item stampede.Item[V]) ([]byte, error) {
+	e, err := stampede.ToEnvelope(item, c.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	err = errors.Join(
+		enc.EncodeArrayLen(fields),
+		enc.EncodeUint8(version),
+		enc.EncodeUint64(e.Flags),
+		enc.EncodeInt64(unixNano(e.Expiry)),
+		enc.EncodeInt64(unixNano(e.HardExpiry)),
+		enc.EncodeInt64(int64(e.Delta)),
+		enc.EncodeInt64(unixNano(e.Created)),
+		enc.EncodeInt64(unixNano(e.Until)),
+		enc.EncodeString(e.Owner),
+		enc.EncodeBytes(e.Value),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements stampede.Codec
+func (c Codec[V]) Unmarshal(b []byte) (stampede.Item[V], error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	n, err := dec.DecodeArrayLen()
+	if err != nil || n < fields {
+		return stampede.Item[V]{}, ErrBadEnvelope
+	}
+
+	var e stampede.Envelope
+	var expiry, hardExpiry, delta, created, until int64
+	err = errors.Join(
+		decode(&e.Version, dec.DecodeUint8),
+		decode(&e.Flags, dec.DecodeUint64),
+		decode(&expiry, dec.DecodeInt64),
+		decode(&hardExpiry, dec.DecodeInt64),
+		decode(&delta, dec.DecodeInt64),
+		decode(&created, dec.DecodeInt64),
+		decode(&until, dec.DecodeInt64),
+		decode(&e.Owner, dec.DecodeString),
+		decode(&e.Value, dec.DecodeBytes),
+	)
+	for range n - fields {
+		err = errors.Join(err, dec.Skip())
+	}
+	if err != nil {
+		return stampede.Item[V]{}, fmt.Errorf("%w: %v", ErrBadEnvelope, err)
+	}
+	if e.Version < 1 {
+		return stampede.Item[V]{}, fmt.Errorf("%w: version %d", ErrBadEnvelope, e.Version)
+	}
+
+	e.Expiry, e.HardExpiry = fromUnixNano(expiry), fromUnixNano(hardExpiry)
+	e.Delta = time.Duration(delta)
+	e.Created, e.Until = fromUnixNano(created), fromUnixNano(until)
+	return stampede.FromEnvelope(e, c.Value)
+}
+
+// decode stores the next element read by fn in v
+func decode[T any](v *T, fn func() (T, error)) error {
+	var err error
+	*v, err = fn()
+	return err
+}
+
+// ValueCodec is a stampede.ValueCodec using MessagePack
+type ValueCodec[V any] struct{}
+
+// MarshalValue implements stampede.ValueCodec
+func (ValueCodec[V]) MarshalValue(v V) ([]byte, error) { return msgpack.Marshal(v) }
+
+// UnmarshalValue implements stampede.ValueCodec
+func (ValueCodec[V]) UnmarshalValue(b []byte) (V, error) {
+	var v V
+	err := msgpack.Unmarshal(b, &v)
+	return v, err
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
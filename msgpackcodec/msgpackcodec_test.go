@@ -0,0 +1,12 @@
+package msgpackcodec_test
+
+import (
+	"testing"
+
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/msgpackcodec"
+)
+
+func TestCodec(t *testing.T) {
+	cachetest.TestCodec(t, msgpackcodec.Codec[string]{Value: msgpackcodec.ValueCodec[string]{}})
+}
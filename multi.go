@@ -0,0 +1,348 @@
+package stampede
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"time"
+)
+
+// ValueTTL is a recomputed value and its desired time-to-live.  If Err is
+// set, the key failed to recompute and Value and TTL are ignored.
+type ValueTTL[V any] struct {
+	Value V
+	TTL   time.Duration
+	Err   error
+}
+
+// MultiRecomputeFunc computes the values for the missing keys in one call.
+// Keys absent from the returned map are left out of the FetchMulti result.
+// An error fails every key in the call; ValueTTL.Err fails one.  Either may
+// be ErrDontCache or a CacheError, with the same effect as from a
+// RecomputeFunc.
+type MultiRecomputeFunc[K comparable, V any] func(ctx context.Context, missing []K) (map[K]ValueTTL[V], error)
+
+// BatchGetter is implemented by caches which can read several keys in one
+// operation
+type BatchGetter[K comparable, V any] interface {
+	// GetMulti returns the items present for keys.  Missing keys are
+	// omitted from the result.
+	GetMulti(ctx context.Context, keys []K) (map[K]Item[V], error)
+}
+
+// BatchSetter is implemented by caches which can write several keys in one
+// operation
+type BatchSetter[K comparable, V any] interface {
+	// SetMulti writes items, unconditionally.  An error may leave some of
+	// them written.
+	SetMulti(ctx context.Context, items map[K]Item[V]) error
+}
+
+// FetchMulti retrieves keys, reading them from the cache together and
+// recomputing all those which are missing or expired with a single call to
+// `recompute`.  The cache is read with GetMulti if it implements BatchGetter,
+// and the recomputed values written with SetMulti if it implements
+// BatchSetter, which takes precedence over ConditionalSetter; asynchronous
+// writes are queued one by one.  A failed SetMulti counts as a write failure
+// of every key in it.  The recompute time of the batch is recorded as the
+// delta of each key in it.
+//
+// Keys fail individually: the values of the others are returned along with a
+// *MultiError holding the failures.  A key with a cached error, see
+// CacheError, fails with it until it expires.  A key whose recompute failed
+// is served its cached value instead if WithStaleIfError allows, as for
+// Fetch.  A read failure of the batch fails every key unless the
+// WithReadErrorHandler handler returns nil.  A write failure returned per
+// the WriteFailurePolicy is reported for its key without withholding the
+// value.  FetchMulti does not coalesce with concurrent fetches.
+//
+// The circuit breakers and rate limits admit the keys to recompute one by
+// one, failing those refused as Fetch would.  The batch as a whole takes one
+// WithMaxConcurrentRecomputes slot and is retried and timed out as a single
+// recompute.
+func (xf *XFetcher[K, V]) FetchMulti(ctx context.Context, keys []K, recompute MultiRecomputeFunc[K, V]) (map[K]V, error) {
+	results, err := xf.FetchMultiResults(ctx, keys, recompute)
+	values := make(map[K]V, len(results))
+	for key, r := range results {
+		values[key] = r.Value
+	}
+	return values, err
+}
+
+// FetchMultiResults is FetchMulti, returning the Result of each key served
+func (xf *XFetcher[K, V]) FetchMultiResults(ctx context.Context, keys []K, recompute MultiRecomputeFunc[K, V]) (map[K]Result[V], error) {
+	results := make(map[K]Result[V], len(keys))
+	errs := make(map[K]error)
+
+	readStart := xf.latencies.start()
+	items, err := getMulti(ctx, xf.cache, keys)
+	xf.latencies.since(opCacheGet, readStart)
+	if err != nil && len(keys) > 0 {
+		// a batch read failure is attributed to the first key
+		if err := xf.readFailed(keys[0], err); err != nil {
+			for _, key := range keys {
+				errs[key] = err
+			}
+			return results, &MultiError[K]{Errs: errs}
+		}
+	}
+
+	seen := make(map[K]bool, len(keys))
+	var missing []K
+	now := xf.clock.Now()
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		item, ok := items[key]
+		if ok && (hardExpired(item, now) || item.Pending != nil && item.Pending.Empty) {
+			// nothing to serve
+			ok = false
+		}
+		if !ok {
+			delete(items, key)
+		}
+		info := FetchInfo{Decision: DecisionMiss, Beta: xf.betaFor(key)}
+		if ok {
+			info.Delta, info.TTL = item.Delta, ttlLeft(item, now)
+		}
+		if ok && xf.sampleExplain() {
+			info.Explain = &Explanation{}
+		}
+		if ok && !xf.shouldRecompute(item, now, info.Beta, info.Explain) {
+			info.Decision = DecisionHit
+			xf.observeLookup(ctx, key, info, now, nopSpan{})
+			if item.Err != nil {
+				// a cached error, returned as by Fetch
+				errs[key] = item.Err
+			} else {
+				results[key] = xf.result(item, SourceCache)
+			}
+			continue
+		}
+		if ok && !item.Expired(now) {
+			info.Decision = DecisionEarlyExpire
+		}
+		xf.observeLookup(ctx, key, info, now, nopSpan{})
+		missing = append(missing, key)
+	}
+
+	if len(missing) > 0 {
+		xf.recomputeMissing(ctx, missing, recompute, items, results, errs)
+	}
+	if len(errs) > 0 {
+		return results, &MultiError[K]{Errs: errs}
+	}
+	return results, nil
+}
+
+// recomputeMissing recomputes the keys of missing which are admitted, adding
+// the results to results and errs
+func (xf *XFetcher[K, V]) recomputeMissing(ctx context.Context, missing []K, recompute MultiRecomputeFunc[K, V], items map[K]Item[V], results map[K]Result[V], errs map[K]error) {
+	if xf.track() {
+		defer xf.life.wg.Done()
+	}
+
+	// a failed key is served stale if allowed; only failures of recompute
+	// itself are RecomputeErrors, as for Fetch
+	fail := func(key K, err error, recomputed bool) {
+		if item, ok := items[key]; ok && xf.canServeStale(item, err, xf.staleIfError) {
+			results[key] = xf.serveStale(ctx, key, item, err)
+			return
+		}
+		if recomputed {
+			err = &RecomputeError{Key: key, Err: err}
+		}
+		errs[key] = err
+	}
+
+	if batch := xf.admitMulti(ctx, missing, items, fail); len(batch) > 0 {
+		xf.recomputeMulti(ctx, batch, recompute, items, results, errs, fail)
+	}
+}
+
+// recomputeMulti recomputes the keys of batch, admitted by admitMulti,
+// adding their results to results and errs and writing them to the cache
+func (xf *XFetcher[K, V]) recomputeMulti(ctx context.Context, batch []K, recompute MultiRecomputeFunc[K, V], items map[K]Item[V], results map[K]Result[V], errs map[K]error, fail func(key K, err error, recomputed bool)) {
+	defer xf.release()
+
+	for _, key := range batch {
+		xf.metrics.RecomputeStart(key)
+		defer xf.startRecompute(key)()
+	}
+	start := xf.clock.Now()
+	fresh, _, hard, delta, err := retry(xf, ctx, func(ctx context.Context) (map[K]ValueTTL[V], time.Duration, error) {
+		fresh, err := recompute(ctx, batch)
+		return fresh, 0, err
+	})
+	elapsed := xf.clock.Now().Sub(start)
+	xf.latencies.observe(opRecompute, elapsed)
+
+	writes := make(map[K]Item[V], len(batch))
+	for _, key := range batch {
+		vt, ok := fresh[key]
+		kerr := cmp.Or(err, vt.Err)
+		dontCache := errors.Is(kerr, ErrDontCache)
+		if dontCache {
+			kerr = nil
+		}
+		neg, negative := asNegative(kerr)
+		if xf.breakers != nil {
+			xf.breakers.record(key, kerr != nil && !negative, xf.clock.Now())
+		}
+		e := RecomputeEvent[K]{Key: key, Start: start, Duration: elapsed, TTL: vt.TTL, Err: kerr}
+		fire(xf.hooks.OnRecompute, e)
+		xf.analysis.recomputed(e)
+		xf.stats.recomputed(elapsed, kerr)
+		xf.keyStats.recomputed(key, elapsed)
+		if kerr != nil {
+			xf.metrics.RecomputeFailure(key, elapsed)
+			xf.logRecomputeFailure(ctx, key, elapsed, kerr)
+		} else {
+			xf.metrics.RecomputeSuccess(key, elapsed)
+		}
+
+		value, ttl := vt.Value, vt.TTL
+		switch {
+		case negative:
+			value, ttl = *new(V), neg.ttl
+		case kerr != nil:
+			fail(key, kerr, true)
+			continue
+		case !ok:
+			continue
+		}
+
+		var prev *Item[V]
+		if item, ok := items[key]; ok && item.Pending == nil {
+			prev = &item
+		}
+		ttl = xf.clampTTL(ttl, xf.callSettings(ctx).defaultTTL)
+		if ttl == 0 {
+			// the value is only returned
+			dontCache = true
+		}
+		ttl = xf.jitter(ttl)
+		now := xf.clock.Now()
+		item := Item[V]{
+			Value:      value,
+			Expiry:     expiry(now, ttl),
+			HardExpiry: xf.hardExpiry(now, ttl, hard),
+			Delta:      xf.smoothDelta(delta, prev),
+			Created:    now,
+		}
+		if negative {
+			item.Err = neg.err
+			errs[key] = kerr
+		} else {
+			results[key] = xf.result(item, SourceRecompute)
+		}
+		if !dontCache {
+			writes[key] = item
+		}
+	}
+
+	xf.writeMulti(ctx, writes, errs)
+}
+
+// admitMulti lets the recomputes of the missing keys through the circuit
+// breakers and rate limits key by key, failing those refused with fail, and
+// takes one WithMaxConcurrentRecomputes slot for the batch, at the urgency of
+// its most urgent key.  It returns the keys admitted; if there are any, the
+// caller must release the slot.
+func (xf *XFetcher[K, V]) admitMulti(ctx context.Context, missing []K, items map[K]Item[V], fail func(key K, err error, recomputed bool)) []K {
+	var batch, trials []K
+	urgency := 0.0
+	for _, key := range missing {
+		trial, err := xf.pass(ctx, key)
+		if err != nil {
+			fail(key, err, false)
+			continue
+		}
+		batch = append(batch, key)
+		if trial {
+			trials = append(trials, key)
+		}
+		var prev *Item[V]
+		if item, ok := items[key]; ok {
+			prev = &item
+		}
+		urgency = max(urgency, xf.urgency(key, prev))
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := xf.acquire(ctx, urgency); err != nil {
+		for _, key := range trials {
+			xf.breakers.abandon(key)
+		}
+		for _, key := range batch {
+			fail(key, err, false)
+		}
+		return nil
+	}
+	return batch
+}
+
+// writeMulti stores computed items, in one operation if the cache supports
+// it and writes are synchronous.  Errors to be reported to the caller are
+// added to errs.
+func (xf *XFetcher[K, V]) writeMulti(ctx context.Context, items map[K]Item[V], errs map[K]error) {
+	bs, ok := xf.cache.(BatchSetter[K, V])
+	if !ok || xf.writes != nil || len(items) < 2 {
+		for key, item := range items {
+			if err := xf.write(ctx, key, item); err != nil {
+				errs[key] = err
+			}
+		}
+		return
+	}
+
+	start := xf.latencies.start()
+	err := bs.SetMulti(ctx, items)
+	xf.latencies.since(opCacheSet, start)
+	if err == nil {
+		return
+	}
+	for key, item := range items {
+		if err := xf.writeError(ctx, key, item, err); err != nil {
+			errs[key] = err
+		}
+	}
+}
+
+// getMulti reads keys from c, in one operation if possible.  Missing keys are
+// omitted from the result.
+func getMulti[K comparable, V any](ctx context.Context, c Cache[K, V], keys []K) (map[K]Item[V], error) {
+	if bg, ok := c.(BatchGetter[K, V]); ok {
+		return bg.GetMulti(ctx, keys)
+	}
+
+	items := make(map[K]Item[V], len(keys))
+	for _, key := range keys {
+		item, err := c.Get(ctx, key)
+		if errors.Is(err, ErrCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return items, err
+		}
+		items[key] = item
+	}
+	return items, nil
+}
+
+// setMulti writes items to c, in one operation if possible
+func setMulti[K comparable, V any](ctx context.Context, c Cache[K, V], items map[K]Item[V]) error {
+	if bs, ok := c.(BatchSetter[K, V]); ok {
+		return bs.SetMulti(ctx, items)
+	}
+
+	var errs []error
+	for key, item := range items {
+		errs = append(errs, c.Set(ctx, key, item))
+	}
+	return errors.Join(errs...)
+}
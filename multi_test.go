@@ -0,0 +1,221 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+// batch returns a MultiRecomputeFunc giving every key the value 1, recording
+// the keys of each call
+func batch(calls *[][]string) stampede.MultiRecomputeFunc[string, int] {
+	return func(ctx context.Context, missing []string) (map[string]stampede.ValueTTL[int], error) {
+		*calls = append(*calls, slices.Clone(missing))
+		m := make(map[string]stampede.ValueTTL[int])
+		for _, key := range missing {
+			m[key] = stampede.ValueTTL[int]{Value: 1, TTL: time.Minute}
+		}
+		return m, nil
+	}
+}
+
+// keyErrs returns the per-key errors of err from FetchMulti
+func keyErrs(t *testing.T, err error) map[string]error {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var merr *stampede.MultiError[string]
+	if !errors.As(err, &merr) {
+		t.Fatalf("FetchMulti error %v is not a *MultiError", err)
+	}
+	return merr.Errs
+}
+
+func TestFetchMulti(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache)
+
+	var calls [][]string
+	got, err := xf.FetchMulti(ctx, []string{"a", "b", "a"}, batch(&calls))
+	if err != nil || len(got) != 2 || got["a"] != 1 || got["b"] != 1 {
+		t.Fatalf("FetchMulti = %v, %v", got, err)
+	}
+	if len(calls) != 1 || len(calls[0]) != 2 {
+		t.Fatalf("recompute calls = %v, want one of two keys", calls)
+	}
+
+	got, err = xf.FetchMulti(ctx, []string{"a", "b", "c"}, batch(&calls))
+	if err != nil || len(got) != 3 {
+		t.Fatalf("FetchMulti = %v, %v", got, err)
+	}
+	if len(calls) != 2 || !slices.Equal(calls[1], []string{"c"}) {
+		t.Fatalf("recompute calls = %v, want only c recomputed", calls)
+	}
+}
+
+func TestFetchMultiBreaker(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithCircuitBreaker(1, time.Minute, byKey),
+	)
+
+	xf.Fetch(ctx, "a", failing)
+
+	var calls [][]string
+	got, err := xf.FetchMulti(ctx, []string{"a", "b"}, batch(&calls))
+	if errs := keyErrs(t, err); !errors.Is(errs["a"], stampede.ErrCircuitOpen) || errs["b"] != nil {
+		t.Fatalf("FetchMulti errors = %v, want a's breaker open", errs)
+	}
+	if got["b"] != 1 || len(calls) != 1 || !slices.Equal(calls[0], []string{"b"}) {
+		t.Fatalf("FetchMulti = %v, calls %v; want only b recomputed", got, calls)
+	}
+
+	// a failing batch opens the breakers of its keys
+	_, err = xf.FetchMulti(ctx, []string{"b"}, func(ctx context.Context, missing []string) (map[string]stampede.ValueTTL[int], error) {
+		return nil, errOrigin
+	})
+	if errs := keyErrs(t, err); !errors.Is(errs["b"], errOrigin) {
+		t.Fatalf("FetchMulti errors = %v, want %v", errs, errOrigin)
+	}
+	if _, err := xf.Fetch(ctx, "b", succeeding); !errors.Is(err, stampede.ErrCircuitOpen) {
+		t.Fatalf("Fetch after failed batch = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestFetchMultiRateLimit(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(0, 0))
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithRateLimit(1, 1, stampede.LimitError),
+	)
+
+	var calls [][]string
+	_, err := xf.FetchMulti(ctx, []string{"a", "b"}, batch(&calls))
+	if errs := keyErrs(t, err); len(errs) != 1 || !errors.Is(errs["b"], stampede.ErrRateLimited) {
+		t.Fatalf("FetchMulti errors = %v, want b rate limited", errs)
+	}
+	if len(calls) != 1 || !slices.Equal(calls[0], []string{"a"}) {
+		t.Fatalf("recompute calls = %v, want only a", calls)
+	}
+}
+
+func TestFetchMultiCapacity(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithMaxConcurrentRecomputes(1, stampede.LimitError),
+	)
+
+	started, unblock, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		xf.Fetch(ctx, "held", func(ctx context.Context) (int, time.Duration, error) {
+			close(started)
+			<-unblock
+			return 1, time.Minute, nil
+		})
+	}()
+	<-started
+
+	var calls [][]string
+	_, err := xf.FetchMulti(ctx, []string{"a", "b"}, batch(&calls))
+	close(unblock)
+	<-done
+	if errs := keyErrs(t, err); !errors.Is(errs["a"], stampede.ErrOverCapacity) || !errors.Is(errs["b"], stampede.ErrOverCapacity) {
+		t.Fatalf("FetchMulti errors = %v, want both over capacity", errs)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("recompute called over capacity: %v", calls)
+	}
+}
+
+func TestFetchMultiTimeout(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithRecomputeTimeout(10*time.Millisecond),
+	)
+
+	_, err := xf.FetchMulti(ctx, []string{"a", "b"}, func(ctx context.Context, missing []string) (map[string]stampede.ValueTTL[int], error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if errs := keyErrs(t, err); !errors.Is(errs["a"], stampede.ErrRecomputeTimeout) || !errors.Is(errs["b"], stampede.ErrRecomputeTimeout) {
+		t.Fatalf("FetchMulti errors = %v, want timeouts", errs)
+	}
+}
+
+func TestFetchMultiRetry(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithRetry(stampede.RetryPolicy{Attempts: 2}),
+	)
+
+	var attempts atomic.Int32
+	var calls [][]string
+	got, err := xf.FetchMulti(ctx, []string{"a"}, func(ctx context.Context, missing []string) (map[string]stampede.ValueTTL[int], error) {
+		if attempts.Add(1) == 1 {
+			return nil, errOrigin
+		}
+		return batch(&calls)(ctx, missing)
+	})
+	if err != nil || got["a"] != 1 || attempts.Load() != 2 {
+		t.Fatalf("FetchMulti = %v, %v after %d attempts", got, err, attempts.Load())
+	}
+}
+
+func TestFetchMultiResultErrors(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache)
+
+	errMissing := errors.New("no such key")
+	got, err := xf.FetchMulti(ctx, []string{"partial", "absent", "failed"}, func(ctx context.Context, missing []string) (map[string]stampede.ValueTTL[int], error) {
+		return map[string]stampede.ValueTTL[int]{
+			"partial": {Value: 2, TTL: time.Minute, Err: stampede.ErrDontCache},
+			"absent":  {Err: stampede.CacheError(errMissing, time.Minute)},
+			"failed":  {Err: errOrigin},
+		}, nil
+	})
+
+	errs := keyErrs(t, err)
+	if got["partial"] != 2 || errs["partial"] != nil {
+		t.Errorf("ErrDontCache key = %v, %v; want its value", got["partial"], errs["partial"])
+	}
+	if _, err := cache.Get(ctx, "partial"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("ErrDontCache key was cached: %v", err)
+	}
+
+	if _, ok := got["absent"]; ok || !errors.Is(errs["absent"], errMissing) {
+		t.Errorf("CacheError key = %v, %v; want %v", got["absent"], errs["absent"], errMissing)
+	}
+	if item, err := cache.Get(ctx, "absent"); err != nil || item.Err == nil {
+		t.Errorf("CacheError key not cached: %+v, %v", item, err)
+	}
+
+	var rerr *stampede.RecomputeError
+	if !errors.As(errs["failed"], &rerr) || !errors.Is(rerr, errOrigin) {
+		t.Errorf("failed key error = %v, want a RecomputeError of %v", errs["failed"], errOrigin)
+	}
+
+	// the cached error is returned without recomputing
+	var calls [][]string
+	got, err = xf.FetchMulti(ctx, []string{"absent"}, batch(&calls))
+	if errs := keyErrs(t, err); len(got) != 0 || errs["absent"] == nil || errs["absent"].Error() != errMissing.Error() {
+		t.Errorf("FetchMulti of cached error = %v, %v; want %v", got, err, errMissing)
+	}
+	if len(calls) != 0 {
+		t.Errorf("cached error recomputed: %v", calls)
+	}
+}
@@ -0,0 +1,214 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Namespace returns a fetcher for the keys of xf's cache prefixed with name
+// and a colon, so that domains sharing a cache cannot collide and can be
+// tuned separately.  The fetcher is configured as xf, with opts applied on
+// top; its metrics, tracer, hooks and locker, unless replaced by opts, are
+// xf's and see the prefixed keys, e.g. for stampedeprom.PrefixBefore(":") labels.  The
+// concurrency and rate limits of xf are shared with the namespace unless opts
+// set its own.
+func Namespace[V any](xf *XFetcher[string, V], name string, opts ...Option) *XFetcher[string, V] {
+	prefix := name + ":"
+
+	c := xf.config
+	c.metrics = prefixMetrics{xf.metrics, prefix}
+	c.tracer = prefixTracer{xf.tracer, prefix}
+	c.hooks = prefixHooks(xf.hooks, prefix)
+	if xf.locker != nil {
+		c.locker = prefixLocker{xf.locker, prefix}
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+
+	nx := newFetcher[string, V](&prefixCache[V]{xf.cache, prefix}, c)
+	xf.onClose(nx.Close)
+	if c.maxRecomputes == xf.maxRecomputes && c.capacityPolicy == xf.capacityPolicy {
+		nx.slots = xf.slots
+	}
+	if len(c.rateLimits) == len(xf.rateLimits) {
+		nx.limiters = xf.limiters
+	}
+	return nx
+}
+
+// prefixCache prepends prefix to the keys of a cache
+type prefixCache[V any] struct {
+	inner  Cache[string, V]
+	prefix string
+}
+
+func (c *prefixCache[V]) Get(ctx context.Context, key string) (Item[V], error) {
+	return c.inner.Get(ctx, c.prefix+key)
+}
+
+func (c *prefixCache[V]) Set(ctx context.Context, key string, item Item[V]) error {
+	return c.inner.Set(ctx, c.prefix+key, item)
+}
+
+func (c *prefixCache[V]) SetIfNewer(ctx context.Context, key string, item Item[V]) (bool, error) {
+	cs, ok := c.inner.(ConditionalSetter[string, V])
+	if !ok {
+		return true, c.Set(ctx, key, item)
+	}
+	return cs.SetIfNewer(ctx, c.prefix+key, item)
+}
+
+func (c *prefixCache[V]) ClaimLease(ctx context.Context, key string, prev *Item[V], lease Item[V]) (bool, error) {
+	lc, ok := c.inner.(LeaseClaimer[string, V])
+	if !ok {
+		return true, c.Set(ctx, key, lease)
+	}
+	return lc.ClaimLease(ctx, c.prefix+key, prev, lease)
+}
+
+func (c *prefixCache[V]) Delete(ctx context.Context, key string) error {
+	return deleteKey(ctx, c.inner, c.prefix+key)
+}
+
+func (c *prefixCache[V]) GetMulti(ctx context.Context, keys []string) (map[string]Item[V], error) {
+	items := make(map[string]Item[V], len(keys))
+	bg, ok := c.inner.(BatchGetter[string, V])
+	if !ok {
+		for _, key := range keys {
+			item, err := c.Get(ctx, key)
+			if errors.Is(err, ErrCacheMiss) {
+				continue
+			}
+			if err != nil {
+				return items, err
+			}
+			items[key] = item
+		}
+		return items, nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	got, err := bg.GetMulti(ctx, prefixed)
+	for _, key := range keys {
+		if item, ok := got[c.prefix+key]; ok {
+			items[key] = item
+		}
+	}
+	return items, err
+}
+
+func (c *prefixCache[V]) SetMulti(ctx context.Context, items map[string]Item[V]) error {
+	prefixed := make(map[string]Item[V], len(items))
+	for key, item := range items {
+		prefixed[c.prefix+key] = item
+	}
+	return setMulti(ctx, c.inner, prefixed)
+}
+
+// prefixMetrics reports keys with prefix prepended
+type prefixMetrics struct {
+	inner  Metrics[string]
+	prefix string
+}
+
+func (m prefixMetrics) Hit(key string)            { m.inner.Hit(m.prefix + key) }
+func (m prefixMetrics) Miss(key string)           { m.inner.Miss(m.prefix + key) }
+func (m prefixMetrics) EarlyExpire(key string)    { m.inner.EarlyExpire(m.prefix + key) }
+func (m prefixMetrics) ReadFailure(key string)    { m.inner.ReadFailure(m.prefix + key) }
+func (m prefixMetrics) RecomputeStart(key string) { m.inner.RecomputeStart(m.prefix + key) }
+func (m prefixMetrics) WriteFailure(key string)   { m.inner.WriteFailure(m.prefix + key) }
+
+func (m prefixMetrics) RecomputeSuccess(key string, d time.Duration) {
+	m.inner.RecomputeSuccess(m.prefix+key, d)
+}
+
+func (m prefixMetrics) RecomputeFailure(key string, d time.Duration) {
+	m.inner.RecomputeFailure(m.prefix+key, d)
+}
+
+func (m prefixMetrics) BreakerStateChange(group string, state BreakerState) {
+	if bm, ok := m.inner.(BreakerMetrics); ok {
+		bm.BreakerStateChange(group, state)
+	}
+}
+
+func (m prefixMetrics) RefreshQueueDepth(n int) {
+	if qm, ok := m.inner.(RefreshQueueMetrics); ok {
+		qm.RefreshQueueDepth(n)
+	}
+}
+
+func (m prefixMetrics) RefreshDropped() {
+	if qm, ok := m.inner.(RefreshQueueMetrics); ok {
+		qm.RefreshDropped()
+	}
+}
+
+func (m prefixMetrics) ConcurrentRecompute(key string, remote bool) {
+	if sm, ok := m.inner.(StampedeMetrics[string]); ok {
+		sm.ConcurrentRecompute(m.prefix+key, remote)
+	}
+}
+
+// prefixTracer traces keys with prefix prepended
+type prefixTracer struct {
+	inner  Tracer[string]
+	prefix string
+}
+
+func (t prefixTracer) StartFetch(ctx context.Context, key string) (context.Context, Span) {
+	return t.inner.StartFetch(ctx, t.prefix+key)
+}
+
+func (t prefixTracer) StartRecompute(ctx context.Context, key string) (context.Context, Span) {
+	return t.inner.StartRecompute(ctx, t.prefix+key)
+}
+
+// prefixLocker locks keys with prefix prepended
+type prefixLocker struct {
+	inner  Locker[string]
+	prefix string
+}
+
+func (l prefixLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	return l.inner.TryLock(ctx, l.prefix+key, ttl)
+}
+
+func (l prefixLocker) Unlock(ctx context.Context, key string, token string) error {
+	return l.inner.Unlock(ctx, l.prefix+key, token)
+}
+
+// prefixHooks returns hooks calling h with prefix prepended to event keys
+func prefixHooks(h Hooks[string], prefix string) Hooks[string] {
+	lookup := func(fn func(LookupEvent[string])) func(LookupEvent[string]) {
+		if fn == nil {
+			return nil
+		}
+		return func(e LookupEvent[string]) {
+			e.Key = prefix + e.Key
+			fn(e)
+		}
+	}
+	var p Hooks[string]
+	p.OnHit = lookup(h.OnHit)
+	p.OnMiss = lookup(h.OnMiss)
+	p.OnEarlyExpire = lookup(h.OnEarlyExpire)
+	if fn := h.OnRecompute; fn != nil {
+		p.OnRecompute = func(e RecomputeEvent[string]) {
+			e.Key = prefix + e.Key
+			fn(e)
+		}
+	}
+	if fn := h.OnStaleServed; fn != nil {
+		p.OnStaleServed = func(e StaleEvent[string]) {
+			e.Key = prefix + e.Key
+			fn(e)
+		}
+	}
+	return p
+}
@@ -0,0 +1,89 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestNamespace(t *testing.T) {
+	ctx := context.Background()
+	mc := memcache.New[string, int]()
+	var mu sync.Mutex
+	var missed []string
+	xf := stampede.New[string, int](mc, stampede.WithHooks(stampede.Hooks[string]{
+		OnMiss: func(e stampede.LookupEvent[string]) {
+			mu.Lock()
+			missed = append(missed, e.Key)
+			mu.Unlock()
+		},
+	}))
+	users := stampede.Namespace(xf, "users")
+	posts := stampede.Namespace(xf, "posts")
+
+	user := func(ctx context.Context) (int, time.Duration, error) { return 1, time.Minute, nil }
+	post := func(ctx context.Context) (int, time.Duration, error) { return 2, time.Minute, nil }
+	if v, _ := users.Fetch(ctx, "1", user); v != 1 {
+		t.Fatalf("users Fetch = %d", v)
+	}
+	if v, _ := posts.Fetch(ctx, "1", post); v != 2 {
+		t.Fatalf("posts Fetch = %d, want no collision with users", v)
+	}
+	if item, err := mc.Get(ctx, "users:1"); err != nil || item.Value != 1 {
+		t.Errorf("cache users:1 = %+v, %v", item, err)
+	}
+	if want := []string{"users:1", "posts:1"}; len(missed) != 2 || missed[0] != want[0] || missed[1] != want[1] {
+		t.Errorf("hooks saw %v, want %v", missed, want)
+	}
+
+	if err := users.Invalidate(ctx, "1"); err != nil {
+		t.Fatalf("Invalidate = %v", err)
+	}
+	if _, err := mc.Get(ctx, "users:1"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("cache users:1 after Invalidate = %v, want ErrCacheMiss", err)
+	}
+	if _, err := mc.Get(ctx, "posts:1"); err != nil {
+		t.Errorf("cache posts:1 after invalidating users:1 = %v", err)
+	}
+}
+
+func TestNamespaceOptions(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithMaxConcurrentRecomputes(1, stampede.LimitError))
+	shared := stampede.Namespace(xf, "shared")
+	own := stampede.Namespace(xf, "own", stampede.WithMaxConcurrentRecomputes(2, stampede.LimitError))
+
+	release := hold(t, xf)
+	defer release()
+	if _, err := shared.Fetch(ctx, "k", succeeding); !errors.Is(err, stampede.ErrOverCapacity) {
+		t.Errorf("Fetch in a namespace sharing the limit = %v, want ErrOverCapacity", err)
+	}
+	if _, err := own.Fetch(ctx, "k", succeeding); err != nil {
+		t.Errorf("Fetch in a namespace with its own limit = %v", err)
+	}
+}
+
+func TestNamespaceBatch(t *testing.T) {
+	ctx := context.Background()
+	mc := memcache.New[string, int]()
+	ns := stampede.Namespace(stampede.New[string, int](mc), "ns")
+
+	var calls [][]string
+	got, err := ns.FetchMulti(ctx, []string{"a", "b"}, batch(&calls))
+	if err != nil || len(got) != 2 {
+		t.Fatalf("FetchMulti = %v, %v", got, err)
+	}
+	if len(calls) != 1 || len(calls[0]) != 2 || calls[0][0] == "ns:a" {
+		t.Errorf("recompute called with %v, want the unprefixed keys", calls)
+	}
+	for _, key := range []string{"ns:a", "ns:b"} {
+		if _, err := mc.Get(ctx, key); err != nil {
+			t.Errorf("cache %s = %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package stampede
+
+import (
+	"errors"
+	"time"
+)
+
+// CacheError wraps an error returned by recompute so that it is cached for
+// ttl, like a value.  Until it expires, fetches of the key return err without
+// calling recompute.  This avoids an origin hit on every request for keys
+// known not to exist.
+//
+// Caches which serialize items keep only the error's message, so errors read
+// back from them do not match err with errors.Is.
+func CacheError(err error, ttl time.Duration) error {
+	return &negativeError{err: err, ttl: ttl}
+}
+
+type negativeError struct {
+	err error
+	ttl time.Duration
+}
+
+func (e *negativeError) Error() string { return e.err.Error() }
+func (e *negativeError) Unwrap() error { return e.err }
+
+// asNegative returns the negativeError in err's chain, if any
+func asNegative(err error) (*negativeError, bool) {
+	var neg *negativeError
+	ok := errors.As(err, &neg)
+	return neg, ok
+}
+
+// errorFromMessage rebuilds a cached error read from a serialized item
+func errorFromMessage(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	return errors.New(msg)
+}
+
+// errorMessage returns the message stored for a cached error
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
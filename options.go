@@ -0,0 +1,481 @@
+package stampede
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// An Option configures an XFetcher
+type Option func(*config)
+
+// config holds the settings shared by all XFetcher instantiations.  Settings
+// which depend on the key or value type are stored as interface values and
+// resolved by New with typed.
+type config struct {
+	beta     float64
+	betaFunc any
+	adaptive *AdaptiveBeta
+
+	// float64 returns a random number in [0.0,1.0) and must be safe for
+	// concurrent use
+	float64 func() float64
+
+	clock Clock
+
+	singleflight bool
+
+	readError func(err error) error
+
+	writePolicy       WriteFailurePolicy
+	writeErrorHandler func(err error)
+	writeAttempts     int
+	writeBackoff      time.Duration
+
+	asyncWorkers  int
+	asyncQueue    int
+	asyncOverflow AsyncOverflowPolicy
+
+	deltaAlpha float64
+	deltaFloor time.Duration
+	deltaCap   time.Duration
+
+	recomputeTimeout time.Duration
+	retryPolicy      RetryPolicy
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	breakerGroup     any
+
+	locker         any
+	lockTTL        time.Duration
+	lockPoll       time.Duration
+	lockWait       time.Duration
+	lockServeStale bool
+
+	placeholderOwner string
+	placeholderTTL   time.Duration
+
+	maxRecomputes  int
+	capacityPolicy LimitPolicy
+	rateLimits     []rateLimit
+	cost           any
+
+	hardTTL time.Duration
+
+	jitterAbs  time.Duration
+	jitterFrac float64
+
+	defaultTTL     time.Duration
+	minTTL, maxTTL time.Duration
+
+	staleIfError         time.Duration
+	staleWhileRevalidate bool
+	refreshWorkers       int
+	refreshQueueSize     int
+	latencyBudget        time.Duration
+
+	metrics  any
+	tracer   any
+	hooks    any
+	strategy any
+	fallback any
+	logger   *slog.Logger
+
+	keySample   float64
+	maxKeyStats int
+
+	analysis      bool
+	analysisGroup any
+
+	latencyWindow time.Duration
+	explainSample float64
+}
+
+func defaultConfig() config {
+	return config{
+		beta:    Beta,
+		float64: rand.Float64,
+
+		clock: SystemClock{},
+
+		singleflight: true,
+
+		lockPoll:       50 * time.Millisecond,
+		lockWait:       time.Second,
+		lockServeStale: true,
+
+		writeErrorHandler: func(error) {},
+		writeAttempts:     3,
+		writeBackoff:      100 * time.Millisecond,
+	}
+}
+
+// typed returns the option value v as a T, or def if the option was not set.
+// It panics if v has the wrong type, which happens when the option was built
+// for a fetcher with a different key or value type.
+func typed[T any](name string, v any, def T) T {
+	if v == nil {
+		return def
+	}
+	t, ok := v.(T)
+	if !ok {
+		panic(fmt.Sprintf("stampede: %s option type %T does not match fetcher, want %T", name, v, t))
+	}
+	return t
+}
+
+// WithBeta sets the beta parameter, which controls early expiration vs.
+// stampede prevention.  Values greater than 1 favour earlier recomputation.
+// The default is Beta.
+func WithBeta(beta float64) Option {
+	return func(c *config) { c.beta = beta }
+}
+
+// WithSingleflight controls whether concurrent recomputes of the same key in
+// this process are coalesced into a single call.  The default is true.
+func WithSingleflight(enabled bool) Option {
+	return func(c *config) { c.singleflight = enabled }
+}
+
+// WithReadErrorHandler sets a function called when the cache read fails with
+// an error other than ErrCacheMiss.  If fn returns nil Fetch recomputes the
+// value as for a miss; otherwise Fetch fails with the returned error.  To fail
+// fast on backend errors, return err unchanged.  err is a *CacheReadError.
+func WithReadErrorHandler(fn func(err error) error) Option {
+	return func(c *config) { c.readError = fn }
+}
+
+// WithWriteFailurePolicy sets how Fetch handles a failed cache write.  The
+// default is WriteFailureIgnore.
+func WithWriteFailurePolicy(p WriteFailurePolicy) Option {
+	return func(c *config) { c.writePolicy = p }
+}
+
+// WithWriteErrorHandler sets the function called with cache write errors,
+// as *CacheWriteError, under the WriteFailureCallback and WriteFailureRetry
+// policies.
+func WithWriteErrorHandler(fn func(err error)) Option {
+	return func(c *config) { c.writeErrorHandler = fn }
+}
+
+// WithWriteRetry sets the number of attempts and the initial backoff used by
+// the WriteFailureRetry policy.  The backoff doubles after each attempt.  The
+// default is 3 attempts starting at 100ms.
+func WithWriteRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.writeAttempts = attempts
+		c.writeBackoff = backoff
+	}
+}
+
+// WithStaleIfError allows Fetch to return a cached value that expired at most
+// maxStale ago when recompute fails.  The default of zero disables stale
+// serving.
+func WithStaleIfError(maxStale time.Duration) Option {
+	return func(c *config) { c.staleIfError = maxStale }
+}
+
+// WithStaleWhileRevalidate controls whether an early expiration returns the
+// cached value immediately while recomputing it in a background goroutine.
+// Values which have actually expired are always recomputed synchronously.
+// The default is false.
+func WithStaleWhileRevalidate(enabled bool) Option {
+	return func(c *config) { c.staleWhileRevalidate = enabled }
+}
+
+// WithRefreshQueue runs the background refreshes of WithStaleWhileRevalidate
+// on the given number of workers, rather than a goroutine each.  Up to
+// queueSize refreshes wait for a worker, the most valuable first, valued by
+// the number of fetches asking for the refresh times the key's cost (see
+// WithCostFunc), divided by the time left before the value expires.  When the
+// queue is full the least valuable refresh is dropped, leaving its value to
+// expire or be refreshed by a later fetch.  The queue depth and drops are
+// reported in Stats and to the Metrics if it implements RefreshQueueMetrics.
+func WithRefreshQueue(workers, queueSize int) Option {
+	return func(c *config) {
+		c.refreshWorkers = workers
+		c.refreshQueueSize = queueSize
+	}
+}
+
+// WithLatencyBudget bounds how long Fetch waits for the recompute of a key
+// which has a cached value.  If the recompute has not finished within d, the
+// cached value is returned, stale if need be, and the recompute carries on in
+// the background to refresh the cache.  Values past their hard expiry are not
+// served.  Misses always wait.  The default of zero disables the budget.
+func WithLatencyBudget(d time.Duration) Option {
+	return func(c *config) { c.latencyBudget = d }
+}
+
+// WithExplain records the inputs of the early expiration decision of the
+// fraction sample of fetches in FetchInfo.Explain: the delta, beta, random
+// draw and time to expiry, and the outcome.  This shows in the hook events,
+// the fetch span and the debug log of lookups, answering why a recompute
+// fired.  Explaining single calls with WithFetchExplain is also possible.
+// The default is none.
+func WithExplain(sample float64) Option {
+	return func(c *config) { c.explainSample = sample }
+}
+
+// WithBetaFunc sets a policy choosing beta per key, overriding WithBeta.  Hot
+// keys behind expensive queries may want beta > 1, cheap long-tail keys beta < 1.
+// The key type must match the fetcher's.
+func WithBetaFunc[K comparable](beta func(key K) float64) Option {
+	return func(c *config) { c.betaFunc = beta }
+}
+
+// WithAdaptiveBeta lets an AdaptiveBeta controller tune beta, in place of
+// WithBeta.  WithBetaFunc takes precedence.
+func WithAdaptiveBeta(a *AdaptiveBeta) Option {
+	return func(c *config) { c.adaptive = a }
+}
+
+// WithMetrics sets the Metrics receiving counts and timings.  The key type must
+// match the fetcher's.
+func WithMetrics[K comparable](m Metrics[K]) Option {
+	return func(c *config) { c.metrics = m }
+}
+
+// WithTracer sets the Tracer for fetches and recomputes.  The key type must
+// match the fetcher's.
+func WithTracer[K comparable](t Tracer[K]) Option {
+	return func(c *config) { c.tracer = t }
+}
+
+// WithHooks sets callbacks fired on fetch decisions and recomputes.  The key
+// type must match the fetcher's.
+func WithHooks[K comparable](h Hooks[K]) Option {
+	return func(c *config) { c.hooks = h }
+}
+
+// WithClock sets the source of time, so tests can control expiry.  The default
+// is SystemClock.
+func WithClock(clock Clock) Option {
+	return func(c *config) { c.clock = clock }
+}
+
+// WithRand sets the source of random numbers in [0.0,1.0) for the early
+// expiration decision, which must be safe for concurrent use.  A draw of
+// exactly 0 always recomputes; a draw of 1 recomputes only expired items.
+// See also AlwaysExpire and NeverExpire.  The default is rand.Float64.
+func WithRand(float64 func() float64) Option {
+	return func(c *config) { c.float64 = float64 }
+}
+
+// WithRandSource draws random numbers for the early expiration decision from
+// src, so that simulations can be reproduced exactly.  Access to src is
+// serialized.
+func WithRandSource(src rand.Source) Option {
+	l := &lockedRand{r: rand.New(src)}
+	return WithRand(l.Float64)
+}
+
+// WithDeltaSmoothing stores an exponentially weighted moving average of
+// recompute times as each item's delta, rather than the latest sample, so one
+// slow recompute does not inflate early expirations for a whole TTL.  Each new
+// sample is weighted by alpha, in (0,1).  By default the latest sample is used.
+func WithDeltaSmoothing(alpha float64) Option {
+	return func(c *config) { c.deltaAlpha = alpha }
+}
+
+// WithDeltaFloor sets the smallest delta used in the early expiration
+// decision, so that keys which recompute almost instantly still get some
+// stampede protection.  The default is zero.
+func WithDeltaFloor(min time.Duration) Option {
+	return func(c *config) { c.deltaFloor = min }
+}
+
+// WithDeltaCap sets the largest delta used in the early expiration decision,
+// so that one pathologically slow recompute cannot make a key expire early on
+// nearly every fetch.  The default of zero means no cap.
+func WithDeltaCap(max time.Duration) Option {
+	return func(c *config) { c.deltaCap = max }
+}
+
+// WithRecomputeTimeout bounds each recompute by d.  The recompute function is
+// passed a context with that deadline and abandoned if it overruns.  On
+// timeout Fetch returns the cached value if there is one, however stale, and
+// otherwise ErrRecomputeTimeout.  The default of zero means no timeout.
+func WithRecomputeTimeout(d time.Duration) Option {
+	return func(c *config) { c.recomputeTimeout = d }
+}
+
+// WithRetry retries failed recomputes according to p, so transient origin
+// errors do not reach callers.  Any recompute timeout applies to each attempt.
+// By default recomputes are not retried.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *config) { c.retryPolicy = p }
+}
+
+// WithCircuitBreaker short-circuits recomputes for a group of keys after
+// threshold consecutive failures, for the cool-down period.  Meanwhile Fetch
+// serves the cached value for the key if there is one, however stale, and
+// otherwise fails with ErrCircuitOpen.  After the cool-down a single trial
+// recompute decides whether the breaker closes again.  Keys are grouped by
+// group, e.g. by prefix; nil puts all keys in one group.  State changes are
+// reported to the Metrics if it implements BreakerMetrics.  The key type must
+// match the fetcher's.
+func WithCircuitBreaker[K comparable](threshold int, cooldown time.Duration, group func(key K) string) Option {
+	return func(c *config) {
+		c.breakerThreshold = threshold
+		c.breakerCooldown = cooldown
+		c.breakerGroup = group
+	}
+}
+
+// WithLocker uses l so that only one process recomputes a key at a time,
+// holding the lock for at most ttl, which should exceed the slowest
+// recompute.  A process which fails to take the lock serves the cached value
+// if it has not expired, or if WithLockServeStale allows it; otherwise it
+// polls the cache for the lock holder's result as set by WithLockWait.  The
+// key type must match the fetcher's.
+func WithLocker[K comparable](l Locker[K], ttl time.Duration) Option {
+	return func(c *config) {
+		c.locker = l
+		c.lockTTL = ttl
+	}
+}
+
+// WithLockWait sets how often, and for how long, a process which failed to
+// take the recompute lock polls the cache for the holder's result before
+// recomputing the value itself.  The default is every 50ms for one second.
+func WithLockWait(poll, timeout time.Duration) Option {
+	return func(c *config) {
+		c.lockPoll = poll
+		c.lockWait = timeout
+	}
+}
+
+// WithLockServeStale controls whether a process which failed to take the
+// recompute lock serves an expired cached value rather than waiting for the
+// holder's result.  The default is true.
+func WithLockServeStale(enabled bool) Option {
+	return func(c *config) { c.lockServeStale = enabled }
+}
+
+// WithPlaceholders writes a placeholder to the cache before recomputing a
+// key, naming owner as the recomputing process and lasting at most ttl.
+// Processes reading the placeholder serve the previous value it carries or,
+// if there is none, poll the cache for owner's result at the interval set by
+// WithLockWait, recomputing themselves once the placeholder is abandoned.
+// If owner is empty, the host name and process ID are used.
+//
+// The placeholder is a lease on the recompute held by owner until it is
+// replaced or ttl passes.  If the cache implements LeaseClaimer the lease is
+// claimed atomically, so only one process recomputes the key; a process
+// losing the claim serves the value it read, or awaits the holder's result,
+// as for a lock held elsewhere (see WithLocker).
+//
+// All processes sharing the cache must use a Codec which stores placeholders,
+// such as the JSONCodec, GobCodec or EnvelopeCodec.
+func WithPlaceholders(owner string, ttl time.Duration) Option {
+	return func(c *config) {
+		if owner == "" {
+			owner = defaultOwner()
+		}
+		c.placeholderOwner = owner
+		c.placeholderTTL = ttl
+	}
+}
+
+// WithMaxConcurrentRecomputes limits the number of recomputes running at once
+// across all keys to n, so a burst of expirations cannot overload the origin.
+// Fetches needing a recompute beyond the limit are handled per policy,
+// failing with ErrOverCapacity if they do not wait.
+func WithMaxConcurrentRecomputes(n int, policy LimitPolicy) Option {
+	return func(c *config) {
+		c.maxRecomputes = n
+		c.capacityPolicy = policy
+	}
+}
+
+// WithCostClass tags keys with their recompute cost class, as a shorthand for
+// WithCostFunc with the classes' costs.  The key type must match the
+// fetcher's.
+func WithCostClass[K comparable](class func(key K) CostClass) Option {
+	return func(c *config) { c.cost = func(key K) float64 { return class(key).Cost() } }
+}
+
+// WithCostFunc sets the relative recompute cost of keys, 1 being normal.
+// Expensive recomputes are scheduled ahead of cheap ones nearing expiry: the
+// WithMaxConcurrentRecomputes limit admits waiting recomputes by cost
+// divided by the time left before their value expires, the WithRefreshQueue
+// queue weighs refreshes by cost, and Refreshers scale their lead by it.  By
+// default all keys cost 1.  The key type must match the fetcher's.
+func WithCostFunc[K comparable](cost func(key K) float64) Option {
+	return func(c *config) { c.cost = cost }
+}
+
+// WithRateLimit limits recomputes across all keys to rate per second, with
+// bursts of up to burst, so a cold start cannot flood the origin.  Recomputes
+// beyond the limit are handled per policy, failing with ErrRateLimited if they
+// do not wait.
+func WithRateLimit(rate float64, burst int, policy LimitPolicy) Option {
+	return func(c *config) {
+		c.rateLimits = append(c.rateLimits, rateLimit{rate: rate, burst: burst, policy: policy})
+	}
+}
+
+// WithKeyRateLimit is like WithRateLimit, but limits each group of keys
+// separately.  Keys are grouped by group, e.g. by prefix, and a bucket is kept
+// for every group seen.  It may be combined with WithRateLimit, and the
+// key type must match the fetcher's.
+func WithKeyRateLimit[K comparable](rate float64, burst int, policy LimitPolicy, group func(key K) string) Option {
+	return func(c *config) {
+		c.rateLimits = append(c.rateLimits, rateLimit{rate: rate, burst: burst, policy: policy, group: group})
+	}
+}
+
+// WithHardTTL gives each item a hard expiry d after its soft expiry.  Between
+// the two the item may be served stale, as set by the other options; past its
+// hard expiry it is treated as a miss.  Recomputes may override it for their
+// value with SetHardTTL.  By default items have no hard expiry.
+func WithHardTTL(d time.Duration) Option {
+	return func(c *config) { c.hardTTL = d }
+}
+
+// WithDefaultTTL sets the time-to-live of values for which recompute returns
+// a zero TTL.  See also WithFetchDefaultTTL.  By default such values are not
+// cached, only shared by concurrent fetches.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(c *config) { c.defaultTTL = d }
+}
+
+// WithTTLClamp bounds the time-to-live returned by recompute to [min,max],
+// after WithDefaultTTL and before WithTTLJitter, so that a misbehaving loader
+// cannot make entries expire at once or live forever.  A max of zero means no
+// upper bound.
+func WithTTLClamp(min, max time.Duration) Option {
+	return func(c *config) {
+		c.minTTL = min
+		c.maxTTL = max
+	}
+}
+
+// WithTTLJitter randomizes the time-to-live of each value written by up to
+// abs plus frac of the time-to-live, in either direction, so keys loaded
+// together do not all expire together.  The random source is that set by
+// WithRand.
+func WithTTLJitter(abs time.Duration, frac float64) Option {
+	return func(c *config) {
+		c.jitterAbs = abs
+		c.jitterFrac = frac
+	}
+}
+
+// WithAsyncWrites writes recomputed values to the cache in the background,
+// with the given number of workers draining a queue of queueSize writes, so
+// slow cache writes do not delay Fetch.  A full queue is handled per
+// overflow.  Write failures are handled per the WriteFailurePolicy, except
+// that under WriteFailureReturn they are passed to the WithWriteErrorHandler
+// handler, as there is no caller to return them to.  By default writes are
+// synchronous.
+func WithAsyncWrites(workers, queueSize int, overflow AsyncOverflowPolicy) Option {
+	return func(c *config) {
+		c.asyncWorkers = workers
+		c.asyncQueue = queueSize
+		c.asyncOverflow = overflow
+	}
+}
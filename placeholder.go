@@ -0,0 +1,161 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Pending marks an item as a placeholder, written to the cache while Owner
+// recomputes the value (see WithPlaceholders).  Other processes seeing it
+// serve the previous value it carries, or wait for Owner's result, rather
+// than recomputing themselves.
+type Pending struct {
+	// Owner identifies the recomputing process
+	Owner string
+
+	// Until is when the placeholder is abandoned if not replaced
+	Until time.Time
+
+	// Empty is set if there was no previous value to carry
+	Empty bool
+}
+
+// LeaseClaimer is implemented by caches which can write a placeholder
+// atomically, only if no other process claimed the key since it was read, so
+// that the placeholder is an exclusive lease on the recompute.  Without it,
+// processes racing to recompute a key may each write a placeholder and all
+// recompute.
+type LeaseClaimer[K comparable, V any] interface {
+	// ClaimLease stores the placeholder lease if the key is absent or its
+	// stored item is Claimable given prev, the item the claimant read or nil
+	// if it read none, and reports whether it was stored
+	ClaimLease(ctx context.Context, key K, prev *Item[V], lease Item[V]) (claimed bool, err error)
+}
+
+// Claimable reports whether a placeholder lease may replace the stored item,
+// for a claimant which read prev: item is an abandoned placeholder, or it is
+// not a placeholder and is the item the claimant read, by creation time
+func (item Item[V]) Claimable(prev *Item[V], now time.Time) bool {
+	if item.Pending != nil {
+		return !now.Before(item.Pending.Until)
+	}
+	return prev != nil && item.Created.Equal(prev.Created)
+}
+
+// errPending is reported to OnStaleServed when a placeholder's previous
+// value is served after its expiry
+var errPending = errors.New("stampede: recompute pending elsewhere")
+
+// defaultOwner identifies this process in placeholders
+func defaultOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// claimedCompute runs compute, first writing a placeholder for key if
+// WithPlaceholders is set.  If no new item is written in its place, because
+// the recompute failed, its result is not to be cached or the write failed,
+// the placeholder is replaced by prev, or deleted if there was none.  If
+// another process claimed the key first, its lease is honoured as a held
+// lock.
+func (xf *XFetcher[K, V]) claimedCompute(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) (Item[V], error) {
+	if xf.placeholderTTL <= 0 {
+		return xf.compute(ctx, key, recompute, prev)
+	}
+
+	p := &Pending{Owner: xf.placeholderOwner, Until: xf.clock.Now().Add(xf.placeholderTTL)}
+	var ph Item[V]
+	if prev != nil {
+		ph = *prev
+	} else {
+		ph.Expiry, p.Empty = p.Until, true
+	}
+	ph.Pending = p
+	claimed, err := xf.claim(ctx, key, prev, ph)
+	if err != nil {
+		return xf.compute(ctx, key, recompute, prev)
+	}
+	if !claimed {
+		return xf.leaseHeld(ctx, key, recompute, prev)
+	}
+
+	item, stored, err := xf.computeStored(ctx, key, recompute, prev)
+	if !stored {
+		wctx := context.WithoutCancel(ctx)
+		if prev != nil {
+			xf.store(wctx, key, *prev)
+		} else {
+			deleteKey(wctx, xf.cache, key)
+		}
+	}
+	return item, err
+}
+
+// claim writes the placeholder ph for key, atomically if the cache is a
+// LeaseClaimer, and reports whether this process holds the lease
+func (xf *XFetcher[K, V]) claim(ctx context.Context, key K, prev *Item[V], ph Item[V]) (bool, error) {
+	if lc, ok := xf.cache.(LeaseClaimer[K, V]); ok {
+		return lc.ClaimLease(ctx, key, prev, ph)
+	}
+	return true, xf.cache.Set(ctx, key, ph)
+}
+
+// leaseHeld handles a key claimed by another process as lockedCompute does a
+// lock held elsewhere: prev is served if possible, and otherwise the holder's
+// result awaited, before computing the value without a lease
+func (xf *XFetcher[K, V]) leaseHeld(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) (Item[V], error) {
+	now := xf.clock.Now()
+	if prev != nil && (!prev.Expired(now) || xf.lockServeStale) {
+		return Item[V]{}, errLockHeld
+	}
+	if item, done, err := xf.awaitHolder(ctx, key); done {
+		return item, err
+	}
+	xf.concurrent(key, true)
+	return xf.compute(ctx, key, recompute, prev)
+}
+
+// awaitPending handles a placeholder read from the cache as item.  If item
+// should be served, or the owner's result arrived while waiting, it returns
+// the result and done is set.  Otherwise, as when the owner fails, item and
+// found are updated to what the fetch should proceed with.
+func (xf *XFetcher[K, V]) awaitPending(ctx context.Context, key K, item *Item[V], found *bool) (res Result[V], done bool, err error) {
+	p := item.Pending
+	now := xf.clock.Now()
+
+	if !now.Before(p.Until) {
+		// abandoned by its owner
+		item.Pending = nil
+		*found = !p.Empty
+		return Result[V]{}, false, nil
+	}
+
+	if !p.Empty && !hardExpired(*item, now) {
+		return xf.serveStale(ctx, key, *item, errPending), true, item.Err
+	}
+
+	for xf.clock.Now().Before(p.Until) {
+		select {
+		case <-xf.clock.After(xf.lockPoll):
+		case <-ctx.Done():
+			return Result[V]{}, true, ctx.Err()
+		}
+		next, err := xf.cache.Get(ctx, key)
+		if errors.Is(err, ErrCacheMiss) {
+			// the owner failed and deleted its placeholder
+			break
+		}
+		if err == nil && next.Pending == nil {
+			if hardExpired(next, xf.clock.Now()) {
+				// the owner failed and restored the previous value
+				break
+			}
+			return xf.result(next, SourceRecompute), true, next.Err
+		}
+	}
+	*found = false
+	return Result[V]{}, false, nil
+}
@@ -0,0 +1,104 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestPlaceholderWritten(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache, stampede.WithPlaceholders("p1", time.Minute))
+
+	var during stampede.Item[int]
+	v, err := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		during, _ = cache.Get(ctx, "k")
+		return 1, time.Minute, nil
+	})
+	if err != nil || v != 1 {
+		t.Fatalf("Fetch = %v, %v", v, err)
+	}
+	if during.Pending == nil || during.Pending.Owner != "p1" || !during.Pending.Empty {
+		t.Errorf("item during recompute = %+v, want an empty placeholder of p1", during)
+	}
+	if item, err := cache.Get(ctx, "k"); err != nil || item.Pending != nil || item.Value != 1 {
+		t.Errorf("item after recompute = %+v, %v", item, err)
+	}
+}
+
+func TestPlaceholderRemoved(t *testing.T) {
+	tests := []struct {
+		name      string
+		recompute stampede.RecomputeFunc[int]
+	}{
+		{"Failed", failing},
+		{"DontCache", func(ctx context.Context) (int, time.Duration, error) {
+			return 2, time.Minute, stampede.ErrDontCache
+		}},
+		{"ZeroTTL", func(ctx context.Context) (int, time.Duration, error) {
+			return 2, 0, nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := memcache.New[string, int]()
+			xf := stampede.New[string, int](cache, stampede.WithPlaceholders("p1", time.Minute))
+
+			// without a previous value the placeholder is deleted
+			xf.Fetch(ctx, "new", tt.recompute)
+			if item, err := cache.Get(ctx, "new"); !errors.Is(err, stampede.ErrCacheMiss) {
+				t.Errorf("placeholder left: %+v, %v", item, err)
+			}
+
+			// with one, it is restored
+			prev := stampede.Item[int]{Value: 1, Expiry: time.Now().Add(-time.Second), Delta: time.Millisecond, Created: time.Now().Add(-time.Minute)}
+			cache.Set(ctx, "old", prev)
+			xf.Fetch(ctx, "old", tt.recompute)
+			item, err := cache.Get(ctx, "old")
+			if err != nil || item.Pending != nil || item.Value != 1 || !item.Created.Equal(prev.Created) {
+				t.Errorf("item after recompute = %+v, %v; want the previous one", item, err)
+			}
+		})
+	}
+}
+
+func TestPlaceholderOwnerFails(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	owner := stampede.New[string, int](cache, stampede.WithPlaceholders("p1", time.Minute))
+	waiter := stampede.New[string, int](cache, stampede.WithPlaceholders("p2", time.Minute), stampede.WithLockWait(time.Millisecond, time.Second))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go owner.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		close(started)
+		<-release
+		return 0, 0, errOrigin
+	})
+	<-started
+
+	// the waiter recomputes as soon as the failed owner's placeholder goes,
+	// not when it would be abandoned
+	fetched := make(chan int)
+	go func() {
+		v, _ := waiter.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+			return 2, time.Minute, nil
+		})
+		fetched <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case v := <-fetched:
+		if v != 2 {
+			t.Errorf("waiting Fetch = %d, want its own recompute", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting Fetch outlived the failed owner")
+	}
+}
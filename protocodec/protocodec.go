@@ -0,0 +1,157 @@
+// Package protocodec provides stampede codecs using Protocol Buffers
+package protocodec
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec is a stampede.Codec writing the item envelope as a protobuf message,
+// with the value serialized by a stampede.ValueCodec:
+//
+//	message Envelope {
+//	  uint64 flags = 1;
+//	  int64 expiry = 2;      // unix nanoseconds
+//	  int64 delta = 3;       // nanoseconds
+//	  int64 created = 4;     // unix nanoseconds
+//	  int64 until = 5;       // unix nanoseconds
+//	  string owner = 6;
+//	  int64 hard_expiry = 7; // unix nanoseconds
+//	  bytes value = 8;
+//	}
+//
+// Times of 0 are the zero time.  Flags are the stampede envelope flags, and
+// until and owner describe a placeholder.  Unknown fields are skipped, so
+// fields may be added.
+type Codec[V any] struct {
+	Value stampede.ValueCodec[V]
+}
+
+const (
+	fieldFlags protowire.Number = iota + 1
+	fieldExpiry
+	fieldDelta
+	fieldCreated
+	fieldUntil
+	fieldOwner
+	fieldHardExpiry
+	fieldValue
+)
+
+// ErrBadEnvelope is returned when decoding a malformed envelope
+var ErrBadEnvelope = errors.New("protocodec: malformed envelope")
+
+// Marshal implements stampede.Codec
+func (c Codec[V]) Marshal(item stampede.Item[V]) ([]byte, error) {
+	e, err := stampede.ToEnvelope(item, c.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	if e.Flags != 0 {
+		b = protowire.AppendTag(b, fieldFlags, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.Flags)
+	}
+	b = appendInt(b, fieldExpiry, unixNano(e.Expiry))
+	b = appendInt(b, fieldDelta, int64(e.Delta))
+	b = appendInt(b, fieldCreated, unixNano(e.Created))
+	b = appendInt(b, fieldUntil, unixNano(e.Until))
+	if e.Owner != "" {
+		b = protowire.AppendTag(b, fieldOwner, protowire.BytesType)
+		b = protowire.AppendString(b, e.Owner)
+	}
+	b = appendInt(b, fieldHardExpiry, unixNano(e.HardExpiry))
+	if len(e.Value) > 0 {
+		b = protowire.AppendTag(b, fieldValue, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.Value)
+	}
+	return b, nil
+}
+
+// appendInt appends the int64 field num, omitted if zero as in proto3
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// Unmarshal implements stampede.Codec
+func (c Codec[V]) Unmarshal(b []byte) (stampede.Item[V], error) {
+	e := stampede.Envelope{Version: stampede.EnvelopeVersion}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return stampede.Item[V]{}, ErrBadEnvelope
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.VarintType && num >= fieldFlags && num <= fieldHardExpiry && num != fieldOwner:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			switch num {
+			case fieldFlags:
+				e.Flags = v
+			case fieldExpiry:
+				e.Expiry = fromUnixNano(int64(v))
+			case fieldDelta:
+				e.Delta = time.Duration(v)
+			case fieldCreated:
+				e.Created = fromUnixNano(int64(v))
+			case fieldUntil:
+				e.Until = fromUnixNano(int64(v))
+			case fieldHardExpiry:
+				e.HardExpiry = fromUnixNano(int64(v))
+			}
+		case typ == protowire.BytesType && num == fieldOwner:
+			e.Owner, n = protowire.ConsumeString(b)
+		case typ == protowire.BytesType && num == fieldValue:
+			e.Value, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return stampede.Item[V]{}, ErrBadEnvelope
+		}
+		b = b[n:]
+	}
+	return stampede.FromEnvelope(e, c.Value)
+}
+
+// ValueCodec is a stampede.ValueCodec for protobuf message values, such as
+// *pb.User
+type ValueCodec[M proto.Message] struct{}
+
+// MarshalValue implements stampede.ValueCodec
+func (ValueCodec[M]) MarshalValue(m M) ([]byte, error) { return proto.Marshal(m) }
+
+// UnmarshalValue implements stampede.ValueCodec
+func (ValueCodec[M]) UnmarshalValue(b []byte) (M, error) {
+	var zero M
+	m := zero.ProtoReflect().New().Interface().(M)
+	if err := proto.Unmarshal(b, m); err != nil {
+		return zero, err
+	}
+	return m, nil
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
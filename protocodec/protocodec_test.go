@@ -0,0 +1,29 @@
+package protocodec_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/protocodec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	cachetest.TestCodec(t, protocodec.Codec[string]{Value: stampede.JSONValueCodec[string]{}})
+}
+
+func TestValueCodec(t *testing.T) {
+	c := protocodec.Codec[*wrapperspb.StringValue]{Value: protocodec.ValueCodec[*wrapperspb.StringValue]{}}
+	want := stampede.Item[*wrapperspb.StringValue]{Value: wrapperspb.String("value"), Expiry: time.Unix(100, 0), Delta: time.Second}
+	b, err := c.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal = %v", err)
+	}
+	got, err := c.Unmarshal(b)
+	if err != nil || !proto.Equal(got.Value, want.Value) || !got.Expiry.Equal(want.Expiry) || got.Delta != want.Delta {
+		t.Fatalf("Unmarshal = %+v, %v; want %+v", got, err, want)
+	}
+}
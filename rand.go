@@ -0,0 +1,27 @@
+package stampede
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// AlwaysExpire is a random source for WithRand which makes every cached item
+// expire early, so every fetch recomputes.  It is intended for unit tests.
+func AlwaysExpire() float64 { return 0 }
+
+// NeverExpire is a random source for WithRand which disables early expiration,
+// so items are recomputed only once they have expired.  It is intended for
+// unit tests.
+func NeverExpire() float64 { return 1 }
+
+// lockedRand makes a rand.Source safe for concurrent use
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
@@ -0,0 +1,84 @@
+package stampede
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimit is the configuration of a rate limiter, with the group function
+// stored untyped until New
+type rateLimit struct {
+	rate   float64
+	burst  int
+	policy LimitPolicy
+	group  any
+}
+
+// rateLimiter holds one token bucket per key group
+type rateLimiter[K comparable] struct {
+	rate   float64
+	burst  float64
+	policy LimitPolicy
+	group  func(key K) string
+
+	mu sync.Mutex
+	m  map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait takes a token for a recompute of key, handling an empty bucket per
+// the limiter's policy
+func (l *rateLimiter[K]) wait(ctx context.Context, key K, clock Clock) error {
+	d, ok := l.take(key, clock.Now())
+	if !ok {
+		return limitFailed(l.policy, ErrRateLimited)
+	}
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take removes a token from the bucket for key at now.  If the bucket is
+// empty and the policy is LimitWait, the token is reserved and d is how long
+// to wait for it; otherwise ok is false.
+func (l *rateLimiter[K]) take(key K, now time.Time) (d time.Duration, ok bool) {
+	name := ""
+	if l.group != nil {
+		name = l.group(key)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.m[name]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.m[name] = b
+	}
+	if now.After(b.last) {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if l.policy != LimitWait {
+		return 0, false
+	}
+	d = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	b.tokens--
+	return d, true
+}
@@ -0,0 +1,48 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bus is a stampede.InvalidationBus using Redis pub/sub
+type Bus struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewBus returns a Bus publishing keys on channel
+func NewBus(client redis.UniversalClient, channel string) *Bus {
+	return &Bus{client: client, channel: channel}
+}
+
+// Publish implements stampede.InvalidationBus
+func (b *Bus) Publish(ctx context.Context, key string) error {
+	return b.client.Publish(ctx, b.channel, key).Err()
+}
+
+// Subscribe implements stampede.InvalidationBus.  Keys published while the
+// connection is being re-established are lost.
+func (b *Bus) Subscribe(ctx context.Context, fn func(key string)) error {
+	ps := b.client.Subscribe(ctx, b.channel)
+	defer ps.Close()
+
+	if _, err := ps.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return errors.New("rediscache: subscription closed")
+			}
+			fn(msg.Payload)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package rediscache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// unlockScript deletes the lock only if it is still held with our token
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// extendScript resets the lock's expiry only if it is still held with our token
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Locker is a stampede.Locker using Redis SET NX PX, with token-checked
+// release so an expired holder cannot unlock its successor.
+type Locker struct {
+	client redis.UniversalClient
+	lockerConfig
+
+	mu      sync.Mutex
+	extends map[string]chan struct{}
+}
+
+// A LockerOption configures a Locker
+type LockerOption func(*lockerConfig)
+
+type lockerConfig struct {
+	prefix   string
+	interval time.Duration
+}
+
+// WithLockPrefix prepends prefix to every lock key.  The default is "lock:".
+func WithLockPrefix(prefix string) LockerOption {
+	return func(c *lockerConfig) { c.prefix = prefix }
+}
+
+// WithAutoExtend extends each held lock by its TTL every interval until it
+// is released, so recomputes slower than the lock TTL keep their lock.
+// interval should be well under the TTL.
+func WithAutoExtend(interval time.Duration) LockerOption {
+	return func(c *lockerConfig) { c.interval = interval }
+}
+
+// NewLocker returns a Locker using client
+func NewLocker(client redis.UniversalClient, opts ...LockerOption) *Locker {
+	l := &Locker{
+		client:       client,
+		lockerConfig: lockerConfig{prefix: "lock:"},
+		extends:      make(map[string]chan struct{}),
+	}
+	for _, o := range opts {
+		o(&l.lockerConfig)
+	}
+	return l
+}
+
+// TryLock implements stampede.Locker
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", false, err
+	}
+	ok, err := l.client.SetNX(ctx, l.prefix+key, token, ttl).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if l.interval > 0 {
+		l.autoExtend(key, token, ttl)
+	}
+	return token, true, nil
+}
+
+// Unlock implements stampede.Locker
+func (l *Locker) Unlock(ctx context.Context, key string, token string) error {
+	l.mu.Lock()
+	if stop, ok := l.extends[token]; ok {
+		close(stop)
+		delete(l.extends, token)
+	}
+	l.mu.Unlock()
+	return unlockScript.Run(ctx, l.client, []string{l.prefix + key}, token).Err()
+}
+
+// Extend resets the expiry of the lock for key to ttl, reporting whether it
+// was still held with token
+func (l *Locker) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	n, err := extendScript.Run(ctx, l.client, []string{l.prefix + key}, token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (l *Locker) autoExtend(key, token string, ttl time.Duration) {
+	stop := make(chan struct{})
+	l.mu.Lock()
+	l.extends[token] = stop
+	l.mu.Unlock()
+
+	go func() {
+		t := time.NewTicker(l.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				ok, err := l.Extend(context.Background(), key, token, ttl)
+				if err == nil && !ok {
+					// lost the lock; nothing left to extend
+					return
+				}
+			}
+		}
+	}()
+}
+
+func newToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
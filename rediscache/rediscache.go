@@ -0,0 +1,390 @@
+// Package rediscache is a stampede.Cache backed by Redis
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores items in Redis, encoded with a stampede.Codec
+type Cache[V any] struct {
+	client redis.UniversalClient
+	codec  stampede.Codec[V]
+	config
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	prefix    string
+	nativeTTL bool
+	grace     time.Duration
+	replica   redis.UniversalClient
+	maxLag    time.Duration
+}
+
+// WithPrefix prepends prefix to every key
+func WithPrefix(prefix string) Option {
+	return func(c *config) { c.prefix = prefix }
+}
+
+// WithNativeTTL sets a Redis expiration on each key, grace after the item
+// expires, so Redis evicts dead entries.  The grace period keeps expired
+// items available for stale serving.  By default keys do not expire.
+func WithNativeTTL(grace time.Duration) Option {
+	return func(c *config) {
+		c.nativeTTL = true
+		c.grace = grace
+	}
+}
+
+// WithReplicaReads reads keys from replica, a client of Redis replicas, and
+// writes them to the client passed to New, taking read load off the primary.
+// Replicas lag the primary, so a key missing on the replica, or whose item
+// expires within maxLag, is read again from the primary, which may already
+// hold its recomputed value.  A larger maxLag tolerates less staleness, at
+// the cost of more primary reads.  Failed replica reads fall back to the
+// primary.  A cluster client can instead route reads to replicas itself,
+// with ClusterOptions.ReadOnly.
+func WithReplicaReads(replica redis.UniversalClient, maxLag time.Duration) Option {
+	return func(c *config) {
+		c.replica = replica
+		c.maxLag = maxLag
+	}
+}
+
+// New returns a Cache using client, encoding items with codec
+func New[V any](client redis.UniversalClient, codec stampede.Codec[V], opts ...Option) *Cache[V] {
+	c := &Cache[V]{client: client, codec: codec}
+	for _, o := range opts {
+		o(&c.config)
+	}
+	return c
+}
+
+// Get implements stampede.Cache.  With WithReplicaReads, the replica's item
+// is returned if the primary cannot be read.
+func (c *Cache[V]) Get(ctx context.Context, key string) (stampede.Item[V], error) {
+	if c.replica == nil {
+		return c.get(ctx, c.client, key)
+	}
+	item, err := c.get(ctx, c.replica, key)
+	if err == nil && !c.lagging(item) {
+		return item, nil
+	}
+	primary, perr := c.get(ctx, c.client, key)
+	if perr != nil && !errors.Is(perr, stampede.ErrCacheMiss) && err == nil {
+		return item, nil
+	}
+	return primary, perr
+}
+
+// lagging reports whether item, read from a replica, should be read again
+// from the primary
+func (c *Cache[V]) lagging(item stampede.Item[V]) bool {
+	return !item.Expiry.IsZero() && !item.Expiry.After(time.Now().Add(c.maxLag))
+}
+
+// get reads key with client
+func (c *Cache[V]) get(ctx context.Context, client redis.UniversalClient, key string) (stampede.Item[V], error) {
+	b, err := client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	if err != nil {
+		return stampede.Item[V]{}, err
+	}
+	return c.codec.Unmarshal(b)
+}
+
+// Set implements stampede.Cache
+func (c *Cache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.prefix+key, b, c.expiration(item)).Err()
+}
+
+// watchAttempts bounds the optimistic transaction retries of SetIfNewer
+const watchAttempts = 3
+
+// errNotNewer aborts a SetIfNewer transaction
+var errNotNewer = errors.New("rediscache: stored item is newer")
+
+// SetIfNewer implements stampede.ConditionalSetter with an optimistic
+// transaction.  It fails with redis.TxFailedErr if the key keeps changing
+// under it.
+func (c *Cache[V]) SetIfNewer(ctx context.Context, key string, item stampede.Item[V]) (bool, error) {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+	k := c.prefix + key
+	txf := func(tx *redis.Tx) error {
+		old, err := tx.Get(ctx, k).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return err
+		default:
+			// an undecodable entry is always replaced
+			if old, err := c.codec.Unmarshal(old); err == nil && !item.Supersedes(old) {
+				return errNotNewer
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, k, b, c.expiration(item))
+			return nil
+		})
+		return err
+	}
+
+	for range watchAttempts {
+		err = c.client.Watch(ctx, txf, k)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if errors.Is(err, errNotNewer) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, err
+}
+
+// errClaimed aborts a ClaimLease transaction
+var errClaimed = errors.New("rediscache: key claimed elsewhere")
+
+// ClaimLease implements stampede.LeaseClaimer with an optimistic
+// transaction.  It fails with redis.TxFailedErr if the key keeps changing
+// under it.
+func (c *Cache[V]) ClaimLease(ctx context.Context, key string, prev *stampede.Item[V], lease stampede.Item[V]) (bool, error) {
+	b, err := c.codec.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	k := c.prefix + key
+	txf := func(tx *redis.Tx) error {
+		old, err := tx.Get(ctx, k).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+		case err != nil:
+			return err
+		default:
+			// an undecodable entry is always replaced
+			if old, err := c.codec.Unmarshal(old); err == nil && !old.Claimable(prev, time.Now()) {
+				return errClaimed
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, k, b, c.expiration(lease))
+			return nil
+		})
+		return err
+	}
+
+	for range watchAttempts {
+		err = c.client.Watch(ctx, txf, k)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if errors.Is(err, errClaimed) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, err
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, c.prefix+key).Err()
+}
+
+// GetMulti implements stampede.BatchGetter with a single MGET.  With a
+// cluster client, the keys are grouped by hash slot and read with one MGET
+// per slot, pipelined, as MGET cannot span slots.  With a ring client, which
+// cannot route MGET, each key is read with a pipelined GET.  With
+// WithReplicaReads, the keys the replica lacks or lags on are then read from
+// the primary together.
+func (c *Cache[V]) GetMulti(ctx context.Context, keys []string) (map[string]stampede.Item[V], error) {
+	items := make(map[string]stampede.Item[V], len(keys))
+	if c.replica == nil {
+		return items, c.getMulti(ctx, c.client, keys, items)
+	}
+
+	// a failed replica read leaves every key to the primary
+	_ = c.getMulti(ctx, c.replica, keys, items)
+	var rest []string
+	for _, key := range keys {
+		if item, ok := items[key]; !ok || c.lagging(item) {
+			rest = append(rest, key)
+		}
+	}
+
+	got := make(map[string]stampede.Item[V], len(rest))
+	if err := c.getMulti(ctx, c.client, rest, got); err != nil {
+		return items, err
+	}
+	for _, key := range rest {
+		if item, ok := got[key]; ok {
+			items[key] = item
+		} else {
+			delete(items, key)
+		}
+	}
+	return items, nil
+}
+
+// getMulti reads keys with client into items
+func (c *Cache[V]) getMulti(ctx context.Context, client redis.UniversalClient, keys []string, items map[string]stampede.Item[V]) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	switch client.(type) {
+	case *redis.ClusterClient:
+		return c.getSlots(ctx, client, keys, items)
+	case *redis.Ring:
+		return c.getEach(ctx, client, keys, items)
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	vals, err := client.MGet(ctx, prefixed...).Result()
+	if err != nil {
+		return err
+	}
+	return c.decode(keys, vals, items)
+}
+
+// getSlots reads keys into items with a pipeline of an MGET per hash slot
+func (c *Cache[V]) getSlots(ctx context.Context, client redis.UniversalClient, keys []string, items map[string]stampede.Item[V]) error {
+	groups := make(map[int][]string)
+	for _, key := range keys {
+		s := slot(c.prefix + key)
+		groups[s] = append(groups[s], key)
+	}
+
+	type read struct {
+		keys []string
+		cmd  *redis.SliceCmd
+	}
+	reads := make([]read, 0, len(groups))
+	_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, keys := range groups {
+			prefixed := make([]string, len(keys))
+			for i, key := range keys {
+				prefixed[i] = c.prefix + key
+			}
+			reads = append(reads, read{keys, p.MGet(ctx, prefixed...)})
+		}
+		return nil
+	})
+
+	// the slots which were read are returned even if others failed
+	for _, r := range reads {
+		if vals, rerr := r.cmd.Result(); rerr == nil {
+			if derr := c.decode(r.keys, vals, items); derr != nil {
+				return derr
+			}
+		}
+	}
+	return err
+}
+
+// getEach reads keys into items with a pipeline of GETs
+func (c *Cache[V]) getEach(ctx context.Context, client redis.UniversalClient, keys []string, items map[string]stampede.Item[V]) error {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = p.Get(ctx, c.prefix+key)
+		}
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		// misses are not failures
+		err = nil
+	}
+
+	for i, cmd := range cmds {
+		b, rerr := cmd.Bytes()
+		if rerr != nil {
+			if !errors.Is(rerr, redis.Nil) && err == nil {
+				err = rerr
+			}
+			continue
+		}
+		item, derr := c.codec.Unmarshal(b)
+		if derr != nil {
+			return derr
+		}
+		items[keys[i]] = item
+	}
+	return err
+}
+
+// decode adds the items in the MGET result vals for keys to items
+func (c *Cache[V]) decode(keys []string, vals []any, items map[string]stampede.Item[V]) error {
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		item, err := c.codec.Unmarshal([]byte(s))
+		if err != nil {
+			return err
+		}
+		items[keys[i]] = item
+	}
+	return nil
+}
+
+// SetMulti implements stampede.BatchSetter with a pipeline of SETs.  A
+// cluster client splits the pipeline by node.
+func (c *Cache[V]) SetMulti(ctx context.Context, items map[string]stampede.Item[V]) error {
+	type set struct {
+		key string
+		b   []byte
+		exp time.Duration
+	}
+	sets := make([]set, 0, len(items))
+	for key, item := range items {
+		b, err := c.codec.Marshal(item)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, set{c.prefix + key, b, c.expiration(item)})
+	}
+
+	_, err := c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, s := range sets {
+			p.Set(ctx, s.key, s.b, s.exp)
+		}
+		return nil
+	})
+	return err
+}
+
+// expiration returns the Redis expiration for item, or zero for none
+func (c *Cache[V]) expiration(item stampede.Item[V]) time.Duration {
+	if !c.nativeTTL {
+		return 0
+	}
+	if !item.HardExpiry.IsZero() {
+		// the item is useless past its hard expiry
+		return max(time.Until(item.HardExpiry), time.Millisecond)
+	}
+	if item.Expiry.IsZero() {
+		// never expires
+		return 0
+	}
+	return max(time.Until(item.Expiry)+c.grace, time.Millisecond)
+}
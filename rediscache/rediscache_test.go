@@ -0,0 +1,182 @@
+package rediscache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/rediscache"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedis returns a client of an in-process Redis server, stopped when the
+// test ends
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestCache(t *testing.T) {
+	_, client := newRedis(t)
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return rediscache.New(client, stampede.JSONCodec[string]{})
+	})
+}
+
+func TestPrefix(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	c := rediscache.New(client, stampede.JSONCodec[string]{}, rediscache.WithPrefix("app:"))
+	c.Set(ctx, "k", stampede.Item[string]{Value: "v"})
+	if !mr.Exists("app:k") {
+		t.Errorf("keys %v, want app:k", mr.Keys())
+	}
+}
+
+func TestNativeTTL(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	c := rediscache.New(client, stampede.JSONCodec[string]{}, rediscache.WithNativeTTL(time.Minute))
+	now := time.Now()
+	c.Set(ctx, "soft", stampede.Item[string]{Value: "v", Expiry: now.Add(time.Hour)})
+	c.Set(ctx, "hard", stampede.Item[string]{Value: "v", Expiry: now.Add(time.Hour), HardExpiry: now.Add(2 * time.Hour)})
+	c.Set(ctx, "forever", stampede.Item[string]{Value: "v"})
+
+	for key, want := range map[string]time.Duration{"soft": time.Hour + time.Minute, "hard": 2 * time.Hour, "forever": 0} {
+		if got := mr.TTL(key); got < want-time.Second || got > want {
+			t.Errorf("TTL(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	mr.FastForward(time.Hour + 2*time.Minute)
+	if _, err := c.Get(ctx, "soft"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("Get past the grace period = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestReplicaReads(t *testing.T) {
+	ctx := context.Background()
+	_, primary := newRedis(t)
+	replicaServer, replica := newRedis(t)
+	codec := stampede.JSONCodec[string]{}
+	c := rediscache.New(primary, codec, rediscache.WithReplicaReads(replica, time.Minute))
+	replicaCache := rediscache.New(replica, codec)
+
+	// simulate replication lag by writing each side separately
+	now := time.Now()
+	replicaCache.Set(ctx, "fresh", stampede.Item[string]{Value: "replica", Expiry: now.Add(time.Hour)})
+	c.Set(ctx, "fresh", stampede.Item[string]{Value: "primary", Expiry: now.Add(time.Hour)})
+	replicaCache.Set(ctx, "expiring", stampede.Item[string]{Value: "replica", Expiry: now.Add(time.Second)})
+	c.Set(ctx, "expiring", stampede.Item[string]{Value: "primary", Expiry: now.Add(time.Hour)})
+	c.Set(ctx, "unreplicated", stampede.Item[string]{Value: "primary"})
+
+	for key, want := range map[string]string{"fresh": "replica", "expiring": "primary", "unreplicated": "primary"} {
+		if item, err := c.Get(ctx, key); err != nil || item.Value != want {
+			t.Errorf("Get(%q) = %+v, %v; want the %s's", key, item, err, want)
+		}
+	}
+
+	// a failed replica read falls back to the primary
+	replicaServer.Close()
+	if item, err := c.Get(ctx, "fresh"); err != nil || item.Value != "primary" {
+		t.Errorf("Get with replica down = %+v, %v; want the primary's", item, err)
+	}
+}
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := rediscache.NewLocker(client)
+
+	token, ok, err := l.TryLock(ctx, "k", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	if !mr.Exists("lock:k") {
+		t.Errorf("keys %v, want lock:k", mr.Keys())
+	}
+	if _, ok, err := l.TryLock(ctx, "k", time.Minute); err != nil || ok {
+		t.Fatalf("second TryLock = %v, %v; want refused", ok, err)
+	}
+
+	// only the holder's token unlocks
+	l.Unlock(ctx, "k", "not the token")
+	if !mr.Exists("lock:k") {
+		t.Fatal("lock released with the wrong token")
+	}
+	if ok, err := l.Extend(ctx, "k", token, time.Hour); err != nil || !ok || mr.TTL("lock:k") != time.Hour {
+		t.Fatalf("Extend = %v, %v with TTL %v", ok, err, mr.TTL("lock:k"))
+	}
+	if err := l.Unlock(ctx, "k", token); err != nil || mr.Exists("lock:k") {
+		t.Fatalf("Unlock = %v, lock held %v", err, mr.Exists("lock:k"))
+	}
+	if ok, _ := l.Extend(ctx, "k", token, time.Hour); ok {
+		t.Error("Extend of a released lock succeeded")
+	}
+
+	// the lock expires with its TTL
+	l.TryLock(ctx, "k", time.Second)
+	mr.FastForward(time.Second)
+	if _, ok, _ := l.TryLock(ctx, "k", time.Second); !ok {
+		t.Error("TryLock after the lock expired was refused")
+	}
+}
+
+func TestLockerAutoExtend(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newRedis(t)
+	l := rediscache.NewLocker(client, rediscache.WithLockPrefix("l/"), rediscache.WithAutoExtend(5*time.Millisecond))
+
+	token, ok, err := l.TryLock(ctx, "k", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	mr.SetTTL("l/k", time.Second)
+	deadline := time.Now().Add(time.Second)
+	for mr.TTL("l/k") != time.Minute {
+		if time.Now().After(deadline) {
+			t.Fatalf("lock TTL %v not extended", mr.TTL("l/k"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.Unlock(ctx, "k", token)
+}
+
+func TestBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, client := newRedis(t)
+	b := rediscache.NewBus(client, "invalidations")
+
+	keys := make(chan string, 1)
+	done := make(chan error)
+	go func() { done <- b.Subscribe(ctx, func(key string) { keys <- key }) }()
+
+	// publish until the subscription is established
+	deadline := time.Now().Add(time.Second)
+	for got := false; !got; {
+		if time.Now().After(deadline) {
+			t.Fatal("published key not received")
+		}
+		b.Publish(ctx, "k")
+		select {
+		case key := <-keys:
+			if key != "k" {
+				t.Fatalf("received %q, want k", key)
+			}
+			got = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Subscribe = %v, want context.Canceled", err)
+	}
+}
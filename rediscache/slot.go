@@ -0,0 +1,33 @@
+package rediscache
+
+import "strings"
+
+// slots is the number of Redis Cluster hash slots
+const slots = 16384
+
+// slot returns the Redis Cluster hash slot of key, hashing only its hash
+// tag, the part between the first { and the following }, if non-empty
+func slot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key)) % slots
+}
+
+// crc16 is the CRC-16/XMODEM checksum used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,23 @@
+package rediscache
+
+import "testing"
+
+func TestSlot(t *testing.T) {
+	for _, tt := range []struct {
+		key  string
+		slot int
+	}{
+		// from the Redis Cluster specification
+		{"123456789", 0x31c3},
+		{"foo", 12182},
+		{"{user1000}.following", slot("user1000")},
+		{"{user1000}.followers", slot("user1000")},
+		{"foo{}{bar}", slot("foo{}{bar}")},
+		{"foo{{bar}}zap", slot("{bar")},
+		{"foo{bar}{zap}", slot("bar")},
+	} {
+		if got := slot(tt.key); got != tt.slot {
+			t.Errorf("slot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package stampede
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Refresher proactively refreshes registered keys shortly before they expire,
+// using a pool of workers, so that fetches of those keys rarely pay the
+// recompute latency.
+type Refresher[K comparable, V any] struct {
+	xf *XFetcher[K, V]
+	refresherConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	keys    map[K]*registration[K, V]
+	pending refreshHeap[K, V]
+	closed  bool
+
+	wake  chan struct{}
+	queue chan *registration[K, V]
+	wg    sync.WaitGroup
+}
+
+type registration[K comparable, V any] struct {
+	key       K
+	recompute RecomputeFunc[V]
+	interval  time.Duration
+
+	due   time.Time
+	index int // in pending, or -1
+}
+
+// A RefresherOption configures a Refresher
+type RefresherOption func(*refresherConfig)
+
+type refresherConfig struct {
+	workers   int
+	queueSize int
+	lead      time.Duration
+	retry     time.Duration
+}
+
+// WithWorkers sets the number of concurrent refreshes.  The default is 4.
+func WithWorkers(n int) RefresherOption {
+	return func(c *refresherConfig) { c.workers = n }
+}
+
+// WithQueueSize sets how many due refreshes may wait for a worker.  Refreshes
+// which do not fit are retried later.  The default is 64.
+func WithQueueSize(n int) RefresherOption {
+	return func(c *refresherConfig) { c.queueSize = n }
+}
+
+// WithRefreshLead sets how long before expiry keys without a fixed interval
+// are refreshed, scaled by the key's cost set with WithCostFunc.  The default
+// is 10 seconds.
+func WithRefreshLead(d time.Duration) RefresherOption {
+	return func(c *refresherConfig) { c.lead = d }
+}
+
+// WithRefreshRetry sets the delay before retrying a refresh which failed or
+// did not fit in the queue.  The default is one second.
+func WithRefreshRetry(d time.Duration) RefresherOption {
+	return func(c *refresherConfig) { c.retry = d }
+}
+
+// NewRefresher starts a Refresher for keys fetched through xf.  Close, or
+// closing xf, stops it.
+func NewRefresher[K comparable, V any](xf *XFetcher[K, V], opts ...RefresherOption) *Refresher[K, V] {
+	cfg := refresherConfig{
+		workers:   4,
+		queueSize: 64,
+		lead:      10 * time.Second,
+		retry:     time.Second,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	r := &Refresher[K, V]{
+		xf:              xf,
+		refresherConfig: cfg,
+		keys:            make(map[K]*registration[K, V]),
+		wake:            make(chan struct{}, 1),
+		queue:           make(chan *registration[K, V], cfg.queueSize),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	r.wg.Add(1 + cfg.workers)
+	go r.schedule()
+	for i := 0; i < cfg.workers; i++ {
+		go r.work()
+	}
+	xf.onClose(func(context.Context) error {
+		r.Close()
+		return nil
+	})
+	return r
+}
+
+// Register schedules key to be refreshed with recompute, which replaces any
+// previous registration.  The first refresh happens immediately.  If interval
+// is zero, the key is refreshed shortly before each expiry; otherwise it is
+// refreshed every interval.
+func (r *Refresher[K, V]) Register(key K, recompute RecomputeFunc[V], interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	if reg, ok := r.keys[key]; ok {
+		reg.recompute, reg.interval = recompute, interval
+		return
+	}
+
+	reg := &registration[K, V]{key: key, recompute: recompute, interval: interval, due: r.xf.clock.Now(), index: -1}
+	r.keys[key] = reg
+	heap.Push(&r.pending, reg)
+	r.poke()
+}
+
+// Unregister stops refreshing key
+func (r *Refresher[K, V]) Unregister(key K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reg, ok := r.keys[key]
+	if !ok {
+		return
+	}
+	delete(r.keys, key)
+	if reg.index >= 0 {
+		heap.Remove(&r.pending, reg.index)
+	}
+}
+
+// Close stops the Refresher, cancelling in-flight refreshes and waiting for
+// its goroutines to exit
+func (r *Refresher[K, V]) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	r.cancel()
+	r.wg.Wait()
+}
+
+// poke wakes the scheduler.  r.mu must be held.
+func (r *Refresher[K, V]) poke() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule moves due registrations to the work queue
+func (r *Refresher[K, V]) schedule() {
+	defer r.wg.Done()
+	defer close(r.queue)
+
+	for {
+		r.mu.Lock()
+		now := r.xf.clock.Now()
+		for r.pending.Len() > 0 && !r.pending[0].due.After(now) {
+			reg := r.pending[0]
+			select {
+			case r.queue <- reg:
+				heap.Pop(&r.pending)
+			default:
+				// queue full: try again later
+				reg.due = now.Add(r.retry)
+				heap.Fix(&r.pending, 0)
+			}
+		}
+		var next <-chan time.Time
+		if r.pending.Len() > 0 {
+			next = r.xf.clock.After(r.pending[0].due.Sub(now))
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-next:
+		case <-r.wake:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// work refreshes queued registrations
+func (r *Refresher[K, V]) work() {
+	defer r.wg.Done()
+
+	for reg := range r.queue {
+		res, err := r.xf.FetchItem(r.ctx, reg.key, reg.recompute, WithBypassCache())
+		r.reschedule(reg, res, err)
+	}
+}
+
+// reschedule sets the next refresh of reg after a refresh returning res and err
+func (r *Refresher[K, V]) reschedule(reg *registration[K, V], res Result[V], err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || r.keys[reg.key] != reg {
+		return
+	}
+
+	now := r.xf.clock.Now()
+	switch {
+	case err != nil:
+		reg.due = now.Add(r.retry)
+	case reg.interval > 0:
+		reg.due = now.Add(reg.interval)
+	case res.Expiry.IsZero():
+		// never expires, so there is nothing to refresh ahead of
+		return
+	default:
+		// expensive keys are refreshed further ahead
+		lead := time.Duration(float64(r.lead) * r.xf.costFor(reg.key))
+		reg.due = res.Expiry.Add(-lead)
+		if reg.due.Before(now.Add(r.retry)) {
+			reg.due = now.Add(r.retry)
+		}
+	}
+	heap.Push(&r.pending, reg)
+	r.poke()
+}
+
+// refreshHeap orders registrations by due time
+type refreshHeap[K comparable, V any] []*registration[K, V]
+
+func (h refreshHeap[K, V]) Len() int           { return len(h) }
+func (h refreshHeap[K, V]) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h refreshHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *refreshHeap[K, V]) Push(x any) {
+	reg := x.(*registration[K, V])
+	reg.index = len(*h)
+	*h = append(*h, reg)
+}
+
+func (h *refreshHeap[K, V]) Pop() any {
+	old := *h
+	reg := old[len(old)-1]
+	old[len(old)-1] = nil
+	reg.index = -1
+	*h = old[:len(old)-1]
+	return reg
+}
@@ -0,0 +1,114 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// settled waits until calls reaches n and the refresher is sleeping
+func settled(t *testing.T, clock *fakeclock.Clock, calls *atomic.Int32, n int32) {
+	t.Helper()
+	waitFor(t, func() bool { return calls.Load() == n && clock.Waiters() == 1 })
+}
+
+func TestRefresher(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	mc := memcache.New[string, int]()
+	xf := stampede.New[string, int](mc, stampede.WithClock(clock))
+	r := stampede.NewRefresher(xf, stampede.WithRefreshLead(10*time.Second))
+	defer r.Close()
+
+	// refreshed at once, then the lead before each expiry
+	var calls atomic.Int32
+	r.Register("k", counting(&calls, time.Minute), 0)
+	settled(t, clock, &calls, 1)
+	if item, err := mc.Get(ctx, "k"); err != nil || item.Value != 1 {
+		t.Fatalf("cache after the first refresh = %+v, %v", item, err)
+	}
+	clock.Advance(49 * time.Second)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d refreshes ahead of the lead, want 1", n)
+	}
+	clock.Advance(time.Second)
+	settled(t, clock, &calls, 2)
+
+	// a fetch is served the refreshed value
+	if v, _ := xf.Fetch(ctx, "k", counting(&calls, time.Minute)); v != 2 {
+		t.Errorf("Fetch = %d, want the refreshed 2", v)
+	}
+
+	r.Unregister("k")
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d refreshes after Unregister, want 2", n)
+	}
+}
+
+func TestRefresherInterval(t *testing.T) {
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithClock(clock))
+	r := stampede.NewRefresher(xf)
+	defer r.Close()
+
+	var calls atomic.Int32
+	r.Register("k", counting(&calls, time.Hour), 5*time.Second)
+	settled(t, clock, &calls, 1)
+	for n := int32(2); n <= 4; n++ {
+		clock.Advance(5 * time.Second)
+		settled(t, clock, &calls, n)
+	}
+}
+
+func TestRefresherRetry(t *testing.T) {
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithClock(clock))
+	r := stampede.NewRefresher(xf, stampede.WithRefreshRetry(2*time.Second))
+	defer r.Close()
+
+	var calls atomic.Int32
+	r.Register("k", func(ctx context.Context) (int, time.Duration, error) {
+		calls.Add(1)
+		return 0, 0, errors.New("boom")
+	}, time.Hour)
+	settled(t, clock, &calls, 1)
+	clock.Advance(2 * time.Second)
+	settled(t, clock, &calls, 2)
+}
+
+func TestRefresherClose(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithClock(clock))
+	r := stampede.NewRefresher(xf)
+
+	// closing the fetcher stops its refreshers, cancelling in-flight
+	// refreshes
+	started := make(chan struct{})
+	r.Register("k", func(ctx context.Context) (int, time.Duration, error) {
+		close(started)
+		<-ctx.Done()
+		return 0, 0, ctx.Err()
+	}, 0)
+	<-started
+	if err := xf.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+
+	// a closed refresher ignores registrations
+	var calls atomic.Int32
+	r.Register("j", counting(&calls, time.Minute), 0)
+	r.Close()
+	time.Sleep(10 * time.Millisecond)
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d refreshes after Close", n)
+	}
+}
@@ -0,0 +1,81 @@
+package stampede
+
+import "time"
+
+// Source is where a fetched value was served from
+type Source int
+
+const (
+	// SourceCache means the value was read from the cache
+	SourceCache Source = iota
+
+	// SourceRecompute means the value was freshly recomputed
+	SourceRecompute
+
+	// SourceStale means an expired value was served because recompute
+	// failed
+	SourceStale
+
+	// SourceFallback means the WithFallback value was served because there
+	// was nothing else
+	SourceFallback
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceCache:
+		return "cache"
+	case SourceRecompute:
+		return "recompute"
+	case SourceStale:
+		return "stale"
+	case SourceFallback:
+		return "fallback"
+	}
+	return "unknown"
+}
+
+// Result is a fetched value with its metadata
+type Result[V any] struct {
+	Value      V
+	Expiry     time.Time
+	HardExpiry time.Time
+	Delta      time.Duration
+	Created    time.Time
+
+	// Age is how long ago the value was computed, as of the fetch.  It is
+	// zero if the creation time is unknown.
+	Age time.Duration
+
+	// TTL is the time left before Expiry, as of the fetch.  It is negative
+	// for stale values, and zero for values which do not expire.
+	TTL time.Duration
+
+	Source Source
+}
+
+// ttlLeft returns the time left before item expires, or zero if it does not
+func ttlLeft[V any](item Item[V], now time.Time) time.Duration {
+	if item.Expiry.IsZero() {
+		return 0
+	}
+	return item.Expiry.Sub(now)
+}
+
+// result builds the Result for item served from source
+func (xf *XFetcher[K, V]) result(item Item[V], source Source) Result[V] {
+	r := Result[V]{
+		Value:      item.Value,
+		Expiry:     item.Expiry,
+		HardExpiry: item.HardExpiry,
+		Delta:      item.Delta,
+		Created:    item.Created,
+		Source:     source,
+	}
+	now := xf.clock.Now()
+	if !item.Created.IsZero() {
+		r.Age = max(now.Sub(item.Created), 0)
+	}
+	r.TTL = ttlLeft(item, now)
+	return r
+}
@@ -0,0 +1,24 @@
+package stampede_test
+
+import (
+	"testing"
+
+	"github.com/dgryski/go-stampede"
+)
+
+func TestSourceString(t *testing.T) {
+	for _, tt := range []struct {
+		s    stampede.Source
+		want string
+	}{
+		{stampede.SourceCache, "cache"},
+		{stampede.SourceRecompute, "recompute"},
+		{stampede.SourceStale, "stale"},
+		{stampede.SourceFallback, "fallback"},
+		{stampede.Source(-1), "unknown"},
+	} {
+		if got := tt.s.String(); got != tt.want {
+			t.Errorf("Source(%d).String() = %q, want %q", int(tt.s), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy configures retries of failed recomputes
+type RetryPolicy struct {
+	// Attempts is the maximum number of calls to recompute, including the
+	// first
+	Attempts int
+
+	// Backoff is the delay before the first retry, doubled after each
+	// subsequent one up to MaxBackoff, if set
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of each delay which is randomized, in [0,1]
+	Jitter float64
+
+	// Retryable reports whether err is worth retrying.  Nil means every
+	// error is.
+	Retryable func(err error) bool
+}
+
+// retry calls recompute under the WithRetry policy, returning also the time
+// taken by the last attempt.  It gives up early if ctx is done.
+func retry[K comparable, V, T any](xf *XFetcher[K, V], ctx context.Context, recompute RecomputeFunc[T]) (value T, ttl, hard, delta time.Duration, err error) {
+	p := xf.retryPolicy
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		start := xf.clock.Now()
+		value, ttl, hard, err = invoke(xf, ctx, recompute)
+		delta = xf.clock.Now().Sub(start)
+		if err == nil || isResult(err) || attempt >= p.Attempts || ctx.Err() != nil || (p.Retryable != nil && !p.Retryable(err)) {
+			return value, ttl, hard, delta, err
+		}
+
+		delay := backoff - time.Duration(p.Jitter*xf.float64()*float64(backoff))
+		select {
+		case <-xf.clock.After(delay):
+		case <-ctx.Done():
+			return value, ttl, hard, delta, err
+		}
+
+		backoff *= 2
+		if p.MaxBackoff > 0 {
+			backoff = min(backoff, p.MaxBackoff)
+		}
+	}
+}
+
+// isResult reports whether err from recompute carries a result rather than a
+// failure, and so must not be retried
+func isResult(err error) bool {
+	_, negative := asNegative(err)
+	return negative || errors.Is(err, ErrDontCache)
+}
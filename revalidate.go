@@ -0,0 +1,210 @@
+package stampede
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// RefreshQueueMetrics may be implemented by a Metrics to observe the queue of
+// background refreshes set with WithRefreshQueue
+type RefreshQueueMetrics interface {
+	// RefreshQueueDepth is called with the number of queued refreshes
+	// whenever it changes
+	RefreshQueueDepth(n int)
+
+	// RefreshDropped is called when a queued refresh is discarded to make
+	// room for a more valuable one
+	RefreshDropped()
+}
+
+// refreshQueue holds the pending background refreshes of a fetcher, most
+// valuable first, for a fixed pool of workers
+type refreshQueue[K comparable, V any] struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	jobs    refreshJobs[K, V]
+	m       map[K]*refreshJob[K, V]
+	size    int
+	closed  bool
+	dropped uint64
+
+	onDepth func(n int)
+	onDrop  func()
+}
+
+// refreshJob is a queued refresh of key, asked for by hits fetches
+type refreshJob[K comparable, V any] struct {
+	ctx       context.Context
+	key       K
+	recompute RecomputeFunc[V]
+	prev      *Item[V]
+
+	hits  int
+	score float64
+	index int
+}
+
+func (xf *XFetcher[K, V]) startRefreshers() {
+	q := &refreshQueue[K, V]{
+		m:       make(map[K]*refreshJob[K, V]),
+		size:    max(xf.refreshQueueSize, 1),
+		onDepth: func(int) {},
+		onDrop:  func() {},
+	}
+	q.cond.L = &q.mu
+	if qm, ok := xf.metrics.(RefreshQueueMetrics); ok {
+		q.onDepth, q.onDrop = qm.RefreshQueueDepth, qm.RefreshDropped
+	}
+	xf.refreshes = q
+
+	xf.life.wg.Add(xf.refreshWorkers)
+	for range xf.refreshWorkers {
+		go func() {
+			defer xf.life.wg.Done()
+			for {
+				job, ok := q.pop()
+				if !ok {
+					return
+				}
+				xf.recompute(job.ctx, job.key, job.recompute, job.prev)
+			}
+		}()
+	}
+	xf.onClose(func(context.Context) error {
+		q.close()
+		return nil
+	})
+}
+
+// revalidate recomputes key in the background, through the refresh queue if
+// there is one
+func (xf *XFetcher[K, V]) revalidate(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) {
+	ctx = context.WithoutCancel(ctx)
+	if xf.refreshes == nil {
+		// once closed, the value is served without refreshing it
+		xf.goBackground(func() { xf.recompute(ctx, key, recompute, prev) })
+		return
+	}
+	xf.refreshes.push(ctx, key, recompute, prev, xf.costFor(key), xf.clock.Now())
+}
+
+// push queues a refresh of key, or counts another request for one already
+// queued.  When the queue is full, the least valuable refresh is dropped.
+func (q *refreshQueue[K, V]) push(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V], cost float64, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	if job, ok := q.m[key]; ok {
+		job.ctx, job.recompute, job.prev = ctx, recompute, prev
+		job.hits++
+		job.score = refreshScore(job.hits, cost, *prev, now)
+		heap.Fix(&q.jobs, job.index)
+		return
+	}
+
+	job := &refreshJob[K, V]{ctx: ctx, key: key, recompute: recompute, prev: prev, hits: 1}
+	job.score = refreshScore(job.hits, cost, *prev, now)
+	if len(q.jobs) >= q.size {
+		victim := q.jobs.least()
+		if q.jobs[victim].score >= job.score {
+			q.dropped++
+			q.onDrop()
+			return
+		}
+		delete(q.m, q.jobs[victim].key)
+		heap.Remove(&q.jobs, victim)
+		q.dropped++
+		q.onDrop()
+	}
+	q.m[key] = job
+	heap.Push(&q.jobs, job)
+	q.onDepth(len(q.jobs))
+	q.cond.Signal()
+}
+
+// pop waits for the most valuable refresh, reporting false once the queue is
+// closed
+func (q *refreshQueue[K, V]) pop() (*refreshJob[K, V], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	job := heap.Pop(&q.jobs).(*refreshJob[K, V])
+	delete(q.m, job.key)
+	q.onDepth(len(q.jobs))
+	return job, true
+}
+
+// close discards the queued refreshes and stops the workers once their
+// current refresh is done
+func (q *refreshQueue[K, V]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.jobs, q.m = nil, nil
+	q.onDepth(0)
+	q.cond.Broadcast()
+}
+
+// depth returns the number of queued refreshes and how many were dropped
+func (q *refreshQueue[K, V]) depth() (int, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs), q.dropped
+}
+
+// refreshScore values a refresh of item by its popularity, the number of
+// fetches asking for it, times its staleness, the inverse of the time it has
+// left before expiring, weighted by its recompute cost
+func refreshScore[V any](hits int, cost float64, item Item[V], now time.Time) float64 {
+	left := max(ttlLeft(item, now), time.Millisecond)
+	return float64(hits) * cost / left.Seconds()
+}
+
+// refreshJobs is a max-heap of refreshes by score
+type refreshJobs[K comparable, V any] []*refreshJob[K, V]
+
+func (h refreshJobs[K, V]) Len() int           { return len(h) }
+func (h refreshJobs[K, V]) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h refreshJobs[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *refreshJobs[K, V]) Push(x any) {
+	job := x.(*refreshJob[K, V])
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *refreshJobs[K, V]) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
+
+// least returns the index of the lowest scoring refresh, which is among the
+// leaves of the heap
+func (h refreshJobs[K, V]) least() int {
+	least := len(h) / 2
+	for i := least + 1; i < len(h); i++ {
+		if h[i].score < h[least].score {
+			least = i
+		}
+	}
+	return least
+}
@@ -0,0 +1,125 @@
+package stampede_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestRefreshQueue(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	m := &countingMetrics{}
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithStaleWhileRevalidate(true),
+		stampede.WithRand(stampede.AlwaysExpire),
+		stampede.WithRefreshQueue(1, 2),
+		stampede.WithMetrics[string](m),
+		stampede.WithCostFunc(func(key string) float64 {
+			if key == "d" {
+				return 10
+			}
+			return 1
+		}),
+	)
+	for _, key := range []string{"held", "a", "b", "c", "d"} {
+		xf.Fetch(ctx, key, succeeding)
+	}
+
+	// the only worker is busy
+	started, unblock := make(chan struct{}), make(chan struct{})
+	xf.Fetch(ctx, "held", func(ctx context.Context) (int, time.Duration, error) {
+		close(started)
+		<-unblock
+		return 2, time.Minute, nil
+	})
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	refresh := func(key string) stampede.RecomputeFunc[int] {
+		return func(ctx context.Context) (int, time.Duration, error) {
+			mu.Lock()
+			order = append(order, key)
+			mu.Unlock()
+			return 2, time.Minute, nil
+		}
+	}
+	for range 3 {
+		xf.Fetch(ctx, "a", refresh("a"))
+	}
+	xf.Fetch(ctx, "b", refresh("b"))
+
+	// a refresh no more valuable than the least queued is dropped
+	xf.Fetch(ctx, "c", refresh("c"))
+	if st := xf.Stats(); st.RefreshQueueDepth != 2 || st.RefreshesDropped != 1 {
+		t.Fatalf("Stats = %+v, want 2 queued and 1 dropped", st)
+	}
+
+	// and a more valuable one takes the least one's place
+	xf.Fetch(ctx, "d", refresh("d"))
+	if st := xf.Stats(); st.RefreshQueueDepth != 2 || st.RefreshesDropped != 2 {
+		t.Fatalf("Stats = %+v, want 2 queued and 2 dropped", st)
+	}
+	if n, depth := m.get("RefreshDropped"), m.queueDepth(); n != 2 || depth != 2 {
+		t.Errorf("RefreshDropped called %d times with depth %d, want 2 at 2", n, depth)
+	}
+
+	// the most valuable runs first
+	close(unblock)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 2
+	})
+	if want := []string{"d", "a"}; !slices.Equal(order, want) {
+		t.Errorf("refreshed %v, want %v", order, want)
+	}
+	if err := xf.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+}
+
+func TestRefreshQueueClose(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithStaleWhileRevalidate(true),
+		stampede.WithRand(stampede.AlwaysExpire),
+		stampede.WithRefreshQueue(1, 10),
+	)
+	xf.Fetch(ctx, "held", succeeding)
+	xf.Fetch(ctx, "k", succeeding)
+	started, unblock := make(chan struct{}), make(chan struct{})
+	xf.Fetch(ctx, "held", func(ctx context.Context) (int, time.Duration, error) {
+		close(started)
+		<-unblock
+		return 2, time.Minute, nil
+	})
+	<-started
+
+	var ran atomic.Bool
+	xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		ran.Store(true)
+		return 2, time.Minute, nil
+	})
+
+	// Close discards the queued refresh and waits for the running one
+	closed := make(chan error)
+	go func() { closed <- xf.Close(ctx) }()
+	waitFor(t, func() bool { return xf.Stats().RefreshQueueDepth == 0 })
+	close(unblock)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if ran.Load() {
+		t.Error("queued refresh ran after Close")
+	}
+}
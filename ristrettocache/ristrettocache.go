@@ -0,0 +1,105 @@
+// Package ristrettocache is a stampede.Cache backed by ristretto
+package ristrettocache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/dgryski/go-stampede"
+)
+
+// Key is the set of key types supported by both ristretto and stampede
+type Key interface {
+	ristretto.Key
+	comparable
+}
+
+// Cache stores items in a ristretto cache, using each item's recompute cost as
+// its admission cost.  Ristretto retains entries of lower cost more readily,
+// so the cost of an item is inversely proportional to its Delta: an item which
+// took one cost unit to recompute has cost 1, one which took a tenth of a unit
+// has cost 10.  The ristretto MaxCost should be sized accordingly.
+type Cache[K Key, V any] struct {
+	c     *ristretto.Cache[K, stampede.Item[V]]
+	unit  time.Duration
+	clone func(V) V
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	unit  time.Duration
+	clone any
+}
+
+// WithCostUnit sets the recompute time which corresponds to a cost of 1.
+// Items with a larger Delta also have cost 1.  The default is one second.
+func WithCostUnit(unit time.Duration) Option {
+	return func(c *config) { c.unit = unit }
+}
+
+// WithClone sets a function copying values, applied to values written and
+// read, so that callers cannot corrupt what other goroutines read by
+// mutating a returned slice or map.  By default values are shared.  The value
+// type must match the cache's.
+func WithClone[V any](fn func(v V) V) Option {
+	return func(c *config) { c.clone = fn }
+}
+
+// New returns a Cache storing items in c
+func New[K Key, V any](c *ristretto.Cache[K, stampede.Item[V]], opts ...Option) *Cache[K, V] {
+	cfg := config{unit: time.Second}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	rc := &Cache[K, V]{c: c, unit: cfg.unit}
+	if cfg.clone != nil {
+		fn, ok := cfg.clone.(func(V) V)
+		if !ok {
+			panic(fmt.Sprintf("ristrettocache: WithClone function is %T, not %T", cfg.clone, fn))
+		}
+		rc.clone = fn
+	}
+	return rc
+}
+
+// Get implements stampede.Cache
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (stampede.Item[V], error) {
+	item, ok := c.c.Get(key)
+	if !ok {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	return c.copy(item), nil
+}
+
+// Set implements stampede.Cache.  Ristretto may decline to admit the item;
+// this is not reported as an error.
+func (c *Cache[K, V]) Set(ctx context.Context, key K, item stampede.Item[V]) error {
+	c.c.Set(key, c.copy(item), c.cost(item.Delta))
+	return nil
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[K, V]) Delete(ctx context.Context, key K) error {
+	c.c.Del(key)
+	return nil
+}
+
+// cost returns the admission cost for an item which took delta to recompute
+func (c *Cache[K, V]) cost(delta time.Duration) int64 {
+	if delta >= c.unit {
+		return 1
+	}
+	return int64(c.unit / max(delta, 1))
+}
+
+// copy returns item with its value cloned, if WithClone is set
+func (c *Cache[K, V]) copy(item stampede.Item[V]) stampede.Item[V] {
+	if c.clone != nil {
+		item.Value = c.clone(item.Value)
+	}
+	return item
+}
@@ -0,0 +1,99 @@
+package ristrettocache_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/ristrettocache"
+)
+
+// waitingCache makes the buffered writes of ristretto visible before
+// returning, as the conformance tests read their writes back at once
+type waitingCache[V any] struct {
+	*ristrettocache.Cache[string, V]
+	r *ristretto.Cache[string, stampede.Item[V]]
+}
+
+func (c waitingCache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	err := c.Cache.Set(ctx, key, item)
+	c.r.Wait()
+	return err
+}
+
+func newRistretto[V any](t *testing.T) *ristretto.Cache[string, stampede.Item[V]] {
+	t.Helper()
+	r, err := ristretto.NewCache(&ristretto.Config[string, stampede.Item[V]]{
+		NumCounters: 1e4,
+		MaxCost:     1e6,
+		BufferItems: 64,
+
+		IgnoreInternalCost: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		r := newRistretto[string](t)
+		return waitingCache[string]{ristrettocache.New(r), r}
+	})
+}
+
+func TestCost(t *testing.T) {
+	ctx := context.Background()
+	r, err := ristretto.NewCache(&ristretto.Config[string, stampede.Item[string]]{
+		NumCounters: 1e4,
+		MaxCost:     1e6,
+		BufferItems: 64,
+		Metrics:     true,
+
+		IgnoreInternalCost: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	c := ristrettocache.New(r, ristrettocache.WithCostUnit(100*time.Millisecond))
+
+	// the cost is the unit over the delta, at least 1
+	for _, tt := range []struct {
+		delta time.Duration
+		cost  uint64
+	}{
+		{time.Second, 1},
+		{100 * time.Millisecond, 1},
+		{10 * time.Millisecond, 10},
+		{time.Millisecond, 100},
+	} {
+		before := r.Metrics.CostAdded()
+		c.Set(ctx, tt.delta.String(), stampede.Item[string]{Value: "v", Delta: tt.delta})
+		r.Wait()
+		if got := r.Metrics.CostAdded() - before; got != tt.cost {
+			t.Errorf("delta %v: cost %d, want %d", tt.delta, got, tt.cost)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	r := newRistretto[[]int](t)
+	c := ristrettocache.New(r, ristrettocache.WithClone(slices.Clone[[]int]))
+	v := []int{1, 2}
+	c.Set(ctx, "k", stampede.Item[[]int]{Value: v, Delta: time.Second})
+	r.Wait()
+	v[0] = 9
+	got, _ := c.Get(ctx, "k")
+	got.Value[1] = 9
+	if again, _ := c.Get(ctx, "k"); !slices.Equal(again.Value, []int{1, 2}) {
+		t.Errorf("cached value %v, want [1 2] unchanged by callers", again.Value)
+	}
+}
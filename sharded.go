@@ -0,0 +1,360 @@
+package stampede
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ShardedCache spreads keys across several caches, e.g. memcached or Redis
+// nodes, by consistent hashing, so that adding or removing a node only moves
+// the keys it gains or loses.  Each key is held by a number of distinct nodes,
+// its first being the primary.
+//
+// Reads try the key's nodes in order, so a key survives the loss of all but
+// one of them.  Writes go to every node of the key and fail only if none
+// stored it.  Deletes go to every node and fail if any did.  Nodes are
+// placed on the ring by name, so processes configured with the same names
+// agree on the routing regardless of order.
+type ShardedCache[K comparable, V any] struct {
+	// HedgeDelay, if set, hedges reads against slow nodes: if a node has
+	// not answered within HedgeDelay, the key's next node is read too, and
+	// the first item returned wins.  It applies to Get, and with more than
+	// one replica.
+	HedgeDelay time.Duration
+
+	// Clock is the source of time for HedgeDelay.  Nil means SystemClock.
+	Clock Clock
+
+	nodes    []Cache[K, V]
+	ring     []ringPoint
+	replicas int
+}
+
+// ringPoint is a position of node on the hash ring
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// shardPoints is the number of ring positions per node, evening out the
+// share of keys each gets
+const shardPoints = 128
+
+// NewShardedCache returns a ShardedCache over nodes, keyed by name, holding
+// each key on replicas of them.  Replicas is clamped to between 1 and the
+// number of nodes.
+func NewShardedCache[K comparable, V any](nodes map[string]Cache[K, V], replicas int) *ShardedCache[K, V] {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	s := &ShardedCache[K, V]{replicas: max(1, min(replicas, len(nodes)))}
+	for i, name := range names {
+		s.nodes = append(s.nodes, nodes[name])
+		for p := range shardPoints {
+			s.ring = append(s.ring, ringPoint{hash: ringHash(fmt.Sprintf("%s#%d", name, p)), node: i})
+		}
+	}
+	slices.SortFunc(s.ring, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), a.node-b.node)
+	})
+	return s
+}
+
+// owners returns the nodes of key, primary first
+func (s *ShardedCache[K, V]) owners(key K) []int {
+	if len(s.ring) == 0 {
+		return nil
+	}
+	h := ringHash(key)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+
+	owners := make([]int, 0, s.replicas)
+	for len(owners) < s.replicas {
+		p := s.ring[i%len(s.ring)]
+		if !slices.Contains(owners, p.node) {
+			owners = append(owners, p.node)
+		}
+		i++
+	}
+	return owners
+}
+
+// Get implements Cache.  A key missing from a node or failing to read is
+// tried on its next; the error is returned if no node held it and none
+// reported it missing.
+func (s *ShardedCache[K, V]) Get(ctx context.Context, key K) (Item[V], error) {
+	owners := s.owners(key)
+	if s.HedgeDelay > 0 && len(owners) > 1 {
+		return s.hedgedGet(ctx, key, owners)
+	}
+
+	var errs []error
+	missed := false
+	for _, n := range owners {
+		item, err := s.nodes[n].Get(ctx, key)
+		if err == nil {
+			return item, nil
+		}
+		if errors.Is(err, ErrCacheMiss) {
+			missed = true
+			continue
+		}
+		errs = append(errs, err)
+	}
+	return Item[V]{}, readFailure(missed, errs)
+}
+
+// hedgedGet is Get, reading each next node of key once the previous read
+// failed, missed or took longer than HedgeDelay.  The reads still running
+// when one returns an item are cancelled.
+func (s *ShardedCache[K, V]) hedgedGet(ctx context.Context, key K, owners []int) (Item[V], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type read struct {
+		item Item[V]
+		err  error
+	}
+	reads := make(chan read, len(owners))
+	started := 0
+	next := func() {
+		c := s.nodes[owners[started]]
+		started++
+		go func() {
+			item, err := c.Get(ctx, key)
+			reads <- read{item, err}
+		}()
+	}
+
+	clock := s.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	var errs []error
+	missed := false
+	next()
+	for done := 0; done < started; {
+		var hedge <-chan time.Time
+		if started < len(owners) {
+			hedge = clock.After(s.HedgeDelay)
+		}
+		select {
+		case r := <-reads:
+			done++
+			if r.err == nil {
+				return r.item, nil
+			}
+			if errors.Is(r.err, ErrCacheMiss) {
+				missed = true
+			} else {
+				errs = append(errs, r.err)
+			}
+			if started < len(owners) {
+				next()
+			}
+		case <-hedge:
+			next()
+		}
+	}
+	return Item[V]{}, readFailure(missed, errs)
+}
+
+// readFailure is the error of a read for which no node returned an item:
+// ErrCacheMiss if any node missed, else the errors of the nodes
+func readFailure(missed bool, errs []error) error {
+	if missed || len(errs) == 0 {
+		return ErrCacheMiss
+	}
+	return errors.Join(errs...)
+}
+
+// Set implements Cache
+func (s *ShardedCache[K, V]) Set(ctx context.Context, key K, item Item[V]) error {
+	_, err := s.each(key, func(c Cache[K, V]) (bool, error) {
+		return true, c.Set(ctx, key, item)
+	})
+	return err
+}
+
+// SetIfNewer implements ConditionalSetter, writing conditionally to the nodes
+// which implement ConditionalSetter and reporting whether any stored item
+func (s *ShardedCache[K, V]) SetIfNewer(ctx context.Context, key K, item Item[V]) (bool, error) {
+	return s.each(key, func(c Cache[K, V]) (bool, error) {
+		if cs, ok := c.(ConditionalSetter[K, V]); ok {
+			return cs.SetIfNewer(ctx, key, item)
+		}
+		return true, c.Set(ctx, key, item)
+	})
+}
+
+// each runs write on each node of key, returning whether any reported
+// storing and the errors if every node failed
+func (s *ShardedCache[K, V]) each(key K, write func(c Cache[K, V]) (bool, error)) (bool, error) {
+	var errs []error
+	stored := false
+	owners := s.owners(key)
+	for _, n := range owners {
+		ok, err := write(s.nodes[n])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stored = stored || ok
+	}
+	if len(errs) == len(owners) {
+		return false, errors.Join(errs...)
+	}
+	return stored, nil
+}
+
+// ClaimLease implements LeaseClaimer.  The claim is made on the first node of
+// key which can be reached, atomically if it implements LeaseClaimer, and the
+// lease then copied to the others on a best-effort basis.
+func (s *ShardedCache[K, V]) ClaimLease(ctx context.Context, key K, prev *Item[V], lease Item[V]) (bool, error) {
+	var errs []error
+	owners := s.owners(key)
+	for i, n := range owners {
+		claimed, err := claimOn(ctx, s.nodes[n], key, prev, lease)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if claimed {
+			for _, r := range owners[i+1:] {
+				_ = s.nodes[r].Set(ctx, key, lease)
+			}
+		}
+		return claimed, nil
+	}
+	return false, errors.Join(errs...)
+}
+
+// claimOn claims the lease on c, atomically if c is a LeaseClaimer
+func claimOn[K comparable, V any](ctx context.Context, c Cache[K, V], key K, prev *Item[V], lease Item[V]) (bool, error) {
+	if lc, ok := c.(LeaseClaimer[K, V]); ok {
+		return lc.ClaimLease(ctx, key, prev, lease)
+	}
+	return true, c.Set(ctx, key, lease)
+}
+
+// Delete implements Deleter, deleting key from every node holding it
+func (s *ShardedCache[K, V]) Delete(ctx context.Context, key K) error {
+	var errs []error
+	for _, n := range s.owners(key) {
+		errs = append(errs, deleteKey(ctx, s.nodes[n], key))
+	}
+	return errors.Join(errs...)
+}
+
+// GetMulti implements BatchGetter, reading the keys of each node in one
+// operation if it supports it.  Keys missing or failing on their primary are
+// read from their next node, and so on.  An error is returned if some key
+// failed on every node without being reported missing.
+func (s *ShardedCache[K, V]) GetMulti(ctx context.Context, keys []K) (map[K]Item[V], error) {
+	items := make(map[K]Item[V], len(keys))
+	owners := make(map[K][]int, len(keys))
+	missed := make(map[K]bool)
+	for _, key := range keys {
+		owners[key] = s.owners(key)
+	}
+
+	var errs []error
+	pending := keys
+	for r := 0; r < s.replicas && len(pending) > 0; r++ {
+		groups := make(map[int][]K)
+		for _, key := range pending {
+			n := owners[key][r]
+			groups[n] = append(groups[n], key)
+		}
+
+		failed := make(map[int]bool)
+		for n, group := range groups {
+			got, err := getMulti(ctx, s.nodes[n], group)
+			if err != nil {
+				failed[n] = true
+				errs = append(errs, err)
+				continue
+			}
+			for key, item := range got {
+				items[key] = item
+			}
+		}
+
+		var next []K
+		for _, key := range pending {
+			if _, ok := items[key]; ok {
+				continue
+			}
+			if !failed[owners[key][r]] {
+				missed[key] = true
+			}
+			next = append(next, key)
+		}
+		pending = next
+	}
+
+	for _, key := range pending {
+		if !missed[key] {
+			return items, errors.Join(errs...)
+		}
+	}
+	return items, nil
+}
+
+// SetMulti implements BatchSetter, writing the items of each node in one
+// operation if it supports it.  An error is returned if some item was stored
+// on none of its nodes.
+func (s *ShardedCache[K, V]) SetMulti(ctx context.Context, items map[K]Item[V]) error {
+	groups := make(map[int]map[K]Item[V])
+	for key, item := range items {
+		for _, n := range s.owners(key) {
+			if groups[n] == nil {
+				groups[n] = make(map[K]Item[V])
+			}
+			groups[n][key] = item
+		}
+	}
+
+	var errs []error
+	stored := make(map[K]bool, len(items))
+	for n, group := range groups {
+		if err := setMulti(ctx, s.nodes[n], group); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for key := range group {
+			stored[key] = true
+		}
+	}
+	if len(stored) < len(items) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// ringHash places v on the hash ring: the FNV-1a hash of its formatted
+// value, mixed to spread similar strings
+func ringHash(v any) uint64 {
+	h := fnv.New64a()
+	if s, ok := v.(string); ok {
+		h.Write([]byte(s))
+	} else {
+		fmt.Fprint(h, v)
+	}
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
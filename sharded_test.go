@@ -0,0 +1,227 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/chaoscache"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// nodes returns n in-memory caches named a, b, c, ...
+func nodes(n int) map[string]*memcache.Cache[string, int] {
+	m := make(map[string]*memcache.Cache[string, int], n)
+	for i := range n {
+		m[string(rune('a'+i))] = memcache.New[string, int]()
+	}
+	return m
+}
+
+func asCaches(m map[string]*memcache.Cache[string, int]) map[string]stampede.Cache[string, int] {
+	caches := make(map[string]stampede.Cache[string, int], len(m))
+	for name, c := range m {
+		caches[name] = c
+	}
+	return caches
+}
+
+// primaries returns the name of the node holding each of keys, for a cache
+// of one replica
+func primaries(caches map[string]*memcache.Cache[string, int], keys []string) map[string]string {
+	ctx := context.Background()
+	s := stampede.NewShardedCache(asCaches(caches), 1)
+	for _, key := range keys {
+		s.Set(ctx, key, stampede.Item[int]{Value: 1})
+	}
+	owner := make(map[string]string, len(keys))
+	for name, c := range caches {
+		for _, key := range keys {
+			if _, err := c.Get(ctx, key); err == nil {
+				owner[key] = name
+			}
+		}
+	}
+	return owner
+}
+
+func TestShardedCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		nodes := make(map[string]stampede.Cache[string, string])
+		for _, name := range []string{"a", "b", "c"} {
+			nodes[name] = memcache.New[string, string]()
+		}
+		return stampede.NewShardedCache(nodes, 2)
+	})
+}
+
+func TestShardedCacheDistribution(t *testing.T) {
+	var keys []string
+	for i := range 10000 {
+		keys = append(keys, fmt.Sprintf("key:%d", i))
+	}
+
+	before := primaries(nodes(4), keys)
+	share := make(map[string]int)
+	for _, name := range before {
+		share[name]++
+	}
+	for name, n := range share {
+		if f := float64(n) / float64(len(keys)); math.Abs(f-0.25) > 0.1 {
+			t.Errorf("node %s holds %.3f of keys, want about a quarter", name, f)
+		}
+	}
+
+	// a fifth node takes its share from the others, and moves nothing else
+	after := primaries(nodes(5), keys)
+	moved := 0
+	for _, key := range keys {
+		if before[key] != after[key] {
+			moved++
+			if after[key] != "e" {
+				t.Fatalf("%s moved from %s to %s, not the new node", key, before[key], after[key])
+			}
+		}
+	}
+	if f := float64(moved) / float64(len(keys)); math.Abs(f-0.2) > 0.1 {
+		t.Errorf("%.3f of keys moved, want about a fifth", f)
+	}
+}
+
+func TestShardedCacheReplicas(t *testing.T) {
+	ctx := context.Background()
+	m := nodes(3)
+	s := stampede.NewShardedCache(asCaches(m), 2)
+	s.Set(ctx, "k", stampede.Item[int]{Value: 1})
+
+	var holders []string
+	for name, c := range m {
+		if _, err := c.Get(ctx, "k"); err == nil {
+			holders = append(holders, name)
+		}
+	}
+	if len(holders) != 2 {
+		t.Fatalf("k held by %v, want 2 replicas", holders)
+	}
+
+	// a key survives losing its primary, in Get and GetMulti
+	m[holders[0]].Delete(ctx, "k")
+	if item, err := s.Get(ctx, "k"); err != nil || item.Value != 1 {
+		t.Errorf("Get with one replica left = %+v, %v", item, err)
+	}
+	if items, err := s.GetMulti(ctx, []string{"k", "absent"}); err != nil || len(items) != 1 {
+		t.Errorf("GetMulti with one replica left = %v, %v", items, err)
+	}
+
+	if err := s.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete = %v", err)
+	}
+	for _, name := range holders {
+		if _, err := m[name].Get(ctx, "k"); err == nil {
+			t.Errorf("k still on %s after Delete", name)
+		}
+	}
+}
+
+func TestShardedCacheFailures(t *testing.T) {
+	ctx := context.Background()
+	broken := func() stampede.Cache[string, int] {
+		return chaoscache.New[string, int](memcache.New[string, int](),
+			chaoscache.WithReadErrorRate(1), chaoscache.WithWriteErrorRate(1))
+	}
+
+	// with every node down, reads and writes fail
+	s := stampede.NewShardedCache(map[string]stampede.Cache[string, int]{"a": broken(), "b": broken()}, 2)
+	if err := s.Set(ctx, "k", stampede.Item[int]{Value: 1}); !errors.Is(err, chaoscache.ErrInjected) {
+		t.Errorf("Set = %v, want the nodes' errors", err)
+	}
+	if _, err := s.Get(ctx, "k"); !errors.Is(err, chaoscache.ErrInjected) {
+		t.Errorf("Get = %v, want the nodes' errors", err)
+	}
+	if _, err := s.GetMulti(ctx, []string{"k"}); !errors.Is(err, chaoscache.ErrInjected) {
+		t.Errorf("GetMulti = %v, want the nodes' errors", err)
+	}
+
+	// with one down, writes succeed and reads are served by the other
+	s = stampede.NewShardedCache(map[string]stampede.Cache[string, int]{"a": broken(), "b": memcache.New[string, int]()}, 2)
+	if err := s.Set(ctx, "k", stampede.Item[int]{Value: 1}); err != nil {
+		t.Errorf("Set with a node down = %v", err)
+	}
+	if err := s.SetMulti(ctx, map[string]stampede.Item[int]{"m": {Value: 2}}); err != nil {
+		t.Errorf("SetMulti with a node down = %v", err)
+	}
+	if item, err := s.Get(ctx, "k"); err != nil || item.Value != 1 {
+		t.Errorf("Get with a node down = %+v, %v", item, err)
+	}
+	if items, err := s.GetMulti(ctx, []string{"k", "m"}); err != nil || len(items) != 2 {
+		t.Errorf("GetMulti with a node down = %v, %v", items, err)
+	}
+	if _, err := s.Get(ctx, "absent"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("Get(absent) = %v, want ErrCacheMiss", err)
+	}
+	if err := s.Delete(ctx, "k"); !errors.Is(err, chaoscache.ErrInjected) {
+		t.Errorf("Delete with a node down = %v, want its error", err)
+	}
+}
+
+// stuckCache is a Cache whose first read across all copies answers only
+// once cancelled
+type stuckCache struct {
+	*memcache.Cache[string, int]
+	reads     *atomic.Int32
+	cancelled chan struct{}
+}
+
+func (c stuckCache) Get(ctx context.Context, key string) (stampede.Item[int], error) {
+	if c.reads.Add(1) == 1 {
+		<-ctx.Done()
+		close(c.cancelled)
+		return stampede.Item[int]{}, ctx.Err()
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+func TestShardedCacheHedge(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	var reads atomic.Int32
+	cancelled := make(chan struct{})
+	s := stampede.NewShardedCache(map[string]stampede.Cache[string, int]{
+		"a": stuckCache{memcache.New[string, int](), &reads, cancelled},
+		"b": stuckCache{memcache.New[string, int](), &reads, cancelled},
+	}, 2)
+	s.Set(ctx, "k", stampede.Item[int]{Value: 1})
+	s.HedgeDelay, s.Clock = 10*time.Millisecond, clock
+
+	done := make(chan error)
+	go func() {
+		_, err := s.Get(ctx, "k")
+		done <- err
+	}()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	if n := reads.Load(); n != 1 {
+		t.Fatalf("%d reads before the hedge delay, want 1", n)
+	}
+
+	// the stuck primary is hedged against after the delay, and cancelled
+	// once the replica answers
+	clock.Advance(10 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("hedged Get = %v", err)
+	}
+	if n := reads.Load(); n != 2 {
+		t.Errorf("%d reads, want the primary and its replica", n)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("stuck read not cancelled")
+	}
+}
@@ -0,0 +1,185 @@
+// Package simulate drives synthetic workloads through an XFetcher, to compare
+// stampedes and wasted recomputes across values of beta, as in the paper.
+//
+// Time is simulated: requests arrive as a Poisson process, and each
+// recompute takes Cost of simulated time before its value becomes visible in
+// the cache.  Fetches are made one at a time, so every fetch which sees a
+// missing or expiring key while its recompute is in flight starts another.
+package simulate
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+)
+
+// Config describes a workload
+type Config struct {
+	// Keys is the number of distinct keys
+	Keys int
+
+	// Skew is the Zipf exponent of key popularity, which must exceed 1;
+	// zero means keys are equally popular
+	Skew float64
+
+	// Rate is the mean number of requests per second, across all keys
+	Rate float64
+
+	// Duration is the length of simulated time
+	Duration time.Duration
+
+	// Cost is how long each recompute takes
+	Cost time.Duration
+
+	// TTL is the time-to-live of each recomputed value
+	TTL time.Duration
+
+	// Beta is passed to the XFetcher; see stampede.WithBeta
+	Beta float64
+
+	// Seed seeds the random sources, so runs are reproducible
+	Seed int64
+}
+
+// Report summarizes a simulation run
+type Report struct {
+	Beta float64
+
+	Requests   int
+	Hits       int
+	Recomputes int
+
+	// Stampedes counts recomputes started while another recompute of the
+	// same key was already in flight
+	Stampedes int
+
+	// Early counts recomputes started before the value expired, and
+	// EarlyBy is the total time left on those values: recomputes which
+	// were, with hindsight, wasted
+	Early   int
+	EarlyBy time.Duration
+}
+
+// Run simulates the workload described by cfg
+func Run(cfg Config) Report {
+	start := time.Unix(0, 0)
+	clock := fakeclock.New(start)
+	c := &cache{clock: clock, cost: cfg.Cost, items: make(map[int]stampede.Item[int]), inflight: make(map[int]int)}
+	xf := stampede.New[int, int](c,
+		stampede.WithBeta(cfg.Beta),
+		stampede.WithClock(clock),
+		stampede.WithRandSource(rand.NewSource(cfg.Seed+1)),
+		stampede.WithSingleflight(false),
+	)
+
+	r := rand.New(rand.NewSource(cfg.Seed))
+	next := func() int { return r.Intn(cfg.Keys) }
+	if cfg.Skew > 1 && cfg.Keys > 1 {
+		z := rand.NewZipf(r, cfg.Skew, 1, uint64(cfg.Keys-1))
+		next = func() int { return int(z.Uint64()) }
+	}
+
+	rep := Report{Beta: cfg.Beta}
+	ctx := context.Background()
+	end := start.Add(cfg.Duration)
+	for now := start; ; {
+		now = now.Add(time.Duration(r.ExpFloat64() / cfg.Rate * float64(time.Second)))
+		if now.After(end) {
+			break
+		}
+		clock.Set(now)
+		c.apply(now)
+
+		key := next()
+		rep.Requests++
+		recomputed := false
+		xf.Fetch(ctx, key, func(ctx context.Context) (int, time.Duration, error) {
+			recomputed = true
+			rep.Recomputes++
+			if c.inflight[key] > 0 {
+				rep.Stampedes++
+			}
+			if item, ok := c.items[key]; ok && now.Before(item.Expiry) {
+				rep.Early++
+				rep.EarlyBy += item.Expiry.Sub(now)
+			}
+			return key, cfg.TTL, nil
+		})
+		if !recomputed {
+			rep.Hits++
+		}
+	}
+	return rep
+}
+
+// Sweep runs cfg once for each of betas
+func Sweep(cfg Config, betas []float64) []Report {
+	reps := make([]Report, len(betas))
+	for i, b := range betas {
+		cfg.Beta = b
+		reps[i] = Run(cfg)
+	}
+	return reps
+}
+
+// cache is a stampede.Cache whose writes become visible cost after they are
+// made, modelling the recompute time
+type cache struct {
+	clock    stampede.Clock
+	cost     time.Duration
+	items    map[int]stampede.Item[int]
+	inflight map[int]int
+	pending  writes
+}
+
+type write struct {
+	at   time.Time
+	key  int
+	item stampede.Item[int]
+}
+
+func (c *cache) Get(ctx context.Context, key int) (stampede.Item[int], error) {
+	item, ok := c.items[key]
+	if !ok {
+		return item, stampede.ErrCacheMiss
+	}
+	return item, nil
+}
+
+func (c *cache) Set(ctx context.Context, key int, item stampede.Item[int]) error {
+	// the recompute finishes cost from now, and its value lives from then
+	at := c.clock.Now().Add(c.cost)
+	item.Expiry = item.Expiry.Add(c.cost)
+	item.Delta = c.cost
+	item.Created = at
+	heap.Push(&c.pending, write{at: at, key: key, item: item})
+	c.inflight[key]++
+	return nil
+}
+
+// apply makes visible the writes finished by now
+func (c *cache) apply(now time.Time) {
+	for len(c.pending) > 0 && !c.pending[0].at.After(now) {
+		w := heap.Pop(&c.pending).(write)
+		c.items[w.key] = w.item
+		c.inflight[w.key]--
+	}
+}
+
+// writes is a min-heap of pending writes by completion time
+type writes []write
+
+func (h writes) Len() int           { return len(h) }
+func (h writes) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h writes) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *writes) Push(x any)        { *h = append(*h, x.(write)) }
+func (h *writes) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return w
+}
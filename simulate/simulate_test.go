@@ -0,0 +1,66 @@
+package simulate_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede/simulate"
+)
+
+var cfg = simulate.Config{
+	Keys:     1,
+	Rate:     100,
+	Duration: time.Hour,
+	Cost:     time.Second,
+	TTL:      time.Minute,
+	Seed:     1,
+}
+
+func TestRun(t *testing.T) {
+	c := cfg
+	c.Beta = 1
+	rep := simulate.Run(c)
+	if want := c.Rate * c.Duration.Seconds(); math.Abs(float64(rep.Requests)-want) > 5*math.Sqrt(want) {
+		t.Errorf("%d requests, want about %v", rep.Requests, want)
+	}
+	if rep.Hits+rep.Recomputes != rep.Requests {
+		t.Errorf("%d hits and %d recomputes of %d requests", rep.Hits, rep.Recomputes, rep.Requests)
+	}
+	if rep != simulate.Run(c) {
+		t.Error("Run not reproducible with the same seed")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	reps := simulate.Sweep(cfg, []float64{0, 1, 4})
+	if len(reps) != 3 {
+		t.Fatalf("%d reports, want 3", len(reps))
+	}
+
+	// without early expiration every expiry stampedes for the recompute's
+	// duration, and nothing is recomputed early
+	none := reps[0]
+	if none.Beta != 0 || none.Early != 0 || none.Stampedes < 1000 {
+		t.Errorf("beta 0: %+v", none)
+	}
+	for i := 1; i < len(reps); i++ {
+		prev, rep := reps[i-1], reps[i]
+		if rep.Stampedes >= prev.Stampedes {
+			t.Errorf("beta %v: %d stampedes, not fewer than %d at beta %v", rep.Beta, rep.Stampedes, prev.Stampedes, prev.Beta)
+		}
+		if rep.EarlyBy <= prev.EarlyBy {
+			t.Errorf("beta %v: recomputed %v early, not more than %v at beta %v", rep.Beta, rep.EarlyBy, prev.EarlyBy, prev.Beta)
+		}
+	}
+}
+
+func TestSkew(t *testing.T) {
+	c := cfg
+	c.Keys, c.Skew, c.Beta = 100, 1.5, 1
+	rep := simulate.Run(c)
+	// popular keys stay cached, so most requests hit
+	if rep.Hits < rep.Requests/2 {
+		t.Errorf("%d hits of %d requests", rep.Hits, rep.Requests)
+	}
+}
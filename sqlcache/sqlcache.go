@@ -0,0 +1,262 @@
+// Package sqlcache is a stampede.Cache backed by a SQL database
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// Dialect is the SQL flavour spoken by the database
+type Dialect int
+
+// The supported dialects
+const (
+	SQLite Dialect = iota
+	Postgres
+	MySQL
+)
+
+// Cache stores items in a table, encoded with a stampede.Codec.  The table has
+// the columns:
+//
+//	cache_key   the key, as a string primary key
+//	item        the encoded item
+//	expires_at  the unix time in seconds after which the row is dead
+//
+// Dead rows are treated as missing, and are deleted by Set at most once per
+// cleanup interval.
+type Cache[V any] struct {
+	db      *sql.DB
+	dialect Dialect
+	codec   stampede.Codec[V]
+	config
+
+	// nextCleanup is the unix time in nanoseconds of the next cleanup
+	nextCleanup atomic.Int64
+}
+
+// An Option configures a Cache
+type Option func(*config)
+
+type config struct {
+	table   string
+	grace   time.Duration
+	cleanup time.Duration
+}
+
+// WithTable sets the name of the table.  The default is "stampede_cache".
+func WithTable(name string) Option {
+	return func(c *config) { c.table = name }
+}
+
+// WithGrace keeps rows for grace after the item expires, so that they remain
+// available for stale serving.  The default is zero.
+func WithGrace(grace time.Duration) Option {
+	return func(c *config) { c.grace = grace }
+}
+
+// WithCleanupInterval sets how often Set deletes dead rows.  Zero disables
+// cleanup.  The default is one minute.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(c *config) { c.cleanup = d }
+}
+
+// New returns a Cache storing items in db, which speaks dialect, encoding items
+// with codec
+func New[V any](db *sql.DB, dialect Dialect, codec stampede.Codec[V], opts ...Option) *Cache[V] {
+	c := &Cache[V]{db: db, dialect: dialect, codec: codec}
+	c.table = "stampede_cache"
+	c.cleanup = time.Minute
+	for _, o := range opts {
+		o(&c.config)
+	}
+	return c
+}
+
+// CreateTable creates the cache's table and index if they do not exist
+func (c *Cache[V]) CreateTable(ctx context.Context) error {
+	key, blob := "TEXT", "BLOB"
+	switch c.dialect {
+	case Postgres:
+		blob = "BYTEA"
+	case MySQL:
+		// MySQL cannot index unbounded text
+		key, blob = "VARCHAR(250)", "LONGBLOB"
+	}
+	_, err := c.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (cache_key %s PRIMARY KEY, item %s NOT NULL, expires_at BIGINT NOT NULL)",
+		c.table, key, blob))
+	if err != nil {
+		return err
+	}
+	if c.dialect == MySQL {
+		// MySQL has no CREATE INDEX IF NOT EXISTS; cleanup scans instead
+		return nil
+	}
+	_, err = c.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)", c.table, c.table))
+	return err
+}
+
+// Get implements stampede.Cache
+func (c *Cache[V]) Get(ctx context.Context, key string) (stampede.Item[V], error) {
+	var b []byte
+	err := c.db.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT item FROM %s WHERE cache_key = %s AND expires_at >= %s",
+		c.table, c.arg(1), c.arg(2)), key, time.Now().Unix()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return stampede.Item[V]{}, stampede.ErrCacheMiss
+	}
+	if err != nil {
+		return stampede.Item[V]{}, err
+	}
+	return c.codec.Unmarshal(b)
+}
+
+// Set implements stampede.Cache with an upsert
+func (c *Cache[V]) Set(ctx context.Context, key string, item stampede.Item[V]) error {
+	b, err := c.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.db.ExecContext(ctx, c.upsert(), key, b, c.expiration(item)); err != nil {
+		return err
+	}
+
+	c.maybeCleanup(ctx)
+	return nil
+}
+
+// SetMulti implements stampede.BatchSetter with upserts in one transaction
+func (c *Cache[V]) SetMulti(ctx context.Context, items map[string]stampede.Item[V]) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, c.upsert())
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, item := range items {
+		b, err := c.codec.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, key, b, c.expiration(item)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	c.maybeCleanup(ctx)
+	return nil
+}
+
+// upsert returns the statement writing a row of key, item and expiry
+func (c *Cache[V]) upsert() string {
+	q := fmt.Sprintf("INSERT INTO %s (cache_key, item, expires_at) VALUES (%s, %s, %s) ", c.table, c.arg(1), c.arg(2), c.arg(3))
+	if c.dialect == MySQL {
+		return q + "ON DUPLICATE KEY UPDATE item = VALUES(item), expires_at = VALUES(expires_at)"
+	}
+	return q + "ON CONFLICT (cache_key) DO UPDATE SET item = excluded.item, expires_at = excluded.expires_at"
+}
+
+// Delete implements stampede.Deleter
+func (c *Cache[V]) Delete(ctx context.Context, key string) error {
+	_, err := c.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE cache_key = %s", c.table, c.arg(1)), key)
+	return err
+}
+
+// GetMulti implements stampede.BatchGetter with a single query
+func (c *Cache[V]) GetMulti(ctx context.Context, keys []string) (map[string]stampede.Item[V], error) {
+	items := make(map[string]stampede.Item[V], len(keys))
+	if len(keys) == 0 {
+		return items, nil
+	}
+
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, time.Now().Unix())
+	in := make([]string, len(keys))
+	for i, key := range keys {
+		args = append(args, key)
+		in[i] = c.arg(i + 2)
+	}
+	rows, err := c.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT cache_key, item FROM %s WHERE expires_at >= %s AND cache_key IN (%s)",
+		c.table, c.arg(1), strings.Join(in, ", ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var b []byte
+		if err := rows.Scan(&key, &b); err != nil {
+			return items, err
+		}
+		item, err := c.codec.Unmarshal(b)
+		if err != nil {
+			return items, err
+		}
+		items[key] = item
+	}
+	return items, rows.Err()
+}
+
+// Cleanup deletes dead rows
+func (c *Cache[V]) Cleanup(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE expires_at < %s", c.table, c.arg(1)), time.Now().Unix())
+	return err
+}
+
+// maybeCleanup runs Cleanup if the cleanup interval has passed since the last
+// one.  Failures are ignored: the rows are retried next time.
+func (c *Cache[V]) maybeCleanup(ctx context.Context) {
+	if c.cleanup <= 0 {
+		return
+	}
+	now := time.Now()
+	next := c.nextCleanup.Load()
+	if now.UnixNano() < next || !c.nextCleanup.CompareAndSwap(next, now.Add(c.cleanup).UnixNano()) {
+		return
+	}
+	c.Cleanup(ctx)
+}
+
+// arg returns the placeholder for the nth query argument, counting from one
+func (c *Cache[V]) arg(n int) string {
+	if c.dialect == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// expiration returns the unix time after which the row for item is dead
+func (c *Cache[V]) expiration(item stampede.Item[V]) int64 {
+	if !item.HardExpiry.IsZero() {
+		// the item is useless past its hard expiry
+		return item.HardExpiry.Unix()
+	}
+	if item.Expiry.IsZero() {
+		// never expires
+		return math.MaxInt64
+	}
+	return item.Expiry.Add(c.grace).Unix()
+}
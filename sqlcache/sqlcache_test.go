@@ -0,0 +1,113 @@
+package sqlcache_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/sqlcache"
+	_ "modernc.org/sqlite"
+)
+
+// open returns an in-memory SQLite database, closed when the test ends
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each connection would have its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newCache(t *testing.T, db *sql.DB, opts ...sqlcache.Option) *sqlcache.Cache[string] {
+	t.Helper()
+	c := sqlcache.New(db, sqlcache.SQLite, stampede.JSONCodec[string]{}, opts...)
+	if err := c.CreateTable(context.Background()); err != nil {
+		t.Fatalf("CreateTable = %v", err)
+	}
+	return c
+}
+
+// rows returns the number of rows in table
+func rows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCache(t *testing.T) {
+	db := open(t)
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return newCache(t, db)
+	})
+}
+
+func TestDeadRows(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	c := newCache(t, db, sqlcache.WithTable("cache"), sqlcache.WithCleanupInterval(0))
+	if err := newCache(t, db, sqlcache.WithTable("cache")).CreateTable(ctx); err != nil {
+		t.Fatalf("second CreateTable = %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	c.Set(ctx, "expired", stampede.Item[string]{Value: "v", Expiry: past})
+	c.Set(ctx, "hard", stampede.Item[string]{Value: "v", Expiry: time.Now().Add(time.Hour), HardExpiry: past})
+	c.Set(ctx, "live", stampede.Item[string]{Value: "v", Expiry: time.Now().Add(time.Hour)})
+	c.Set(ctx, "forever", stampede.Item[string]{Value: "v"})
+
+	for _, key := range []string{"expired", "hard"} {
+		if _, err := c.Get(ctx, key); !errors.Is(err, stampede.ErrCacheMiss) {
+			t.Errorf("Get(%q) = %v, want ErrCacheMiss", key, err)
+		}
+	}
+	if items, err := c.GetMulti(ctx, []string{"expired", "live", "forever"}); err != nil || len(items) != 2 {
+		t.Errorf("GetMulti = %v, %v; want the live items", items, err)
+	}
+
+	if n := rows(t, db, "cache"); n != 4 {
+		t.Fatalf("%d rows before cleanup, want 4", n)
+	}
+	if err := c.Cleanup(ctx); err != nil {
+		t.Fatalf("Cleanup = %v", err)
+	}
+	if n := rows(t, db, "cache"); n != 2 {
+		t.Errorf("%d rows after cleanup, want 2", n)
+	}
+}
+
+func TestGrace(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	c := newCache(t, db, sqlcache.WithGrace(time.Hour))
+
+	past := time.Now().Add(-time.Minute)
+	c.Set(ctx, "k", stampede.Item[string]{Value: "v", Expiry: past})
+	if item, err := c.Get(ctx, "k"); err != nil || item.Value != "v" {
+		t.Errorf("Get within the grace period = %+v, %v", item, err)
+	}
+}
+
+func TestCleanupOnSet(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	c := newCache(t, db, sqlcache.WithCleanupInterval(time.Hour))
+
+	// the first Set cleans up, and the next not for an hour
+	c.Set(ctx, "a", stampede.Item[string]{Value: "v"})
+	c.Set(ctx, "expired", stampede.Item[string]{Value: "v", Expiry: time.Now().Add(-time.Minute)})
+	c.SetMulti(ctx, map[string]stampede.Item[string]{"b": {Value: "v"}})
+	if n := rows(t, db, "stampede_cache"); n != 3 {
+		t.Errorf("%d rows, want the expired row kept until the next cleanup", n)
+	}
+}
@@ -5,68 +5,612 @@
 package stampede
 
 import (
+	"context"
+	"errors"
 	"math"
-	"math/rand"
+	"runtime/debug"
 	"time"
 )
 
-// Item is a cache item
-type Item struct {
-	Value  interface{}
-	Expiry time.Time
-	Delta  time.Duration
+// Item is a cache item.  Expiry is the soft expiry, which the XFetch
+// algorithm recomputes ahead of, or zero if the item does not expire;
+// HardExpiry, if set, is when the item stops being served at all.
+type Item[V any] struct {
+	Value      V
+	Expiry     time.Time
+	HardExpiry time.Time
+	Delta      time.Duration
+
+	// Created is when the value was computed
+	Created time.Time
+
+	// Err is set if the item is a cached error; see CacheError
+	Err error `json:"-"`
+
+	// Pending is set if the item is a placeholder; see WithPlaceholders
+	Pending *Pending
 }
 
 // Cache is the interface to the backing cache
-type Cache interface {
-	// Cache Read
-	Get(key string) (Item, error)
+type Cache[K comparable, V any] interface {
+	// Cache Read.  Returns ErrCacheMiss if key is not present; any other
+	// error indicates a backend failure.
+	Get(ctx context.Context, key K) (Item[V], error)
 
 	// Cache Write
-	Set(key string, item Item) error
+	Set(ctx context.Context, key K, item Item[V]) error
 }
 
-// XFetcher provides stampede protection for items in a cache
-type XFetcher struct {
-	cache Cache
-	r     *rand.Rand
-	beta  float64
+// RecomputeFunc computes the value for a key, returning also the desired
+// time-to-live and any error.  A TTL of NoExpiry keeps the value until it is
+// refreshed, invalidated or evicted.  A zero TTL, unless replaced by
+// WithDefaultTTL or WithTTLClamp, does not cache the value, but it is still
+// shared by concurrent fetches, so the fetcher can serve purely to deduplicate
+// requests.
+type RecomputeFunc[V any] func(ctx context.Context) (value V, ttl time.Duration, err error)
+
+// XFetcher provides stampede protection for items in a cache.  It is safe for
+// concurrent use by multiple goroutines, provided the underlying Cache is.
+type XFetcher[K comparable, V any] struct {
+	cache   Cache[K, V]
+	flight  *flightGroup[K, V]
+	metrics Metrics[K]
+	tracer  Tracer[K]
+	hooks   Hooks[K]
+
+	betaFunc  func(key K) float64
+	costFunc  func(key K) float64
+	strategy  Strategy[V]
+	fallback  func(ctx context.Context, key K, err error) (V, error)
+	breakers  *breakerGroup[K]
+	locker    Locker[K]
+	slots     *slotPool
+	writes    chan writeJob[K, V]
+	refreshes *refreshQueue[K, V]
+	limiters  []*rateLimiter[K]
+	stats     fetcherStats
+	keyStats  *keyStats[K]
+	analysis  *analysis[K]
+	inflight  inflight[K]
+	latencies *latencies
+	life      lifecycle
+	held      heldLocks[K]
+
+	config
+}
+
+// NoExpiry is the TTL of values which never expire, such as configuration.
+// Their items have a zero Expiry and no hard expiry, and are never recomputed
+// early; only Refresh, invalidation or eviction replaces them.  It is still
+// bounded by WithTTLClamp.
+const NoExpiry time.Duration = math.MaxInt64
+
+// Expired reports whether the item has passed its soft expiry at now.  Items
+// with a zero Expiry never expire.
+func (item Item[V]) Expired(now time.Time) bool {
+	return !item.Expiry.IsZero() && !now.Before(item.Expiry)
 }
 
+// Beta is the default beta parameter
 const Beta = 1
 
-// New returns a new XFetcher protecting the cache.  The beta parameter
-// controls early expiration vs. stampede prevention.  1 is a good default.
-// For more information, see the referenced paper.
-func New(cache Cache, beta float64) *XFetcher {
-	return &XFetcher{
-		cache: cache,
-		r:     rand.New(rand.NewSource(time.Now().UnixNano())),
-		beta:  beta,
+// New returns a new XFetcher protecting the cache, configured by opts.
+// Beta controls early expiration vs. stampede prevention; see WithBeta and
+// the referenced paper.
+func New[K comparable, V any](cache Cache[K, V], opts ...Option) *XFetcher[K, V] {
+	c := defaultConfig()
+	for _, o := range opts {
+		o(&c)
+	}
+	return newFetcher(cache, c)
+}
+
+// newFetcher returns an XFetcher for cache with the settings in c
+func newFetcher[K comparable, V any](cache Cache[K, V], c config) *XFetcher[K, V] {
+	xf := &XFetcher[K, V]{
+		cache:   cache,
+		metrics: typed[Metrics[K]]("WithMetrics", c.metrics, nopMetrics[K]{}),
+		tracer:  typed[Tracer[K]]("WithTracer", c.tracer, nopTracer[K]{}),
+		hooks:   typed[Hooks[K]]("WithHooks", c.hooks, Hooks[K]{}),
+
+		betaFunc: typed[func(K) float64]("WithBetaFunc", c.betaFunc, nil),
+		costFunc: typed[func(K) float64]("WithCostFunc", c.cost, nil),
+		locker:   typed[Locker[K]]("WithLocker", c.locker, nil),
+		strategy: typed[Strategy[V]]("WithStrategy", c.strategy, nil),
+		fallback: typed[func(context.Context, K, error) (V, error)]("WithFallback", c.fallback, nil),
+
+		config: c,
+	}
+	xf.life.done = make(chan struct{})
+	for _, rl := range c.rateLimits {
+		xf.limiters = append(xf.limiters, &rateLimiter[K]{
+			rate:   rl.rate,
+			burst:  float64(max(rl.burst, 1)),
+			policy: rl.policy,
+			group:  typed[func(K) string]("WithKeyRateLimit", rl.group, nil),
+			m:      make(map[string]*bucket),
+		})
+	}
+	if c.maxRecomputes > 0 {
+		xf.slots = newSlotPool(c.maxRecomputes)
+	}
+	if c.asyncWorkers > 0 {
+		xf.startWriters()
+	}
+	if c.refreshWorkers > 0 {
+		xf.startRefreshers()
 	}
+	if c.keySample > 0 && c.maxKeyStats > 0 {
+		xf.keyStats = newKeyStats[K](c.keySample, c.maxKeyStats, c.float64)
+	}
+	if c.latencyWindow > 0 {
+		xf.latencies = newLatencies(c.latencyWindow, c.clock)
+	}
+	if c.analysis {
+		xf.analysis = newAnalysis(typed[func(K) string]("WithAnalysis", c.analysisGroup, nil), c.clock.Now())
+	}
+	if c.singleflight {
+		xf.flight = &flightGroup[K, V]{}
+	}
+	if c.breakerThreshold > 0 {
+		xf.breakers = &breakerGroup[K]{
+			threshold: c.breakerThreshold,
+			cooldown:  c.breakerCooldown,
+			group:     typed[func(K) string]("WithCircuitBreaker", c.breakerGroup, nil),
+			m:         make(map[string]*breaker),
+		}
+		if bm, ok := xf.metrics.(BreakerMetrics); ok {
+			xf.breakers.onChange = bm.BreakerStateChange
+		}
+	}
+	return xf
 }
 
 // Fetch retrieves `key`, recomputing it if needed.  The `recompute` function
 // should compute the value for key, returning also the desired time-to-live and any
-// error.
-func (xf *XFetcher) Fetch(key string, recompute func() (value interface{}, ttl time.Duration, err error)) (interface{}, error) {
+// error.  The context is passed through to the cache and to `recompute`.
+//
+// A cache read failure other than ErrCacheMiss is passed to the handler set
+// with WithReadErrorHandler, if any, and otherwise treated as a miss.
+//
+// If `recompute` fails and the cache holds a value that expired no longer ago
+// than the bound set with WithStaleIfError, that stale value is returned
+// instead of the error.
+//
+// With WithStaleWhileRevalidate, an early expiration of a value which has not
+// yet actually expired returns that value immediately and recomputes it in the
+// background.
+//
+// A cache write failure is handled according to the WriteFailurePolicy; see
+// WithWriteFailurePolicy.
+//
+// A panic in `recompute` is recovered and treated as a failure with a
+// *RecomputePanicError.
+//
+// Concurrent calls in this process which decide to recompute the same key
+// share a single call to `recompute`, unless disabled with WithSingleflight.
+func (xf *XFetcher[K, V]) Fetch(ctx context.Context, key K, recompute RecomputeFunc[V], opts ...FetchOption) (V, error) {
+	r, err := xf.fetchTraced(ctx, key, recompute, opts)
+	return r.Value, err
+}
+
+// FetchWithBeta is like Fetch, but uses beta for this call in place of the
+// fetcher's beta.  It is shorthand for Fetch with WithFetchBeta.
+func (xf *XFetcher[K, V]) FetchWithBeta(ctx context.Context, key K, beta float64, recompute RecomputeFunc[V]) (V, error) {
+	return xf.Fetch(ctx, key, recompute, WithFetchBeta(beta))
+}
+
+// FetchItem is like Fetch, but returns the value along with its metadata and
+// where it was served from.
+func (xf *XFetcher[K, V]) FetchItem(ctx context.Context, key K, recompute RecomputeFunc[V], opts ...FetchOption) (Result[V], error) {
+	return xf.fetchTraced(ctx, key, recompute, opts)
+}
+
+// Refresh recomputes key and writes it to the cache regardless of the cached
+// item's expiry, e.g. after a known change to the underlying data.  It is
+// Fetch with WithBypassCache.
+func (xf *XFetcher[K, V]) Refresh(ctx context.Context, key K, recompute RecomputeFunc[V]) (V, error) {
+	return xf.Fetch(ctx, key, recompute, WithBypassCache())
+}
 
-	item, err := xf.cache.Get(key)
+func (xf *XFetcher[K, V]) fetchTraced(ctx context.Context, key K, recompute RecomputeFunc[V], opts []FetchOption) (Result[V], error) {
+	fc := fetchConfig{beta: -1, timeout: xf.recomputeTimeout, defaultTTL: xf.defaultTTL, staleIfError: xf.staleIfError, latencyBudget: xf.latencyBudget}
+	for _, o := range opts {
+		o(&fc)
+	}
+	if fc.beta < 0 {
+		fc.beta = xf.betaFor(key)
+	}
+	ctx = xf.withCall(ctx, &fc)
+
+	ctx, span := xf.tracer.StartFetch(ctx, key)
+	r, err := xf.fetch(ctx, key, recompute, &fc, span)
+	span.End(err)
+	return xf.fallbackFor(ctx, key, r, err)
+}
 
-	if err != nil || time.Now().Add(-time.Duration(float64(item.Delta)*xf.beta*math.Log(xf.r.Float64()))).After(item.Expiry) {
-		start := time.Now()
-		value, ttl, err := recompute()
-		if err != nil {
-			return nil, err
+func (xf *XFetcher[K, V]) fetch(ctx context.Context, key K, recompute RecomputeFunc[V], fc *fetchConfig, span Span) (Result[V], error) {
+
+	if fc.bypass {
+		item, err := xf.compute(ctx, key, recompute, nil)
+		return xf.result(item, SourceRecompute), err
+	}
+
+	start := xf.latencies.start()
+	item, err := xf.cache.Get(ctx, key)
+	xf.latencies.since(opCacheGet, start)
+	if err := xf.readFailed(key, err); err != nil {
+		return Result[V]{}, err
+	}
+
+	found := err == nil
+	if found && item.Pending != nil {
+		if res, done, err := xf.awaitPending(ctx, key, &item, &found); done {
+			return res, err
 		}
-		item = Item{
-			Value:  value,
-			Expiry: time.Now().Add(ttl),
-			Delta:  time.Since(start),
+	}
+
+	now := xf.clock.Now()
+	if found && hardExpired(item, now) {
+		found = false
+	}
+	info := FetchInfo{Decision: DecisionMiss, Beta: fc.beta}
+	if found {
+		info.Delta, info.TTL = item.Delta, ttlLeft(item, now)
+	}
+
+	if found && (fc.explain || xf.sampleExplain()) {
+		info.Explain = &Explanation{}
+	}
+	if found && !xf.shouldRecompute(item, now, fc.beta, info.Explain) {
+		info.Decision = DecisionHit
+		xf.observeLookup(ctx, key, info, now, span)
+		return xf.result(item, SourceCache), item.Err
+	}
+
+	early := found && !item.Expired(now)
+	if early {
+		info.Decision = DecisionEarlyExpire
+	}
+	xf.observeLookup(ctx, key, info, now, span)
+
+	var prev *Item[V]
+	if found {
+		prev = &item
+	}
+
+	if early && xf.staleWhileRevalidate {
+		xf.revalidate(ctx, key, recompute, prev)
+		return xf.result(item, SourceCache), item.Err
+	}
+
+	var fresh Item[V]
+	var shared bool
+	if found && fc.latencyBudget > 0 {
+		r, late := xf.budgetedRecompute(ctx, key, recompute, prev, fc)
+		if late {
+			return xf.serveStale(ctx, key, item, errOverBudget), item.Err
 		}
-		// TODO(dgryski): Determine behaviour on cache write failure
-		_ /* err */ = xf.cache.Set(key, item)
+		fresh, shared, err = r.item, r.shared, r.err
+	} else {
+		fresh, shared, err = xf.recompute(ctx, key, recompute, prev)
+	}
+	if xf.adaptive != nil {
+		xf.adaptive.observe(early, shared)
+	}
+	if err != nil {
+		if found && xf.canServeStale(item, err, fc.staleIfError) {
+			return xf.serveStale(ctx, key, item, err), item.Err
+		}
+		return xf.result(fresh, SourceRecompute), err
 	}
 
-	return item.Value, nil
+	return xf.result(fresh, SourceRecompute), nil
+}
+
+// readFailed handles err from a cache read of key.  A non-nil return should
+// fail the fetch.
+func (xf *XFetcher[K, V]) readFailed(key K, err error) error {
+	if err == nil || errors.Is(err, ErrCacheMiss) {
+		return nil
+	}
+	xf.metrics.ReadFailure(key)
+	xf.stats.readFailures.Add(1)
+	if xf.readError == nil {
+		return nil
+	}
+	return xf.readError(&CacheReadError{Key: key, Err: err})
+}
+
+// betaFor returns the beta for key, from the WithBetaFunc policy or
+// WithAdaptiveBeta controller if set
+func (xf *XFetcher[K, V]) betaFor(key K) float64 {
+	if xf.betaFunc != nil {
+		return xf.betaFunc(key)
+	}
+	if xf.adaptive != nil {
+		return xf.adaptive.Beta()
+	}
+	return xf.beta
+}
+
+// shouldRecompute makes the early expiration decision for item at time now,
+// with the WithStrategy strategy if set and otherwise XFetch.  The inputs and
+// decision are recorded in ex, if not nil.
+func (xf *XFetcher[K, V]) shouldRecompute(item Item[V], now time.Time, beta float64, ex *Explanation) bool {
+	delta := xf.clampDelta(item.Delta)
+	if ex != nil {
+		*ex = Explanation{Delta: delta, TTL: ttlLeft(item, now), Beta: beta}
+	}
+	if item.Expiry.IsZero() {
+		return false
+	}
+	if xf.strategy != nil {
+		recompute := !now.Before(item.Expiry) || xf.strategy.ShouldRecompute(item, now)
+		if ex != nil {
+			ex.Strategy, ex.Recompute = true, recompute
+		}
+		return recompute
+	}
+	rnd := xf.float64()
+	recompute := ShouldEarlyExpire(delta, beta, item.Expiry, now, rnd)
+	if ex != nil {
+		ex.Rand, ex.Gap, ex.Recompute = rnd, earlyGap(delta, beta, rnd), recompute
+	}
+	return recompute
+}
+
+// sampleExplain reports whether a fetch is sampled by WithExplain to record an
+// Explanation
+func (xf *XFetcher[K, V]) sampleExplain() bool {
+	return xf.explainSample > 0 && xf.float64() < xf.explainSample
+}
+
+// recompute runs recompute for key, coalescing with any in-flight call, in
+// which case shared is set.  prev is the cached item, if any.
+func (xf *XFetcher[K, V]) recompute(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) (item Item[V], shared bool, err error) {
+	if xf.flight == nil {
+		item, err = xf.lockedCompute(ctx, key, recompute, prev)
+		return item, false, err
+	}
+	return xf.flight.do(ctx, key, func() (Item[V], error) {
+		return xf.lockedCompute(ctx, key, recompute, prev)
+	})
+}
+
+// compute calls recompute and stores the result in the cache
+func (xf *XFetcher[K, V]) compute(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) (Item[V], error) {
+	item, _, err := xf.computeStored(ctx, key, recompute, prev)
+	return item, err
+}
+
+// computeStored is compute, reporting also whether the result was written or
+// queued to be written to the cache
+func (xf *XFetcher[K, V]) computeStored(ctx context.Context, key K, recompute RecomputeFunc[V], prev *Item[V]) (Item[V], bool, error) {
+	if xf.track() {
+		defer xf.life.wg.Done()
+	}
+	if err := xf.admit(ctx, key, prev); err != nil {
+		return Item[V]{}, false, err
+	}
+	defer xf.release()
+
+	xf.metrics.RecomputeStart(key)
+	defer xf.startRecompute(key)()
+	rctx, span := xf.tracer.StartRecompute(ctx, key)
+	start := xf.clock.Now()
+	value, ttl, hard, delta, err := retry(xf, rctx, recompute)
+	elapsed := xf.clock.Now().Sub(start)
+	dontCache := errors.Is(err, ErrDontCache)
+	if dontCache {
+		err = nil
+	}
+	span.End(err)
+	neg, negative := asNegative(err)
+	if xf.breakers != nil {
+		xf.breakers.record(key, err != nil && !negative, xf.clock.Now())
+	}
+	re := RecomputeEvent[K]{Key: key, Start: start, Duration: elapsed, TTL: ttl, Err: err}
+	fire(xf.hooks.OnRecompute, re)
+	xf.analysis.recomputed(re)
+	xf.stats.recomputed(elapsed, err)
+	xf.keyStats.recomputed(key, elapsed)
+	xf.latencies.observe(opRecompute, elapsed)
+	if err != nil {
+		xf.metrics.RecomputeFailure(key, elapsed)
+		xf.logRecomputeFailure(ctx, key, elapsed, err)
+		if !negative {
+			return Item[V]{}, false, &RecomputeError{Key: key, Err: err}
+		}
+		value, ttl = *new(V), neg.ttl
+	} else {
+		xf.metrics.RecomputeSuccess(key, elapsed)
+	}
+
+	ttl = xf.clampTTL(ttl, xf.callSettings(ctx).defaultTTL)
+	if ttl == 0 {
+		// the value is only shared with concurrent fetches
+		dontCache = true
+	}
+	ttl = xf.jitter(ttl)
+	now := xf.clock.Now()
+	item := Item[V]{
+		Value:      value,
+		Expiry:     expiry(now, ttl),
+		HardExpiry: xf.hardExpiry(now, ttl, hard),
+		Delta:      xf.smoothDelta(delta, prev),
+		Created:    now,
+	}
+	if negative {
+		item.Err = neg.err
+	}
+	if dontCache {
+		return item, false, err
+	}
+	if werr := xf.write(ctx, key, item); werr != nil {
+		return item, false, werr
+	}
+	// err is nil or the CacheError, which callers see through
+	return item, true, err
+}
+
+// admit lets a recompute of key through the circuit breakers, rate limits and
+// WithMaxConcurrentRecomputes slots.  If it succeeds the caller must release
+// the slot, and record the outcome with the breakers.
+func (xf *XFetcher[K, V]) admit(ctx context.Context, key K, prev *Item[V]) error {
+	trial, err := xf.pass(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := xf.acquire(ctx, xf.urgency(key, prev)); err != nil {
+		if trial {
+			xf.breakers.abandon(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// pass lets a recompute of key through the circuit breakers and rate limits,
+// reporting whether it is the trial of a half-open breaker
+func (xf *XFetcher[K, V]) pass(ctx context.Context, key K) (trial bool, err error) {
+	if xf.breakers != nil {
+		var ok bool
+		if ok, trial = xf.breakers.allow(key, xf.clock.Now()); !ok {
+			return false, ErrCircuitOpen
+		}
+	}
+	for _, l := range xf.limiters {
+		if err := l.wait(ctx, key, xf.clock); err != nil {
+			if trial {
+				xf.breakers.abandon(key)
+			}
+			return false, err
+		}
+	}
+	return trial, nil
+}
+
+// invoke calls recompute, bounded by the WithRecomputeTimeout setting,
+// returning also any hard time-to-live it set.  On timeout the recompute is
+// abandoned and left to finish in the background.
+func invoke[K comparable, V, T any](xf *XFetcher[K, V], ctx context.Context, recompute RecomputeFunc[T]) (T, time.Duration, time.Duration, error) {
+	timeout := xf.callSettings(ctx).timeout
+	if timeout <= 0 {
+		hctx, hard := withHardTTL(ctx)
+		value, ttl, err := protect(hctx, recompute)
+		return value, ttl, hard.get(), err
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	hctx, hard := withHardTTL(tctx)
+
+	type result struct {
+		value T
+		ttl   time.Duration
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.value, r.ttl, r.err = protect(hctx, recompute)
+		done <- r
+	}()
+
+	// a timeout is ours only if the caller's context is still live
+	select {
+	case r := <-done:
+		if r.err != nil && tctx.Err() != nil && ctx.Err() == nil {
+			r.err = ErrRecomputeTimeout
+		}
+		return r.value, r.ttl, hard.get(), r.err
+	case <-tctx.Done():
+		var zero T
+		if ctx.Err() != nil {
+			return zero, 0, 0, ctx.Err()
+		}
+		return zero, 0, 0, ErrRecomputeTimeout
+	}
+}
+
+// protect calls recompute, converting a panic into a *RecomputePanicError
+func protect[V any](ctx context.Context, recompute RecomputeFunc[V]) (value V, ttl time.Duration, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			var zero V
+			value, ttl, err = zero, 0, &RecomputePanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return recompute(ctx)
+}
+
+// canServeStale reports whether item may be served in place of a recompute
+// which failed with err.  Timeouts, open circuit breakers, recompute locks
+// held elsewhere and, if so configured, capacity and rate limits serve stale
+// values of any age; other recompute errors are bounded by staleIfError.
+// Nothing is served past its hard expiry.
+func (xf *XFetcher[K, V]) canServeStale(item Item[V], err error, staleIfError time.Duration) bool {
+	var werr *CacheWriteError
+	if _, negative := asNegative(err); negative || errors.As(err, &werr) {
+		return false
+	}
+	if hardExpired(item, xf.clock.Now()) {
+		return false
+	}
+	if errors.Is(err, ErrRecomputeTimeout) || errors.Is(err, ErrCircuitOpen) || err == errLockHeld {
+		return true
+	}
+	if staleAllowed(err) {
+		return true
+	}
+	return staleIfError > 0 && (item.Expiry.IsZero() || xf.clock.Now().Sub(item.Expiry) <= staleIfError)
+}
+
+// clampTTL replaces a zero ttl with def, then bounds it by the WithTTLClamp
+// setting
+func (xf *XFetcher[K, V]) clampTTL(ttl, def time.Duration) time.Duration {
+	if ttl == 0 {
+		ttl = def
+	}
+	ttl = max(ttl, xf.minTTL)
+	if xf.maxTTL > 0 {
+		ttl = min(ttl, xf.maxTTL)
+	}
+	return ttl
+}
+
+// expiry returns the expiry of an item written at now with ttl
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl == NoExpiry {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// jitter randomizes ttl by the WithTTLJitter setting
+func (xf *XFetcher[K, V]) jitter(ttl time.Duration) time.Duration {
+	j := float64(xf.jitterAbs) + xf.jitterFrac*float64(ttl)
+	if j <= 0 || ttl == NoExpiry {
+		return ttl
+	}
+	return max(ttl+time.Duration((2*xf.float64()-1)*j), 1)
+}
+
+// clampDelta bounds delta by the WithDeltaFloor and WithDeltaCap settings
+func (xf *XFetcher[K, V]) clampDelta(delta time.Duration) time.Duration {
+	delta = max(delta, xf.deltaFloor)
+	if xf.deltaCap > 0 {
+		delta = min(delta, xf.deltaCap)
+	}
+	return delta
+}
+
+// smoothDelta returns the delta to store for a recompute which took sample,
+// given the previously cached item, if any
+func (xf *XFetcher[K, V]) smoothDelta(sample time.Duration, prev *Item[V]) time.Duration {
+	if prev == nil || xf.deltaAlpha <= 0 || xf.deltaAlpha >= 1 {
+		return sample
+	}
+	return time.Duration(xf.deltaAlpha*float64(sample) + (1-xf.deltaAlpha)*float64(prev.Delta))
 }
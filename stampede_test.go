@@ -0,0 +1,539 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+// brokenCache is a Cache whose every operation fails
+type brokenCache struct{}
+
+var errBackend = errors.New("backend down")
+
+func (brokenCache) Get(ctx context.Context, key string) (stampede.Item[int], error) {
+	return stampede.Item[int]{}, errBackend
+}
+
+func (brokenCache) Set(ctx context.Context, key string, item stampede.Item[int]) error {
+	return errBackend
+}
+
+// counting returns a RecomputeFunc returning the number of calls so far, with
+// a TTL of ttl
+func counting(calls *atomic.Int32, ttl time.Duration) stampede.RecomputeFunc[int] {
+	return func(ctx context.Context) (int, time.Duration, error) {
+		return int(calls.Add(1)), ttl, nil
+	}
+}
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	clock := fakeclock.New(start)
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache, stampede.WithClock(clock), stampede.WithRand(stampede.NeverExpire))
+
+	var calls atomic.Int32
+	recompute := func(ctx context.Context) (int, time.Duration, error) {
+		clock.Advance(time.Second)
+		return counting(&calls, time.Minute)(ctx)
+	}
+	r, err := xf.FetchItem(ctx, "k", recompute)
+	if err != nil || r.Value != 1 || r.Source != stampede.SourceRecompute {
+		t.Fatalf("FetchItem on miss = %+v, %v", r, err)
+	}
+
+	item, err := cache.Get(ctx, "k")
+	if err != nil {
+		t.Fatalf("Get = %v", err)
+	}
+	done := start.Add(time.Second)
+	if item.Value != 1 || !item.Created.Equal(done) || !item.Expiry.Equal(done.Add(time.Minute)) || item.Delta != time.Second {
+		t.Errorf("cached item = %+v, want value 1 created at %v with a minute's TTL and a second's delta", item, done)
+	}
+
+	clock.Advance(30 * time.Second)
+	r, err = xf.FetchItem(ctx, "k", recompute)
+	if err != nil || r.Value != 1 || r.Source != stampede.SourceCache || r.Age != 30*time.Second || r.TTL != 30*time.Second {
+		t.Fatalf("FetchItem on hit = %+v, %v", r, err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if v, err := xf.Fetch(ctx, "k", recompute); err != nil || v != 2 {
+		t.Fatalf("Fetch after expiry = %v, %v; want a recompute", v, err)
+	}
+}
+
+func TestFetchSingleflight(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		ctx := context.Background()
+		xf := stampede.New[string, int](testutil.NullCache[string, int]{}, stampede.WithSingleflight(enabled))
+
+		const n = 10
+		var calls atomic.Int32
+		var started sync.WaitGroup
+		started.Add(n)
+		unblock := make(chan struct{})
+		recompute := func(ctx context.Context) (int, time.Duration, error) {
+			calls.Add(1)
+			<-unblock
+			return 1, time.Minute, nil
+		}
+
+		var wg sync.WaitGroup
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				started.Done()
+				if v, err := xf.Fetch(ctx, "k", recompute); err != nil || v != 1 {
+					t.Errorf("Fetch = %v, %v", v, err)
+				}
+			}()
+		}
+		started.Wait()
+		// let the fetches reach the recompute
+		time.Sleep(20 * time.Millisecond)
+		close(unblock)
+		wg.Wait()
+
+		want := int32(n)
+		if enabled {
+			want = 1
+		}
+		if calls.Load() != want {
+			t.Errorf("singleflight %v: %d recomputes, want %d", enabled, calls.Load(), want)
+		}
+	}
+}
+
+func TestFetchCachesErrors(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache)
+
+	errNotFound := errors.New("not found")
+	var calls atomic.Int32
+	recompute := func(ctx context.Context) (int, time.Duration, error) {
+		calls.Add(1)
+		return 0, 0, stampede.CacheError(errNotFound, time.Minute)
+	}
+	for range 2 {
+		if _, err := xf.Fetch(ctx, "k", recompute); !errors.Is(err, errNotFound) {
+			t.Fatalf("Fetch = %v, want %v", err, errNotFound)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d recomputes of a cached error, want 1", calls.Load())
+	}
+}
+
+func TestFetchDontCache(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache)
+
+	v, err := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		return 2, time.Minute, stampede.ErrDontCache
+	})
+	if err != nil || v != 2 {
+		t.Fatalf("Fetch = %v, %v; want 2", v, err)
+	}
+	if _, err := cache.Get(ctx, "k"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("value was cached: %v", err)
+	}
+}
+
+func TestFetchRecomputeError(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int]())
+
+	_, err := xf.Fetch(ctx, "k", failing)
+	var rerr *stampede.RecomputeError
+	if !errors.As(err, &rerr) || rerr.Key != "k" || !errors.Is(err, errOrigin) {
+		t.Fatalf("Fetch = %v, want a RecomputeError of k wrapping %v", err, errOrigin)
+	}
+
+	_, err = xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		panic("boom")
+	})
+	var perr *stampede.RecomputePanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Fatalf("Fetch of panicking recompute = %v, want a RecomputePanicError", err)
+	}
+}
+
+func TestFetchStaleIfError(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithStaleIfError(time.Minute),
+	)
+
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(2 * time.Minute)
+	r, err := xf.FetchItem(ctx, "k", failing)
+	if err != nil || r.Value != 1 || r.Source != stampede.SourceStale || r.TTL != -time.Minute {
+		t.Fatalf("FetchItem within the stale bound = %+v, %v", r, err)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := xf.Fetch(ctx, "k", failing); !errors.Is(err, errOrigin) {
+		t.Fatalf("Fetch beyond the stale bound = %v, want %v", err, errOrigin)
+	}
+
+	// the per-fetch bound overrides the fetcher's
+	r, err = xf.FetchItem(ctx, "k", failing, stampede.WithFetchStaleIfError(time.Hour))
+	if err != nil || r.Source != stampede.SourceStale {
+		t.Fatalf("FetchItem with WithFetchStaleIfError = %+v, %v", r, err)
+	}
+}
+
+func TestFetchStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache,
+		stampede.WithStaleWhileRevalidate(true),
+		stampede.WithRand(stampede.AlwaysExpire),
+	)
+
+	var calls atomic.Int32
+	xf.Fetch(ctx, "k", counting(&calls, time.Minute))
+
+	unblock := make(chan struct{})
+	refreshed := make(chan struct{})
+	v, err := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		defer close(refreshed)
+		<-unblock
+		return 2, time.Minute, nil
+	})
+	if err != nil || v != 1 {
+		t.Fatalf("Fetch of early expired value = %v, %v; want the cached 1 at once", v, err)
+	}
+	close(unblock)
+	<-refreshed
+	if err := xf.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if item, err := cache.Get(ctx, "k"); err != nil || item.Value != 2 {
+		t.Errorf("cached after revalidation = %+v, %v; want 2", item, err)
+	}
+}
+
+func TestFetchRecomputeTimeout(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRecomputeTimeout(10*time.Millisecond),
+	)
+
+	slow := func(ctx context.Context) (int, time.Duration, error) {
+		<-ctx.Done()
+		return 0, 0, ctx.Err()
+	}
+	if _, err := xf.Fetch(ctx, "k", slow); !errors.Is(err, stampede.ErrRecomputeTimeout) {
+		t.Fatalf("Fetch on miss = %v, want ErrRecomputeTimeout", err)
+	}
+
+	// a cached value is served however stale
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(time.Hour)
+	if r, err := xf.FetchItem(ctx, "k", slow); err != nil || r.Value != 1 || r.Source != stampede.SourceStale {
+		t.Fatalf("FetchItem with stale value = %+v, %v", r, err)
+	}
+
+	// the per-fetch timeout overrides the fetcher's
+	v, err := xf.Fetch(ctx, "other", func(ctx context.Context) (int, time.Duration, error) {
+		time.Sleep(30 * time.Millisecond)
+		return 2, time.Minute, nil
+	}, stampede.WithFetchTimeout(time.Second))
+	if err != nil || v != 2 {
+		t.Fatalf("Fetch with WithFetchTimeout = %v, %v", v, err)
+	}
+}
+
+func TestFetchReadError(t *testing.T) {
+	ctx := context.Background()
+
+	// by default a failed read is a miss
+	xf := stampede.New[string, int](brokenCache{})
+	if v, err := xf.Fetch(ctx, "k", succeeding); err != nil || v != 1 {
+		t.Fatalf("Fetch = %v, %v; want the recomputed 1", v, err)
+	}
+	if n := xf.Stats().ReadFailures; n != 1 {
+		t.Errorf("Stats().ReadFailures = %d, want 1", n)
+	}
+
+	xf = stampede.New[string, int](brokenCache{}, stampede.WithReadErrorHandler(func(err error) error { return err }))
+	_, err := xf.Fetch(ctx, "k", succeeding)
+	var rerr *stampede.CacheReadError
+	if !errors.As(err, &rerr) || rerr.Key != "k" || !errors.Is(err, errBackend) {
+		t.Fatalf("Fetch = %v, want a CacheReadError of k", err)
+	}
+}
+
+func TestFetchTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+
+	fixed := func(ttl time.Duration) stampede.RecomputeFunc[int] {
+		return func(ctx context.Context) (int, time.Duration, error) { return 1, ttl, nil }
+	}
+	tests := []struct {
+		name      string
+		opts      []stampede.Option
+		fetchOpts []stampede.FetchOption
+		ttl       time.Duration
+		want      time.Duration // -1 if not cached
+	}{
+		{"Zero", nil, nil, 0, -1},
+		{"NoExpiry", nil, nil, stampede.NoExpiry, 0},
+		{"Default", []stampede.Option{stampede.WithDefaultTTL(time.Minute)}, nil, 0, time.Minute},
+		{"FetchDefault", []stampede.Option{stampede.WithDefaultTTL(time.Minute)}, []stampede.FetchOption{stampede.WithFetchDefaultTTL(time.Hour)}, 0, time.Hour},
+		{"ClampMin", []stampede.Option{stampede.WithTTLClamp(time.Minute, time.Hour)}, nil, time.Second, time.Minute},
+		{"ClampMax", []stampede.Option{stampede.WithTTLClamp(time.Minute, time.Hour)}, nil, 2 * time.Hour, time.Hour},
+		{"ClampNoExpiry", []stampede.Option{stampede.WithTTLClamp(0, time.Hour)}, nil, stampede.NoExpiry, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := memcache.New[string, int]()
+			xf := stampede.New[string, int](cache, append(tt.opts, stampede.WithClock(clock))...)
+			if _, err := xf.Fetch(ctx, "k", fixed(tt.ttl), tt.fetchOpts...); err != nil {
+				t.Fatalf("Fetch = %v", err)
+			}
+			item, err := cache.Get(ctx, "k")
+			if tt.want < 0 {
+				if !errors.Is(err, stampede.ErrCacheMiss) {
+					t.Fatalf("value cached: %+v, %v", item, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get = %v", err)
+			}
+			var got time.Duration
+			if !item.Expiry.IsZero() {
+				got = item.Expiry.Sub(clock.Now())
+			}
+			if got != tt.want {
+				t.Errorf("TTL %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchTTLJitter(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache, stampede.WithClock(clock), stampede.WithTTLJitter(time.Second, 0.1))
+
+	lo, hi := time.Duration(1<<62), time.Duration(0)
+	for i := range 200 {
+		key := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		xf.Fetch(ctx, key, func(ctx context.Context) (int, time.Duration, error) { return 1, 10 * time.Second, nil })
+		item, _ := cache.Get(ctx, key)
+		ttl := item.Expiry.Sub(clock.Now())
+		lo, hi = min(lo, ttl), max(hi, ttl)
+	}
+	// 10s ± (1s + 10% of 10s)
+	if lo < 8*time.Second || hi > 12*time.Second || hi-lo < 2*time.Second {
+		t.Errorf("jittered TTLs span [%v,%v], want most of [8s,12s]", lo, hi)
+	}
+}
+
+func TestFetchDelta(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache, stampede.WithClock(clock), stampede.WithDeltaSmoothing(0.5))
+
+	taking := func(d time.Duration) stampede.RecomputeFunc[int] {
+		return func(ctx context.Context) (int, time.Duration, error) {
+			clock.Advance(d)
+			return 1, time.Minute, nil
+		}
+	}
+	xf.Fetch(ctx, "k", taking(4*time.Second))
+	clock.Advance(time.Minute)
+	xf.Fetch(ctx, "k", taking(2*time.Second))
+	if item, _ := cache.Get(ctx, "k"); item.Delta != 3*time.Second {
+		t.Errorf("smoothed delta = %v, want 3s", item.Delta)
+	}
+}
+
+func TestFetchDeltaBounds(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	cache := memcache.New[string, int]()
+	expiry := clock.Now().Add(time.Minute)
+
+	// a draw of 1/e recomputes when delta*beta reaches the time left
+	draw := func() float64 { return 0.36787944117144233 }
+	fetch := func(delta time.Duration, opts ...stampede.Option) bool {
+		cache.Set(ctx, "k", stampede.Item[int]{Value: 1, Expiry: expiry, Delta: delta})
+		xf := stampede.New[string, int](cache, append(opts, stampede.WithClock(clock), stampede.WithRand(draw))...)
+		r, _ := xf.FetchItem(ctx, "k", succeeding)
+		return r.Source == stampede.SourceRecompute
+	}
+	if fetch(time.Second) {
+		t.Error("recomputed a minute early with a second's delta")
+	}
+	if !fetch(time.Second, stampede.WithDeltaFloor(2*time.Minute)) {
+		t.Error("delta floor did not recompute early")
+	}
+	if fetch(time.Hour, stampede.WithDeltaCap(time.Second)) {
+		t.Error("delta cap did not stop an early recompute")
+	}
+}
+
+func TestFetchBeta(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	cache := memcache.New[string, int]()
+	expiry := clock.Now().Add(time.Minute)
+	draw := func() float64 { return 0.36787944117144233 }
+
+	fetch := func(xf *stampede.XFetcher[string, int], key string, opts ...stampede.FetchOption) bool {
+		cache.Set(ctx, key, stampede.Item[int]{Value: 1, Expiry: expiry, Delta: time.Second})
+		r, _ := xf.FetchItem(ctx, key, succeeding, opts...)
+		return r.Source == stampede.SourceRecompute
+	}
+
+	xf := stampede.New[string, int](cache, stampede.WithClock(clock), stampede.WithRand(draw), stampede.WithBeta(60))
+	if !fetch(xf, "k") {
+		t.Error("beta 60 did not recompute a minute early")
+	}
+	if fetch(xf, "k", stampede.WithFetchBeta(1)) {
+		t.Error("WithFetchBeta(1) recomputed a minute early")
+	}
+	if v, _ := xf.FetchWithBeta(ctx, "k", 1, failing); v != 1 {
+		t.Error("FetchWithBeta(1) recomputed a minute early")
+	}
+
+	xf = stampede.New[string, int](cache, stampede.WithClock(clock), stampede.WithRand(draw),
+		stampede.WithBetaFunc(func(key string) float64 {
+			if key == "hot" {
+				return 60
+			}
+			return 1
+		}),
+	)
+	if !fetch(xf, "hot") || fetch(xf, "cold") {
+		t.Error("WithBetaFunc did not choose beta by key")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	cache := memcache.New[string, int]()
+	xf := stampede.New[string, int](cache)
+
+	var calls atomic.Int32
+	xf.Fetch(ctx, "k", counting(&calls, time.Hour))
+	if v, err := xf.Refresh(ctx, "k", counting(&calls, time.Hour)); err != nil || v != 2 {
+		t.Fatalf("Refresh = %v, %v; want 2", v, err)
+	}
+	if v, _ := xf.Fetch(ctx, "k", counting(&calls, time.Hour)); v != 2 {
+		t.Errorf("Fetch after Refresh = %v, want the refreshed 2", v)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	errFatal := errors.New("fatal")
+	tests := []struct {
+		name     string
+		policy   stampede.RetryPolicy
+		failures int
+		err      error
+		attempts int32
+		ok       bool
+	}{
+		{"Recovers", stampede.RetryPolicy{Attempts: 3}, 2, errOrigin, 3, true},
+		{"GivesUp", stampede.RetryPolicy{Attempts: 3}, 5, errOrigin, 3, false},
+		{"NotRetryable", stampede.RetryPolicy{Attempts: 3, Retryable: func(err error) bool { return err != errFatal }}, 5, errFatal, 1, false},
+		{"Backoff", stampede.RetryPolicy{Attempts: 2, Backoff: time.Millisecond, Jitter: 0.5}, 1, errOrigin, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xf := stampede.New[string, int](testutil.NullCache[string, int]{}, stampede.WithRetry(tt.policy))
+			var attempts atomic.Int32
+			_, err := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+				if int(attempts.Add(1)) <= tt.failures {
+					return 0, 0, tt.err
+				}
+				return 1, time.Minute, nil
+			})
+			if (err == nil) != tt.ok || attempts.Load() != tt.attempts {
+				t.Errorf("Fetch = %v after %d attempts, want success %v after %d", err, attempts.Load(), tt.ok, tt.attempts)
+			}
+		})
+	}
+
+	// cached errors are results, not failures
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithRetry(stampede.RetryPolicy{Attempts: 3}))
+	var attempts atomic.Int32
+	xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		attempts.Add(1)
+		return 0, 0, stampede.CacheError(errOrigin, time.Minute)
+	})
+	if attempts.Load() != 1 {
+		t.Errorf("CacheError retried: %d attempts", attempts.Load())
+	}
+}
+
+func TestRetryBackoffClock(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](testutil.NullCache[string, int]{},
+		stampede.WithClock(clock),
+		stampede.WithRetry(stampede.RetryPolicy{Attempts: 3, Backoff: time.Second}),
+	)
+
+	var attempts atomic.Int32
+	done := make(chan error)
+	go func() {
+		_, err := xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+			attempts.Add(1)
+			return 0, 0, errOrigin
+		})
+		done <- err
+	}()
+
+	// the backoffs double: one second, then two
+	waitFor(t, func() bool { return attempts.Load() == 1 && clock.Waiters() == 1 })
+	clock.Advance(time.Second)
+	waitFor(t, func() bool { return attempts.Load() == 2 && clock.Waiters() == 1 })
+	clock.Advance(time.Second)
+	if attempts.Load() != 2 {
+		t.Fatal("retried before the doubled backoff")
+	}
+	clock.Advance(time.Second)
+	if err := <-done; !errors.Is(err, errOrigin) || attempts.Load() != 3 {
+		t.Fatalf("Fetch = %v after %d attempts", err, attempts.Load())
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
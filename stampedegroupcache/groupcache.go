@@ -0,0 +1,120 @@
+// Package stampedegroupcache layers XFetch early expiration over groupcache
+package stampedegroupcache
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/golang/groupcache"
+)
+
+// LoadFunc computes the value for key and its desired time-to-live
+type LoadFunc[V any] func(ctx context.Context, key string) (value V, ttl time.Duration, err error)
+
+// Group is a groupcache group whose values are loaded through an XFetcher.
+//
+// groupcache values are immutable and never expire, so each key is asked of
+// the group under a version which changes every window.  The peer owning a
+// key loads it with the XFetcher, backed by that peer's own cache, which
+// decides when to recompute; the group only routes, coalesces and hot-caches
+// the reads of the other peers.  Values served through the group are thus up
+// to one window older than the owner's copy.  The windows of different keys
+// are offset from one another, so that they do not all roll over at once.
+//
+// Values are stored in the group as Item envelopes encoded with the codec,
+// so that readers see the expiry and creation time set by the owner.
+type Group[V any] struct {
+	group  *groupcache.Group
+	codec  stampede.Codec[V]
+	clock  stampede.Clock
+	window time.Duration
+}
+
+// An Option configures a Group
+type Option func(*config)
+
+type config struct {
+	window time.Duration
+	clock  stampede.Clock
+}
+
+// WithWindow sets how long a key's version lasts, bounding how stale the
+// copies hot-cached by other peers can be.  It should be small relative to
+// the TTLs of the values.  The default is 10s.
+func WithWindow(d time.Duration) Option {
+	return func(c *config) { c.window = d }
+}
+
+// WithClock sets the clock used to version keys.  Peers' clocks are assumed
+// to agree to within a fraction of the window.
+func WithClock(clock stampede.Clock) Option {
+	return func(c *config) { c.clock = clock }
+}
+
+// NewGroup creates the groupcache group name, holding up to cacheBytes of
+// values, which loads keys with xf and load
+func NewGroup[V any](name string, cacheBytes int64, xf *stampede.XFetcher[string, V], codec stampede.Codec[V], load LoadFunc[V], opts ...Option) *Group[V] {
+	cfg := config{window: 10 * time.Second, clock: stampede.SystemClock{}}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.window <= 0 {
+		cfg.window = time.Second
+	}
+
+	g := &Group[V]{codec: codec, clock: cfg.clock, window: cfg.window}
+	g.group = groupcache.NewGroup(name, cacheBytes, groupcache.GetterFunc(func(ctx context.Context, vkey string, dest groupcache.Sink) error {
+		_, key, _ := strings.Cut(vkey, "/")
+		r, err := xf.FetchItem(ctx, key, func(ctx context.Context) (V, time.Duration, error) {
+			return load(ctx, key)
+		})
+		if err != nil {
+			return err
+		}
+		b, err := codec.Marshal(stampede.Item[V]{
+			Value:      r.Value,
+			Expiry:     r.Expiry,
+			HardExpiry: r.HardExpiry,
+			Delta:      r.Delta,
+			Created:    r.Created,
+		})
+		if err != nil {
+			return err
+		}
+		return dest.SetBytes(b)
+	}))
+	return g
+}
+
+// Get returns the value for key
+func (g *Group[V]) Get(ctx context.Context, key string) (V, error) {
+	item, err := g.Item(ctx, key)
+	return item.Value, err
+}
+
+// Item returns the item for key, as last loaded by its owner
+func (g *Group[V]) Item(ctx context.Context, key string) (stampede.Item[V], error) {
+	var b []byte
+	if err := g.group.Get(ctx, g.version(key), groupcache.AllocatingByteSliceSink(&b)); err != nil {
+		return stampede.Item[V]{}, err
+	}
+	return g.codec.Unmarshal(b)
+}
+
+// Groupcache returns the underlying group, for its stats
+func (g *Group[V]) Groupcache() *groupcache.Group {
+	return g.group
+}
+
+// version returns the group key for key in the current window
+func (g *Group[V]) version(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	window := uint64(g.window)
+	v := (uint64(g.clock.Now().UnixNano()) + h.Sum64()%window) / window
+	return strconv.FormatUint(v, 36) + "/" + key
+}
@@ -0,0 +1,65 @@
+package stampedegroupcache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/stampedegroupcache"
+)
+
+func TestGroup(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	mc := memcache.New[string, int]()
+	xf := stampede.New[string, int](mc, stampede.WithClock(clock), stampede.WithRand(stampede.NeverExpire))
+
+	loads := 0
+	g := stampedegroupcache.NewGroup(t.Name(), 1<<20, xf, stampede.JSONCodec[int]{},
+		func(ctx context.Context, key string) (int, time.Duration, error) {
+			loads++
+			return loads, time.Minute, nil
+		},
+		stampedegroupcache.WithClock(clock),
+		stampedegroupcache.WithWindow(10*time.Second),
+	)
+
+	item, err := g.Item(ctx, "k")
+	if err != nil || item.Value != 1 || !item.Expiry.Equal(clock.Now().Add(time.Minute)) || !item.Created.Equal(clock.Now()) {
+		t.Fatalf("Item = %+v, %v", item, err)
+	}
+
+	// the group serves its copy until the window rolls over, even if the
+	// owner's cache changes
+	mc.Set(ctx, "k", stampede.Item[int]{Value: 100, Expiry: clock.Now().Add(time.Minute)})
+	if v, _ := g.Get(ctx, "k"); v != 1 {
+		t.Errorf("Get in the same window = %d, want the group's copy", v)
+	}
+	clock.Advance(10 * time.Second)
+	if v, _ := g.Get(ctx, "k"); v != 100 {
+		t.Errorf("Get in the next window = %d, want the owner's copy", v)
+	}
+
+	// the owner's XFetcher recomputes once its copy expires
+	clock.Advance(time.Minute)
+	if v, _ := g.Get(ctx, "k"); v != 2 || loads != 2 {
+		t.Errorf("Get after expiry = %d after %d loads, want a reload", v, loads)
+	}
+	if g.Groupcache().Name() != t.Name() {
+		t.Errorf("Groupcache().Name() = %q", g.Groupcache().Name())
+	}
+}
+
+func TestGroupError(t *testing.T) {
+	errBoom := errors.New("boom")
+	xf := stampede.New[string, int](memcache.New[string, int]())
+	g := stampedegroupcache.NewGroup(t.Name(), 1<<20, xf, stampede.JSONCodec[int]{},
+		func(ctx context.Context, key string) (int, time.Duration, error) { return 0, 0, errBoom })
+	if _, err := g.Get(context.Background(), "k"); !errors.Is(err, errBoom) {
+		t.Errorf("Get = %v, want the load's error", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Package stampedegrpc caches gRPC responses with stampede protection
+package stampedegrpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Method configures caching for one gRPC method
+type Method struct {
+	// Key derives the cache key from the request message.  If ok is
+	// false the call is not cached.
+	Key func(req any) (key string, ok bool)
+
+	// TTL is how long responses are cached
+	TTL time.Duration
+}
+
+// UnaryClientInterceptor returns an interceptor caching the responses of the
+// methods configured, keyed by full method name, in xf.  Responses are stored
+// in protobuf wire format; calls which fail are not cached.  Calls to other
+// methods are passed through.
+//
+// Concurrent calls for a key are served by a single invocation, made with
+// the context and call options of one of them.
+func UnaryClientInterceptor(xf *stampede.XFetcher[string, []byte], methods map[string]Method) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		m, ok := methods[method]
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		key, ok := m.Key(req)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		msg, ok := reply.(proto.Message)
+		if !ok {
+			return fmt.Errorf("stampedegrpc: reply for %s is %T, not a proto.Message", method, reply)
+		}
+
+		b, err := xf.Fetch(ctx, method+" "+key, func(ctx context.Context) ([]byte, time.Duration, error) {
+			if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+				return nil, 0, err
+			}
+			b, err := proto.Marshal(msg)
+			return b, m.TTL, err
+		})
+		if err != nil {
+			return err
+		}
+		return proto.Unmarshal(b, msg)
+	}
+}
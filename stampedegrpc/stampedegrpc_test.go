@@ -0,0 +1,96 @@
+package stampedegrpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/stampedegrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	cached   = "/svc.Service/Cached"
+	uncached = "/svc.Service/Uncached"
+)
+
+// invoker replies to a StringValue request with its value and the call
+// number, counting calls
+func invoker(calls *int, err error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if err != nil {
+			return err
+		}
+		reply.(*wrapperspb.StringValue).Value = req.(*wrapperspb.StringValue).Value + string(rune('0'+*calls))
+		return nil
+	}
+}
+
+func newInterceptor() grpc.UnaryClientInterceptor {
+	xf := stampede.New[string, []byte](memcache.New[string, []byte]())
+	return stampedegrpc.UnaryClientInterceptor(xf, map[string]stampedegrpc.Method{
+		cached: {
+			Key: func(req any) (string, bool) {
+				v := req.(*wrapperspb.StringValue).Value
+				return v, v != "skip"
+			},
+			TTL: time.Minute,
+		},
+	})
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	ctx := context.Background()
+	intercept := newInterceptor()
+	var calls int
+	call := func(method, req string) string {
+		t.Helper()
+		reply := &wrapperspb.StringValue{}
+		if err := intercept(ctx, method, wrapperspb.String(req), reply, nil, invoker(&calls, nil)); err != nil {
+			t.Fatalf("%s(%q) = %v", method, req, err)
+		}
+		return reply.Value
+	}
+
+	for _, tt := range []struct {
+		method, req string
+		want        string
+	}{
+		{cached, "a", "a1"},
+		{cached, "a", "a1"},
+		{cached, "b", "b2"},
+		{cached, "skip", "skip3"},
+		{cached, "skip", "skip4"},
+		{uncached, "a", "a5"},
+		{uncached, "a", "a6"},
+	} {
+		if got := call(tt.method, tt.req); got != tt.want {
+			t.Errorf("%s(%q) = %q, want %q", tt.method, tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestUnaryClientInterceptorError(t *testing.T) {
+	ctx := context.Background()
+	intercept := newInterceptor()
+	var calls int
+	errUnavailable := errors.New("unavailable")
+	reply := &wrapperspb.StringValue{}
+	if err := intercept(ctx, cached, wrapperspb.String("a"), reply, nil, invoker(&calls, errUnavailable)); !errors.Is(err, errUnavailable) {
+		t.Fatalf("failing call = %v, want its error", err)
+	}
+	if err := intercept(ctx, cached, wrapperspb.String("a"), reply, nil, invoker(&calls, nil)); err != nil || reply.Value != "a2" {
+		t.Fatalf("call after a failure = %q, %v; want the failure not cached", reply.Value, err)
+	}
+
+	// a reply which is not a protobuf message cannot be cached
+	var notProto struct{}
+	if err := intercept(ctx, cached, wrapperspb.String("a"), &notProto, nil, invoker(&calls, nil)); err == nil {
+		t.Error("call with a non-proto reply succeeded")
+	}
+}
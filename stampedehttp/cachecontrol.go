@@ -0,0 +1,60 @@
+package stampedehttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// freshness returns how long a response with header h may be cached, from
+// its Cache-Control and Expires headers, or def if they do not say.  It
+// reports false if the response must not be cached.
+func freshness(h http.Header, def time.Duration, now time.Time) (time.Duration, bool) {
+	var maxAge, sMaxAge = -1, -1
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(d), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache", "private":
+				return 0, false
+			case "max-age":
+				maxAge = seconds(arg)
+			case "s-maxage":
+				sMaxAge = seconds(arg)
+			}
+		}
+	}
+
+	switch {
+	case sMaxAge >= 0:
+		return time.Duration(sMaxAge) * time.Second, sMaxAge > 0
+	case maxAge >= 0:
+		return time.Duration(maxAge) * time.Second, maxAge > 0
+	}
+
+	if v := h.Get("Expires"); v != "" {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			// an invalid Expires means already expired
+			return 0, false
+		}
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			now = d
+		}
+		ttl := t.Sub(now)
+		return ttl, ttl > 0
+	}
+
+	return def, def > 0
+}
+
+// seconds parses a delta-seconds directive argument, returning -1 if it is
+// malformed
+func seconds(arg string) int {
+	n, err := strconv.Atoi(strings.Trim(arg, `"`))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,44 @@
+package stampedehttp
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	date := func(t time.Time) string { return t.Format(http.TimeFormat) }
+	for _, tt := range []struct {
+		name   string
+		header map[string]string
+		def    time.Duration
+		ttl    time.Duration
+		ok     bool
+	}{
+		{"None", nil, 0, 0, false},
+		{"Default", nil, time.Minute, time.Minute, true},
+		{"MaxAge", map[string]string{"Cache-Control": "public, max-age=60"}, 0, time.Minute, true},
+		{"QuotedMaxAge", map[string]string{"Cache-Control": `max-age="60"`}, 0, time.Minute, true},
+		{"MaxAgeZero", map[string]string{"Cache-Control": "max-age=0"}, time.Hour, 0, false},
+		{"MalformedMaxAge", map[string]string{"Cache-Control": "max-age=soon"}, time.Hour, time.Hour, true},
+		{"SMaxAge", map[string]string{"Cache-Control": "max-age=60, s-maxage=120"}, 0, 2 * time.Minute, true},
+		{"NoStore", map[string]string{"Cache-Control": "max-age=60, no-store"}, time.Hour, 0, false},
+		{"NoCache", map[string]string{"Cache-Control": "No-Cache"}, time.Hour, 0, false},
+		{"Private", map[string]string{"Cache-Control": "private, max-age=60"}, time.Hour, 0, false},
+		{"Expires", map[string]string{"Expires": date(now.Add(time.Hour))}, 0, time.Hour, true},
+		{"ExpiresPast", map[string]string{"Expires": date(now.Add(-time.Hour))}, time.Hour, -time.Hour, false},
+		{"ExpiresInvalid", map[string]string{"Expires": "0"}, time.Hour, 0, false},
+		{"ExpiresAgainstDate", map[string]string{"Expires": date(now.Add(time.Hour)), "Date": date(now.Add(30 * time.Minute))}, 0, 30 * time.Minute, true},
+		{"MaxAgeOverExpires", map[string]string{"Cache-Control": "max-age=60", "Expires": date(now.Add(time.Hour))}, 0, time.Minute, true},
+	} {
+		h := make(http.Header)
+		for k, v := range tt.header {
+			h.Set(k, v)
+		}
+		ttl, ok := freshness(h, tt.def, now)
+		if ttl != tt.ttl || ok != tt.ok {
+			t.Errorf("%s: freshness = %v, %v; want %v, %v", tt.name, ttl, ok, tt.ttl, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package stampedehttp caches HTTP responses with stampede protection
+package stampedehttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// Response is a cached HTTP response
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// An Option configures Middleware or a Transport
+type Option func(*config)
+
+type config struct {
+	ttl     time.Duration
+	ttlFunc func(r *http.Request, resp Response, ttl time.Duration) time.Duration
+	key     func(r *http.Request) string
+}
+
+// WithDefaultTTL caches responses for ttl when their headers do not specify
+// a lifetime.  By default such responses are not cached.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(c *config) { c.ttl = ttl }
+}
+
+// WithKeyFunc derives cache keys from requests with fn.  The default key is
+// the method and URL.
+func WithKeyFunc(fn func(r *http.Request) string) Option {
+	return func(c *config) { c.key = fn }
+}
+
+// WithTTLFunc overrides response lifetimes with fn, which is passed the
+// lifetime derived from the response headers, or 0 if the response would not
+// be cached.  Responses are cached only if fn returns a positive duration.
+func WithTTLFunc(fn func(r *http.Request, resp Response, ttl time.Duration) time.Duration) Option {
+	return func(c *config) { c.ttlFunc = fn }
+}
+
+func newConfig(opts []Option) config {
+	c := config{key: defaultKey}
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+func defaultKey(r *http.Request) string {
+	return r.Method + " " + r.URL.String()
+}
+
+// lifetime returns how long resp, a response to r, should be cached,
+// reporting false if it should not be
+func (c *config) lifetime(r *http.Request, resp Response) (time.Duration, bool) {
+	ttl, ok := freshness(resp.Header, c.ttl, time.Now())
+	if !ok || resp.Status >= 500 {
+		ttl = 0
+	}
+	if c.ttlFunc != nil {
+		ttl = c.ttlFunc(r, resp, ttl)
+	}
+	return ttl, ttl > 0
+}
+
+// Middleware returns middleware caching the responses of GET and HEAD
+// requests in xf.  A response's lifetime is taken from its Cache-Control or
+// Expires header, unless overridden by WithTTLFunc; those marked no-store,
+// no-cache or private, and server errors, are not cached.  Vary is not supported: use WithKeyFunc to include
+// any request headers the response depends on.
+//
+// Concurrent requests for a key are served by a single call to the wrapped
+// handler, made with the context of one of them.  Responses served from the
+// cache carry an Age header.
+func Middleware(xf *stampede.XFetcher[string, Response], opts ...Option) func(http.Handler) http.Handler {
+	c := newConfig(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := xf.FetchItem(r.Context(), c.key(r), func(ctx context.Context) (Response, time.Duration, error) {
+				rec := &recorder{header: make(http.Header)}
+				next.ServeHTTP(rec, r.WithContext(ctx))
+				resp := rec.response()
+				ttl, ok := c.lifetime(r, resp)
+				if !ok {
+					return resp, 0, stampede.ErrDontCache
+				}
+				return resp, ttl, nil
+			})
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+				return
+			}
+
+			resp := res.Value
+			h := w.Header()
+			for k, v := range resp.Header {
+				h[k] = slices.Clone(v)
+			}
+			if res.Source != stampede.SourceRecompute {
+				h.Set("Age", strconv.Itoa(int(res.Age/time.Second)))
+			}
+			w.WriteHeader(resp.Status)
+			if r.Method != http.MethodHead {
+				w.Write(resp.Body)
+			}
+		})
+	}
+}
+
+// recorder is a ResponseWriter capturing a response
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *recorder) response() Response {
+	r.WriteHeader(http.StatusOK)
+	return Response{Status: r.status, Header: r.header, Body: r.body.Bytes()}
+}
@@ -0,0 +1,148 @@
+package stampedehttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/stampedehttp"
+)
+
+// origin is a handler counting its calls, which replies with the call number
+// and any Cache-Control given in the request's cc parameter
+func origin(calls *atomic.Int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if cc := r.URL.Query().Get("cc"); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		if code := r.URL.Query().Get("code"); code != "" {
+			status, _ := strconv.Atoi(code)
+			w.WriteHeader(status)
+		}
+		io.WriteString(w, strconv.FormatInt(n, 10))
+	})
+}
+
+func newFetcher() *stampede.XFetcher[string, stampedehttp.Response] {
+	return stampede.New[string, stampedehttp.Response](memcache.New[string, stampedehttp.Response]())
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls atomic.Int64
+	h := stampedehttp.Middleware(newFetcher())(origin(&calls))
+
+	for _, tt := range []struct {
+		method, target string
+		body           string
+		cached         bool
+	}{
+		{"GET", "/a?cc=max-age%3D60", "1", false},
+		{"GET", "/a?cc=max-age%3D60", "1", true},
+		{"HEAD", "/a?cc=max-age%3D60", "", false},
+		{"GET", "/b?cc=no-store", "3", false},
+		{"GET", "/b?cc=no-store", "4", false},
+		// without a lifetime or a default, nothing is cached
+		{"GET", "/c", "5", false},
+		{"GET", "/c", "6", false},
+		{"POST", "/a?cc=max-age%3D60", "7", false},
+		{"GET", "/d?cc=max-age%3D60&code=503", "8", false},
+		{"GET", "/d?cc=max-age%3D60&code=503", "9", false},
+	} {
+		w := serve(h, tt.method, tt.target)
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf("%s %s = %q, want %q", tt.method, tt.target, got, tt.body)
+		}
+		if _, aged := w.Header()["Age"]; aged != tt.cached {
+			t.Errorf("%s %s: Age header present %v, want %v", tt.method, tt.target, aged, tt.cached)
+		}
+	}
+
+	// the cached response keeps its status and headers
+	w := serve(h, "GET", "/a?cc=max-age%3D60")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("cached response %d with headers %v", w.Code, w.Header())
+	}
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	var calls atomic.Int64
+	h := stampedehttp.Middleware(newFetcher(),
+		stampedehttp.WithDefaultTTL(time.Minute),
+		stampedehttp.WithKeyFunc(func(r *http.Request) string { return r.URL.Path }),
+		stampedehttp.WithTTLFunc(func(r *http.Request, resp stampedehttp.Response, ttl time.Duration) time.Duration {
+			if r.URL.Path == "/never" {
+				return 0
+			}
+			return ttl
+		}),
+	)(origin(&calls))
+
+	serve(h, "GET", "/a?x=1")
+	if w := serve(h, "GET", "/a?x=2"); w.Body.String() != "1" {
+		t.Errorf("GET with the same path = %q, want the cached 1", w.Body.String())
+	}
+	serve(h, "GET", "/never")
+	if w := serve(h, "GET", "/never"); w.Body.String() != "3" {
+		t.Errorf("GET refused by the TTL func = %q, want a new response", w.Body.String())
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	h := stampedehttp.Middleware(newFetcher())(panicking)
+	if w := serve(h, "GET", "/"); w.Code != http.StatusBadGateway {
+		t.Errorf("failed recompute served %d, want 502", w.Code)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(origin(&calls))
+	defer srv.Close()
+	client := &http.Client{Transport: stampedehttp.NewTransport(newFetcher(), nil)}
+
+	get := func(method, path string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s = %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		body         string
+	}{
+		{"GET", "/a?cc=max-age%3D60", "1"},
+		{"GET", "/a?cc=max-age%3D60", "1"},
+		{"POST", "/a?cc=max-age%3D60", "2"},
+		{"GET", "/b", "3"},
+		{"GET", "/b", "4"},
+	} {
+		if status, body := get(tt.method, tt.path); status != http.StatusOK || body != tt.body {
+			t.Errorf("%s %s = %d %q, want %q", tt.method, tt.path, status, body, tt.body)
+		}
+	}
+
+	srv.Close()
+	if _, err := client.Get(srv.URL + "/c"); err == nil {
+		t.Error("GET from a closed server succeeded")
+	}
+}
@@ -0,0 +1,74 @@
+package stampedehttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// Transport is an http.RoundTripper caching the responses to GET requests in
+// an XFetcher.  Response lifetimes are derived as for Middleware.  Other
+// requests, and those whose responses are not cached, are sent with Base.
+//
+// The default key is the method and URL; requests which differ in other
+// ways, such as by credentials, need a WithKeyFunc which distinguishes them.
+type Transport struct {
+	xf   *stampede.XFetcher[string, Response]
+	base http.RoundTripper
+	config
+}
+
+// NewTransport returns a Transport caching in xf and sending requests with
+// base, or http.DefaultTransport if base is nil
+func NewTransport(xf *stampede.XFetcher[string, Response], base http.RoundTripper, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{xf: xf, base: base, config: newConfig(opts)}
+}
+
+// RoundTrip implements http.RoundTripper.  Concurrent requests for a key are
+// served by a single round trip, made with the context of one of them.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	resp, err := t.xf.Fetch(req.Context(), t.key(req), func(ctx context.Context) (Response, time.Duration, error) {
+		hresp, err := t.base.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			return Response{}, 0, err
+		}
+		defer hresp.Body.Close()
+		body, err := io.ReadAll(hresp.Body)
+		if err != nil {
+			return Response{}, 0, err
+		}
+		resp := Response{Status: hresp.StatusCode, Header: hresp.Header, Body: body}
+		ttl, ok := t.lifetime(req, resp)
+		if !ok {
+			return resp, 0, stampede.ErrDontCache
+		}
+		return resp, ttl, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        resp.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,69 @@
+// Package stampedeotel traces stampede fetches with OpenTelemetry
+package stampedeotel
+
+import (
+	"context"
+
+	"github.com/dgryski/go-stampede"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/dgryski/go-stampede"
+
+// Tracer is a stampede.Tracer creating OpenTelemetry spans
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ stampede.Tracer[string] = (*Tracer)(nil)
+
+// New returns a Tracer creating spans from tp
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: tp.Tracer(instrumentationName)}
+}
+
+// StartFetch implements stampede.Tracer
+func (t *Tracer) StartFetch(ctx context.Context, key string) (context.Context, stampede.Span) {
+	ctx, s := t.tracer.Start(ctx, "stampede.Fetch", trace.WithAttributes(attribute.String("stampede.key", key)))
+	return ctx, span{s}
+}
+
+// StartRecompute implements stampede.Tracer
+func (t *Tracer) StartRecompute(ctx context.Context, key string) (context.Context, stampede.Span) {
+	ctx, s := t.tracer.Start(ctx, "stampede.Recompute", trace.WithAttributes(attribute.String("stampede.key", key)))
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) Annotate(info stampede.FetchInfo) {
+	s.s.SetAttributes(
+		attribute.String("stampede.decision", info.Decision.String()),
+		attribute.Bool("stampede.hit", info.Decision == stampede.DecisionHit),
+		attribute.Bool("stampede.early_expire", info.Decision == stampede.DecisionEarlyExpire),
+		attribute.Int64("stampede.delta_ms", info.Delta.Milliseconds()),
+		attribute.Int64("stampede.ttl_remaining_ms", info.TTL.Milliseconds()),
+		attribute.Float64("stampede.beta", info.Beta),
+	)
+	if ex := info.Explain; ex != nil {
+		s.s.SetAttributes(
+			attribute.Int64("stampede.explain.delta_ms", ex.Delta.Milliseconds()),
+			attribute.Float64("stampede.explain.rand", ex.Rand),
+			attribute.Int64("stampede.explain.gap_ms", ex.Gap.Milliseconds()),
+			attribute.Bool("stampede.explain.strategy", ex.Strategy),
+			attribute.Bool("stampede.explain.recompute", ex.Recompute),
+		)
+	}
+}
+
+func (s span) End(err error) {
+	if err != nil {
+		s.s.RecordError(err)
+		s.s.SetStatus(codes.Error, err.Error())
+	}
+	s.s.End()
+}
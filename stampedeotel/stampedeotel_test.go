@@ -0,0 +1,128 @@
+package stampedeotel_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/stampedeotel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// provider is a trace.TracerProvider recording the spans it starts
+type provider struct {
+	noop.TracerProvider
+	mu    sync.Mutex
+	spans []*span
+}
+
+type tracer struct {
+	noop.Tracer
+	p *provider
+}
+
+type span struct {
+	noop.Span
+	name   string
+	parent trace.SpanContext
+	ctx    trace.SpanContext
+	attrs  map[attribute.Key]attribute.Value
+	err    error
+	status codes.Code
+	ended  bool
+}
+
+func (p *provider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return tracer{p: p}
+}
+
+func (t tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	s := &span{
+		name:   name,
+		parent: trace.SpanContextFromContext(ctx),
+		ctx:    trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{byte(len(t.p.spans) + 1)}}),
+		attrs:  make(map[attribute.Key]attribute.Value),
+	}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	t.p.spans = append(t.p.spans, s)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (s *span) SpanContext() trace.SpanContext { return s.ctx }
+
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *span) RecordError(err error, opts ...trace.EventOption) { s.err = err }
+
+func (s *span) SetStatus(code codes.Code, description string) { s.status = code }
+
+func (s *span) End(opts ...trace.SpanEndOption) { s.ended = true }
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+	p := &provider{}
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithTracer[string](stampedeotel.New(p)),
+		stampede.WithExplain(1),
+	)
+
+	recompute := func(ctx context.Context) (int, time.Duration, error) { return 1, time.Minute, nil }
+	xf.Fetch(ctx, "k", recompute)
+	if len(p.spans) != 2 {
+		t.Fatalf("%d spans, want a fetch and a recompute", len(p.spans))
+	}
+	fetch, rec := p.spans[0], p.spans[1]
+	if fetch.name != "stampede.Fetch" || rec.name != "stampede.Recompute" {
+		t.Fatalf("spans %q and %q", fetch.name, rec.name)
+	}
+	if !rec.parent.Equal(fetch.ctx) {
+		t.Error("recompute span not a child of the fetch span")
+	}
+	if !fetch.ended || !rec.ended {
+		t.Error("spans not ended")
+	}
+	if fetch.attrs["stampede.key"].AsString() != "k" || fetch.attrs["stampede.decision"].AsString() != "miss" ||
+		fetch.attrs["stampede.hit"].AsBool() {
+		t.Errorf("miss attributes %v", fetch.attrs)
+	}
+
+	p.spans = nil
+	xf.Fetch(ctx, "k", recompute)
+	if len(p.spans) != 1 {
+		t.Fatalf("%d spans for a hit, want 1", len(p.spans))
+	}
+	hit := p.spans[0]
+	if !hit.attrs["stampede.hit"].AsBool() || hit.attrs["stampede.ttl_remaining_ms"].AsInt64() <= 0 {
+		t.Errorf("hit attributes %v", hit.attrs)
+	}
+	if _, ok := hit.attrs["stampede.explain.recompute"]; !ok {
+		t.Errorf("hit attributes %v, want the explanation", hit.attrs)
+	}
+}
+
+func TestTracerError(t *testing.T) {
+	p := &provider{}
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithTracer[string](stampedeotel.New(p)))
+
+	errBoom := errors.New("boom")
+	xf.Fetch(context.Background(), "k", func(ctx context.Context) (int, time.Duration, error) { return 0, 0, errBoom })
+	for _, s := range p.spans {
+		if !errors.Is(s.err, errBoom) || s.status != codes.Error {
+			t.Errorf("%s: error %v, status %v", s.name, s.err, s.status)
+		}
+	}
+}
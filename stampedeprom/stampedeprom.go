@@ -0,0 +1,242 @@
+// Package stampedeprom exports stampede metrics to Prometheus
+package stampedeprom
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector implementing stampede.Metrics
+type Collector struct {
+	prefix func(key string) string
+
+	hits         *prometheus.CounterVec
+	misses       *prometheus.CounterVec
+	earlyExpires *prometheus.CounterVec
+	readFailures *prometheus.CounterVec
+	recomputes   *prometheus.CounterVec
+	writeFails   *prometheus.CounterVec
+	concurrent   *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+	inflight     *prometheus.GaugeVec
+	breakers     *prometheus.GaugeVec
+	queueDepth   prometheus.Gauge
+	dropped      prometheus.Counter
+	swept        prometheus.Counter
+	sweeps       prometheus.Histogram
+}
+
+var (
+	_ stampede.Metrics[string]         = (*Collector)(nil)
+	_ stampede.BreakerMetrics          = (*Collector)(nil)
+	_ stampede.RefreshQueueMetrics     = (*Collector)(nil)
+	_ stampede.SweepMetrics            = (*Collector)(nil)
+	_ stampede.StampedeMetrics[string] = (*Collector)(nil)
+)
+
+// An Option configures a Collector
+type Option func(*config)
+
+type config struct {
+	namespace string
+	prefix    func(key string) string
+	buckets   []float64
+}
+
+// WithNamespace sets the metric namespace.  The default is "stampede".
+func WithNamespace(namespace string) Option {
+	return func(c *config) { c.namespace = namespace }
+}
+
+// WithKeyPrefix labels every metric with prefix(key) as "prefix".  By default
+// metrics are unlabelled.
+func WithKeyPrefix(prefix func(key string) string) Option {
+	return func(c *config) { c.prefix = prefix }
+}
+
+// WithBuckets sets the recompute duration histogram buckets, in seconds.  The
+// default is prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) { c.buckets = buckets }
+}
+
+// PrefixBefore returns a key prefix function for WithKeyPrefix which takes
+// everything before the first sep, or the whole key if sep is absent
+func PrefixBefore(sep string) func(key string) string {
+	return func(key string) string {
+		prefix, _, _ := strings.Cut(key, sep)
+		return prefix
+	}
+}
+
+// New returns a Collector.  It must be registered with a prometheus.Registerer
+// to be exported.
+func New(opts ...Option) *Collector {
+	cfg := config{namespace: "stampede", buckets: prometheus.DefBuckets}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	var labels []string
+	if cfg.prefix != nil {
+		labels = []string{"prefix"}
+	}
+
+	counter := func(name, help string, extra ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      name,
+			Help:      help,
+		}, append(labels, extra...))
+	}
+
+	return &Collector{
+		prefix:       cfg.prefix,
+		hits:         counter("hits_total", "Fresh values served from the cache."),
+		misses:       counter("misses_total", "Keys absent from the cache or expired."),
+		earlyExpires: counter("early_expirations_total", "Values recomputed early by the XFetch algorithm."),
+		readFailures: counter("read_failures_total", "Cache reads failing with an error other than a miss."),
+		recomputes:   counter("recomputes_total", "Completed recomputes, by result.", "result"),
+		writeFails:   counter("write_failures_total", "Failed cache writes."),
+		concurrent:   counter("concurrent_recomputes_total", "Recomputes started while another of the key was in flight, by scope: local in this process, remote elsewhere.", "scope"),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "recompute_duration_seconds",
+			Help:      "Time taken by recomputes.",
+			Buckets:   cfg.buckets,
+		}, labels),
+		inflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.namespace,
+			Name:      "recomputes_in_flight",
+			Help:      "Recomputes currently running.",
+		}, labels),
+		breakers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.namespace,
+			Name:      "circuit_breaker_state",
+			Help:      "Recompute circuit breaker state by group: 0 closed, 1 open, 2 half-open.",
+		}, []string{"group"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: cfg.namespace,
+			Name:      "refresh_queue_depth",
+			Help:      "Background refreshes waiting for a worker.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "refreshes_dropped_total",
+			Help:      "Background refreshes dropped from a full queue.",
+		}),
+		swept: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "swept_entries_total",
+			Help:      "Expired entries removed by in-memory cache sweeps.",
+		}),
+		sweeps: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "sweep_duration_seconds",
+			Help:      "Time taken by in-memory cache sweeps.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.hits, c.misses, c.earlyExpires, c.readFailures,
+		c.recomputes, c.writeFails, c.concurrent, c.duration, c.inflight, c.breakers,
+		c.queueDepth, c.dropped, c.swept, c.sweeps,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
+
+// labels returns the label values for key
+func (c *Collector) labels(key string, extra ...string) []string {
+	if c.prefix == nil {
+		return extra
+	}
+	return append([]string{c.prefix(key)}, extra...)
+}
+
+// Hit implements stampede.Metrics
+func (c *Collector) Hit(key string) { c.hits.WithLabelValues(c.labels(key)...).Inc() }
+
+// Miss implements stampede.Metrics
+func (c *Collector) Miss(key string) { c.misses.WithLabelValues(c.labels(key)...).Inc() }
+
+// EarlyExpire implements stampede.Metrics
+func (c *Collector) EarlyExpire(key string) {
+	c.earlyExpires.WithLabelValues(c.labels(key)...).Inc()
+}
+
+// ReadFailure implements stampede.Metrics
+func (c *Collector) ReadFailure(key string) {
+	c.readFailures.WithLabelValues(c.labels(key)...).Inc()
+}
+
+// RecomputeStart implements stampede.Metrics
+func (c *Collector) RecomputeStart(key string) {
+	c.inflight.WithLabelValues(c.labels(key)...).Inc()
+}
+
+// RecomputeSuccess implements stampede.Metrics
+func (c *Collector) RecomputeSuccess(key string, d time.Duration) {
+	c.recomputeDone(key, d, "success")
+}
+
+// RecomputeFailure implements stampede.Metrics
+func (c *Collector) RecomputeFailure(key string, d time.Duration) {
+	c.recomputeDone(key, d, "failure")
+}
+
+func (c *Collector) recomputeDone(key string, d time.Duration, result string) {
+	labels := c.labels(key)
+	c.inflight.WithLabelValues(labels...).Dec()
+	c.duration.WithLabelValues(labels...).Observe(d.Seconds())
+	c.recomputes.WithLabelValues(c.labels(key, result)...).Inc()
+}
+
+// WriteFailure implements stampede.Metrics
+func (c *Collector) WriteFailure(key string) {
+	c.writeFails.WithLabelValues(c.labels(key)...).Inc()
+}
+
+// BreakerStateChange implements stampede.BreakerMetrics
+func (c *Collector) BreakerStateChange(group string, state stampede.BreakerState) {
+	c.breakers.WithLabelValues(group).Set(float64(state))
+}
+
+// RefreshQueueDepth implements stampede.RefreshQueueMetrics
+func (c *Collector) RefreshQueueDepth(n int) { c.queueDepth.Set(float64(n)) }
+
+// RefreshDropped implements stampede.RefreshQueueMetrics
+func (c *Collector) RefreshDropped() { c.dropped.Inc() }
+
+// Swept implements stampede.SweepMetrics
+func (c *Collector) Swept(removed int, d time.Duration) {
+	c.swept.Add(float64(removed))
+	c.sweeps.Observe(d.Seconds())
+}
+
+// ConcurrentRecompute implements stampede.StampedeMetrics
+func (c *Collector) ConcurrentRecompute(key string, remote bool) {
+	scope := "local"
+	if remote {
+		scope = "remote"
+	}
+	c.concurrent.WithLabelValues(c.labels(key, scope)...).Inc()
+}
@@ -0,0 +1,140 @@
+package stampedeprom_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/stampedeprom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	ctx := context.Background()
+	c := stampedeprom.New(stampedeprom.WithKeyPrefix(stampedeprom.PrefixBefore(":")))
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithMetrics[string](c))
+
+	ok := func(ctx context.Context) (int, time.Duration, error) { return 1, time.Minute, nil }
+	fail := func(ctx context.Context) (int, time.Duration, error) { return 0, 0, errors.New("boom") }
+	xf.Fetch(ctx, "user:1", ok)
+	xf.Fetch(ctx, "user:1", ok)
+	xf.Fetch(ctx, "user:2", ok)
+	xf.Fetch(ctx, "post:1", fail)
+
+	want := `
+# HELP stampede_hits_total Fresh values served from the cache.
+# TYPE stampede_hits_total counter
+stampede_hits_total{prefix="user"} 1
+# HELP stampede_misses_total Keys absent from the cache or expired.
+# TYPE stampede_misses_total counter
+stampede_misses_total{prefix="post"} 1
+stampede_misses_total{prefix="user"} 2
+# HELP stampede_recomputes_in_flight Recomputes currently running.
+# TYPE stampede_recomputes_in_flight gauge
+stampede_recomputes_in_flight{prefix="post"} 0
+stampede_recomputes_in_flight{prefix="user"} 0
+# HELP stampede_recomputes_total Completed recomputes, by result.
+# TYPE stampede_recomputes_total counter
+stampede_recomputes_total{prefix="post",result="failure"} 1
+stampede_recomputes_total{prefix="user",result="success"} 2
+`
+	names := []string{"stampede_hits_total", "stampede_misses_total", "stampede_recomputes_in_flight", "stampede_recomputes_total"}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "stampede_recompute_duration_seconds"); n != 2 {
+		t.Errorf("%d duration histograms, want 2", n)
+	}
+}
+
+func TestCollectorEvents(t *testing.T) {
+	c := stampedeprom.New(stampedeprom.WithNamespace("app"), stampedeprom.WithBuckets([]float64{1}))
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	c.EarlyExpire("k")
+	c.ReadFailure("k")
+	c.WriteFailure("k")
+	c.ConcurrentRecompute("k", false)
+	c.ConcurrentRecompute("k", true)
+	c.ConcurrentRecompute("k", true)
+	c.BreakerStateChange("db", stampede.BreakerOpen)
+	c.RefreshQueueDepth(3)
+	c.RefreshDropped()
+	c.Swept(5, time.Millisecond)
+
+	want := `
+# HELP app_circuit_breaker_state Recompute circuit breaker state by group: 0 closed, 1 open, 2 half-open.
+# TYPE app_circuit_breaker_state gauge
+app_circuit_breaker_state{group="db"} 1
+# HELP app_concurrent_recomputes_total Recomputes started while another of the key was in flight, by scope: local in this process, remote elsewhere.
+# TYPE app_concurrent_recomputes_total counter
+app_concurrent_recomputes_total{scope="local"} 1
+app_concurrent_recomputes_total{scope="remote"} 2
+# HELP app_early_expirations_total Values recomputed early by the XFetch algorithm.
+# TYPE app_early_expirations_total counter
+app_early_expirations_total 1
+# HELP app_read_failures_total Cache reads failing with an error other than a miss.
+# TYPE app_read_failures_total counter
+app_read_failures_total 1
+# HELP app_refresh_queue_depth Background refreshes waiting for a worker.
+# TYPE app_refresh_queue_depth gauge
+app_refresh_queue_depth 3
+# HELP app_refreshes_dropped_total Background refreshes dropped from a full queue.
+# TYPE app_refreshes_dropped_total counter
+app_refreshes_dropped_total 1
+# HELP app_swept_entries_total Expired entries removed by in-memory cache sweeps.
+# TYPE app_swept_entries_total counter
+app_swept_entries_total 5
+# HELP app_write_failures_total Failed cache writes.
+# TYPE app_write_failures_total counter
+app_write_failures_total 1
+`
+	names := []string{
+		"app_circuit_breaker_state", "app_concurrent_recomputes_total", "app_early_expirations_total", "app_read_failures_total",
+		"app_refresh_queue_depth", "app_refreshes_dropped_total", "app_swept_entries_total", "app_write_failures_total",
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrefixBefore(t *testing.T) {
+	prefix := stampedeprom.PrefixBefore("/")
+	for key, want := range map[string]string{"a/b/c": "a", "abc": "abc", "/a": ""} {
+		if got := prefix(key); got != want {
+			t.Errorf("PrefixBefore(/)(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestStatsCollector(t *testing.T) {
+	stats := stampede.Stats{
+		RecomputeLatency: stampede.LatencySummary{Count: 10, P50: time.Second, P95: 2 * time.Second, P99: 3 * time.Second, Max: 4 * time.Second},
+	}
+	c := stampedeprom.NewStatsCollector(func() stampede.Stats { return stats })
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	// operations without latencies are omitted
+	want := `
+# HELP stampede_latency_max_seconds Recent maximum operation latency, by operation.
+# TYPE stampede_latency_max_seconds gauge
+stampede_latency_max_seconds{op="recompute"} 4
+# HELP stampede_latency_seconds Recent operation latency percentiles, by operation: recompute, cache_get or cache_set.
+# TYPE stampede_latency_seconds gauge
+stampede_latency_seconds{op="recompute",quantile="0.5"} 1
+stampede_latency_seconds{op="recompute",quantile="0.95"} 2
+stampede_latency_seconds{op="recompute",quantile="0.99"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
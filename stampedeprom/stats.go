@@ -0,0 +1,70 @@
+package stampedeprom
+
+import (
+	"github.com/dgryski/go-stampede"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsCollector is a prometheus.Collector exporting the latency percentiles
+// of a fetcher's Stats, recorded over the stampede.WithLatencyWindow window,
+// as the gauge latency_seconds labelled by operation and quantile.  Unlike
+// the Collector's recompute histogram, the percentiles cover only the recent
+// window, and include cache reads and writes.
+type StatsCollector struct {
+	stats   func() stampede.Stats
+	latency *prometheus.Desc
+	max     *prometheus.Desc
+}
+
+// NewStatsCollector returns a StatsCollector reading stats, such as an
+// XFetcher's Stats method.  Of the options, only WithNamespace applies.
+func NewStatsCollector(stats func() stampede.Stats, opts ...Option) *StatsCollector {
+	cfg := config{namespace: "stampede"}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &StatsCollector{
+		stats: stats,
+		latency: prometheus.NewDesc(prometheus.BuildFQName(cfg.namespace, "", "latency_seconds"),
+			"Recent operation latency percentiles, by operation: recompute, cache_get or cache_set.",
+			[]string{"op", "quantile"}, nil),
+		max: prometheus.NewDesc(prometheus.BuildFQName(cfg.namespace, "", "latency_max_seconds"),
+			"Recent maximum operation latency, by operation.",
+			[]string{"op"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *StatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.latency
+	ch <- c.max
+}
+
+// Collect implements prometheus.Collector.  Operations with no recent
+// latencies are omitted.
+func (c *StatsCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.stats()
+	for _, op := range []struct {
+		name string
+		sum  stampede.LatencySummary
+	}{
+		{"recompute", st.RecomputeLatency},
+		{"cache_get", st.CacheGetLatency},
+		{"cache_set", st.CacheSetLatency},
+	} {
+		if op.sum.Count == 0 {
+			continue
+		}
+		for _, q := range []struct {
+			label string
+			v     float64
+		}{
+			{"0.5", op.sum.P50.Seconds()},
+			{"0.95", op.sum.P95.Seconds()},
+			{"0.99", op.sum.P99.Seconds()},
+		} {
+			ch <- prometheus.MustNewConstMetric(c.latency, prometheus.GaugeValue, q.v, op.name, q.label)
+		}
+		ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, op.sum.Max.Seconds(), op.name)
+	}
+}
@@ -0,0 +1,100 @@
+package stampede
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the events of an XFetcher since it was created
+type Stats struct {
+	Hits         uint64
+	Misses       uint64
+	EarlyExpires uint64
+
+	// Recomputes counts calls to recompute, of which RecomputeFailures
+	// failed.  A FetchMulti batch counts once per key.
+	Recomputes        uint64
+	RecomputeFailures uint64
+
+	ReadFailures  uint64
+	WriteFailures uint64
+	StaleServed   uint64
+
+	// ConcurrentRecomputes counts recomputes started while another of the
+	// same key ran in this process, and RemoteConcurrentRecomputes those
+	// started while another process held the key's lock or lease: the
+	// stampedes which got through
+	ConcurrentRecomputes       uint64
+	RemoteConcurrentRecomputes uint64
+
+	// RefreshQueueDepth is the number of background refreshes waiting in
+	// the WithRefreshQueue queue, which has dropped RefreshesDropped
+	RefreshQueueDepth int
+	RefreshesDropped  uint64
+
+	// AverageDelta is the mean duration of the recomputes
+	AverageDelta time.Duration
+
+	// RecomputeLatency, CacheGetLatency and CacheSetLatency summarize the
+	// durations of recent recomputes, cache reads and cache writes, if
+	// WithLatencyWindow is set
+	RecomputeLatency LatencySummary
+	CacheGetLatency  LatencySummary
+	CacheSetLatency  LatencySummary
+}
+
+// fetcherStats holds the counters behind Stats
+type fetcherStats struct {
+	hits, misses, early  atomic.Uint64
+	recomputes, failures atomic.Uint64
+	readFailures         atomic.Uint64
+	writeFailures        atomic.Uint64
+	stale                atomic.Uint64
+	concurrent           atomic.Uint64
+	remoteConcurrent     atomic.Uint64
+	deltaSum             atomic.Int64
+}
+
+func (s *fetcherStats) recomputed(d time.Duration, err error) {
+	s.recomputes.Add(1)
+	s.deltaSum.Add(int64(d))
+	if err != nil {
+		s.failures.Add(1)
+	}
+}
+
+// Stats returns a snapshot of the fetcher's counters
+func (xf *XFetcher[K, V]) Stats() Stats {
+	s := &xf.stats
+	st := Stats{
+		Hits:              s.hits.Load(),
+		Misses:            s.misses.Load(),
+		EarlyExpires:      s.early.Load(),
+		Recomputes:        s.recomputes.Load(),
+		RecomputeFailures: s.failures.Load(),
+		ReadFailures:      s.readFailures.Load(),
+		WriteFailures:     s.writeFailures.Load(),
+		StaleServed:       s.stale.Load(),
+
+		ConcurrentRecomputes:       s.concurrent.Load(),
+		RemoteConcurrentRecomputes: s.remoteConcurrent.Load(),
+	}
+	if xf.refreshes != nil {
+		st.RefreshQueueDepth, st.RefreshesDropped = xf.refreshes.depth()
+	}
+	st.RecomputeLatency = xf.latencies.summary(opRecompute)
+	st.CacheGetLatency = xf.latencies.summary(opCacheGet)
+	st.CacheSetLatency = xf.latencies.summary(opCacheSet)
+	if st.Recomputes > 0 {
+		st.AverageDelta = time.Duration(s.deltaSum.Load() / int64(st.Recomputes))
+	}
+	return st
+}
+
+// PublishExpvar publishes the fetcher's Stats as the expvar name, served as
+// JSON on /debug/vars.  Like expvar.Publish, it panics if name is already in
+// use.
+func (xf *XFetcher[K, V]) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return xf.Stats() }))
+}
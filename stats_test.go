@@ -0,0 +1,68 @@
+package stampede_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestStats(t *testing.T) {
+	clock := fakeclock.New(time.Unix(1000, 0))
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(func() float64 { return 0.5 }),
+	)
+	if st := xf.Stats(); st != (stampede.Stats{}) {
+		t.Fatalf("Stats of a new fetcher = %+v", st)
+	}
+	exercise(t, xf, clock)
+
+	want := stampede.Stats{
+		Hits:              1,
+		Misses:            2,
+		EarlyExpires:      1,
+		Recomputes:        3,
+		RecomputeFailures: 1,
+		StaleServed:       1,
+		AverageDelta:      2 * time.Second / 3,
+	}
+	if st := xf.Stats(); st != want {
+		t.Errorf("Stats = %+v, want %+v", st, want)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	clock := fakeclock.New(time.Unix(1000, 0))
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(func() float64 { return 0.5 }),
+	)
+	// a fresh name, as expvars outlive the test
+	name := "stampede_test"
+	for i := 0; expvar.Get(name) != nil; i++ {
+		name = "stampede_test" + strconv.Itoa(i)
+	}
+	xf.PublishExpvar(name)
+	exercise(t, xf, clock)
+
+	var st stampede.Stats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &st); err != nil {
+		t.Fatalf("expvar is not JSON: %v", err)
+	}
+	if st != xf.Stats() {
+		t.Errorf("expvar = %+v, want %+v", st, xf.Stats())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("publishing a name twice did not panic")
+		}
+	}()
+	xf.PublishExpvar(name)
+}
@@ -0,0 +1,104 @@
+package stampede
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Strategy decides whether a cached item should be recomputed ahead of its
+// expiry.  Items which have expired are always recomputed without consulting
+// the Strategy.  A Strategy must be safe for concurrent use.
+type Strategy[V any] interface {
+	// ShouldRecompute reports whether the unexpired item should be
+	// recomputed at now
+	ShouldRecompute(item Item[V], now time.Time) bool
+}
+
+// WithStrategy replaces the XFetch early expiration decision with s.  Beta
+// settings, including WithBetaFunc, WithAdaptiveBeta and WithFetchBeta, only
+// apply to the default and are ignored.  The value type must match the
+// fetcher's.
+func WithStrategy[V any](s Strategy[V]) Option {
+	return func(c *config) { c.strategy = s }
+}
+
+// XFetch is the Strategy of the XFetch algorithm, which recomputes an item
+// with probability rising exponentially as its expiry nears, scaled by its
+// recompute time and Beta.  It is the default strategy; an explicit XFetch is
+// useful to wrap or compare against.
+type XFetch[V any] struct {
+	Beta float64
+
+	// Rand returns a random number in [0.0,1.0).  If nil, math/rand is
+	// used.
+	Rand func() float64
+}
+
+// ShouldRecompute implements Strategy
+func (x XFetch[V]) ShouldRecompute(item Item[V], now time.Time) bool {
+	rnd := x.Rand
+	if rnd == nil {
+		rnd = rand.Float64
+	}
+	return ShouldEarlyExpire(item.Delta, x.Beta, item.Expiry, now, rnd())
+}
+
+// ShouldEarlyExpire is the XFetch decision: whether a value taking delta to
+// recompute and expiring at expiry should be recomputed at now, given rnd
+// drawn uniformly from [0.0,1.0).  It recomputes when
+//
+//	now - delta*beta*log(rnd) >= expiry
+//
+// so with t left before expiry, the probability of recomputing is
+// exp(-t/(delta*beta)): certain once expired, and falling exponentially with
+// the time left.  A rnd of 0 always recomputes.
+func ShouldEarlyExpire(delta time.Duration, beta float64, expiry, now time.Time, rnd float64) bool {
+	gap := earlyGap(delta, beta, rnd)
+	if gap == math.MaxInt64 {
+		return true
+	}
+	return !now.Add(gap).Before(expiry)
+}
+
+// earlyGap returns -delta*beta*log(rnd), how long before expiry the draw rnd
+// recomputes, saturating at math.MaxInt64
+func earlyGap(delta time.Duration, beta float64, rnd float64) time.Duration {
+	if rnd == 0 {
+		// -log(0) is +Inf
+		return math.MaxInt64
+	}
+	gap := -float64(delta) * beta * math.Log(rnd)
+	if gap >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(gap)
+}
+
+// FixedWindow is a Strategy recomputing items once they are within Window of
+// their expiry.  It is deterministic, so concurrent fetches in the window all
+// recompute; pair it with singleflight or a Locker.
+type FixedWindow[V any] struct {
+	Window time.Duration
+}
+
+// ShouldRecompute implements Strategy
+func (w FixedWindow[V]) ShouldRecompute(item Item[V], now time.Time) bool {
+	return !now.Add(w.Window).Before(item.Expiry)
+}
+
+// TTLFraction is a Strategy recomputing items once less than Fraction of
+// their time-to-live remains.  Items whose creation time is unknown are only
+// recomputed once expired.
+type TTLFraction[V any] struct {
+	Fraction float64
+}
+
+// ShouldRecompute implements Strategy
+func (f TTLFraction[V]) ShouldRecompute(item Item[V], now time.Time) bool {
+	if item.Created.IsZero() {
+		return false
+	}
+	ttl := item.Expiry.Sub(item.Created)
+	return float64(item.Expiry.Sub(now)) < f.Fraction*float64(ttl)
+}
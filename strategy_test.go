@@ -0,0 +1,280 @@
+package stampede_test
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// TestShouldEarlyExpireDistribution checks the probability of recomputing
+// with t left before expiry against the paper's exp(-t/(delta*beta))
+func TestShouldEarlyExpireDistribution(t *testing.T) {
+	const n = 100000
+	r := rand.New(rand.NewSource(1))
+	delta := 100 * time.Millisecond
+	expiry := time.Unix(1000, 0)
+
+	for _, beta := range []float64{0.5, 1, 2} {
+		for _, left := range []float64{0, 0.25, 0.5, 1, 2, 4} {
+			gap := time.Duration(left * float64(delta))
+			now := expiry.Add(-gap)
+			hits := 0
+			for range n {
+				if stampede.ShouldEarlyExpire(delta, beta, expiry, now, r.Float64()) {
+					hits++
+				}
+			}
+
+			want := math.Exp(-float64(gap) / (float64(delta) * beta))
+			got := float64(hits) / n
+			// five standard deviations of the binomial proportion
+			tol := 5*math.Sqrt(want*(1-want)/n) + 1e-9
+			if math.Abs(got-want) > tol {
+				t.Errorf("beta %v, %v left: recomputed %.4f of the time, want %.4f ± %.4f", beta, gap, got, want, tol)
+			}
+		}
+	}
+}
+
+// qcheck runs the property f on random inputs
+func qcheck(t *testing.T, f any) {
+	t.Helper()
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(3))}); err != nil {
+		t.Error(err)
+	}
+}
+
+// args maps random integers onto the arguments of ShouldEarlyExpire
+func args(d, b, l uint16, u uint32) (delta time.Duration, beta float64, expiry, now time.Time, rnd float64) {
+	delta = time.Duration(d) * time.Millisecond
+	beta = float64(b) / 1000
+	expiry = time.Unix(1000, 0)
+	now = expiry.Add(-time.Duration(l) * time.Millisecond)
+	rnd = float64(u) / (1 << 32)
+	return
+}
+
+func TestShouldEarlyExpireProperties(t *testing.T) {
+	t.Run("Expired", func(t *testing.T) {
+		qcheck(t, func(d, b, l uint16, u uint32) bool {
+			delta, beta, expiry, _, rnd := args(d, b, l, u)
+			past := expiry.Add(time.Duration(l) * time.Millisecond)
+			return stampede.ShouldEarlyExpire(delta, beta, expiry, past, rnd)
+		})
+	})
+	t.Run("ZeroDraw", func(t *testing.T) {
+		qcheck(t, func(d, b, l uint16) bool {
+			delta, beta, expiry, now, _ := args(d, b, l, 0)
+			return stampede.ShouldEarlyExpire(delta, beta, expiry, now, 0)
+		})
+	})
+	t.Run("NoEarlyWithoutDeltaOrBeta", func(t *testing.T) {
+		qcheck(t, func(d, b, l uint16, u uint32) bool {
+			delta, beta, expiry, now, rnd := args(d, b, l, u)
+			if rnd == 0 || now.Equal(expiry) {
+				return true
+			}
+			return !stampede.ShouldEarlyExpire(0, beta, expiry, now, rnd) &&
+				!stampede.ShouldEarlyExpire(delta, 0, expiry, now, rnd)
+		})
+	})
+	t.Run("MonotoneInDraw", func(t *testing.T) {
+		qcheck(t, func(d, b, l uint16, u, v uint32) bool {
+			delta, beta, expiry, now, rnd := args(d, b, l, u)
+			_, _, _, _, lower := args(d, b, l, v)
+			if lower > rnd {
+				rnd, lower = lower, rnd
+			}
+			return !stampede.ShouldEarlyExpire(delta, beta, expiry, now, rnd) || stampede.ShouldEarlyExpire(delta, beta, expiry, now, lower)
+		})
+	})
+	t.Run("MonotoneInTime", func(t *testing.T) {
+		qcheck(t, func(d, b, l, k uint16, u uint32) bool {
+			delta, beta, expiry, now, rnd := args(d, b, l, u)
+			later := now.Add(time.Duration(k) * time.Millisecond)
+			return !stampede.ShouldEarlyExpire(delta, beta, expiry, now, rnd) || stampede.ShouldEarlyExpire(delta, beta, expiry, later, rnd)
+		})
+	})
+	t.Run("MonotoneInDeltaAndBeta", func(t *testing.T) {
+		qcheck(t, func(d, b, l, k uint16, u uint32) bool {
+			delta, beta, expiry, now, rnd := args(d, b, l, u)
+			if !stampede.ShouldEarlyExpire(delta, beta, expiry, now, rnd) {
+				return true
+			}
+			more := time.Duration(k) * time.Millisecond
+			return stampede.ShouldEarlyExpire(delta+more, beta, expiry, now, rnd) &&
+				stampede.ShouldEarlyExpire(delta, beta+float64(k)/1000, expiry, now, rnd)
+		})
+	})
+	t.Run("Threshold", func(t *testing.T) {
+		// recomputes exactly when -delta*beta*log(rnd) reaches the time left
+		qcheck(t, func(d, b, l uint16, u uint32) bool {
+			delta, beta, expiry, now, rnd := args(d, b, l, u)
+			if rnd == 0 {
+				return true
+			}
+			lead := -float64(delta) * beta * math.Log(rnd)
+			left := float64(expiry.Sub(now))
+			if math.Abs(lead-left) < 1 {
+				// too close to call after rounding to nanoseconds
+				return true
+			}
+			return stampede.ShouldEarlyExpire(delta, beta, expiry, now, rnd) == (lead >= left)
+		})
+	})
+}
+
+func TestShouldEarlyExpireHuge(t *testing.T) {
+	expiry := time.Unix(1000, 0)
+	now := expiry.Add(-time.Hour)
+	if !stampede.ShouldEarlyExpire(math.MaxInt64/2, 1e6, expiry, now, 0.5) {
+		t.Error("overflowing lead did not recompute")
+	}
+	if !stampede.ShouldEarlyExpire(time.Hour, 1, expiry, now, math.SmallestNonzeroFloat64) {
+		t.Error("tiny draw did not recompute")
+	}
+}
+
+// TestShouldEarlyExpireBoundary checks an item is expired at its expiry, as
+// with the strategies and hard expiry, not only after it
+func TestShouldEarlyExpireBoundary(t *testing.T) {
+	expiry := time.Unix(1000, 0)
+	if !stampede.ShouldEarlyExpire(0, 1, expiry, expiry, 0.5) {
+		t.Error("did not recompute at expiry")
+	}
+	if stampede.ShouldEarlyExpire(0, 1, expiry, expiry.Add(-1), 0.5) {
+		t.Error("recomputed before expiry without a delta")
+	}
+
+	ctx := context.Background()
+	clock := fakeclock.New(expiry.Add(-time.Second))
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithClock(clock))
+	calls := 0
+	recompute := func(ctx context.Context) (int, time.Duration, error) {
+		calls++
+		return calls, time.Second, nil
+	}
+	xf.Fetch(ctx, "k", recompute)
+	clock.Set(expiry)
+	if v, _ := xf.Fetch(ctx, "k", recompute); v != 2 {
+		t.Errorf("Fetch at expiry = %d, want a recomputed 2", v)
+	}
+}
+
+func TestXFetchStrategy(t *testing.T) {
+	expiry := time.Unix(1000, 0)
+	qcheck(t, func(d, b, l uint16, u uint32) bool {
+		delta, beta, expiry, now, rnd := args(d, b, l, u)
+		x := stampede.XFetch[int]{Beta: beta, Rand: func() float64 { return rnd }}
+		item := stampede.Item[int]{Expiry: expiry, Delta: delta}
+		return x.ShouldRecompute(item, now) == stampede.ShouldEarlyExpire(delta, beta, expiry, now, rnd)
+	})
+
+	// the default source draws from [0,1)
+	x := stampede.XFetch[int]{Beta: 1}
+	item := stampede.Item[int]{Expiry: expiry, Delta: time.Millisecond}
+	if x.ShouldRecompute(item, expiry.Add(-time.Hour)) {
+		t.Error("recomputed an hour ahead of a millisecond's delta")
+	}
+}
+
+func TestFixedWindow(t *testing.T) {
+	expiry := time.Unix(1000, 0)
+	w := stampede.FixedWindow[int]{Window: time.Minute}
+	item := stampede.Item[int]{Expiry: expiry}
+	for _, tt := range []struct {
+		left time.Duration
+		want bool
+	}{
+		{2 * time.Minute, false},
+		{time.Minute + 1, false},
+		{time.Minute, true},
+		{time.Second, true},
+	} {
+		if got := w.ShouldRecompute(item, expiry.Add(-tt.left)); got != tt.want {
+			t.Errorf("%v left: ShouldRecompute = %v, want %v", tt.left, got, tt.want)
+		}
+	}
+}
+
+func TestTTLFraction(t *testing.T) {
+	created := time.Unix(1000, 0)
+	f := stampede.TTLFraction[int]{Fraction: 0.25}
+	item := stampede.Item[int]{Created: created, Expiry: created.Add(100 * time.Second)}
+	for _, tt := range []struct {
+		elapsed time.Duration
+		want    bool
+	}{
+		{0, false},
+		{75 * time.Second, false},
+		{76 * time.Second, true},
+	} {
+		if got := f.ShouldRecompute(item, created.Add(tt.elapsed)); got != tt.want {
+			t.Errorf("after %v: ShouldRecompute = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+
+	item.Created = time.Time{}
+	if f.ShouldRecompute(item, item.Expiry.Add(-time.Second)) {
+		t.Error("recomputed an item of unknown age")
+	}
+}
+
+func TestWithStrategy(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithStrategy[int](stampede.FixedWindow[int]{Window: 10 * time.Second}),
+	)
+
+	recomputes := 0
+	recompute := func(ctx context.Context) (int, time.Duration, error) {
+		recomputes++
+		return recomputes, time.Minute, nil
+	}
+	xf.Fetch(ctx, "k", recompute)
+	clock.Advance(49 * time.Second)
+	if v, _ := xf.Fetch(ctx, "k", recompute); v != 1 {
+		t.Fatalf("Fetch outside the window = %d, want the cached 1", v)
+	}
+	clock.Advance(time.Second)
+	if v, _ := xf.Fetch(ctx, "k", recompute); v != 2 {
+		t.Fatalf("Fetch in the window = %d, want a recompute", v)
+	}
+}
+
+func TestWithRandExtremes(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	for _, tt := range []struct {
+		name string
+		rand func() float64
+		want int
+	}{
+		{"AlwaysExpire", stampede.AlwaysExpire, 3},
+		{"NeverExpire", stampede.NeverExpire, 1},
+	} {
+		xf := stampede.New[string, int](memcache.New[string, int](),
+			stampede.WithClock(clock),
+			stampede.WithRand(tt.rand),
+		)
+		recomputes := 0
+		for range 3 {
+			xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+				recomputes++
+				return 1, time.Minute, nil
+			})
+		}
+		if recomputes != tt.want {
+			t.Errorf("%s: %d recomputes, want %d", tt.name, recomputes, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package stampede_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+)
+
+func TestStripedLocker(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	l := stampede.NewStripedLocker[string](1024, clock)
+
+	token, ok, err := l.TryLock(ctx, "k", time.Minute)
+	if err != nil || !ok || token == "" {
+		t.Fatalf("TryLock = %q, %v, %v", token, ok, err)
+	}
+	if _, ok, _ := l.TryLock(ctx, "k", time.Minute); ok {
+		t.Fatal("second TryLock succeeded")
+	}
+
+	// only the holder's token unlocks
+	l.Unlock(ctx, "k", "0")
+	if _, ok, _ := l.TryLock(ctx, "k", time.Minute); ok {
+		t.Fatal("TryLock succeeded after Unlock with the wrong token")
+	}
+	l.Unlock(ctx, "k", token)
+	token, ok, _ = l.TryLock(ctx, "k", time.Minute)
+	if !ok {
+		t.Fatal("TryLock after Unlock failed")
+	}
+
+	// an expired lock is taken over, and its old token no longer unlocks
+	clock.Advance(time.Minute)
+	next, ok, _ := l.TryLock(ctx, "k", time.Minute)
+	if !ok || next == token {
+		t.Fatalf("TryLock of an expired lock = %q, %v", next, ok)
+	}
+	l.Unlock(ctx, "k", token)
+	if _, ok, _ := l.TryLock(ctx, "k", time.Minute); ok {
+		t.Error("stale token released the lock")
+	}
+
+	want := stampede.LockStats{Acquired: 3, Contended: 3, Expired: 1}
+	if st := l.Stats(); st != want {
+		t.Errorf("Stats = %+v, want %+v", st, want)
+	}
+}
+
+func TestStripedLockerStripes(t *testing.T) {
+	ctx := context.Background()
+
+	// with one stripe every key shares the lock
+	l := stampede.NewStripedLocker[int](0, nil)
+	l.TryLock(ctx, 1, time.Minute)
+	if _, ok, _ := l.TryLock(ctx, 2, time.Minute); ok {
+		t.Error("TryLock of another key on the only stripe succeeded")
+	}
+
+	// with many, most keys do not contend
+	l = stampede.NewStripedLocker[int](4096, nil)
+	held := 0
+	for key := range 64 {
+		if _, ok, _ := l.TryLock(ctx, key, time.Minute); ok {
+			held++
+		}
+	}
+	if held < 60 {
+		t.Errorf("%d of 64 keys locked, want nearly all", held)
+	}
+}
@@ -0,0 +1,223 @@
+// Package testutil provides caches for unit-testing code using stampede
+package testutil
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+)
+
+// NullCache is a stampede.Cache which stores nothing: every read misses and
+// every write is discarded, so every fetch recomputes
+type NullCache[K comparable, V any] struct{}
+
+// Get implements stampede.Cache
+func (NullCache[K, V]) Get(ctx context.Context, key K) (stampede.Item[V], error) {
+	return stampede.Item[V]{}, stampede.ErrCacheMiss
+}
+
+// Set implements stampede.Cache
+func (NullCache[K, V]) Set(ctx context.Context, key K, item stampede.Item[V]) error {
+	return nil
+}
+
+// Delete implements stampede.Deleter
+func (NullCache[K, V]) Delete(ctx context.Context, key K) error {
+	return nil
+}
+
+// Op is a kind of cache operation
+type Op int
+
+// The kinds of operation
+const (
+	OpGet Op = iota
+	OpSet
+	OpSetIfNewer
+	OpDelete
+	OpGetMulti
+	OpSetMulti
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpSet:
+		return "set"
+	case OpSetIfNewer:
+		return "setifnewer"
+	case OpDelete:
+		return "delete"
+	case OpGetMulti:
+		return "getmulti"
+	case OpSetMulti:
+		return "setmulti"
+	}
+	return "unknown"
+}
+
+// Record is one operation seen by a RecordingCache
+type Record[K comparable, V any] struct {
+	Op   Op
+	Time time.Time
+
+	// Keys holds the key, or for OpGetMulti and OpSetMulti the keys, of
+	// the operation
+	Keys []K
+
+	// Item is the item written, or read by a successful OpGet.  Items holds
+	// the items of an OpSetMulti instead.
+	Item  stampede.Item[V]
+	Items map[K]stampede.Item[V]
+
+	// Stored is whether an OpSetIfNewer wrote its item
+	Stored bool
+
+	Err error
+}
+
+// RecordingCache wraps a stampede.Cache, recording every operation made
+// through it.  Deletes, batch reads and writes, and conditional writes are
+// passed on if
+// the wrapped cache supports them, and emulated as stampede does otherwise.
+type RecordingCache[K comparable, V any] struct {
+	inner stampede.Cache[K, V]
+	clock stampede.Clock
+
+	mu      sync.Mutex
+	records []Record[K, V]
+}
+
+// NewRecordingCache returns a RecordingCache wrapping inner, timestamping
+// records with clock, or the system clock if it is nil
+func NewRecordingCache[K comparable, V any](inner stampede.Cache[K, V], clock stampede.Clock) *RecordingCache[K, V] {
+	if clock == nil {
+		clock = stampede.SystemClock{}
+	}
+	return &RecordingCache[K, V]{inner: inner, clock: clock}
+}
+
+// Get implements stampede.Cache
+func (c *RecordingCache[K, V]) Get(ctx context.Context, key K) (stampede.Item[V], error) {
+	t := c.clock.Now()
+	item, err := c.inner.Get(ctx, key)
+	c.record(Record[K, V]{Op: OpGet, Time: t, Keys: []K{key}, Item: item, Err: err})
+	return item, err
+}
+
+// Set implements stampede.Cache
+func (c *RecordingCache[K, V]) Set(ctx context.Context, key K, item stampede.Item[V]) error {
+	t := c.clock.Now()
+	err := c.inner.Set(ctx, key, item)
+	c.record(Record[K, V]{Op: OpSet, Time: t, Keys: []K{key}, Item: item, Err: err})
+	return err
+}
+
+// SetIfNewer implements stampede.ConditionalSetter
+func (c *RecordingCache[K, V]) SetIfNewer(ctx context.Context, key K, item stampede.Item[V]) (bool, error) {
+	t := c.clock.Now()
+	var stored bool
+	var err error
+	if cs, ok := c.inner.(stampede.ConditionalSetter[K, V]); ok {
+		stored, err = cs.SetIfNewer(ctx, key, item)
+	} else {
+		err = c.inner.Set(ctx, key, item)
+		stored = err == nil
+	}
+	c.record(Record[K, V]{Op: OpSetIfNewer, Time: t, Keys: []K{key}, Item: item, Stored: stored, Err: err})
+	return stored, err
+}
+
+// Delete implements stampede.Deleter.  It fails with
+// stampede.ErrDeleteUnsupported if the wrapped cache cannot delete.
+func (c *RecordingCache[K, V]) Delete(ctx context.Context, key K) error {
+	t := c.clock.Now()
+	err := stampede.ErrDeleteUnsupported
+	if d, ok := c.inner.(stampede.Deleter[K]); ok {
+		err = d.Delete(ctx, key)
+	}
+	c.record(Record[K, V]{Op: OpDelete, Time: t, Keys: []K{key}, Err: err})
+	return err
+}
+
+// GetMulti implements stampede.BatchGetter
+func (c *RecordingCache[K, V]) GetMulti(ctx context.Context, keys []K) (map[K]stampede.Item[V], error) {
+	t := c.clock.Now()
+	var items map[K]stampede.Item[V]
+	var err error
+	if bg, ok := c.inner.(stampede.BatchGetter[K, V]); ok {
+		items, err = bg.GetMulti(ctx, keys)
+	} else {
+		items = make(map[K]stampede.Item[V], len(keys))
+		for _, key := range keys {
+			item, gerr := c.inner.Get(ctx, key)
+			if errors.Is(gerr, stampede.ErrCacheMiss) {
+				continue
+			}
+			if gerr != nil {
+				err = gerr
+				break
+			}
+			items[key] = item
+		}
+	}
+	c.record(Record[K, V]{Op: OpGetMulti, Time: t, Keys: slices.Clone(keys), Err: err})
+	return items, err
+}
+
+// SetMulti implements stampede.BatchSetter
+func (c *RecordingCache[K, V]) SetMulti(ctx context.Context, items map[K]stampede.Item[V]) error {
+	t := c.clock.Now()
+	var err error
+	if bs, ok := c.inner.(stampede.BatchSetter[K, V]); ok {
+		err = bs.SetMulti(ctx, items)
+	} else {
+		var errs []error
+		for key, item := range items {
+			errs = append(errs, c.inner.Set(ctx, key, item))
+		}
+		err = errors.Join(errs...)
+	}
+	keys := slices.Collect(maps.Keys(items))
+	c.record(Record[K, V]{Op: OpSetMulti, Time: t, Keys: keys, Items: maps.Clone(items), Err: err})
+	return err
+}
+
+func (c *RecordingCache[K, V]) record(r Record[K, V]) {
+	c.mu.Lock()
+	c.records = append(c.records, r)
+	c.mu.Unlock()
+}
+
+// Records returns the operations so far, in the order they completed
+func (c *RecordingCache[K, V]) Records() []Record[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.records)
+}
+
+// Count returns how many operations of kind op were made on key
+func (c *RecordingCache[K, V]) Count(op Op, key K) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for _, r := range c.records {
+		if r.Op == op && slices.Contains(r.Keys, key) {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset discards the records so far
+func (c *RecordingCache[K, V]) Reset() {
+	c.mu.Lock()
+	c.records = nil
+	c.mu.Unlock()
+}
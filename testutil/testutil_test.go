@@ -0,0 +1,81 @@
+package testutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+func TestNullCache(t *testing.T) {
+	ctx := context.Background()
+	var c testutil.NullCache[string, string]
+	if err := c.Set(ctx, "k", stampede.Item[string]{Value: "v"}); err != nil {
+		t.Fatalf("Set = %v", err)
+	}
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, stampede.ErrCacheMiss) {
+		t.Errorf("Get = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestRecordingCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return testutil.NewRecordingCache[string, string](memcache.New[string, string](), nil)
+	})
+}
+
+func TestRecords(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Unix(1000, 0))
+	c := testutil.NewRecordingCache[string, string](memcache.New[string, string](), clock)
+
+	c.Get(ctx, "a")
+	clock.Advance(time.Second)
+	c.Set(ctx, "a", stampede.Item[string]{Value: "v"})
+	c.SetMulti(ctx, map[string]stampede.Item[string]{"a": {Value: "v"}, "b": {Value: "w"}})
+	c.GetMulti(ctx, []string{"a", "b"})
+	c.Delete(ctx, "b")
+
+	records := c.Records()
+	want := []testutil.Op{testutil.OpGet, testutil.OpSet, testutil.OpSetMulti, testutil.OpGetMulti, testutil.OpDelete}
+	if len(records) != len(want) {
+		t.Fatalf("%d records, want %d", len(records), len(want))
+	}
+	for i, op := range want {
+		if records[i].Op != op {
+			t.Errorf("record %d is a %v, want %v", i, records[i].Op, op)
+		}
+	}
+	if r := records[0]; !errors.Is(r.Err, stampede.ErrCacheMiss) || !r.Time.Equal(time.Unix(1000, 0)) {
+		t.Errorf("get record = %+v, want a miss at the start", r)
+	}
+	if r := records[1]; r.Item.Value != "v" || !r.Time.Equal(time.Unix(1001, 0)) {
+		t.Errorf("set record = %+v", r)
+	}
+
+	if n := c.Count(testutil.OpSet, "a"); n != 1 {
+		t.Errorf("Count(set, a) = %d, want 1", n)
+	}
+	if n := c.Count(testutil.OpGetMulti, "b"); n != 1 {
+		t.Errorf("Count(getmulti, b) = %d, want 1", n)
+	}
+	c.Reset()
+	if len(c.Records()) != 0 {
+		t.Error("Records after Reset not empty")
+	}
+}
+
+func TestRecordingCacheDeleteUnsupported(t *testing.T) {
+	// a cache without Delete
+	inner := struct{ stampede.Cache[string, string] }{memcache.New[string, string]()}
+	c := testutil.NewRecordingCache[string, string](inner, nil)
+	if err := c.Delete(context.Background(), "k"); !errors.Is(err, stampede.ErrDeleteUnsupported) {
+		t.Errorf("Delete = %v, want ErrDeleteUnsupported", err)
+	}
+}
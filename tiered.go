@@ -0,0 +1,196 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TieredCache composes an in-process L1 cache in front of a remote L2 cache.
+// Reads try L1, then L2, promoting L2 hits into L1.  Writes go to both.
+//
+// L1 stores each item wrapped in an outer Item whose Expiry is the L1 entry's
+// own deadline, so the item's real expiry and delta are seen unchanged by the
+// XFetch algorithm.  Once the L1 deadline passes, the key is read from L2 again.
+// To hedge reads against slow L2 nodes, L2 may be a ShardedCache with
+// replicas and a HedgeDelay.
+type TieredCache[K comparable, V any] struct {
+	L1 Cache[K, Item[V]]
+	L2 Cache[K, V]
+
+	// L1TTLScale scales the remaining time-to-live of items written to L1.
+	// Values below 1 make L1 re-read L2 before the item expires.
+	L1TTLScale float64
+
+	// Clock is the source of time for L1 deadlines.  Nil means
+	// SystemClock.
+	Clock Clock
+
+	// Bus, if set, announces deleted keys so other processes evict them
+	// from their L1; see Listen.
+	Bus InvalidationBus[K]
+}
+
+// NewTieredCache returns a TieredCache with an L1TTLScale of 1
+func NewTieredCache[K comparable, V any](l1 Cache[K, Item[V]], l2 Cache[K, V]) *TieredCache[K, V] {
+	return &TieredCache[K, V]{L1: l1, L2: l2, L1TTLScale: 1}
+}
+
+// Get implements Cache.  If L2 fails with an error other than ErrCacheMiss,
+// an L1 entry past its L1 deadline is returned instead.
+func (t *TieredCache[K, V]) Get(ctx context.Context, key K) (Item[V], error) {
+	l1, l1err := t.L1.Get(ctx, key)
+	if l1err == nil && !l1.Expired(t.now()) {
+		return l1.Value, nil
+	}
+
+	item, err := t.L2.Get(ctx, key)
+	if err != nil {
+		if l1err == nil && !errors.Is(err, ErrCacheMiss) {
+			return l1.Value, nil
+		}
+		return Item[V]{}, err
+	}
+
+	// promotion is best-effort
+	_ = t.L1.Set(ctx, key, t.l1Item(item))
+	return item, nil
+}
+
+// Set implements Cache
+func (t *TieredCache[K, V]) Set(ctx context.Context, key K, item Item[V]) error {
+	err := t.L2.Set(ctx, key, item)
+	return errors.Join(err, t.L1.Set(ctx, key, t.l1Item(item)))
+}
+
+// SetIfNewer implements ConditionalSetter.  The write to L2 is conditional if
+// L2 implements ConditionalSetter; if it is refused, the key is evicted from
+// L1 so the newer item is read from L2.
+func (t *TieredCache[K, V]) SetIfNewer(ctx context.Context, key K, item Item[V]) (bool, error) {
+	cs, ok := t.L2.(ConditionalSetter[K, V])
+	if !ok {
+		return true, t.Set(ctx, key, item)
+	}
+	stored, err := cs.SetIfNewer(ctx, key, item)
+	if err != nil {
+		return false, err
+	}
+	if !stored {
+		_ = deleteKey(ctx, t.L1, key)
+		return false, nil
+	}
+	return true, t.L1.Set(ctx, key, t.l1Item(item))
+}
+
+// GetMulti implements BatchGetter, reading the keys missing or past their
+// deadline in L1 from L2 together and promoting them.  Each tier is read in
+// one operation if it supports it.  If L2 fails, L1 entries past their
+// deadline are returned instead, along with the error.
+func (t *TieredCache[K, V]) GetMulti(ctx context.Context, keys []K) (map[K]Item[V], error) {
+	l1, l1err := getMulti(ctx, t.L1, keys)
+	if l1err != nil {
+		l1 = nil
+	}
+
+	items := make(map[K]Item[V], len(keys))
+	var rest []K
+	now := t.now()
+	for _, key := range keys {
+		if e, ok := l1[key]; ok && !e.Expired(now) {
+			items[key] = e.Value
+			continue
+		}
+		rest = append(rest, key)
+	}
+	if len(rest) == 0 {
+		return items, nil
+	}
+
+	got, err := getMulti(ctx, t.L2, rest)
+	for _, key := range rest {
+		if _, ok := got[key]; ok {
+			continue
+		}
+		if e, ok := l1[key]; ok && err != nil {
+			items[key] = e.Value
+		}
+	}
+	promote := make(map[K]Item[Item[V]], len(got))
+	for key, item := range got {
+		items[key] = item
+		promote[key] = t.l1Item(item)
+	}
+	// promotion is best-effort
+	_ = setMulti(ctx, t.L1, promote)
+	return items, err
+}
+
+// SetMulti implements BatchSetter, writing each tier in one operation if it
+// supports it
+func (t *TieredCache[K, V]) SetMulti(ctx context.Context, items map[K]Item[V]) error {
+	err := setMulti(ctx, t.L2, items)
+	l1 := make(map[K]Item[Item[V]], len(items))
+	for key, item := range items {
+		l1[key] = t.l1Item(item)
+	}
+	return errors.Join(err, setMulti(ctx, t.L1, l1))
+}
+
+// ClaimLease implements LeaseClaimer.  The claim is made on L2, atomically if
+// L2 implements LeaseClaimer, and the lease then written to L1.
+func (t *TieredCache[K, V]) ClaimLease(ctx context.Context, key K, prev *Item[V], lease Item[V]) (bool, error) {
+	lc, ok := t.L2.(LeaseClaimer[K, V])
+	if !ok {
+		return true, t.Set(ctx, key, lease)
+	}
+	claimed, err := lc.ClaimLease(ctx, key, prev, lease)
+	if err != nil || !claimed {
+		return false, err
+	}
+	return true, t.L1.Set(ctx, key, t.l1Item(lease))
+}
+
+// Delete implements Deleter.  The key is deleted from both tiers; L2 must
+// support deletion, L1 is skipped if it does not.  The deletion is then
+// published on Bus, if set.
+func (t *TieredCache[K, V]) Delete(ctx context.Context, key K) error {
+	err := deleteKey(ctx, t.L2, key)
+	if l1err := deleteKey(ctx, t.L1, key); !errors.Is(l1err, ErrDeleteUnsupported) {
+		err = errors.Join(err, l1err)
+	}
+	if t.Bus != nil {
+		err = errors.Join(err, t.Bus.Publish(ctx, key))
+	}
+	return err
+}
+
+// Listen evicts keys published on Bus from L1, until ctx is done or the
+// subscription fails.  L1 must support deletion.  Each process sharing L2
+// should run Listen in its own goroutine.
+func (t *TieredCache[K, V]) Listen(ctx context.Context) error {
+	if _, ok := t.L1.(Deleter[K]); !ok {
+		return ErrDeleteUnsupported
+	}
+	return t.Bus.Subscribe(ctx, func(key K) {
+		_ = deleteKey(ctx, t.L1, key)
+	})
+}
+
+// l1Item wraps item for storage in L1
+func (t *TieredCache[K, V]) l1Item(item Item[V]) Item[Item[V]] {
+	if item.Expiry.IsZero() {
+		return Item[Item[V]]{Value: item}
+	}
+	now := t.now()
+	return Item[Item[V]]{
+		Value:  item,
+		Expiry: now.Add(time.Duration(float64(item.Expiry.Sub(now)) * t.L1TTLScale)),
+	}
+}
+
+func (t *TieredCache[K, V]) now() time.Time {
+	if t.Clock == nil {
+		return time.Now()
+	}
+	return t.Clock.Now()
+}
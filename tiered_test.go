@@ -0,0 +1,183 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/cachetest"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+	"github.com/dgryski/go-stampede/testutil"
+)
+
+// flakyCache is a Cache which fails every operation while down
+type flakyCache struct {
+	*memcache.Cache[string, int]
+	down atomic.Bool
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) (stampede.Item[int], error) {
+	if c.down.Load() {
+		return stampede.Item[int]{}, errBackend
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+// localBus is an InvalidationBus within the process
+type localBus struct {
+	mu   sync.Mutex
+	subs []chan string
+}
+
+func (b *localBus) Publish(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		ch <- key
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(ctx context.Context, fn func(key string)) error {
+	ch := make(chan string, 16)
+	b.mu.Lock()
+	b.subs = append(b.subs, ch)
+	b.mu.Unlock()
+	for {
+		select {
+		case key := <-ch:
+			fn(key)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	cachetest.TestCache(t, func(t *testing.T) stampede.Cache[string, string] {
+		return stampede.NewTieredCache[string, string](memcache.New[string, stampede.Item[string]](), memcache.New[string, string]())
+	})
+}
+
+func TestTieredCacheL1(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	l1 := memcache.New[string, stampede.Item[int]]()
+	l2 := testutil.NewRecordingCache[string, int](memcache.New[string, int](), clock)
+	tc := stampede.NewTieredCache[string, int](l1, l2)
+	tc.L1TTLScale, tc.Clock = 0.5, clock
+
+	item := stampede.Item[int]{Value: 1, Expiry: clock.Now().Add(time.Minute), Delta: time.Second}
+	tc.Set(ctx, "k", item)
+	l2.Reset()
+
+	// L1 serves the item unchanged until half its TTL has passed
+	clock.Advance(29 * time.Second)
+	if got, err := tc.Get(ctx, "k"); err != nil || !got.Expiry.Equal(item.Expiry) || got.Delta != item.Delta {
+		t.Fatalf("Get = %+v, %v; want %+v", got, err, item)
+	}
+	if n := l2.Count(testutil.OpGet, "k"); n != 0 {
+		t.Fatalf("%d L2 reads within the L1 deadline, want 0", n)
+	}
+	clock.Advance(time.Second)
+	tc.Get(ctx, "k")
+	if n := l2.Count(testutil.OpGet, "k"); n != 1 {
+		t.Fatalf("%d L2 reads past the L1 deadline, want 1", n)
+	}
+
+	// the L2 hit was promoted with a new deadline, half the remaining TTL
+	clock.Advance(14 * time.Second)
+	tc.Get(ctx, "k")
+	if n := l2.Count(testutil.OpGet, "k"); n != 1 {
+		t.Errorf("%d L2 reads after promotion, want 1", n)
+	}
+}
+
+func TestTieredCacheL2Down(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	l2 := &flakyCache{Cache: memcache.New[string, int]()}
+	tc := stampede.NewTieredCache[string, int](memcache.New[string, stampede.Item[int]](), l2)
+	tc.L1TTLScale, tc.Clock = 0.5, clock
+	tc.Set(ctx, "k", stampede.Item[int]{Value: 1, Expiry: clock.Now().Add(time.Minute)})
+
+	// past its L1 deadline, the L1 entry stands in for a failed L2
+	clock.Advance(45 * time.Second)
+	l2.down.Store(true)
+	if item, err := tc.Get(ctx, "k"); err != nil || item.Value != 1 {
+		t.Errorf("Get with L2 down = %+v, %v; want the L1 entry", item, err)
+	}
+	items, err := tc.GetMulti(ctx, []string{"k", "absent"})
+	if !errors.Is(err, errBackend) || len(items) != 1 || items["k"].Value != 1 {
+		t.Errorf("GetMulti with L2 down = %v, %v; want the L1 entry and the error", items, err)
+	}
+	if _, err := tc.Get(ctx, "absent"); !errors.Is(err, errBackend) {
+		t.Errorf("Get(absent) with L2 down = %v, want its error", err)
+	}
+}
+
+func TestTieredCacheSetIfNewerRefused(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := memcache.New[string, stampede.Item[int]](), memcache.New[string, int]()
+	tc := stampede.NewTieredCache[string, int](l1, l2)
+	now := time.Now()
+
+	// another process wrote a newer item to L2 only
+	tc.Set(ctx, "k", stampede.Item[int]{Value: 1, Created: now.Add(-time.Hour)})
+	l2.Set(ctx, "k", stampede.Item[int]{Value: 3, Created: now})
+	if ok, err := tc.SetIfNewer(ctx, "k", stampede.Item[int]{Value: 2, Created: now.Add(-time.Minute)}); ok || err != nil {
+		t.Fatalf("SetIfNewer of an older item = %v, %v", ok, err)
+	}
+	if item, _ := tc.Get(ctx, "k"); item.Value != 3 {
+		t.Errorf("Get after a refused write = %d, want L2's newer 3", item.Value)
+	}
+}
+
+func TestTieredCacheBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := &localBus{}
+	l2 := memcache.New[string, int]()
+	a := stampede.NewTieredCache[string, int](memcache.New[string, stampede.Item[int]](), l2)
+	bl1 := memcache.New[string, stampede.Item[int]]()
+	b := stampede.NewTieredCache[string, int](bl1, l2)
+	a.Bus, b.Bus = bus, bus
+
+	done := make(chan error)
+	go func() { done <- b.Listen(ctx) }()
+	waitFor(t, func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		return len(bus.subs) == 1
+	})
+
+	a.Set(ctx, "k", stampede.Item[int]{Value: 1})
+	b.Get(ctx, "k")
+	if err := a.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete = %v", err)
+	}
+	waitFor(t, func() bool {
+		_, err := bl1.Get(ctx, "k")
+		return errors.Is(err, stampede.ErrCacheMiss)
+	})
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Listen = %v, want context.Canceled", err)
+	}
+
+	// L1 must support deletion to listen
+	nodelete := struct {
+		stampede.Cache[string, stampede.Item[int]]
+	}{memcache.New[string, stampede.Item[int]]()}
+	c := stampede.NewTieredCache[string, int](nodelete, l2)
+	c.Bus = bus
+	if err := c.Listen(ctx); !errors.Is(err, stampede.ErrDeleteUnsupported) {
+		t.Errorf("Listen without L1 deletion = %v, want ErrDeleteUnsupported", err)
+	}
+}
@@ -0,0 +1,108 @@
+package stampede
+
+import (
+	"context"
+	"time"
+)
+
+// Decision is how Fetch treated the cached item
+type Decision int
+
+const (
+	// DecisionHit means a fresh value was served from the cache
+	DecisionHit Decision = iota
+
+	// DecisionMiss means the key was absent from the cache or expired
+	DecisionMiss
+
+	// DecisionEarlyExpire means the XFetch algorithm chose to recompute a
+	// value which had not yet expired
+	DecisionEarlyExpire
+)
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionHit:
+		return "hit"
+	case DecisionMiss:
+		return "miss"
+	case DecisionEarlyExpire:
+		return "early-expire"
+	}
+	return "unknown"
+}
+
+// FetchInfo describes the cache lookup made by Fetch
+type FetchInfo struct {
+	Decision Decision
+
+	// Delta is the cached item's recompute time, and TTL the time
+	// remaining until it expires, negative if already expired.  Both are
+	// zero if the key was absent.
+	Delta time.Duration
+	TTL   time.Duration
+
+	// Beta is the beta of the early expiration decision
+	Beta float64
+
+	// Explain holds the inputs of the early expiration decision, if the
+	// fetch was explained with WithExplain or WithFetchExplain and the key
+	// was present
+	Explain *Explanation
+}
+
+// Explanation records why a fetch did or did not recompute a cached item
+type Explanation struct {
+	// Delta is the recompute time used, as bounded by WithDeltaFloor and
+	// WithDeltaCap, and TTL the time remaining until expiry, negative if
+	// already expired
+	Delta time.Duration
+	TTL   time.Duration
+	Beta  float64
+
+	// Rand is the random draw of the XFetch formula, and Gap the
+	// resulting -Delta*Beta*log(Rand): the fetch recomputes if Gap is at
+	// least TTL.  Both are zero if the item never expires, or if the
+	// decision was made by the WithStrategy strategy, flagged by Strategy.
+	Rand     float64
+	Gap      time.Duration
+	Strategy bool
+
+	// Recompute is the decision
+	Recompute bool
+}
+
+// Tracer traces fetches, e.g. with OpenTelemetry spans
+type Tracer[K comparable] interface {
+	// StartFetch starts a span around Fetch.  The returned context is
+	// used for the cache accesses and recompute.
+	StartFetch(ctx context.Context, key K) (context.Context, Span)
+
+	// StartRecompute starts a span around the recompute function
+	StartRecompute(ctx context.Context, key K) (context.Context, Span)
+}
+
+// Span is a traced operation
+type Span interface {
+	// Annotate records the cache lookup made by a fetch.  It is not called
+	// on recompute spans.
+	Annotate(info FetchInfo)
+
+	// End finishes the span, recording err if non-nil
+	End(err error)
+}
+
+type nopTracer[K comparable] struct{}
+
+func (nopTracer[K]) StartFetch(ctx context.Context, key K) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopTracer[K]) StartRecompute(ctx context.Context, key K) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) Annotate(FetchInfo) {}
+func (nopSpan) End(error)          {}
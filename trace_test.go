@@ -0,0 +1,113 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestDecisionString(t *testing.T) {
+	for _, tt := range []struct {
+		d    stampede.Decision
+		want string
+	}{
+		{stampede.DecisionHit, "hit"},
+		{stampede.DecisionMiss, "miss"},
+		{stampede.DecisionEarlyExpire, "early-expire"},
+		{stampede.Decision(-1), "unknown"},
+	} {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("Decision(%d).String() = %q, want %q", int(tt.d), got, tt.want)
+		}
+	}
+}
+
+// spanKey marks the contexts of recordingTracer spans
+type spanKey struct{}
+
+// recordingTracer records the spans it starts
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name  string
+	key   string
+	info  []stampede.FetchInfo
+	err   error
+	ended bool
+}
+
+func (rt *recordingTracer) start(ctx context.Context, name, key string) (context.Context, stampede.Span) {
+	s := &recordedSpan{name: name, key: key}
+	rt.mu.Lock()
+	rt.spans = append(rt.spans, s)
+	rt.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (rt *recordingTracer) StartFetch(ctx context.Context, key string) (context.Context, stampede.Span) {
+	return rt.start(ctx, "fetch", key)
+}
+
+func (rt *recordingTracer) StartRecompute(ctx context.Context, key string) (context.Context, stampede.Span) {
+	return rt.start(ctx, "recompute", key)
+}
+
+func (s *recordedSpan) Annotate(info stampede.FetchInfo) { s.info = append(s.info, info) }
+func (s *recordedSpan) End(err error)                    { s.err, s.ended = err, true }
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	rt := &recordingTracer{}
+	xf := stampede.New[string, int](memcache.New[string, int](),
+		stampede.WithClock(clock),
+		stampede.WithRand(stampede.NeverExpire),
+		stampede.WithTracer[string](rt),
+	)
+
+	// the recompute runs under its span, inside the fetch's
+	var parent any
+	xf.Fetch(ctx, "k", func(ctx context.Context) (int, time.Duration, error) {
+		parent = ctx.Value(spanKey{})
+		return 1, time.Minute, nil
+	})
+	xf.Fetch(ctx, "k", succeeding)
+	clock.Advance(2 * time.Minute)
+	xf.Fetch(ctx, "k", failing)
+
+	if len(rt.spans) != 5 {
+		t.Fatalf("%d spans started, want 5", len(rt.spans))
+	}
+	miss, recompute, hit, expired, failed := rt.spans[0], rt.spans[1], rt.spans[2], rt.spans[3], rt.spans[4]
+	if parent != recompute || recompute.name != "recompute" || len(recompute.info) != 0 {
+		t.Errorf("recompute span = %+v", recompute)
+	}
+	for _, tt := range []struct {
+		s        *recordedSpan
+		decision stampede.Decision
+		err      error
+	}{
+		{miss, stampede.DecisionMiss, nil},
+		{hit, stampede.DecisionHit, nil},
+		{expired, stampede.DecisionMiss, errOrigin},
+	} {
+		if tt.s.name != "fetch" || tt.s.key != "k" || !tt.s.ended || len(tt.s.info) != 1 || tt.s.info[0].Decision != tt.decision || !errors.Is(tt.s.err, tt.err) {
+			t.Errorf("fetch span = %+v, want decision %v ending with %v", tt.s, tt.decision, tt.err)
+		}
+	}
+	if !errors.Is(failed.err, errOrigin) || !failed.ended {
+		t.Errorf("failed recompute span = %+v", failed)
+	}
+	if info := expired.info[0]; info.TTL != -time.Minute || info.Beta != stampede.Beta {
+		t.Errorf("FetchInfo of an expired item = %+v", info)
+	}
+}
@@ -0,0 +1,63 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// warmWorkers is the number of keys Warm recomputes at once, unless
+// WithMaxConcurrentRecomputes sets a lower limit
+const warmWorkers = 8
+
+// Warm populates the cache for keys before traffic arrives, recomputing them
+// concurrently with recompute.  Recompute times are recorded as for Fetch, so
+// early expiration works from the first request.  Failures for individual
+// keys are joined in the returned error.
+func (xf *XFetcher[K, V]) Warm(ctx context.Context, keys []K, recompute func(ctx context.Context, key K) (V, time.Duration, error)) error {
+	workers := warmWorkers
+	if xf.maxRecomputes > 0 {
+		workers = min(workers, xf.maxRecomputes)
+	}
+
+	ch := make(chan K)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range ch {
+				_, _, err := xf.recompute(ctx, key, func(ctx context.Context) (V, time.Duration, error) {
+					return recompute(ctx, key)
+				}, nil)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("stampede: warming %v: %w", key, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, key := range keys {
+		select {
+		case ch <- key:
+		case <-ctx.Done():
+			mu.Lock()
+			errs = append(errs, ctx.Err())
+			mu.Unlock()
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
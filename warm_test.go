@@ -0,0 +1,99 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+func TestWarm(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	mc := memcache.New[string, int]()
+	xf := stampede.New[string, int](mc, stampede.WithClock(clock))
+
+	var keys []string
+	for i := range 20 {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	errOdd := errors.New("odd")
+	err := xf.Warm(ctx, keys, func(ctx context.Context, key string) (int, time.Duration, error) {
+		n, _ := strconv.Atoi(key)
+		if n%2 == 1 {
+			return 0, 0, errOdd
+		}
+		return n, time.Minute, nil
+	})
+	if !errors.Is(err, errOdd) {
+		t.Fatalf("Warm = %v, want the failures joined", err)
+	}
+
+	for _, key := range keys {
+		n, _ := strconv.Atoi(key)
+		item, err := mc.Get(ctx, key)
+		if n%2 == 1 {
+			if err == nil && item.Err == nil {
+				t.Errorf("failed key %s cached as %+v", key, item)
+			}
+			continue
+		}
+		if err != nil || item.Value != n || item.Created.IsZero() {
+			t.Errorf("warmed key %s = %+v, %v", key, item, err)
+		}
+	}
+	// warmed keys are hits
+	if _, err := xf.Fetch(ctx, "2", func(ctx context.Context) (int, time.Duration, error) {
+		t.Error("warmed key recomputed")
+		return 0, 0, nil
+	}); err != nil {
+		t.Errorf("Fetch of a warmed key = %v", err)
+	}
+}
+
+func TestWarmConcurrency(t *testing.T) {
+	ctx := context.Background()
+	xf := stampede.New[string, int](memcache.New[string, int](), stampede.WithMaxConcurrentRecomputes(2, stampede.LimitWait))
+
+	var running, peak atomic.Int32
+	var keys []string
+	for i := range 10 {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	err := xf.Warm(ctx, keys, func(ctx context.Context, key string) (int, time.Duration, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return 1, time.Minute, nil
+	})
+	if err != nil {
+		t.Fatalf("Warm = %v", err)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d concurrent recomputes, want at most 2", p)
+	}
+}
+
+func TestWarmCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	xf := stampede.New[string, int](memcache.New[string, int]())
+	err := xf.Warm(ctx, []string{"a", "b"}, func(ctx context.Context, key string) (int, time.Duration, error) {
+		return 1, time.Minute, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Warm with a cancelled context = %v, want context.Canceled", err)
+	}
+}
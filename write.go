@@ -0,0 +1,82 @@
+package stampede
+
+import "context"
+
+// WriteFailurePolicy determines how Fetch handles a failed cache write
+type WriteFailurePolicy int
+
+const (
+	// WriteFailureIgnore discards cache write errors
+	WriteFailureIgnore WriteFailurePolicy = iota
+
+	// WriteFailureReturn returns cache write errors from Fetch, along with
+	// the freshly computed value
+	WriteFailureReturn
+
+	// WriteFailureCallback passes cache write errors to the handler set with
+	// WithWriteErrorHandler
+	WriteFailureCallback
+
+	// WriteFailureRetry retries the write in the background with
+	// exponential backoff, as configured by WithWriteRetry.  If all attempts
+	// fail, the last error is passed to the handler set with
+	// WithWriteErrorHandler.
+	WriteFailureRetry
+)
+
+// write stores a computed item, in the background if WithAsyncWrites is set.
+// A non-nil return should be reported to the caller.
+func (xf *XFetcher[K, V]) write(ctx context.Context, key K, item Item[V]) error {
+	if xf.writes != nil {
+		return xf.enqueue(ctx, key, item)
+	}
+	return xf.writeSync(ctx, key, item)
+}
+
+func (xf *XFetcher[K, V]) writeSync(ctx context.Context, key K, item Item[V]) error {
+	err := xf.store(ctx, key, item)
+	if err == nil {
+		return nil
+	}
+	return xf.writeError(ctx, key, item, err)
+}
+
+// writeError records and handles err from writing item to key.  A non-nil
+// return should be reported to the caller.
+func (xf *XFetcher[K, V]) writeError(ctx context.Context, key K, item Item[V], err error) error {
+	xf.metrics.WriteFailure(key)
+	xf.stats.writeFailures.Add(1)
+	xf.logWriteFailure(ctx, key, err)
+	return xf.writeFailed(ctx, key, item, err)
+}
+
+// writeFailed handles err from writing item to key.  A non-nil return should
+// be reported to the caller.
+func (xf *XFetcher[K, V]) writeFailed(ctx context.Context, key K, item Item[V], err error) error {
+	switch xf.writePolicy {
+	case WriteFailureReturn:
+		return &CacheWriteError{Key: key, Err: err}
+	case WriteFailureCallback:
+		xf.writeErrorHandler(&CacheWriteError{Key: key, Err: err})
+	case WriteFailureRetry:
+		if !xf.goBackground(func() { xf.retryWrite(context.WithoutCancel(ctx), key, item) }) {
+			xf.writeErrorHandler(&CacheWriteError{Key: key, Err: ErrClosed})
+		}
+	}
+	return nil
+}
+
+// retryWrite retries a failed cache write until it succeeds or the attempts
+// configured with WithWriteRetry are exhausted
+func (xf *XFetcher[K, V]) retryWrite(ctx context.Context, key K, item Item[V]) {
+	var err error
+	backoff := xf.writeBackoff
+	for i := 0; i < xf.writeAttempts; i++ {
+		<-xf.clock.After(backoff)
+		backoff *= 2
+		if err = xf.store(ctx, key, item); err == nil {
+			return
+		}
+	}
+	xf.writeErrorHandler(&CacheWriteError{Key: key, Err: err})
+}
@@ -0,0 +1,138 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/fakeclock"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// failingWrites is a Cache whose next fails writes fail.  It embeds only the
+// Cache interface, so that writes are not made with SetIfNewer.
+type failingWrites struct {
+	stampede.Cache[string, int]
+	fails atomic.Int32
+}
+
+func (c *failingWrites) Set(ctx context.Context, key string, item stampede.Item[int]) error {
+	if c.fails.Add(-1) >= 0 {
+		return errWrite
+	}
+	return c.Cache.Set(ctx, key, item)
+}
+
+func newFailingWrites(fails int32) *failingWrites {
+	c := &failingWrites{Cache: memcache.New[string, int]()}
+	c.fails.Store(fails)
+	return c
+}
+
+// errorLog collects the errors passed to a write error handler
+type errorLog struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *errorLog) handle(err error) {
+	l.mu.Lock()
+	l.errs = append(l.errs, err)
+	l.mu.Unlock()
+}
+
+func (l *errorLog) get() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+func TestWriteFailurePolicies(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		policy  stampede.WriteFailurePolicy
+		ret     bool
+		handled bool
+	}{
+		{stampede.WriteFailureIgnore, false, false},
+		{stampede.WriteFailureReturn, true, false},
+		{stampede.WriteFailureCallback, false, true},
+	} {
+		var log errorLog
+		xf := stampede.New[string, int](newFailingWrites(1),
+			stampede.WithWriteFailurePolicy(tt.policy),
+			stampede.WithWriteErrorHandler(log.handle),
+		)
+		v, err := xf.Fetch(ctx, "k", succeeding)
+		if v != 1 {
+			t.Errorf("policy %d: Fetch = %d, want the computed value", tt.policy, v)
+		}
+		var werr *stampede.CacheWriteError
+		if got := errors.As(err, &werr); got != tt.ret || (got && (werr.Key != "k" || !errors.Is(err, errWrite))) {
+			t.Errorf("policy %d: Fetch = %v, want error returned %v", tt.policy, err, tt.ret)
+		}
+		if errs := log.get(); (len(errs) == 1) != tt.handled {
+			t.Errorf("policy %d: handler called with %v, want called %v", tt.policy, errs, tt.handled)
+		}
+		if n := xf.Stats().WriteFailures; n != 1 {
+			t.Errorf("policy %d: Stats().WriteFailures = %d, want 1", tt.policy, n)
+		}
+	}
+}
+
+func TestWriteFailureRetry(t *testing.T) {
+	ctx := context.Background()
+	clock := fakeclock.New(time.Now())
+	cache := newFailingWrites(2)
+	var log errorLog
+	xf := stampede.New[string, int](cache,
+		stampede.WithClock(clock),
+		stampede.WithWriteFailurePolicy(stampede.WriteFailureRetry),
+		stampede.WithWriteRetry(3, time.Second),
+		stampede.WithWriteErrorHandler(log.handle),
+	)
+
+	if _, err := xf.Fetch(ctx, "k", succeeding); err != nil {
+		t.Fatalf("Fetch = %v", err)
+	}
+	// the retry backs off a second, then two
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Second)
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(2 * time.Second)
+	if err := xf.Close(ctx); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	if item, err := cache.Get(ctx, "k"); err != nil || item.Value != 1 {
+		t.Errorf("cached after retries = %+v, %v", item, err)
+	}
+	if errs := log.get(); len(errs) != 0 {
+		t.Errorf("handler called with %v", errs)
+	}
+
+	// abandoned once the attempts run out
+	cache.fails.Store(10)
+	xf = stampede.New[string, int](cache,
+		stampede.WithClock(clock),
+		stampede.WithWriteFailurePolicy(stampede.WriteFailureRetry),
+		stampede.WithWriteRetry(1, time.Second),
+		stampede.WithWriteErrorHandler(log.handle),
+	)
+	xf.Fetch(ctx, "j", succeeding)
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Second)
+	xf.Close(ctx)
+	if errs := log.get(); len(errs) != 1 || !errors.Is(errs[0], errWrite) {
+		t.Errorf("handler called with %v, want the last write error", errs)
+	}
+
+	// and not started once the fetcher is closed
+	xf.Fetch(ctx, "i", succeeding)
+	if errs := log.get(); len(errs) != 2 || !errors.Is(errs[1], stampede.ErrClosed) {
+		t.Errorf("handler called with %v, want ErrClosed", errs)
+	}
+}
@@ -0,0 +1,95 @@
+package stampede
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrWriteQueueFull is passed to the write error handler when an
+// asynchronous write is dropped under the AsyncWriteDrop policy
+var ErrWriteQueueFull = errors.New("stampede: write queue full")
+
+// AsyncOverflowPolicy determines what happens to an asynchronous write when
+// the queue is full
+type AsyncOverflowPolicy int
+
+const (
+	// AsyncWriteBlock waits for room in the queue
+	AsyncWriteBlock AsyncOverflowPolicy = iota
+
+	// AsyncWriteDrop discards the write, passing ErrWriteQueueFull to the
+	// handler set with WithWriteErrorHandler
+	AsyncWriteDrop
+
+	// AsyncWriteSync performs the write synchronously
+	AsyncWriteSync
+)
+
+type writeJob[K comparable, V any] struct {
+	ctx  context.Context
+	key  K
+	item Item[V]
+}
+
+func (xf *XFetcher[K, V]) startWriters() {
+	xf.writes = make(chan writeJob[K, V], xf.asyncQueue)
+	xf.life.wg.Add(xf.asyncWorkers)
+	for range xf.asyncWorkers {
+		go func() {
+			defer xf.life.wg.Done()
+			for w := range xf.writes {
+				if err := xf.writeSync(w.ctx, w.key, w.item); err != nil {
+					// nobody is waiting for the write to return it
+					xf.writeErrorHandler(err)
+				}
+			}
+		}()
+	}
+}
+
+// enqueue queues a write for the background writers, handling a full queue
+// per the WithAsyncWrites policy.  Once the fetcher is closed writes are
+// synchronous.
+func (xf *XFetcher[K, V]) enqueue(ctx context.Context, key K, item Item[V]) error {
+	// the queue is only closed once the writes being queued are in
+	if !xf.life.add(&xf.life.senders) {
+		return xf.writeSync(ctx, key, item)
+	}
+	handled, err := xf.queue(ctx, key, item)
+	xf.life.senders.Done()
+	if handled || err != nil {
+		return err
+	}
+	return xf.writeSync(ctx, key, item)
+}
+
+// queue adds a write to the queue, or drops it.  It reports false if the
+// write should be made synchronously instead, as it is when the fetcher is
+// closed while waiting for room.
+func (xf *XFetcher[K, V]) queue(ctx context.Context, key K, item Item[V]) (bool, error) {
+	w := writeJob[K, V]{ctx: context.WithoutCancel(ctx), key: key, item: item}
+	select {
+	case xf.writes <- w:
+		return true, nil
+	default:
+	}
+
+	switch xf.asyncOverflow {
+	case AsyncWriteDrop:
+		xf.metrics.WriteFailure(key)
+		xf.stats.writeFailures.Add(1)
+		xf.logWriteFailure(ctx, key, ErrWriteQueueFull)
+		xf.writeErrorHandler(&CacheWriteError{Key: key, Err: ErrWriteQueueFull})
+		return true, nil
+	case AsyncWriteSync:
+		return false, nil
+	}
+	select {
+	case xf.writes <- w:
+		return true, nil
+	case <-xf.life.done:
+		return false, nil
+	case <-ctx.Done():
+		return true, &CacheWriteError{Key: key, Err: ctx.Err()}
+	}
+}
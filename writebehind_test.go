@@ -0,0 +1,190 @@
+package stampede_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgryski/go-stampede"
+	"github.com/dgryski/go-stampede/memcache"
+)
+
+// blockingWrites is a Cache whose writes wait for release
+type blockingWrites struct {
+	stampede.Cache[string, int]
+	release chan struct{}
+	started atomic.Int32
+}
+
+func (c *blockingWrites) Set(ctx context.Context, key string, item stampede.Item[int]) error {
+	c.started.Add(1)
+	<-c.release
+	return c.Cache.Set(ctx, key, item)
+}
+
+func newBlockingWrites() *blockingWrites {
+	return &blockingWrites{Cache: memcache.New[string, int](), release: make(chan struct{})}
+}
+
+func TestAsyncWrites(t *testing.T) {
+	ctx := context.Background()
+	cache := newBlockingWrites()
+	xf := stampede.New[string, int](cache, stampede.WithAsyncWrites(1, 4, stampede.AsyncWriteBlock))
+
+	// fetches return without waiting for their writes
+	for _, key := range []string{"a", "b", "c"} {
+		if v, err := xf.Fetch(ctx, key, succeeding); err != nil || v != 1 {
+			t.Fatalf("Fetch(%s) = %v, %v", key, v, err)
+		}
+	}
+
+	// Close drains the queue
+	closed := make(chan error)
+	go func() { closed <- xf.Close(ctx) }()
+	close(cache.release)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := cache.Get(ctx, key); err != nil {
+			t.Errorf("Get(%s) after Close = %v, want the write drained", key, err)
+		}
+	}
+
+	// after Close writes are synchronous
+	xf.Fetch(ctx, "d", succeeding)
+	if _, err := cache.Get(ctx, "d"); err != nil {
+		t.Errorf("Get(d) after a fetch on the closed fetcher = %v", err)
+	}
+}
+
+func TestAsyncWriteOverflow(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name     string
+		policy   stampede.AsyncOverflowPolicy
+		dropped  bool
+		blocking bool
+	}{
+		{"Drop", stampede.AsyncWriteDrop, true, false},
+		{"Sync", stampede.AsyncWriteSync, false, true},
+		{"Block", stampede.AsyncWriteBlock, false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newBlockingWrites()
+			var log errorLog
+			xf := stampede.New[string, int](cache,
+				stampede.WithAsyncWrites(1, 1, tt.policy),
+				stampede.WithWriteErrorHandler(log.handle),
+			)
+			defer xf.Close(ctx)
+
+			// the worker takes the first write and the queue the second
+			xf.Fetch(ctx, "a", succeeding)
+			waitFor(t, func() bool { return cache.started.Load() == 1 })
+			xf.Fetch(ctx, "b", succeeding)
+
+			done := make(chan error)
+			go func() {
+				_, err := xf.Fetch(ctx, "c", succeeding)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if tt.blocking {
+					t.Fatalf("overflowing Fetch returned %v without waiting", err)
+				}
+			case <-time.After(20 * time.Millisecond):
+				if !tt.blocking {
+					t.Fatal("overflowing Fetch blocked")
+				}
+			}
+			close(cache.release)
+			if tt.blocking {
+				if err := <-done; err != nil {
+					t.Fatalf("overflowing Fetch = %v", err)
+				}
+			}
+
+			errs := log.get()
+			if dropped := len(errs) == 1 && errors.Is(errs[0], stampede.ErrWriteQueueFull); dropped != tt.dropped {
+				t.Errorf("handler called with %v, want dropped %v", errs, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestAsyncWriteBlockCancel(t *testing.T) {
+	cache := newBlockingWrites()
+	defer close(cache.release)
+	xf := stampede.New[string, int](cache, stampede.WithAsyncWrites(1, 1, stampede.AsyncWriteBlock))
+
+	xf.Fetch(context.Background(), "a", succeeding)
+	waitFor(t, func() bool { return cache.started.Load() == 1 })
+	xf.Fetch(context.Background(), "b", succeeding)
+
+	// a fetch waiting for room in the queue gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	v, err := xf.Fetch(ctx, "c", succeeding)
+	var werr *stampede.CacheWriteError
+	if v != 1 || !errors.As(err, &werr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch = %v, %v; want the value and a write timeout", v, err)
+	}
+}
+
+func TestCloseBlockedWrite(t *testing.T) {
+	ctx := context.Background()
+	// the first write fails once released, and is retried
+	cache := &blockingWrites{Cache: newFailingWrites(1), release: make(chan struct{})}
+	var log errorLog
+	xf := stampede.New[string, int](cache,
+		stampede.WithAsyncWrites(1, 1, stampede.AsyncWriteBlock),
+		stampede.WithWriteFailurePolicy(stampede.WriteFailureRetry),
+		stampede.WithWriteRetry(3, time.Millisecond),
+		stampede.WithWriteErrorHandler(log.handle),
+	)
+
+	xf.Fetch(ctx, "a", succeeding)
+	waitFor(t, func() bool { return cache.started.Load() == 1 })
+	xf.Fetch(ctx, "b", succeeding)
+	fetched := make(chan error)
+	go func() {
+		_, err := xf.Fetch(ctx, "c", succeeding)
+		fetched <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Close keeps to its deadline while a fetch waits for room in the queue
+	closed := make(chan error)
+	go func() {
+		cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		closed <- xf.Close(cctx)
+	}()
+	select {
+	case err := <-closed:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Close = %v, want its deadline exceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked past its deadline")
+	}
+
+	// the waiting write is made synchronously, the queue drained, and the
+	// retry of whichever write failed abandoned
+	close(cache.release)
+	if err := <-fetched; err != nil {
+		t.Fatalf("Fetch(c) = %v", err)
+	}
+	waitFor(t, func() bool {
+		_, err := cache.Get(ctx, "b")
+		return err == nil
+	})
+	waitFor(t, func() bool {
+		errs := log.get()
+		return len(errs) == 1 && errors.Is(errs[0], stampede.ErrClosed)
+	})
+}